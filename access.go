@@ -0,0 +1,173 @@
+package txkv
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// AccessStatsOptions tune an AccessStatsKV.
+type AccessStatsOptions struct {
+	// SampleRate is the fraction of operations counted, to bound the
+	// overhead on hot paths. Counts are scaled back up. Defaults to 1, every
+	// operation.
+	SampleRate float64
+	// Group maps keys to what is counted, like their prefix. Defaults to the
+	// keys themselves.
+	Group func(Key) Key
+	// MaxEntries bounds how many keys or groups are tracked. Once full, the
+	// least accessed one makes room for a new one, which inherits its counts
+	// so that keys accessed often enough still rise to the top. Defaults to
+	// 10000.
+	MaxEntries int
+}
+
+// KeyAccess is how often a key or group was accessed.
+type KeyAccess struct {
+	Key    Key
+	Reads  uint64
+	Writes uint64
+}
+
+// Total is the number of reads and writes.
+func (a KeyAccess) Total() uint64 { return a.Reads + a.Writes }
+
+// WithAccessStats returns an AccessStatsKV over `kv`.
+func WithAccessStats(kv TransactionalKV, opts AccessStatsOptions) *AccessStatsKV {
+	if opts.SampleRate <= 0 || opts.SampleRate > 1 {
+		opts.SampleRate = 1
+	}
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = 10000
+	}
+	return &AccessStatsKV{kv: kv, opts: opts, counts: make(map[string]*KeyAccess)}
+}
+
+// AccessStatsKV is a TransactionalKV counting the reads and writes of keys,
+// including within transactions, to find hot and cold keys for eviction and
+// capacity decisions. Get counts as a read, Put and Delete as writes, and
+// List as a read of the listed prefix.
+type AccessStatsKV struct {
+	kv   TransactionalKV
+	opts AccessStatsOptions
+
+	mu     sync.Mutex
+	counts map[string]*KeyAccess
+}
+
+// Stats returns the access counts of the tracked keys or groups, the most
+// accessed first. They're estimates when sampling, or once MaxEntries was
+// reached.
+func (a *AccessStatsKV) Stats() []KeyAccess {
+	a.mu.Lock()
+	stats := make([]KeyAccess, 0, len(a.counts))
+	for _, c := range a.counts {
+		stats = append(stats, *c)
+	}
+	a.mu.Unlock()
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Total() != stats[j].Total() {
+			return stats[i].Total() > stats[j].Total()
+		}
+		return string(stats[i].Key) < string(stats[j].Key)
+	})
+	return stats
+}
+
+// Reset forgets every count.
+func (a *AccessStatsKV) Reset() {
+	a.mu.Lock()
+	a.counts = make(map[string]*KeyAccess)
+	a.mu.Unlock()
+}
+
+func (a *AccessStatsKV) record(key Key, write bool) {
+	if a.opts.SampleRate < 1 && rand.Float64() >= a.opts.SampleRate {
+		return
+	}
+	if a.opts.Group != nil {
+		key = a.opts.Group(key)
+	}
+	n := uint64(1 / a.opts.SampleRate)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	c, ok := a.counts[string(key)]
+	if !ok {
+		c = &KeyAccess{}
+		if len(a.counts) >= a.opts.MaxEntries {
+			var min *KeyAccess
+			for _, other := range a.counts {
+				if min == nil || other.Total() < min.Total() {
+					min = other
+				}
+			}
+			delete(a.counts, string(min.Key))
+			*c = *min
+		}
+		c.Key = append(Key{}, key...)
+		a.counts[string(key)] = c
+	}
+	if write {
+		c.Writes += n
+	} else {
+		c.Reads += n
+	}
+}
+
+func (a *AccessStatsKV) Put(ctx context.Context, key Key, value Value) error {
+	a.record(key, true)
+	return a.kv.Put(ctx, key, value)
+}
+
+func (a *AccessStatsKV) Get(ctx context.Context, key Key) (Value, bool, error) {
+	a.record(key, false)
+	return a.kv.Get(ctx, key)
+}
+
+func (a *AccessStatsKV) Delete(ctx context.Context, key Key) error {
+	a.record(key, true)
+	return a.kv.Delete(ctx, key)
+}
+
+func (a *AccessStatsKV) List(ctx context.Context, prefix Key) ([]Key, error) {
+	a.record(prefix, false)
+	return a.kv.List(ctx, prefix)
+}
+
+func (a *AccessStatsKV) Begin(ctx context.Context) (TxKV, error) {
+	tx, err := a.kv.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &accessStatsTx{a: a, tx: tx}, nil
+}
+
+type accessStatsTx struct {
+	a  *AccessStatsKV
+	tx TxKV
+}
+
+func (t *accessStatsTx) Put(ctx context.Context, key Key, value Value) error {
+	t.a.record(key, true)
+	return t.tx.Put(ctx, key, value)
+}
+
+func (t *accessStatsTx) Get(ctx context.Context, key Key) (Value, bool, error) {
+	t.a.record(key, false)
+	return t.tx.Get(ctx, key)
+}
+
+func (t *accessStatsTx) Delete(ctx context.Context, key Key) error {
+	t.a.record(key, true)
+	return t.tx.Delete(ctx, key)
+}
+
+func (t *accessStatsTx) List(ctx context.Context, prefix Key) ([]Key, error) {
+	t.a.record(prefix, false)
+	return t.tx.List(ctx, prefix)
+}
+
+func (t *accessStatsTx) Commit(ctx context.Context) error   { return t.tx.Commit(ctx) }
+func (t *accessStatsTx) Rollback(ctx context.Context) error { return t.tx.Rollback(ctx) }
@@ -0,0 +1,67 @@
+package txkv_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestAccessStats(t *testing.T) {
+	ctx := context.Background()
+	kv := WithAccessStats(InMem(), AccessStatsOptions{})
+	mustPut(ctx, t, kv, Key("hot"), Value("1"))
+	mustPut(ctx, t, kv, Key("cold"), Value("1"))
+	for i := 0; i < 3; i++ {
+		_, _, err := kv.Get(ctx, Key("hot"))
+		require.NoError(t, err)
+	}
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Delete(ctx, Key("hot")))
+	require.NoError(t, tx.Commit(ctx))
+
+	require.Equal(t, []KeyAccess{
+		{Key: Key("hot"), Reads: 3, Writes: 2},
+		{Key: Key("cold"), Writes: 1},
+	}, kv.Stats())
+
+	kv.Reset()
+	require.Empty(t, kv.Stats())
+}
+
+func TestAccessStatsGroupsAndBounds(t *testing.T) {
+	ctx := context.Background()
+	byTenant := func(key Key) Key {
+		if i := bytes.IndexByte(key, '/'); i >= 0 {
+			return key[:i+1]
+		}
+		return key
+	}
+	kv := WithAccessStats(InMem(), AccessStatsOptions{Group: byTenant, MaxEntries: 2})
+	mustPut(ctx, t, kv, Key("a/1"), Value("1"))
+	mustPut(ctx, t, kv, Key("a/2"), Value("1"))
+	mustPut(ctx, t, kv, Key("b/1"), Value("1"))
+	// evicts b/, the least accessed, inheriting its count
+	mustPut(ctx, t, kv, Key("c/1"), Value("1"))
+
+	require.Equal(t, []KeyAccess{
+		{Key: Key("a/"), Writes: 2},
+		{Key: Key("c/"), Writes: 2},
+	}, kv.Stats())
+}
+
+func TestAccessStatsSampling(t *testing.T) {
+	ctx := context.Background()
+	kv := WithAccessStats(InMem(), AccessStatsOptions{SampleRate: 0.1})
+	for i := 0; i < 10000; i++ {
+		_, _, err := kv.Get(ctx, Key("k"))
+		require.NoError(t, err)
+	}
+	stats := kv.Stats()
+	require.Len(t, stats, 1)
+	require.InDelta(t, 10000, stats[0].Reads, 1500)
+}
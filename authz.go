@@ -0,0 +1,278 @@
+package txkv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Principal identifies on behalf of whom an operation is performed.
+type Principal string
+
+type principalKey struct{}
+
+// ContextWithPrincipal returns a context carrying `p`.
+func ContextWithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the principal carried by `ctx`, if any.
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// Action is a kind of access to a key.
+type Action int
+
+// The actions a Policy decides upon.
+const (
+	ActionRead Action = iota
+	ActionWrite
+	ActionList
+	// ActionAdmin is an operational action, like a backup, on the key naming
+	// it. See txkvadmin.
+	ActionAdmin
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionRead:
+		return "read"
+	case ActionWrite:
+		return "write"
+	case ActionList:
+		return "list"
+	case ActionAdmin:
+		return "admin"
+	}
+	return fmt.Sprintf("Action(%d)", int(a))
+}
+
+// Policy decides whether principal `p` may perform `action` on `key`. The
+// principal is empty if the context doesn't carry one.
+type Policy func(ctx context.Context, p Principal, action Action, key Key) (bool, error)
+
+// ErrPermissionDenied is matched by the errors returned when a Policy
+// refuses an operation.
+var ErrPermissionDenied = errors.New("txkv: permission denied")
+
+// PermissionDeniedError is returned when a Policy refuses an operation.
+type PermissionDeniedError struct {
+	Principal Principal
+	Action    Action
+	Key       Key
+}
+
+func (e *PermissionDeniedError) Error() string {
+	return fmt.Sprintf("txkv: principal %q may not %v key %q", e.Principal, e.Action, e.Key)
+}
+
+func (e *PermissionDeniedError) Is(target error) bool { return target == ErrPermissionDenied }
+
+// WithAuthorization returns a TransactionalKV where every operation, including
+// those within transactions, is first checked against `policy` using the
+// principal found in the context.
+//
+// Get is checked for ActionRead, Put and Delete for ActionWrite. List never
+// fails for lack of permission: keys the principal may not list are left out
+// of the results.
+func WithAuthorization(kv TransactionalKV, policy Policy) TransactionalKV {
+	return &authzKV{kv: kv, policy: policy}
+}
+
+type authzKV struct {
+	kv     TransactionalKV
+	policy Policy
+}
+
+func (a *authzKV) Put(ctx context.Context, key Key, value Value) error {
+	return authorizedPut(ctx, a.policy, a.kv, key, value)
+}
+
+func (a *authzKV) Get(ctx context.Context, key Key) (Value, bool, error) {
+	return authorizedGet(ctx, a.policy, a.kv, key)
+}
+
+func (a *authzKV) Delete(ctx context.Context, key Key) error {
+	return authorizedDelete(ctx, a.policy, a.kv, key)
+}
+
+func (a *authzKV) List(ctx context.Context, prefix Key) ([]Key, error) {
+	return authorizedList(ctx, a.policy, a.kv, prefix)
+}
+
+func (a *authzKV) Begin(ctx context.Context) (TxKV, error) {
+	tx, err := a.kv.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &authzTx{tx: tx, policy: a.policy}, nil
+}
+
+type authzTx struct {
+	tx     TxKV
+	policy Policy
+}
+
+func (a *authzTx) Put(ctx context.Context, key Key, value Value) error {
+	return authorizedPut(ctx, a.policy, a.tx, key, value)
+}
+
+func (a *authzTx) Get(ctx context.Context, key Key) (Value, bool, error) {
+	return authorizedGet(ctx, a.policy, a.tx, key)
+}
+
+func (a *authzTx) Delete(ctx context.Context, key Key) error {
+	return authorizedDelete(ctx, a.policy, a.tx, key)
+}
+
+func (a *authzTx) List(ctx context.Context, prefix Key) ([]Key, error) {
+	return authorizedList(ctx, a.policy, a.tx, prefix)
+}
+
+func (a *authzTx) Commit(ctx context.Context) error   { return a.tx.Commit(ctx) }
+func (a *authzTx) Rollback(ctx context.Context) error { return a.tx.Rollback(ctx) }
+
+func authorize(ctx context.Context, policy Policy, action Action, key Key) error {
+	p, _ := PrincipalFromContext(ctx)
+	ok, err := policy(ctx, p, action, key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return &PermissionDeniedError{Principal: p, Action: action, Key: key}
+	}
+	return nil
+}
+
+func authorizedPut(ctx context.Context, policy Policy, kv KV, key Key, value Value) error {
+	if err := authorize(ctx, policy, ActionWrite, key); err != nil {
+		return err
+	}
+	return kv.Put(ctx, key, value)
+}
+
+func authorizedGet(ctx context.Context, policy Policy, kv KV, key Key) (Value, bool, error) {
+	if err := authorize(ctx, policy, ActionRead, key); err != nil {
+		return nil, false, err
+	}
+	return kv.Get(ctx, key)
+}
+
+func authorizedDelete(ctx context.Context, policy Policy, kv KV, key Key) error {
+	if err := authorize(ctx, policy, ActionWrite, key); err != nil {
+		return err
+	}
+	return kv.Delete(ctx, key)
+}
+
+func authorizedList(ctx context.Context, policy Policy, kv KV, prefix Key) ([]Key, error) {
+	keys, err := kv.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	allowed := keys[:0]
+	for _, key := range keys {
+		err := authorize(ctx, policy, ActionList, key)
+		switch {
+		case err == nil:
+			allowed = append(allowed, key)
+		case !errors.Is(err, ErrPermissionDenied):
+			return nil, err
+		}
+	}
+	return allowed, nil
+}
+
+// PrefixACL is a declarative Policy whose rules are stored in a KV, under
+// a dedicated prefix. Each rule grants a principal some actions on all keys
+// under a key prefix. Since rules live in the KV, they can be changed at
+// runtime and are shared by all users of the store.
+//
+// Rules are read from the KV on every check, so the KV passed to NewPrefixACL
+// should be the unwrapped store. Conversely, the rules' own prefix should only
+// be writable by administrators.
+type PrefixACL struct {
+	kv     KV
+	prefix Key
+}
+
+// NewPrefixACL returns an ACL whose rules are stored in `kv` under `prefix`.
+func NewPrefixACL(kv KV, prefix Key) *PrefixACL {
+	return &PrefixACL{kv: kv, prefix: prefix}
+}
+
+// rules are stored at `aclPrefix + principal + 0x00 + keyPrefix`, so that a
+// principal's rules can be listed at once. The value lists the actions
+// granted, as their first letter: "r", "w", "l" and "a". Principals
+// containing 0x00 would read the rules of others, so they're refused.
+func (acl *PrefixACL) ruleKey(p Principal, keyPrefix Key) Key {
+	k := make(Key, 0, len(acl.prefix)+len(p)+1+len(keyPrefix))
+	k = append(k, acl.prefix...)
+	k = append(k, p...)
+	k = append(k, 0)
+	return append(k, keyPrefix...)
+}
+
+func validPrincipal(p Principal) error {
+	if strings.IndexByte(string(p), 0) >= 0 {
+		return fmt.Errorf("txkv: principal %q can't contain 0x00", p)
+	}
+	return nil
+}
+
+// Grant allows `p` to perform `actions` on all keys starting with
+// `keyPrefix`, replacing any previous grant on that same prefix. Principals
+// can't contain 0x00.
+func (acl *PrefixACL) Grant(ctx context.Context, p Principal, keyPrefix Key, actions ...Action) error {
+	if err := validPrincipal(p); err != nil {
+		return err
+	}
+	var sb strings.Builder
+	for _, action := range actions {
+		sb.WriteByte(action.String()[0])
+	}
+	return acl.kv.Put(ctx, acl.ruleKey(p, keyPrefix), Value(sb.String()))
+}
+
+// Revoke removes the grant of `p` on `keyPrefix`.
+func (acl *PrefixACL) Revoke(ctx context.Context, p Principal, keyPrefix Key) error {
+	if err := validPrincipal(p); err != nil {
+		return err
+	}
+	return acl.kv.Delete(ctx, acl.ruleKey(p, keyPrefix))
+}
+
+// Policy returns the Policy enforcing the ACL. An action is allowed if any
+// rule of the principal covering the key grants it. Principals containing
+// 0x00 are denied everything.
+func (acl *PrefixACL) Policy() Policy {
+	return func(ctx context.Context, p Principal, action Action, key Key) (bool, error) {
+		if validPrincipal(p) != nil {
+			return false, nil
+		}
+		rulesPrefix := acl.ruleKey(p, nil)
+		rules, err := acl.kv.List(ctx, rulesPrefix)
+		if err != nil {
+			return false, err
+		}
+		letter := action.String()[0]
+		for _, rule := range rules {
+			keyPrefix := rule[len(rulesPrefix):]
+			if !bytes.HasPrefix(key, keyPrefix) {
+				continue
+			}
+			granted, ok, err := acl.kv.Get(ctx, rule)
+			if err != nil {
+				return false, err
+			}
+			if ok && bytes.IndexByte(granted, letter) >= 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
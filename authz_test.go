@@ -0,0 +1,80 @@
+package txkv_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestAuthorizationAllowAll(t *testing.T) {
+	testKV(t, func(t testing.TB) TransactionalKV {
+		return WithAuthorization(InMem(), func(context.Context, Principal, Action, Key) (bool, error) {
+			return true, nil
+		})
+	})
+}
+
+func TestAuthorizationPrefixACL(t *testing.T) {
+	ctx := context.Background()
+	raw := InMem()
+	acl := NewPrefixACL(raw, Key("__acl/"))
+	kv := WithAuthorization(raw, acl.Policy())
+
+	alice := ContextWithPrincipal(ctx, "alice")
+	bob := ContextWithPrincipal(ctx, "bob")
+
+	require.NoError(t, acl.Grant(ctx, "alice", Key("alice/"), ActionRead, ActionWrite, ActionList))
+	require.NoError(t, acl.Grant(ctx, "bob", Key("alice/public/"), ActionRead, ActionList))
+
+	mustPut(alice, t, kv, Key("alice/secret"), Value("1"))
+	mustPut(alice, t, kv, Key("alice/public/hello"), Value("2"))
+
+	// bob can't write there
+	err := kv.Put(bob, Key("alice/public/hello"), Value("3"))
+	var perr *PermissionDeniedError
+	require.True(t, errors.As(err, &perr), "%v", err)
+	require.Equal(t, Principal("bob"), perr.Principal)
+	require.Equal(t, ActionWrite, perr.Action)
+
+	// nor read alice's secrets
+	_, _, err = kv.Get(bob, Key("alice/secret"))
+	require.ErrorIs(t, err, ErrPermissionDenied)
+
+	// he only sees what he's allowed to list
+	mustList(bob, t, kv, Key("alice/"), []Key{Key("alice/public/hello")})
+	mustFind(bob, t, kv, Key("alice/public/hello"), Value("2"))
+
+	// the anonymous principal has no rights at all
+	_, _, err = kv.Get(ctx, Key("alice/public/hello"))
+	require.ErrorIs(t, err, ErrPermissionDenied)
+
+	// the same holds within transactions
+	tx, err := kv.Begin(bob)
+	require.NoError(t, err)
+	err = tx.Delete(bob, Key("alice/secret"))
+	require.ErrorIs(t, err, ErrPermissionDenied)
+	require.NoError(t, tx.Rollback(bob))
+
+	// revoking takes effect right away
+	require.NoError(t, acl.Revoke(ctx, "bob", Key("alice/public/")))
+	_, _, err = kv.Get(bob, Key("alice/public/hello"))
+	require.ErrorIs(t, err, ErrPermissionDenied)
+}
+
+func TestAuthorizationPrefixACLNulPrincipal(t *testing.T) {
+	ctx := context.Background()
+	raw := InMem()
+	acl := NewPrefixACL(raw, Key("__acl/"))
+	kv := WithAuthorization(raw, acl.Policy())
+
+	// "a" granted "b\x00..." would be read as a rule of "a\x00b"
+	require.NoError(t, acl.Grant(ctx, "a", Key("b\x00"), ActionRead, ActionWrite))
+	require.Error(t, acl.Grant(ctx, "a\x00b", Key(""), ActionRead))
+	require.Error(t, acl.Revoke(ctx, "a\x00b", Key("")))
+	err := kv.Put(ContextWithPrincipal(ctx, "a\x00b"), Key("anything"), Value("v"))
+	require.ErrorIs(t, err, ErrPermissionDenied)
+}
@@ -0,0 +1,195 @@
+package txkv
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrBusy is returned when a write is refused because the store is falling
+// behind, so callers back off instead of piling up.
+var ErrBusy = errors.New("txkv: store is busy")
+
+// BackpressureOptions tune a BackpressureKV.
+type BackpressureOptions struct {
+	// MaxInFlight is the most writes let through at once. 0 means no limit.
+	MaxInFlight int
+	// Behind, if set, tells when the store is falling behind on background
+	// work, like flushing a log or compacting. Writes wait while it's true.
+	Behind func() bool
+	// Budget is how long a write waits before failing with ErrBusy. 0 fails
+	// right away.
+	Budget time.Duration
+	// PollInterval is how often Behind is checked while waiting. Defaults to
+	// a millisecond.
+	PollInterval time.Duration
+}
+
+// StallStats describe the writes that had to wait.
+type StallStats struct {
+	// Stalls is the number of writes that waited, including those that were
+	// then refused.
+	Stalls uint64
+	// Refused is the number of writes that failed with ErrBusy.
+	Refused uint64
+	// Stalled is the total time writes waited.
+	Stalled time.Duration
+}
+
+// WithBackpressure returns a BackpressureKV over `kv`.
+func WithBackpressure(kv TransactionalKV, opts BackpressureOptions) *BackpressureKV {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Millisecond
+	}
+	b := &BackpressureKV{kv: kv, opts: opts}
+	if opts.MaxInFlight > 0 {
+		b.slots = make(chan struct{}, opts.MaxInFlight)
+	}
+	return b
+}
+
+// BackpressureKV is a TransactionalKV pushing back on writes when `kv` falls
+// behind: Put, Delete and Commit wait while too many writes are in flight,
+// or while the store reports being behind, and fail with ErrBusy past their
+// budget. Bursts of writes then slow callers down rather than grow queues
+// and memory without bounds. Reads and writes buffered in transactions
+// aren't held back.
+type BackpressureKV struct {
+	kv    TransactionalKV
+	opts  BackpressureOptions
+	slots chan struct{} // nil without MaxInFlight
+
+	mu    sync.Mutex
+	stats StallStats
+}
+
+// Stats returns the stalls so far.
+func (b *BackpressureKV) Stats() StallStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stats
+}
+
+// admit waits for the write to be let through.
+func (b *BackpressureKV) admit(ctx context.Context) error {
+	if b.tryAdmit() {
+		return nil
+	}
+	start := time.Now()
+	err := b.wait(ctx)
+	b.mu.Lock()
+	b.stats.Stalls++
+	b.stats.Stalled += time.Since(start)
+	if err == ErrBusy {
+		b.stats.Refused++
+	}
+	b.mu.Unlock()
+	return err
+}
+
+// tryAdmit takes a slot, if there are any, unless the store is behind.
+func (b *BackpressureKV) tryAdmit() bool {
+	if b.slots != nil {
+		select {
+		case b.slots <- struct{}{}:
+		default:
+			return false
+		}
+	}
+	if b.opts.Behind != nil && b.opts.Behind() {
+		b.done()
+		return false
+	}
+	return true
+}
+
+// wait polls until the write is admitted, for up to the budget.
+func (b *BackpressureKV) wait(ctx context.Context) error {
+	if b.opts.Budget <= 0 {
+		return ErrBusy
+	}
+	budget := time.NewTimer(b.opts.Budget)
+	defer budget.Stop()
+	poll := time.NewTicker(b.opts.PollInterval)
+	defer poll.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-budget.C:
+			return ErrBusy
+		case <-poll.C:
+			if b.tryAdmit() {
+				return nil
+			}
+		}
+	}
+}
+
+// done gives back the slot of an admitted write.
+func (b *BackpressureKV) done() {
+	if b.slots != nil {
+		<-b.slots
+	}
+}
+
+// write runs `fn` once admitted.
+func (b *BackpressureKV) write(ctx context.Context, fn func() error) error {
+	if err := b.admit(ctx); err != nil {
+		return err
+	}
+	defer b.done()
+	return fn()
+}
+
+func (b *BackpressureKV) Put(ctx context.Context, key Key, value Value) error {
+	return b.write(ctx, func() error { return b.kv.Put(ctx, key, value) })
+}
+
+func (b *BackpressureKV) Get(ctx context.Context, key Key) (Value, bool, error) {
+	return b.kv.Get(ctx, key)
+}
+
+func (b *BackpressureKV) Delete(ctx context.Context, key Key) error {
+	return b.write(ctx, func() error { return b.kv.Delete(ctx, key) })
+}
+
+func (b *BackpressureKV) List(ctx context.Context, prefix Key) ([]Key, error) {
+	return b.kv.List(ctx, prefix)
+}
+
+func (b *BackpressureKV) Begin(ctx context.Context) (TxKV, error) {
+	tx, err := b.kv.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &backpressureTx{b: b, tx: tx}, nil
+}
+
+type backpressureTx struct {
+	b  *BackpressureKV
+	tx TxKV
+}
+
+func (t *backpressureTx) Put(ctx context.Context, key Key, value Value) error {
+	return t.tx.Put(ctx, key, value)
+}
+
+func (t *backpressureTx) Get(ctx context.Context, key Key) (Value, bool, error) {
+	return t.tx.Get(ctx, key)
+}
+
+func (t *backpressureTx) Delete(ctx context.Context, key Key) error {
+	return t.tx.Delete(ctx, key)
+}
+
+func (t *backpressureTx) List(ctx context.Context, prefix Key) ([]Key, error) {
+	return t.tx.List(ctx, prefix)
+}
+
+func (t *backpressureTx) Commit(ctx context.Context) error {
+	return t.b.write(ctx, func() error { return t.tx.Commit(ctx) })
+}
+
+func (t *backpressureTx) Rollback(ctx context.Context) error { return t.tx.Rollback(ctx) }
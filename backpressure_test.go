@@ -0,0 +1,57 @@
+package txkv_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestBackpressureBehind(t *testing.T) {
+	ctx := context.Background()
+	var behind atomic.Bool
+	kv := WithBackpressure(InMem(), BackpressureOptions{
+		Behind: behind.Load,
+		Budget: 20 * time.Millisecond,
+	})
+	mustPut(ctx, t, kv, Key("a"), Value("v"))
+	require.Zero(t, kv.Stats())
+
+	behind.Store(true)
+	require.ErrorIs(t, kv.Put(ctx, Key("b"), Value("v")), ErrBusy)
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Put(ctx, Key("b"), Value("v")))
+	require.ErrorIs(t, tx.Commit(ctx), ErrBusy)
+	// reads go through
+	mustFind(ctx, t, kv, Key("a"), Value("v"))
+
+	// catching up within the budget lets the write through
+	time.AfterFunc(5*time.Millisecond, func() { behind.Store(false) })
+	mustDelete(ctx, t, kv, Key("a"))
+
+	stats := kv.Stats()
+	require.EqualValues(t, 3, stats.Stalls)
+	require.EqualValues(t, 2, stats.Refused)
+	require.GreaterOrEqual(t, stats.Stalled, 40*time.Millisecond)
+}
+
+func TestBackpressureInFlight(t *testing.T) {
+	ctx := context.Background()
+	inner := &gatedKV{TransactionalKV: InMem(), gate: make(chan struct{})}
+	kv := WithBackpressure(inner, BackpressureOptions{MaxInFlight: 1})
+
+	var wg sync.WaitGroup
+	commitInBackground(t, &wg, kv, "first", PriorityNormal)
+	// no budget, refused right away
+	require.ErrorIs(t, kv.Put(ctx, Key("b"), Value("v")), ErrBusy)
+	inner.gate <- struct{}{}
+	wg.Wait()
+	mustPut(ctx, t, kv, Key("b"), Value("v"))
+	require.EqualValues(t, 1, kv.Stats().Refused)
+}
@@ -0,0 +1,65 @@
+package txkv
+
+import "context"
+
+// Op is a write of a batch: a Put of Value at Key, or a Delete of Key.
+type Op struct {
+	Key    Key
+	Value  Value
+	Delete bool
+}
+
+// PutOp returns an Op putting `value` at `key`.
+func PutOp(key Key, value Value) Op { return Op{Key: key, Value: value} }
+
+// DeleteOp returns an Op deleting `key`.
+func DeleteOp(key Key) Op { return Op{Key: key, Delete: true} }
+
+// Batcher is implemented by stores that can apply a batch of writes
+// atomically in one go, like remote backends with a batch API.
+type Batcher interface {
+	Apply(ctx context.Context, ops []Op) error
+}
+
+// Apply applies `ops` to `kv` atomically, in order: when a key is written
+// more than once, the last write wins. Stores that are a Batcher apply them
+// natively, others in a transaction.
+func Apply(ctx context.Context, kv TransactionalKV, ops []Op) error {
+	if b, ok := kv.(Batcher); ok {
+		return b.Apply(ctx, ops)
+	}
+	tx, err := kv.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	for _, op := range ops {
+		if op.Delete {
+			err = tx.Delete(ctx, op.Key)
+		} else {
+			err = tx.Put(ctx, op.Key, op.Value)
+		}
+		if err != nil {
+			_ = tx.Rollback(ctx)
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// DeleteMany deletes `keys` from `kv` atomically.
+func DeleteMany(ctx context.Context, kv TransactionalKV, keys []Key) error {
+	ops := make([]Op, 0, len(keys))
+	for _, key := range keys {
+		ops = append(ops, DeleteOp(key))
+	}
+	return Apply(ctx, kv, ops)
+}
+
+// PendingLister is implemented by transactions that can tell what they'll
+// write when committed, for debugging and auditing. The in-memory store's
+// transactions implement it.
+type PendingLister interface {
+	// Pending returns the writes of the transaction so far, in key order,
+	// one per key.
+	Pending(ctx context.Context) ([]Op, error)
+}
@@ -0,0 +1,38 @@
+package txkv_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestApply(t *testing.T) {
+	ctx := context.Background()
+	kvs := map[string]TransactionalKV{
+		"inmem":   InMem(),
+		"wrapped": WithMaintenance(InMem()),
+	}
+	for name, kv := range kvs {
+		t.Run(name, func(t *testing.T) {
+			mustPut(ctx, t, kv, Key("a"), Value("old"))
+			mustPut(ctx, t, kv, Key("b"), Value("old"))
+
+			require.NoError(t, Apply(ctx, kv, []Op{
+				PutOp(Key("c"), Value("first")),
+				DeleteOp(Key("a")),
+				PutOp(Key("b"), Value("new")),
+				PutOp(Key("c"), Value("last")),
+				DeleteOp(Key("nope")),
+			}))
+			mustList(ctx, t, kv, Key(""), []Key{Key("b"), Key("c")})
+			mustFind(ctx, t, kv, Key("b"), Value("new"))
+			mustFind(ctx, t, kv, Key("c"), Value("last"))
+
+			require.NoError(t, DeleteMany(ctx, kv, []Key{Key("b"), Key("c")}))
+			mustList(ctx, t, kv, Key(""), nil)
+		})
+	}
+}
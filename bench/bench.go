@@ -0,0 +1,289 @@
+// Package bench runs standard workloads against any txkv store, to compare
+// backends, or versions of one, with numbers that can be kept and diffed.
+//
+// Workloads are modeled on YCSB: a keyspace is loaded, then operations pick
+// keys following a Zipfian distribution, so a few keys are hot.
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aybabtme/txkv"
+)
+
+// Workload is a mix of operations, picked at random in the given
+// proportions. The proportions needn't add up to 1.
+type Workload struct {
+	Name string
+	// Read is the proportion of Gets.
+	Read float64
+	// Update is the proportion of Puts to existing keys.
+	Update float64
+	// Scan is the proportion of listings of ScanLength keys.
+	Scan float64
+	// Tx is the proportion of transactions reading then writing TxKeys keys.
+	Tx float64
+
+	// Keys is the number of keys loaded. Defaults to 10000.
+	Keys int
+	// ValueSize is the size of values, in bytes. Defaults to 100.
+	ValueSize int
+	// ScanLength is the number of keys a scan lists. Defaults to 100.
+	ScanLength int
+	// TxKeys is the number of keys a transaction reads and writes. Defaults
+	// to 4.
+	TxKeys int
+}
+
+// The standard workloads.
+var (
+	ReadHeavy  = Workload{Name: "read-heavy", Read: 0.95, Update: 0.05}
+	WriteHeavy = Workload{Name: "write-heavy", Read: 0.05, Update: 0.95}
+	ScanHeavy  = Workload{Name: "scan-heavy", Scan: 0.95, Update: 0.05}
+	TxHeavy    = Workload{Name: "tx-heavy", Read: 0.25, Tx: 0.75}
+	Mixed      = Workload{Name: "mixed", Read: 0.5, Update: 0.3, Scan: 0.1, Tx: 0.1}
+)
+
+// Workloads are the standard workloads.
+var Workloads = []Workload{ReadHeavy, WriteHeavy, ScanHeavy, TxHeavy, Mixed}
+
+func (w Workload) withDefaults() Workload {
+	if w.Keys <= 0 {
+		w.Keys = 10000
+	}
+	if w.ValueSize <= 0 {
+		w.ValueSize = 100
+	}
+	if w.ScanLength <= 0 {
+		w.ScanLength = 100
+	}
+	if w.TxKeys <= 0 {
+		w.TxKeys = 4
+	}
+	return w
+}
+
+// Options tune Run.
+type Options struct {
+	// Ops is the number of operations run. Defaults to 10000.
+	Ops int
+	// Concurrency is the number of goroutines running them. Defaults to 1.
+	Concurrency int
+	// Seed seeds the choice of operations and keys. Runs with the same seed
+	// run the same operations.
+	Seed int64
+}
+
+// Result is the outcome of a run. It marshals to JSON, to be kept and
+// compared.
+type Result struct {
+	Workload    string        `json:"workload"`
+	Ops         int           `json:"ops"`
+	Errors      int           `json:"errors"`
+	Duration    time.Duration `json:"duration_ns"`
+	OpsPerSec   float64       `json:"ops_per_sec"`
+	Concurrency int           `json:"concurrency"`
+	// The latencies of operations, transactions counting as one.
+	P50 time.Duration `json:"p50_ns"`
+	P99 time.Duration `json:"p99_ns"`
+	Max time.Duration `json:"max_ns"`
+	// Conflicts counts the transactions that failed to commit with an error
+	// matching txkv.ErrConflict, which aren't counted as errors.
+	Conflicts int `json:"conflicts"`
+}
+
+// WriteResults writes `results` to `w` as JSON lines.
+func WriteResults(w io.Writer, results []Result) error {
+	enc := json.NewEncoder(w)
+	for _, r := range results {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load puts the keys of workload `w` in `kv`.
+func Load(ctx context.Context, kv txkv.TransactionalKV, w Workload) error {
+	w = w.withDefaults()
+	value := make(txkv.Value, w.ValueSize)
+	ops := make([]txkv.Op, 0, 100)
+	for i := 0; i < w.Keys; i++ {
+		ops = append(ops, txkv.PutOp(key(i), value))
+		if len(ops) == cap(ops) || i == w.Keys-1 {
+			if err := txkv.Apply(ctx, kv, ops); err != nil {
+				return err
+			}
+			ops = ops[:0]
+		}
+	}
+	return nil
+}
+
+// Run loads the keys of workload `w` in `kv`, then runs its operations. Errors
+// of operations are counted rather than stopping the run.
+func Run(ctx context.Context, kv txkv.TransactionalKV, w Workload, opts Options) (Result, error) {
+	w = w.withDefaults()
+	if opts.Ops <= 0 {
+		opts.Ops = 10000
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	if err := Load(ctx, kv, w); err != nil {
+		return Result{}, fmt.Errorf("bench: loading %s: %w", w.Name, err)
+	}
+
+	latencies := make([]time.Duration, opts.Ops)
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		failures  int
+		conflicts int
+	)
+	start := time.Now()
+	for g := 0; g < opts.Concurrency; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			r := newRunner(kv, w, opts.Seed+int64(g))
+			failed, conflicted := 0, 0
+			// goroutines take every Concurrency-th op
+			for i := g; i < opts.Ops && ctx.Err() == nil; i += opts.Concurrency {
+				opStart := time.Now()
+				if err := r.op(ctx); errors.Is(err, txkv.ErrConflict) {
+					conflicted++
+				} else if err != nil {
+					failed++
+				}
+				latencies[i] = time.Since(opStart)
+			}
+			mu.Lock()
+			failures += failed
+			conflicts += conflicted
+			mu.Unlock()
+		}(g)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+	elapsed := time.Since(start)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return Result{
+		Workload:    w.Name,
+		Ops:         opts.Ops,
+		Errors:      failures,
+		Conflicts:   conflicts,
+		Duration:    elapsed,
+		OpsPerSec:   float64(opts.Ops) / elapsed.Seconds(),
+		Concurrency: opts.Concurrency,
+		P50:         latencies[len(latencies)/2],
+		P99:         latencies[len(latencies)*99/100],
+		Max:         latencies[len(latencies)-1],
+	}, nil
+}
+
+// Benchmark runs every standard workload as a sub-benchmark of `b`, on stores
+// made by `newKV`, for use in the benchmarks of a backend.
+func Benchmark(b *testing.B, newKV func() txkv.TransactionalKV) {
+	ctx := context.Background()
+	for _, w := range Workloads {
+		w := w.withDefaults()
+		b.Run(w.Name, func(b *testing.B) {
+			kv := newKV()
+			if err := Load(ctx, kv, w); err != nil {
+				b.Fatal(err)
+			}
+			r := newRunner(kv, w, 1)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := r.op(ctx); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func key(i int) txkv.Key {
+	return txkv.Key(fmt.Sprintf("user%08d", i))
+}
+
+// runner runs the operations of a workload from a single goroutine.
+type runner struct {
+	kv    txkv.TransactionalKV
+	w     Workload
+	rand  *rand.Rand
+	zipf  *rand.Zipf
+	value txkv.Value
+	total float64
+}
+
+func newRunner(kv txkv.TransactionalKV, w Workload, seed int64) *runner {
+	r := rand.New(rand.NewSource(seed))
+	return &runner{
+		kv:    kv,
+		w:     w,
+		rand:  r,
+		zipf:  rand.NewZipf(r, 1.1, 1, uint64(w.Keys-1)),
+		value: make(txkv.Value, w.ValueSize),
+		total: w.Read + w.Update + w.Scan + w.Tx,
+	}
+}
+
+// nextKey picks a key, the hot ones spread over the keyspace rather than
+// all at its start.
+func (r *runner) nextKey() txkv.Key {
+	n := int(r.zipf.Uint64())
+	return key(n * 7919 % r.w.Keys)
+}
+
+func (r *runner) op(ctx context.Context) error {
+	x := r.rand.Float64() * r.total
+	switch {
+	case x < r.w.Read:
+		_, _, err := r.kv.Get(ctx, r.nextKey())
+		return err
+	case x < r.w.Read+r.w.Update:
+		return r.kv.Put(ctx, r.nextKey(), r.value)
+	case x < r.w.Read+r.w.Update+r.w.Scan:
+		_, err := txkv.ListWithOptions(ctx, r.kv, txkv.Key("user"), txkv.ListOptions{
+			Limit: r.w.ScanLength,
+			After: r.nextKey(),
+		})
+		return err
+	default:
+		return r.tx(ctx)
+	}
+}
+
+// tx reads then rewrites TxKeys keys in a transaction.
+func (r *runner) tx(ctx context.Context) error {
+	tx, err := r.kv.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < r.w.TxKeys; i++ {
+		k := r.nextKey()
+		if _, _, err := tx.Get(ctx, k); err != nil {
+			_ = tx.Rollback(ctx)
+			return err
+		}
+		if err := tx.Put(ctx, k, r.value); err != nil {
+			_ = tx.Rollback(ctx)
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,43 @@
+package bench_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aybabtme/txkv"
+	"github.com/aybabtme/txkv/bench"
+)
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	var results []bench.Result
+	for _, w := range bench.Workloads {
+		w.Keys = 500
+		res, err := bench.Run(ctx, txkv.InMem(), w, bench.Options{Ops: 1000, Concurrency: 4})
+		require.NoError(t, err)
+		require.Equal(t, w.Name, res.Workload)
+		require.Equal(t, 1000, res.Ops)
+		require.Zero(t, res.Errors)
+		require.Positive(t, res.OpsPerSec)
+		require.LessOrEqual(t, res.P50, res.P99)
+		require.LessOrEqual(t, res.P99, res.Max)
+		results = append(results, res)
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, bench.WriteResults(&buf, results))
+	dec := json.NewDecoder(&buf)
+	for _, want := range results {
+		var got bench.Result
+		require.NoError(t, dec.Decode(&got))
+		require.Equal(t, want, got)
+	}
+}
+
+func BenchmarkInMem(b *testing.B) {
+	bench.Benchmark(b, func() txkv.TransactionalKV { return txkv.InMem() })
+}
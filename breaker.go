@@ -0,0 +1,256 @@
+package txkv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by a CircuitBreakerKV while it fails fast.
+var ErrCircuitOpen = errors.New("txkv: circuit breaker is open")
+
+// CircuitState is the state of a circuit breaker.
+type CircuitState int
+
+// The states of a circuit breaker.
+const (
+	// CircuitClosed lets operations through.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails operations fast.
+	CircuitOpen
+	// CircuitHalfOpen lets a single probe through, to find out whether the
+	// backend recovered.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("CircuitState(%d)", int(s))
+}
+
+// CircuitBreakerOptions tune a CircuitBreakerKV.
+type CircuitBreakerOptions struct {
+	// FailureThreshold is the number of consecutive failures that trip the
+	// breaker. Defaults to 5.
+	FailureThreshold int
+	// OpenTimeout is how long the breaker fails fast before probing the
+	// backend. Defaults to 10s.
+	OpenTimeout time.Duration
+	// IsFailure tells which errors count as failures of the backend.
+	// Defaults to transient errors and exceeded deadlines; other errors,
+	// like ErrPermissionDenied, are the caller's problem.
+	IsFailure func(error) bool
+	// OnStateChange, if set, is called on every change of state. It's called
+	// with the breaker locked, so it must not use the breaker.
+	OnStateChange func(from, to CircuitState)
+}
+
+func isBackendFailure(err error) bool {
+	return IsTransient(err) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// WithCircuitBreaker returns a CircuitBreakerKV over `kv`. It's meant for
+// stores fronting a remote service: once the service fails repeatedly,
+// operations fail fast with ErrCircuitOpen instead of piling up, until a
+// probe finds the service back.
+func WithCircuitBreaker(kv TransactionalKV, opts CircuitBreakerOptions) *CircuitBreakerKV {
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = 5
+	}
+	if opts.OpenTimeout <= 0 {
+		opts.OpenTimeout = 10 * time.Second
+	}
+	if opts.IsFailure == nil {
+		opts.IsFailure = isBackendFailure
+	}
+	return &CircuitBreakerKV{kv: kv, opts: opts}
+}
+
+// CircuitBreakerKV is a TransactionalKV guarded by a circuit breaker. Every
+// operation counts, including those within transactions.
+type CircuitBreakerKV struct {
+	kv   TransactionalKV
+	opts CircuitBreakerOptions
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// State returns the current state of the breaker.
+func (c *CircuitBreakerKV) State() CircuitState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// setState must be called with the lock held.
+func (c *CircuitBreakerKV) setState(state CircuitState) {
+	from := c.state
+	if from == state {
+		return
+	}
+	c.state = state
+	if c.opts.OnStateChange != nil {
+		c.opts.OnStateChange(from, state)
+	}
+}
+
+// do runs `fn` if the breaker allows it, and accounts for its outcome.
+func (c *CircuitBreakerKV) do(fn func() error) (err error) {
+	probe, err := c.admit()
+	if err != nil {
+		return err
+	}
+	// accounted for even if `fn` panics, so that a probe that did doesn't
+	// keep the breaker half-open for good
+	returned := false
+	defer func() { c.account(probe, returned, err) }()
+	err = fn()
+	returned = true
+	return err
+}
+
+// admit tells whether an operation may go through, and if it's the probe of
+// a half-open breaker.
+func (c *CircuitBreakerKV) admit() (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch c.state {
+	case CircuitOpen:
+		if time.Since(c.openedAt) < c.opts.OpenTimeout {
+			return false, ErrCircuitOpen
+		}
+		c.setState(CircuitHalfOpen)
+	case CircuitHalfOpen:
+		if c.probing {
+			return false, ErrCircuitOpen
+		}
+	default:
+		return false, nil
+	}
+	c.probing = true
+	return true, nil
+}
+
+// account updates the breaker with the outcome of an operation. Only the
+// probe decides the state of a breaker that isn't closed: operations let
+// through before it tripped don't.
+func (c *CircuitBreakerKV) account(probe, returned bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if probe {
+		c.probing = false
+	}
+	// a panic isn't the outcome of the backend: the next operation probes
+	if !returned {
+		return
+	}
+	failed := err != nil && c.opts.IsFailure(err)
+	if probe {
+		if failed {
+			c.openedAt = time.Now()
+			c.setState(CircuitOpen)
+		} else {
+			c.failures = 0
+			c.setState(CircuitClosed)
+		}
+		return
+	}
+	if c.state != CircuitClosed {
+		return
+	}
+	if !failed {
+		c.failures = 0
+		return
+	}
+	c.failures++
+	if c.failures >= c.opts.FailureThreshold {
+		c.openedAt = time.Now()
+		c.setState(CircuitOpen)
+	}
+}
+
+func (c *CircuitBreakerKV) Put(ctx context.Context, key Key, value Value) error {
+	return c.do(func() error { return c.kv.Put(ctx, key, value) })
+}
+
+func (c *CircuitBreakerKV) Get(ctx context.Context, key Key) (v Value, ok bool, err error) {
+	err = c.do(func() (err error) {
+		v, ok, err = c.kv.Get(ctx, key)
+		return err
+	})
+	return v, ok, err
+}
+
+func (c *CircuitBreakerKV) Delete(ctx context.Context, key Key) error {
+	return c.do(func() error { return c.kv.Delete(ctx, key) })
+}
+
+func (c *CircuitBreakerKV) List(ctx context.Context, prefix Key) (keys []Key, err error) {
+	err = c.do(func() (err error) {
+		keys, err = c.kv.List(ctx, prefix)
+		return err
+	})
+	return keys, err
+}
+
+func (c *CircuitBreakerKV) Begin(ctx context.Context) (TxKV, error) {
+	var tx TxKV
+	err := c.do(func() (err error) {
+		tx, err = c.kv.Begin(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &breakerTx{c: c, tx: tx}, nil
+}
+
+type breakerTx struct {
+	c  *CircuitBreakerKV
+	tx TxKV
+}
+
+func (t *breakerTx) Put(ctx context.Context, key Key, value Value) error {
+	return t.c.do(func() error { return t.tx.Put(ctx, key, value) })
+}
+
+func (t *breakerTx) Get(ctx context.Context, key Key) (v Value, ok bool, err error) {
+	err = t.c.do(func() (err error) {
+		v, ok, err = t.tx.Get(ctx, key)
+		return err
+	})
+	return v, ok, err
+}
+
+func (t *breakerTx) Delete(ctx context.Context, key Key) error {
+	return t.c.do(func() error { return t.tx.Delete(ctx, key) })
+}
+
+func (t *breakerTx) List(ctx context.Context, prefix Key) (keys []Key, err error) {
+	err = t.c.do(func() (err error) {
+		keys, err = t.tx.List(ctx, prefix)
+		return err
+	})
+	return keys, err
+}
+
+func (t *breakerTx) Commit(ctx context.Context) error {
+	return t.c.do(func() error { return t.tx.Commit(ctx) })
+}
+
+// Rollback always goes through, so resources are released even while the
+// breaker is open.
+func (t *breakerTx) Rollback(ctx context.Context) error { return t.tx.Rollback(ctx) }
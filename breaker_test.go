@@ -0,0 +1,136 @@
+package txkv_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	testKV(t, func(t testing.TB) TransactionalKV {
+		return WithCircuitBreaker(InMem(), CircuitBreakerOptions{})
+	})
+}
+
+func TestCircuitBreakerTrips(t *testing.T) {
+	ctx := context.Background()
+	flaky := &flakyKV{TransactionalKV: InMem(), failures: 3, err: ErrTransient}
+	var changes []string
+	kv := WithCircuitBreaker(flaky, CircuitBreakerOptions{
+		FailureThreshold: 3,
+		OpenTimeout:      20 * time.Millisecond,
+		OnStateChange: func(from, to CircuitState) {
+			changes = append(changes, from.String()+"->"+to.String())
+		},
+	})
+
+	// errors that aren't failures of the backend don't count
+	flaky.failures, flaky.err = 5, errors.New("bad request")
+	for i := 0; i < 5; i++ {
+		require.Error(t, kv.Put(ctx, Key("k"), Value("v")))
+	}
+	require.Equal(t, CircuitClosed, kv.State())
+
+	flaky.failures, flaky.err = 3, ErrTransient
+	for i := 0; i < 3; i++ {
+		require.ErrorIs(t, kv.Put(ctx, Key("k"), Value("v")), ErrTransient)
+	}
+	require.Equal(t, CircuitOpen, kv.State())
+
+	// fails fast while open, without reaching the backend
+	calls := flaky.calls
+	_, _, err := kv.Get(ctx, Key("k"))
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	require.Equal(t, calls, flaky.calls)
+
+	// a failed probe opens it again
+	time.Sleep(25 * time.Millisecond)
+	flaky.failures = 1
+	require.ErrorIs(t, kv.Put(ctx, Key("k"), Value("v")), ErrTransient)
+	require.Equal(t, CircuitOpen, kv.State())
+
+	// a successful one closes it
+	time.Sleep(25 * time.Millisecond)
+	mustPut(ctx, t, kv, Key("k"), Value("v"))
+	require.Equal(t, CircuitClosed, kv.State())
+	mustFind(ctx, t, kv, Key("k"), Value("v"))
+
+	require.Equal(t, []string{
+		"closed->open",
+		"open->half-open", "half-open->open",
+		"open->half-open", "half-open->closed",
+	}, changes)
+}
+
+// panicKV panics on Put while `panics` is set.
+type panicKV struct {
+	TransactionalKV
+	panics bool
+}
+
+func (p *panicKV) Put(ctx context.Context, key Key, value Value) error {
+	if p.panics {
+		panic("boom")
+	}
+	return p.TransactionalKV.Put(ctx, key, value)
+}
+
+func TestCircuitBreakerProbePanics(t *testing.T) {
+	ctx := context.Background()
+	flaky := &flakyKV{TransactionalKV: InMem(), failures: 1, err: ErrTransient}
+	backend := &panicKV{TransactionalKV: flaky}
+	kv := WithCircuitBreaker(backend, CircuitBreakerOptions{FailureThreshold: 1, OpenTimeout: time.Millisecond})
+	require.ErrorIs(t, kv.Put(ctx, Key("k"), Value("v")), ErrTransient)
+	require.Equal(t, CircuitOpen, kv.State())
+
+	// a probe that panics doesn't keep others from probing
+	time.Sleep(2 * time.Millisecond)
+	backend.panics = true
+	require.Panics(t, func() { _ = kv.Put(ctx, Key("k"), Value("v")) })
+	require.Equal(t, CircuitHalfOpen, kv.State())
+	backend.panics = false
+	mustPut(ctx, t, kv, Key("k"), Value("v"))
+	require.Equal(t, CircuitClosed, kv.State())
+}
+
+// blockingKV blocks Get until `release` is closed.
+type blockingKV struct {
+	TransactionalKV
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingKV) Get(ctx context.Context, key Key) (Value, bool, error) {
+	close(b.started)
+	<-b.release
+	return b.TransactionalKV.Get(ctx, key)
+}
+
+func TestCircuitBreakerStaleOutcome(t *testing.T) {
+	ctx := context.Background()
+	flaky := &flakyKV{TransactionalKV: InMem(), failures: 1, err: ErrTransient}
+	backend := &blockingKV{TransactionalKV: flaky, started: make(chan struct{}), release: make(chan struct{})}
+	kv := WithCircuitBreaker(backend, CircuitBreakerOptions{FailureThreshold: 1, OpenTimeout: time.Hour})
+
+	// an operation let through before the breaker tripped...
+	done := make(chan error)
+	go func() {
+		_, _, err := kv.Get(ctx, Key("k"))
+		done <- err
+	}()
+	<-backend.started
+	require.ErrorIs(t, kv.Put(ctx, Key("k"), Value("v")), ErrTransient)
+	require.Equal(t, CircuitOpen, kv.State())
+
+	// ...doesn't close it when it succeeds
+	close(backend.release)
+	require.NoError(t, <-done)
+	require.Equal(t, CircuitOpen, kv.State())
+	_, _, err := kv.Get(ctx, Key("k"))
+	require.ErrorIs(t, err, ErrCircuitOpen)
+}
@@ -0,0 +1,123 @@
+package txkv
+
+import "context"
+
+// EntryIterator yields key-value pairs to load into a store. Any Iterator is
+// an EntryIterator.
+type EntryIterator interface {
+	Next(ctx context.Context) bool
+	Key() Key
+	Value() Value
+	Err() error
+}
+
+// BulkLoadOptions tune BulkLoad.
+type BulkLoadOptions struct {
+	// BatchSize is the number of entries written at once. Defaults to
+	// DefaultBulkLoadBatchSize.
+	BatchSize int
+	// OnProgress, if set, is called after each batch with the number of
+	// entries loaded so far.
+	OnProgress func(loaded int)
+}
+
+// DefaultBulkLoadBatchSize is the batch size of BulkLoad when none is set.
+const DefaultBulkLoadBatchSize = 1000
+
+// BulkLoader is implemented by stores that can load entries faster than
+// through transactions.
+type BulkLoader interface {
+	BulkLoad(ctx context.Context, iter EntryIterator, opts BulkLoadOptions) (int, error)
+}
+
+// BulkLoad writes all the entries of `iter` to `kv`, returning how many it
+// loaded. It's meant for initial loads: stores that are a BulkLoader write
+// straight into their underlying structure, others get a transaction per
+// batch. Each batch is atomic, but the load as a whole isn't; if it fails,
+// the entries of the batches already loaded stay.
+func BulkLoad(ctx context.Context, kv TransactionalKV, iter EntryIterator, opts BulkLoadOptions) (int, error) {
+	if bl, ok := kv.(BulkLoader); ok {
+		return bl.BulkLoad(ctx, iter, opts)
+	}
+	return bulkLoad(ctx, iter, opts, func(batch []entry) error {
+		tx, err := kv.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		for _, e := range batch {
+			if err := tx.Put(ctx, e.key, e.value); err != nil {
+				_ = tx.Rollback(ctx)
+				return err
+			}
+		}
+		return tx.Commit(ctx)
+	})
+}
+
+type entry struct {
+	key   Key
+	value Value
+}
+
+// bulkLoad reads `iter` in batches, handing each one to `load`.
+func bulkLoad(ctx context.Context, iter EntryIterator, opts BulkLoadOptions, load func([]entry) error) (int, error) {
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBulkLoadBatchSize
+	}
+	loaded := 0
+	batch := make([]entry, 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := load(batch); err != nil {
+			return err
+		}
+		loaded += len(batch)
+		batch = batch[:0]
+		if opts.OnProgress != nil {
+			opts.OnProgress(loaded)
+		}
+		return nil
+	}
+	for iter.Next(ctx) {
+		batch = append(batch, entry{key: iter.Key(), value: iter.Value()})
+		if len(batch) == batchSize {
+			if err := flush(); err != nil {
+				return loaded, err
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return loaded, err
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return loaded, err
+	}
+	return loaded, flush()
+}
+
+// SliceIterator returns an EntryIterator over `keys` and `values`, which
+// must have the same length.
+func SliceIterator(keys []Key, values []Value) EntryIterator {
+	return &sliceIterator{keys: keys, values: values, i: -1}
+}
+
+type sliceIterator struct {
+	keys   []Key
+	values []Value
+	i      int
+}
+
+func (it *sliceIterator) Next(ctx context.Context) bool {
+	if it.i+1 >= len(it.keys) {
+		return false
+	}
+	it.i++
+	return true
+}
+
+func (it *sliceIterator) Key() Key     { return it.keys[it.i] }
+func (it *sliceIterator) Value() Value { return it.values[it.i] }
+func (it *sliceIterator) Err() error   { return nil }
@@ -0,0 +1,62 @@
+package txkv_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestBulkLoad(t *testing.T) {
+	ctx := context.Background()
+	var (
+		keys   []Key
+		values []Value
+	)
+	for i := 0; i < 250; i++ {
+		keys = append(keys, Key(fmt.Sprintf("k/%03d", i)))
+		values = append(values, Value(fmt.Sprint(i)))
+	}
+
+	kvs := map[string]TransactionalKV{
+		"inmem":   InMem(),
+		"wrapped": WithMaintenance(InMem()),
+	}
+	for name, kv := range kvs {
+		t.Run(name, func(t *testing.T) {
+			var progress []int
+			n, err := BulkLoad(ctx, kv, SliceIterator(keys, values), BulkLoadOptions{
+				BatchSize:  100,
+				OnProgress: func(loaded int) { progress = append(progress, loaded) },
+			})
+			require.NoError(t, err)
+			require.Equal(t, 250, n)
+			require.Equal(t, []int{100, 200, 250}, progress)
+			mustList(ctx, t, kv, Key("k/"), keys)
+			mustFind(ctx, t, kv, Key("k/042"), Value("42"))
+
+			// loading from another store
+			dst := InMem()
+			its, err := ScanPartitions(ctx, kv, Key(""), 1)
+			require.NoError(t, err)
+			n, err = BulkLoad(ctx, dst, its[0], BulkLoadOptions{})
+			require.NoError(t, err)
+			require.Equal(t, 250, n)
+			mustList(ctx, t, dst, Key(""), keys)
+		})
+	}
+}
+
+func TestBulkLoadDuplicates(t *testing.T) {
+	ctx := context.Background()
+	kv := InMem()
+	keys := []Key{Key("b"), Key("a"), Key("b")}
+	values := []Value{Value("first"), Value("a"), Value("last")}
+	_, err := BulkLoad(ctx, kv, SliceIterator(keys, values), BulkLoadOptions{})
+	require.NoError(t, err)
+	mustList(ctx, t, kv, Key(""), []Key{Key("a"), Key("b")})
+	mustFind(ctx, t, kv, Key("b"), Value("last"))
+}
@@ -0,0 +1,28 @@
+package txkv
+
+import (
+	"context"
+	"errors"
+)
+
+// ChangeTracker is implemented by stores that keep track of when each key was
+// last modified, so that incremental sync jobs can find what changed without
+// comparing values or watching the store. The in-memory store implements it.
+//
+// A typical sync job notes Seq, copies what it needs, then later asks for the
+// keys modified since the noted Seq.
+type ChangeTracker interface {
+	// Seq returns the sequence number of the last commit. Every commit,
+	// whether a transaction or a single Put or Delete, gets a greater one.
+	Seq(ctx context.Context) (uint64, error)
+	// ListModifiedSince lists the keys with `prefix` that were modified by a
+	// commit after `since`. Keys deleted since then are listed too, and won't
+	// be found. It fails with ErrCompacted if the deletions since then were
+	// forgotten.
+	ListModifiedSince(ctx context.Context, prefix Key, since uint64) ([]Key, error)
+}
+
+// ErrCompacted is returned by ListModifiedSince when the store forgot the
+// keys deleted since the given seq, to release their memory. Callers must
+// then compare everything again.
+var ErrCompacted = errors.New("txkv: changes since that seq were compacted")
@@ -0,0 +1,64 @@
+package txkv_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestListModifiedSince(t *testing.T) {
+	ctx := context.Background()
+	kv := InMem()
+	tracker, ok := kv.(ChangeTracker)
+	require.True(t, ok)
+
+	mustPut(ctx, t, kv, Key("a/1"), Value("v"))
+	mustPut(ctx, t, kv, Key("a/2"), Value("v"))
+	mustPut(ctx, t, kv, Key("b/1"), Value("v"))
+
+	seq, err := tracker.Seq(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), seq)
+
+	keys, err := tracker.ListModifiedSince(ctx, Key("a/"), 0)
+	require.NoError(t, err)
+	require.Equal(t, []Key{Key("a/1"), Key("a/2")}, keys)
+
+	keys, err = tracker.ListModifiedSince(ctx, Key("a/"), seq)
+	require.NoError(t, err)
+	require.Empty(t, keys)
+
+	// a transaction is a single commit
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	mustPut(ctx, t, tx, Key("a/3"), Value("v"))
+	mustDelete(ctx, t, tx, Key("a/1"))
+	mustPut(ctx, t, tx, Key("b/1"), Value("v2"))
+	require.NoError(t, tx.Commit(ctx))
+
+	next, err := tracker.Seq(ctx)
+	require.NoError(t, err)
+	require.Equal(t, seq+1, next)
+
+	// deleted keys show up too
+	keys, err = tracker.ListModifiedSince(ctx, Key("a/"), seq)
+	require.NoError(t, err)
+	require.Equal(t, []Key{Key("a/1"), Key("a/3")}, keys)
+	mustNotFind(ctx, t, kv, Key("a/1"))
+
+	keys, err = tracker.ListModifiedSince(ctx, Key(""), seq)
+	require.NoError(t, err)
+	require.Equal(t, []Key{Key("a/1"), Key("a/3"), Key("b/1")}, keys)
+
+	// rolled back transactions change nothing
+	tx, err = kv.Begin(ctx)
+	require.NoError(t, err)
+	mustPut(ctx, t, tx, Key("a/4"), Value("v"))
+	require.NoError(t, tx.Rollback(ctx))
+	keys, err = tracker.ListModifiedSince(ctx, Key(""), next)
+	require.NoError(t, err)
+	require.Empty(t, keys)
+}
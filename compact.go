@@ -0,0 +1,14 @@
+package txkv
+
+import "context"
+
+// Compacter is implemented by stores that hold on to memory for deleted keys,
+// and can release it. The in-memory store remembers deleted keys for
+// ListModifiedSince: once compacted, it fails with ErrCompacted for seqs
+// before the compaction, and the keys deleted until then are forgotten.
+// WithAutoCompact has it compact on its own.
+type Compacter interface {
+	// Compact releases the memory held for deleted keys, and returns how
+	// many it forgot.
+	Compact(ctx context.Context) (int, error)
+}
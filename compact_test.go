@@ -0,0 +1,65 @@
+package txkv_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestCompact(t *testing.T) {
+	ctx := context.Background()
+	kv := InMem()
+	tracker := kv.(ChangeTracker)
+	mustPut(ctx, t, kv, Key("a"), Value("v"))
+	mustPut(ctx, t, kv, Key("b"), Value("v"))
+	mustDelete(ctx, t, kv, Key("b"))
+	before, err := tracker.Seq(ctx)
+	require.NoError(t, err)
+
+	n, err := kv.(Compacter).Compact(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	n, err = kv.(Compacter).Compact(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	_, err = tracker.ListModifiedSince(ctx, Key(""), before-1)
+	require.ErrorIs(t, err, ErrCompacted)
+	keys, err := tracker.ListModifiedSince(ctx, Key(""), before)
+	require.NoError(t, err)
+	require.Empty(t, keys)
+
+	mustPut(ctx, t, kv, Key("c"), Value("v"))
+	mustDelete(ctx, t, kv, Key("a"))
+	keys, err = tracker.ListModifiedSince(ctx, Key(""), before)
+	require.NoError(t, err)
+	require.Equal(t, []Key{Key("a"), Key("c")}, keys)
+	mustList(ctx, t, kv, Key(""), []Key{Key("c")})
+}
+
+func TestAutoCompact(t *testing.T) {
+	ctx := context.Background()
+	kv := InMem(WithAutoCompact(1))
+	tracker := kv.(ChangeTracker)
+	mustPut(ctx, t, kv, Key("live"), Value("v"))
+	for i := 0; i < MinAutoCompact; i++ {
+		key := Key(fmt.Sprintf("dead/%d", i))
+		mustPut(ctx, t, kv, key, Value("v"))
+		mustDelete(ctx, t, kv, key)
+		if i < MinAutoCompact-1 {
+			_, err := tracker.ListModifiedSince(ctx, Key(""), 0)
+			require.NoError(t, err)
+		}
+	}
+	_, err := tracker.ListModifiedSince(ctx, Key(""), 0)
+	require.ErrorIs(t, err, ErrCompacted)
+	mustFind(ctx, t, kv, Key("live"), Value("v"))
+
+	n, err := kv.(Compacter).Compact(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+}
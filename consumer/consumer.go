@@ -0,0 +1,250 @@
+// Package consumer delivers the changes made to a txkv store under a prefix
+// to named, durable consumer groups.
+//
+// The keys are spread over a fixed number of partitions by hash. Each member
+// of a group is given its share of the partitions, as member `index` of
+// `count`, and each partition keeps the seq its changes were acked up to in
+// a state store. Every change is thus delivered to a single member of the
+// group, as long as its members agree on their count, and delivered again
+// if it isn't acked. Delivery is at-least-once: handlers must be idempotent.
+//
+// A change is a key modified since the last ack: successive changes of a key
+// between two fetches are delivered once, with its current value. The source
+// must be a txkv.ChangeTracker.
+package consumer
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"time"
+
+	"github.com/aybabtme/txkv"
+)
+
+// DefaultStatePrefix is the default location of the state of the groups.
+var DefaultStatePrefix = txkv.Key("__consumer/")
+
+// DefaultRunInterval is how often Run fetches changes when given an interval
+// of 0.
+const DefaultRunInterval = time.Second
+
+// ErrPartitionsChanged is returned when a group is opened with a number of
+// partitions other than the one it was created with.
+var ErrPartitionsChanged = errors.New("consumer: the number of partitions of the group changed")
+
+// Options tune a Group.
+type Options struct {
+	// StatePrefix is where the state of the groups is stored. Defaults to
+	// DefaultStatePrefix.
+	StatePrefix txkv.Key
+	// Partitions is the number of partitions of the keys, which bounds the
+	// number of members a group can usefully have. It can't change once the
+	// group is created. Defaults to 16.
+	Partitions int
+}
+
+// Event is a change of a key.
+type Event struct {
+	Key     txkv.Key
+	Value   txkv.Value
+	Deleted bool
+}
+
+// Group is a named consumer group of the changes made to the keys of a source
+// under a prefix.
+type Group struct {
+	source  txkv.KV
+	tracker txkv.ChangeTracker
+	prefix  txkv.Key
+	state   txkv.TransactionalKV
+	name    string
+	opts    Options
+}
+
+// NewGroup opens the group `name` of the changes made to `source` under
+// `prefix`, keeping its state in `state`. The group is created if it doesn't
+// exist, starting from the whole prefix.
+func NewGroup(ctx context.Context, source txkv.KV, prefix txkv.Key, state txkv.TransactionalKV, name string, opts Options) (*Group, error) {
+	tracker, ok := source.(txkv.ChangeTracker)
+	if !ok {
+		return nil, errors.New("consumer: the source must be a ChangeTracker")
+	}
+	if opts.StatePrefix == nil {
+		opts.StatePrefix = DefaultStatePrefix
+	}
+	if opts.Partitions <= 0 {
+		opts.Partitions = 16
+	}
+	g := &Group{source: source, tracker: tracker, prefix: prefix, state: state, name: name, opts: opts}
+
+	want := strconv.Itoa(opts.Partitions)
+	got, ok, err := state.Get(ctx, g.stateKey("partitions"))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if err := state.Put(ctx, g.stateKey("partitions"), txkv.Value(want)); err != nil {
+			return nil, err
+		}
+	} else if string(got) != want {
+		return nil, fmt.Errorf("%w: group %q has %s partitions, not %s", ErrPartitionsChanged, name, got, want)
+	}
+	return g, nil
+}
+
+// Member returns member `index` of a group of `count` members.
+func (g *Group) Member(index, count int) (*Member, error) {
+	if count <= 0 || index < 0 || index >= count {
+		return nil, fmt.Errorf("consumer: no member %d of %d", index, count)
+	}
+	m := &Member{g: g}
+	for p := index; p < g.opts.Partitions; p += count {
+		m.partitions = append(m.partitions, p)
+	}
+	return m, nil
+}
+
+// partition returns the partition of `key`.
+func (g *Group) partition(key txkv.Key) int {
+	h := fnv.New32a()
+	h.Write(key)
+	return int(h.Sum32() % uint32(g.opts.Partitions))
+}
+
+func (g *Group) stateKey(name string) txkv.Key {
+	k := append(txkv.Key(nil), g.opts.StatePrefix...)
+	k = append(k, g.name...)
+	k = append(k, '/')
+	return append(k, name...)
+}
+
+func (g *Group) cursorKey(p int) txkv.Key {
+	return g.stateKey("cursor/" + strconv.Itoa(p))
+}
+
+// Member is a member of a group, consuming the changes of its partitions.
+type Member struct {
+	g          *Group
+	partitions []int
+}
+
+// Batch is the changes fetched by a member. They're delivered again until
+// they're acked.
+type Batch struct {
+	Events []Event
+
+	m   *Member
+	seq uint64
+}
+
+// Fetch returns the changes of the member's partitions not acked yet, which
+// may be none.
+func (m *Member) Fetch(ctx context.Context) (*Batch, error) {
+	g := m.g
+	// note the seq first: keys modified meanwhile are fetched now, and again
+	// next time
+	seq, err := g.tracker.Seq(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// partitions acked up to the same seq are listed at once
+	bySeq := make(map[uint64][]int)
+	for _, p := range m.partitions {
+		cursor, err := m.cursor(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		bySeq[cursor] = append(bySeq[cursor], p)
+	}
+	b := &Batch{m: m, seq: seq}
+	for since, partitions := range bySeq {
+		if since == seq {
+			continue
+		}
+		keys, err := g.tracker.ListModifiedSince(ctx, g.prefix, since)
+		if errors.Is(err, txkv.ErrCompacted) {
+			// the deletions are lost, but the keys are still around
+			keys, err = g.source.List(ctx, g.prefix)
+		}
+		if err != nil {
+			return nil, err
+		}
+		mine := make(map[int]bool, len(partitions))
+		for _, p := range partitions {
+			mine[p] = true
+		}
+		for _, key := range keys {
+			if !mine[g.partition(key)] {
+				continue
+			}
+			v, ok, err := g.source.Get(ctx, key)
+			if err != nil {
+				return nil, err
+			}
+			b.Events = append(b.Events, Event{Key: key, Value: v, Deleted: !ok})
+		}
+	}
+	return b, nil
+}
+
+// Ack records that the changes of the batch were processed.
+func (b *Batch) Ack(ctx context.Context) error {
+	g := b.m.g
+	tx, err := g.state.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	for _, p := range b.m.partitions {
+		if err := tx.Put(ctx, g.cursorKey(p), binary.AppendUvarint(nil, b.seq)); err != nil {
+			_ = tx.Rollback(ctx)
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// Run fetches changes every `interval`, handing them to `handle` and acking
+// them once it returns nil, until `ctx` is done or `handle` fails. An
+// `interval` of 0 means DefaultRunInterval.
+func (m *Member) Run(ctx context.Context, interval time.Duration, handle func(ctx context.Context, events []Event) error) error {
+	if interval <= 0 {
+		interval = DefaultRunInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		b, err := m.Fetch(ctx)
+		if err != nil {
+			return err
+		}
+		if len(b.Events) > 0 {
+			if err := handle(ctx, b.Events); err != nil {
+				return err
+			}
+		}
+		if err := b.Ack(ctx); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Member) cursor(ctx context.Context, p int) (uint64, error) {
+	b, ok, err := m.g.state.Get(ctx, m.g.cursorKey(p))
+	if err != nil || !ok {
+		return 0, err
+	}
+	seq, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, fmt.Errorf("consumer: corrupted cursor at %q", m.g.cursorKey(p))
+	}
+	return seq, nil
+}
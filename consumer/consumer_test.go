@@ -0,0 +1,136 @@
+package consumer_test
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aybabtme/txkv"
+	"github.com/aybabtme/txkv/consumer"
+)
+
+func keys(events []consumer.Event) []string {
+	var out []string
+	for _, e := range events {
+		out = append(out, string(e.Key))
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestGroup(t *testing.T) {
+	ctx := context.Background()
+	source, state := txkv.InMem(), txkv.InMem()
+	for i := 0; i < 20; i++ {
+		require.NoError(t, source.Put(ctx, txkv.Key(fmt.Sprintf("job/%02d", i)), txkv.Value("todo")))
+	}
+	require.NoError(t, source.Put(ctx, txkv.Key("other"), txkv.Value("ignored")))
+
+	g, err := consumer.NewGroup(ctx, source, txkv.Key("job/"), state, "workers", consumer.Options{Partitions: 4})
+	require.NoError(t, err)
+	m0, err := g.Member(0, 2)
+	require.NoError(t, err)
+	m1, err := g.Member(1, 2)
+	require.NoError(t, err)
+
+	// members split the keys between them
+	b0, err := m0.Fetch(ctx)
+	require.NoError(t, err)
+	b1, err := m1.Fetch(ctx)
+	require.NoError(t, err)
+	require.NotEmpty(t, b0.Events)
+	require.NotEmpty(t, b1.Events)
+	all := append(keys(b0.Events), keys(b1.Events)...)
+	sort.Strings(all)
+	require.Len(t, all, 20)
+	for i, key := range all {
+		require.Equal(t, fmt.Sprintf("job/%02d", i), key)
+	}
+
+	// unacked changes are delivered again, acked ones aren't
+	require.NoError(t, b0.Ack(ctx))
+	again0, err := m0.Fetch(ctx)
+	require.NoError(t, err)
+	require.Empty(t, again0.Events)
+	again1, err := m1.Fetch(ctx)
+	require.NoError(t, err)
+	require.Equal(t, keys(b1.Events), keys(again1.Events))
+	require.NoError(t, again1.Ack(ctx))
+
+	// new changes, deletions included, go to the member of their partition
+	require.NoError(t, source.Delete(ctx, txkv.Key("job/03")))
+	require.NoError(t, source.Put(ctx, txkv.Key("job/04"), txkv.Value("done")))
+	b0, err = m0.Fetch(ctx)
+	require.NoError(t, err)
+	b1, err = m1.Fetch(ctx)
+	require.NoError(t, err)
+	events := append(b0.Events, b1.Events...)
+	sort.Slice(events, func(i, j int) bool { return string(events[i].Key) < string(events[j].Key) })
+	require.Equal(t, []consumer.Event{
+		{Key: txkv.Key("job/03"), Deleted: true},
+		{Key: txkv.Key("job/04"), Value: txkv.Value("done")},
+	}, events)
+
+	// a group reopened with other partitions is refused
+	_, err = consumer.NewGroup(ctx, source, txkv.Key("job/"), state, "workers", consumer.Options{Partitions: 8})
+	require.ErrorIs(t, err, consumer.ErrPartitionsChanged)
+	// other groups have their own cursors
+	other, err := consumer.NewGroup(ctx, source, txkv.Key("job/"), state, "auditors", consumer.Options{})
+	require.NoError(t, err)
+	solo, err := other.Member(0, 1)
+	require.NoError(t, err)
+	b, err := solo.Fetch(ctx)
+	require.NoError(t, err)
+	require.Len(t, b.Events, 20)
+	require.Equal(t, consumer.Event{Key: txkv.Key("job/03"), Deleted: true}, b.Events[3])
+
+	_, err = g.Member(2, 2)
+	require.Error(t, err)
+}
+
+func TestRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	source, state := txkv.InMem(), txkv.InMem()
+	require.NoError(t, source.Put(ctx, txkv.Key("job/a"), txkv.Value("todo")))
+	g, err := consumer.NewGroup(ctx, source, txkv.Key("job/"), state, "workers", consumer.Options{})
+	require.NoError(t, err)
+	m, err := g.Member(0, 1)
+	require.NoError(t, err)
+
+	var got []string
+	err = m.Run(ctx, 1, func(ctx context.Context, events []consumer.Event) error {
+		got = append(got, keys(events)...)
+		cancel()
+		return nil
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, []string{"job/a"}, got)
+
+	b, err := m.Fetch(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, b.Events)
+}
+
+func TestRunDefaultInterval(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	source, state := txkv.InMem(), txkv.InMem()
+	require.NoError(t, source.Put(ctx, txkv.Key("job/a"), txkv.Value("todo")))
+	g, err := consumer.NewGroup(ctx, source, txkv.Key("job/"), state, "workers", consumer.Options{})
+	require.NoError(t, err)
+	m, err := g.Member(0, 1)
+	require.NoError(t, err)
+
+	var got []string
+	err = m.Run(ctx, 0, func(ctx context.Context, events []consumer.Event) error {
+		got = append(got, keys(events)...)
+		return nil
+	})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, []string{"job/a"}, got)
+}
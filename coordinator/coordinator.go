@@ -0,0 +1,284 @@
+// Package coordinator runs transactions spanning several independent stores,
+// like two shards and the store of their index, so that their writes are
+// either all made or none are.
+//
+// Writes are buffered until commit. Each participant then gets to prepare
+// its writes, and may refuse them. Once all have accepted, the decision to
+// commit is recorded in a designated store along with the writes: that's
+// the commit point. The writes are then applied to each participant, in its
+// own transaction, and the record is deleted. A commit interrupted by a
+// crash is completed by Recover.
+//
+// Stores only offer read-committed transactions and no locks, so other
+// users of the participants can see the writes of a transaction applied to
+// some participants and not yet to others.
+package coordinator
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/aybabtme/txkv"
+)
+
+// Participant is a store taking part in transactions.
+type Participant struct {
+	Name string
+	KV   txkv.TransactionalKV
+	// Prepare, if set, is given the writes to the participant before they're
+	// committed, and refuses them by failing. It must not write them.
+	Prepare func(ctx context.Context, ops []txkv.Op) error
+}
+
+// Options tune a Coordinator.
+type Options struct {
+	// StatePrefix is where commit decisions are recorded. Defaults to
+	// DefaultStatePrefix.
+	StatePrefix txkv.Key
+	// Retry tells how to retry applying the writes to a participant. Its
+	// Retryable defaults to txkv.IsTransient.
+	Retry txkv.RetryPolicy
+}
+
+// DefaultStatePrefix is the default location of the commit decisions.
+var DefaultStatePrefix = txkv.Key("__coordinator/")
+
+var (
+	// ErrAborted is matched by the errors returned when a participant
+	// refuses to prepare a transaction. Nothing was written.
+	ErrAborted = errors.New("coordinator: transaction aborted")
+	// ErrIncomplete is matched by the errors returned when a transaction was
+	// committed, but its writes couldn't all be applied. Recover completes
+	// it.
+	ErrIncomplete = errors.New("coordinator: transaction committed but incomplete")
+	// ErrUnknownParticipant is returned when naming a participant the
+	// coordinator doesn't have.
+	ErrUnknownParticipant = errors.New("coordinator: unknown participant")
+	// ErrDone is returned when using a transaction after its end.
+	ErrDone = errors.New("coordinator: transaction already committed or rolled back")
+)
+
+// Coordinator runs transactions across participants.
+type Coordinator struct {
+	state        txkv.KV
+	participants map[string]Participant
+	opts         Options
+}
+
+// New returns a Coordinator of `participants`, recording its decisions in
+// `state`. Their names must be unique, and stay the same across restarts for
+// Recover to find them.
+func New(state txkv.KV, participants []Participant, opts Options) (*Coordinator, error) {
+	if opts.StatePrefix == nil {
+		opts.StatePrefix = DefaultStatePrefix
+	}
+	c := &Coordinator{state: state, participants: make(map[string]Participant), opts: opts}
+	for _, p := range participants {
+		if _, ok := c.participants[p.Name]; ok {
+			return nil, fmt.Errorf("coordinator: participant %q appears twice", p.Name)
+		}
+		c.participants[p.Name] = p
+	}
+	return c, nil
+}
+
+// record is a commit decision: the writes to make to each participant.
+type record struct {
+	Ops map[string][]txkv.Op `json:"ops"`
+}
+
+// Begin starts a transaction.
+func (c *Coordinator) Begin() *Tx {
+	return &Tx{c: c, ops: make(map[string][]txkv.Op)}
+}
+
+// Recover completes the transactions that were committed but not fully
+// applied, and returns how many it completed. It must run before new
+// transactions are, since applying writes again overwrites those made since.
+func (c *Coordinator) Recover(ctx context.Context) (int, error) {
+	keys, err := c.state.List(ctx, c.opts.StatePrefix)
+	if err != nil {
+		return 0, err
+	}
+	for i, key := range keys {
+		v, ok, err := c.state.Get(ctx, key)
+		if err != nil {
+			return i, err
+		}
+		if !ok {
+			continue
+		}
+		var rec record
+		if err := json.Unmarshal(v, &rec); err != nil {
+			return i, fmt.Errorf("coordinator: decoding %q: %w", key, err)
+		}
+		if err := c.apply(ctx, key, rec); err != nil {
+			return i, err
+		}
+	}
+	return len(keys), nil
+}
+
+// apply applies the writes of a committed transaction, and forgets it.
+func (c *Coordinator) apply(ctx context.Context, key txkv.Key, rec record) error {
+	names := make([]string, 0, len(rec.Ops))
+	for name := range rec.Ops {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p, ok := c.participants[name]
+		if !ok {
+			return fmt.Errorf("%w: %q", ErrUnknownParticipant, name)
+		}
+		err := txkv.Retry(ctx, c.opts.Retry, func(ctx context.Context) error {
+			return txkv.Apply(ctx, p.KV, rec.Ops[name])
+		})
+		if err != nil {
+			return fmt.Errorf("%w: applying to %q: %v", ErrIncomplete, name, err)
+		}
+	}
+	return c.state.Delete(ctx, key)
+}
+
+// Tx is a transaction across participants.
+type Tx struct {
+	c    *Coordinator
+	ops  map[string][]txkv.Op
+	done bool
+}
+
+// Store returns the view of participant `name` within the transaction:
+// reads go to the participant, and see the writes of the transaction, which
+// are buffered until commit.
+func (tx *Tx) Store(name string) (txkv.KV, error) {
+	p, ok := tx.c.participants[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownParticipant, name)
+	}
+	return &store{tx: tx, name: name, kv: p.KV}, nil
+}
+
+// Commit prepares the writes with each participant, records the decision,
+// then applies them. It fails with ErrAborted if a participant refused
+// them, and ErrIncomplete if they were committed but not all applied.
+func (tx *Tx) Commit(ctx context.Context) error {
+	if tx.done {
+		return ErrDone
+	}
+	tx.done = true
+	rec := record{Ops: make(map[string][]txkv.Op)}
+	for name, ops := range tx.ops {
+		if len(ops) > 0 {
+			rec.Ops[name] = ops
+		}
+	}
+	if len(rec.Ops) == 0 {
+		return nil
+	}
+	for name, ops := range rec.Ops {
+		if prepare := tx.c.participants[name].Prepare; prepare != nil {
+			if err := prepare(ctx, ops); err != nil {
+				return fmt.Errorf("%w: %q refused: %v", ErrAborted, name, err)
+			}
+		}
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	key := append(append(txkv.Key(nil), tx.c.opts.StatePrefix...), hex.EncodeToString(id)...)
+	v, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if err := tx.c.state.Put(ctx, key, v); err != nil {
+		return err
+	}
+	return tx.c.apply(ctx, key, rec)
+}
+
+// Rollback discards the writes of the transaction.
+func (tx *Tx) Rollback(ctx context.Context) error {
+	if tx.done {
+		return ErrDone
+	}
+	tx.done, tx.ops = true, nil
+	return nil
+}
+
+type store struct {
+	tx   *Tx
+	name string
+	kv   txkv.KV
+}
+
+func (s *store) write(op txkv.Op) error {
+	if s.tx.done {
+		return ErrDone
+	}
+	s.tx.ops[s.name] = append(s.tx.ops[s.name], op)
+	return nil
+}
+
+func (s *store) Put(ctx context.Context, key txkv.Key, value txkv.Value) error {
+	return s.write(txkv.PutOp(key, value))
+}
+
+func (s *store) Delete(ctx context.Context, key txkv.Key) error {
+	return s.write(txkv.DeleteOp(key))
+}
+
+// last returns the last write of the transaction to `key`, if any.
+func (s *store) last(key txkv.Key) (txkv.Op, bool) {
+	ops := s.tx.ops[s.name]
+	for i := len(ops) - 1; i >= 0; i-- {
+		if bytes.Equal(ops[i].Key, key) {
+			return ops[i], true
+		}
+	}
+	return txkv.Op{}, false
+}
+
+func (s *store) Get(ctx context.Context, key txkv.Key) (txkv.Value, bool, error) {
+	if s.tx.done {
+		return nil, false, ErrDone
+	}
+	if op, ok := s.last(key); ok {
+		return op.Value, !op.Delete, nil
+	}
+	return s.kv.Get(ctx, key)
+}
+
+func (s *store) List(ctx context.Context, prefix txkv.Key) ([]txkv.Key, error) {
+	if s.tx.done {
+		return nil, ErrDone
+	}
+	keys, err := s.kv.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	listed := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		listed[string(key)] = true
+	}
+	for _, op := range s.tx.ops[s.name] {
+		if bytes.HasPrefix(op.Key, prefix) {
+			listed[string(op.Key)] = !op.Delete
+		}
+	}
+	out := keys[:0]
+	for key, ok := range listed {
+		if ok {
+			out = append(out, txkv.Key(key))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return bytes.Compare(out[i], out[j]) < 0 })
+	return out, nil
+}
@@ -0,0 +1,114 @@
+package coordinator_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aybabtme/txkv"
+	"github.com/aybabtme/txkv/coordinator"
+)
+
+// brokenKV fails to begin transactions while broken.
+type brokenKV struct {
+	txkv.TransactionalKV
+	broken bool
+}
+
+var errBroken = errors.New("broken")
+
+func (b *brokenKV) Begin(ctx context.Context) (txkv.TxKV, error) {
+	if b.broken {
+		return nil, errBroken
+	}
+	return b.TransactionalKV.Begin(ctx)
+}
+
+func mustGet(t *testing.T, kv txkv.KV, key string) string {
+	t.Helper()
+	v, ok, err := kv.Get(context.Background(), txkv.Key(key))
+	require.NoError(t, err)
+	if !ok {
+		return "<none>"
+	}
+	return string(v)
+}
+
+func TestCoordinator(t *testing.T) {
+	ctx := context.Background()
+	shard0, shard1, index := txkv.InMem(), txkv.InMem(), &brokenKV{TransactionalKV: txkv.InMem()}
+	state := txkv.InMem()
+	var refused error
+	c, err := coordinator.New(state, []coordinator.Participant{
+		{Name: "shard0", KV: shard0},
+		{Name: "shard1", KV: shard1},
+		{Name: "index", KV: index, Prepare: func(ctx context.Context, ops []txkv.Op) error { return refused }},
+	}, coordinator.Options{Retry: txkv.RetryPolicy{MaxAttempts: 1}})
+	require.NoError(t, err)
+	require.NoError(t, shard0.Put(ctx, txkv.Key("user/ann"), txkv.Value("paris")))
+
+	// moving a user across shards, and updating the index
+	move := func() *coordinator.Tx {
+		tx := c.Begin()
+		s0, err := tx.Store("shard0")
+		require.NoError(t, err)
+		s1, err := tx.Store("shard1")
+		require.NoError(t, err)
+		idx, err := tx.Store("index")
+		require.NoError(t, err)
+		require.Equal(t, "paris", mustGet(t, s0, "user/ann"))
+		require.NoError(t, s1.Put(ctx, txkv.Key("user/ann"), txkv.Value("paris")))
+		require.NoError(t, s0.Delete(ctx, txkv.Key("user/ann")))
+		require.NoError(t, idx.Put(ctx, txkv.Key("ann"), txkv.Value("shard1")))
+		// the transaction sees its writes, others don't
+		require.Equal(t, "<none>", mustGet(t, s0, "user/ann"))
+		keys, err := s1.List(ctx, txkv.Key("user/"))
+		require.NoError(t, err)
+		require.Equal(t, []txkv.Key{txkv.Key("user/ann")}, keys)
+		require.Equal(t, "paris", mustGet(t, shard0, "user/ann"))
+		return tx
+	}
+
+	// a participant refuses
+	refused = errors.New("nope")
+	require.ErrorIs(t, move().Commit(ctx), coordinator.ErrAborted)
+	require.Equal(t, "paris", mustGet(t, shard0, "user/ann"))
+	require.Equal(t, "<none>", mustGet(t, shard1, "user/ann"))
+	refused = nil
+
+	// rolled back
+	tx := move()
+	require.NoError(t, tx.Rollback(ctx))
+	require.ErrorIs(t, tx.Commit(ctx), coordinator.ErrDone)
+	require.Equal(t, "paris", mustGet(t, shard0, "user/ann"))
+
+	// committed, but a participant fails midway
+	index.broken = true
+	require.ErrorIs(t, move().Commit(ctx), coordinator.ErrIncomplete)
+	require.Equal(t, "<none>", mustGet(t, index, "ann"))
+
+	// a restarted coordinator completes it
+	index.broken = false
+	c, err = coordinator.New(state, []coordinator.Participant{
+		{Name: "shard0", KV: shard0},
+		{Name: "shard1", KV: shard1},
+		{Name: "index", KV: index},
+	}, coordinator.Options{})
+	require.NoError(t, err)
+	n, err := c.Recover(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.Equal(t, "<none>", mustGet(t, shard0, "user/ann"))
+	require.Equal(t, "paris", mustGet(t, shard1, "user/ann"))
+	require.Equal(t, "shard1", mustGet(t, index, "ann"))
+	n, err = c.Recover(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	_, err = c.Begin().Store("nope")
+	require.ErrorIs(t, err, coordinator.ErrUnknownParticipant)
+	_, err = coordinator.New(state, []coordinator.Participant{{Name: "a"}, {Name: "a"}}, coordinator.Options{})
+	require.Error(t, err)
+}
@@ -0,0 +1,58 @@
+// Package counter maintains counters in a txkv store, for analytics kept
+// directly in the store: exact counters, and approximate counts of distinct
+// items with HyperLogLog sketches.
+//
+// Counters are updated within a transaction, so they can be committed along
+// with the writes they count; they're read from any store, or transaction.
+// Updates read the counter and write it back: for concurrent ones not to
+// overwrite each other, the store must fail transactions that read a key
+// written since, with an error matching txkv.ErrConflict, like InMem, Disk
+// and txkvbadger do, or serialize them, like txkvbolt does. Run them with
+// txkv.RetryTx to retry those conflicts.
+package counter
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/aybabtme/txkv"
+)
+
+// Add adds `delta` to the counter at `key` as part of `tx`, starting from 0
+// if it doesn't exist, and returns its new value.
+func Add(ctx context.Context, tx txkv.TxKV, key txkv.Key, delta int64) (int64, error) {
+	n, err := Get(ctx, tx, key)
+	if err != nil {
+		return 0, err
+	}
+	n += delta
+	return n, tx.Put(ctx, key, binary.BigEndian.AppendUint64(nil, uint64(n)))
+}
+
+// Get returns the value of the counter at `key`, 0 if it doesn't exist.
+func Get(ctx context.Context, kv txkv.KV, key txkv.Key) (int64, error) {
+	v, ok, err := kv.Get(ctx, key)
+	if err != nil || !ok {
+		return 0, err
+	}
+	if len(v) != 8 {
+		return 0, fmt.Errorf("counter: %q isn't a counter", key)
+	}
+	return int64(binary.BigEndian.Uint64(v)), nil
+}
+
+// Merge adds the counters at `srcs` to the one at `dst` as part of `tx`, like
+// when rolling up the counters of shards or periods, and returns its new
+// value. The counters at `srcs` are left as they are.
+func Merge(ctx context.Context, tx txkv.TxKV, dst txkv.Key, srcs ...txkv.Key) (int64, error) {
+	var sum int64
+	for _, src := range srcs {
+		n, err := Get(ctx, tx, src)
+		if err != nil {
+			return 0, err
+		}
+		sum += n
+	}
+	return Add(ctx, tx, dst, sum)
+}
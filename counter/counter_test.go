@@ -0,0 +1,88 @@
+package counter_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aybabtme/txkv"
+	"github.com/aybabtme/txkv/counter"
+)
+
+// inTx runs `fn` in a transaction of `kv`, and commits it.
+func inTx(t *testing.T, kv txkv.TransactionalKV, fn func(tx txkv.TxKV)) {
+	t.Helper()
+	ctx := context.Background()
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	fn(tx)
+	require.NoError(t, tx.Commit(ctx))
+}
+
+func TestCounter(t *testing.T) {
+	ctx := context.Background()
+	kv := txkv.InMem()
+	inTx(t, kv, func(tx txkv.TxKV) {
+		n, err := counter.Add(ctx, tx, txkv.Key("views/mon"), 3)
+		require.NoError(t, err)
+		require.Equal(t, int64(3), n)
+		n, err = counter.Add(ctx, tx, txkv.Key("views/mon"), 2)
+		require.NoError(t, err)
+		require.Equal(t, int64(5), n)
+		_, err = counter.Add(ctx, tx, txkv.Key("views/tue"), -1)
+		require.NoError(t, err)
+	})
+	n, err := counter.Get(ctx, kv, txkv.Key("views/tue"))
+	require.NoError(t, err)
+	require.Equal(t, int64(-1), n)
+	n, err = counter.Get(ctx, kv, txkv.Key("views/wed"))
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	inTx(t, kv, func(tx txkv.TxKV) {
+		n, err := counter.Merge(ctx, tx, txkv.Key("views/week"), txkv.Key("views/mon"), txkv.Key("views/tue"), txkv.Key("views/wed"))
+		require.NoError(t, err)
+		require.Equal(t, int64(4), n)
+	})
+	n, err = counter.Get(ctx, kv, txkv.Key("views/mon"))
+	require.NoError(t, err)
+	require.Equal(t, int64(5), n)
+
+	require.NoError(t, kv.Put(ctx, txkv.Key("views/bad"), txkv.Value("nope")))
+	_, err = counter.Get(ctx, kv, txkv.Key("views/bad"))
+	require.Error(t, err)
+}
+
+func TestCounterConcurrent(t *testing.T) {
+	ctx := context.Background()
+	for name, kv := range map[string]txkv.TransactionalKV{
+		"inmem":   txkv.InMem(),
+		"wrapped": txkv.WithMaintenance(txkv.InMem()),
+	} {
+		t.Run(name, func(t *testing.T) {
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					err := txkv.RetryTx(ctx, kv, txkv.RetryPolicy{MaxAttempts: 1000}, func(ctx context.Context, tx txkv.TxKV) error {
+						if _, err := counter.Add(ctx, tx, txkv.Key("n"), 1); err != nil {
+							return err
+						}
+						return counter.AddDistinct(ctx, tx, txkv.Key("hll"), 10, []byte{byte(i)})
+					})
+					require.NoError(t, err)
+				}(i)
+			}
+			wg.Wait()
+			n, err := counter.Get(ctx, kv, txkv.Key("n"))
+			require.NoError(t, err)
+			require.Equal(t, int64(20), n)
+			count, err := counter.CountDistinct(ctx, kv, txkv.Key("hll"))
+			require.NoError(t, err)
+			require.Equal(t, uint64(20), count)
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package counter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+
+	"github.com/aybabtme/txkv"
+)
+
+// The bounds of the precision of a HyperLogLog.
+const (
+	MinPrecision = 4
+	MaxPrecision = 18
+	// DefaultPrecision has sketches of 16KiB, with a standard error of
+	// about 0.8%.
+	DefaultPrecision = 14
+)
+
+// ErrPrecisionMismatch is returned when merging sketches of different
+// precisions.
+var ErrPrecisionMismatch = errors.New("counter: sketches have different precisions")
+
+// HLL is a HyperLogLog sketch, estimating the number of distinct items added
+// to it in constant space: 2^precision bytes, for a standard error of about
+// 1.04/sqrt(2^precision).
+type HLL struct {
+	precision uint8
+	registers []byte
+}
+
+// NewHLL returns an empty sketch of `precision`, between MinPrecision and
+// MaxPrecision.
+func NewHLL(precision int) (*HLL, error) {
+	if precision < MinPrecision || precision > MaxPrecision {
+		return nil, fmt.Errorf("counter: precision %d not within [%d, %d]", precision, MinPrecision, MaxPrecision)
+	}
+	return &HLL{precision: uint8(precision), registers: make([]byte, 1<<precision)}, nil
+}
+
+// hash64 hashes `item` stably across processes, as sketches are stored.
+func hash64(item []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(item)
+	x := h.Sum64()
+	// fnv mixes its last bytes poorly; finish like murmur3 does
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// Add adds `item` to the sketch, and tells whether it changed.
+func (h *HLL) Add(item []byte) bool {
+	x := hash64(item)
+	i := x >> (64 - h.precision)
+	// the rank of the first set bit of the rest, bounded for the rest being 0
+	rank := byte(bits.LeadingZeros64(x<<h.precision|1<<(h.precision-1)) + 1)
+	if rank <= h.registers[i] {
+		return false
+	}
+	h.registers[i] = rank
+	return true
+}
+
+// Count estimates the number of distinct items added.
+func (h *HLL) Count() uint64 {
+	m := float64(len(h.registers))
+	var sum float64
+	zeros := 0
+	for _, r := range h.registers {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+	var alpha float64
+	switch len(h.registers) {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	default:
+		alpha = 0.7213 / (1 + 1.079/m)
+	}
+	estimate := alpha * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		// linear counting is more accurate for small counts
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
+
+// Merge adds the items of `other` to the sketch, making it the sketch of
+// their union.
+func (h *HLL) Merge(other *HLL) error {
+	if h.precision != other.precision {
+		return ErrPrecisionMismatch
+	}
+	for i, r := range other.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+	return nil
+}
+
+// MarshalBinary encodes the sketch: its precision, then its registers.
+func (h *HLL) MarshalBinary() ([]byte, error) {
+	return append([]byte{h.precision}, h.registers...), nil
+}
+
+// UnmarshalBinary decodes a sketch encoded by MarshalBinary.
+func (h *HLL) UnmarshalBinary(b []byte) error {
+	if len(b) == 0 || b[0] < MinPrecision || b[0] > MaxPrecision || len(b)-1 != 1<<b[0] {
+		return errors.New("counter: malformed sketch")
+	}
+	h.precision = b[0]
+	h.registers = append([]byte(nil), b[1:]...)
+	return nil
+}
+
+// LoadHLL returns the sketch at `key`, or an empty one of `precision` if it
+// doesn't exist.
+func LoadHLL(ctx context.Context, kv txkv.KV, key txkv.Key, precision int) (*HLL, error) {
+	v, ok, err := kv.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return NewHLL(precision)
+	}
+	h := new(HLL)
+	if err := h.UnmarshalBinary(v); err != nil {
+		return nil, fmt.Errorf("%w at %q", err, key)
+	}
+	return h, nil
+}
+
+// AddDistinct adds `items` to the sketch at `key` as part of `tx`, creating it
+// with `precision` if it doesn't exist. It's only written if it changed.
+func AddDistinct(ctx context.Context, tx txkv.TxKV, key txkv.Key, precision int, items ...[]byte) error {
+	h, err := LoadHLL(ctx, tx, key, precision)
+	if err != nil {
+		return err
+	}
+	changed := false
+	for _, item := range items {
+		if h.Add(item) {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return storeHLL(ctx, tx, key, h)
+}
+
+// CountDistinct estimates the number of distinct items added to the sketches
+// at `keys`, together. Missing sketches count as empty.
+func CountDistinct(ctx context.Context, kv txkv.KV, keys ...txkv.Key) (uint64, error) {
+	var union *HLL
+	for _, key := range keys {
+		v, ok, err := kv.Get(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			continue
+		}
+		h := new(HLL)
+		if err := h.UnmarshalBinary(v); err != nil {
+			return 0, fmt.Errorf("%w at %q", err, key)
+		}
+		if union == nil {
+			union = h
+		} else if err := union.Merge(h); err != nil {
+			return 0, err
+		}
+	}
+	if union == nil {
+		return 0, nil
+	}
+	return union.Count(), nil
+}
+
+// MergeDistinct merges the sketches at `srcs` into the one at `dst` as part
+// of `tx`, creating it with `precision` if it doesn't exist. The sketches at
+// `srcs` are left as they are.
+func MergeDistinct(ctx context.Context, tx txkv.TxKV, dst txkv.Key, precision int, srcs ...txkv.Key) error {
+	h, err := LoadHLL(ctx, tx, dst, precision)
+	if err != nil {
+		return err
+	}
+	for _, src := range srcs {
+		v, ok, err := tx.Get(ctx, src)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		other := new(HLL)
+		if err := other.UnmarshalBinary(v); err != nil {
+			return fmt.Errorf("%w at %q", err, src)
+		}
+		if err := h.Merge(other); err != nil {
+			return err
+		}
+	}
+	return storeHLL(ctx, tx, dst, h)
+}
+
+func storeHLL(ctx context.Context, tx txkv.TxKV, key txkv.Key, h *HLL) error {
+	b, err := h.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return tx.Put(ctx, key, b)
+}
@@ -0,0 +1,76 @@
+package counter_test
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aybabtme/txkv"
+	"github.com/aybabtme/txkv/counter"
+)
+
+func requireClose(t *testing.T, want int, got uint64, tolerance float64) {
+	t.Helper()
+	err := math.Abs(float64(got)-float64(want)) / float64(want)
+	require.LessOrEqual(t, err, tolerance, "estimated %d for %d", got, want)
+}
+
+func TestHLL(t *testing.T) {
+	for _, n := range []int{10, 1000, 100000} {
+		h, err := counter.NewHLL(counter.DefaultPrecision)
+		require.NoError(t, err)
+		for i := 0; i < n; i++ {
+			h.Add([]byte(fmt.Sprintf("user-%d", i)))
+			h.Add([]byte(fmt.Sprintf("user-%d", i/2))) // duplicates
+		}
+		requireClose(t, n, h.Count(), 0.03)
+	}
+
+	_, err := counter.NewHLL(3)
+	require.Error(t, err)
+
+	a, _ := counter.NewHLL(10)
+	b, _ := counter.NewHLL(12)
+	require.ErrorIs(t, a.Merge(b), counter.ErrPrecisionMismatch)
+	require.Zero(t, a.Count())
+}
+
+func TestDistinct(t *testing.T) {
+	ctx := context.Background()
+	kv := txkv.InMem()
+	mon, tue, week := txkv.Key("visitors/mon"), txkv.Key("visitors/tue"), txkv.Key("visitors/week")
+	inTx(t, kv, func(tx txkv.TxKV) {
+		for i := 0; i < 5000; i++ {
+			require.NoError(t, counter.AddDistinct(ctx, tx, mon, counter.DefaultPrecision, []byte(fmt.Sprint(i))))
+		}
+		for i := 2500; i < 7500; i++ {
+			require.NoError(t, counter.AddDistinct(ctx, tx, tue, counter.DefaultPrecision, []byte(fmt.Sprint(i))))
+		}
+	})
+	n, err := counter.CountDistinct(ctx, kv, mon)
+	require.NoError(t, err)
+	requireClose(t, 5000, n, 0.03)
+	n, err = counter.CountDistinct(ctx, kv, mon, tue)
+	require.NoError(t, err)
+	requireClose(t, 7500, n, 0.03)
+	n, err = counter.CountDistinct(ctx, kv, txkv.Key("visitors/none"))
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	inTx(t, kv, func(tx txkv.TxKV) {
+		require.NoError(t, counter.MergeDistinct(ctx, tx, week, counter.DefaultPrecision, mon, tue))
+	})
+	n, err = counter.CountDistinct(ctx, kv, week)
+	require.NoError(t, err)
+	requireClose(t, 7500, n, 0.03)
+
+	// sketches round-trip through the store
+	h, err := counter.LoadHLL(ctx, kv, week, counter.DefaultPrecision)
+	require.NoError(t, err)
+	b, err := h.MarshalBinary()
+	require.NoError(t, err)
+	require.Len(t, b, 1+1<<counter.DefaultPrecision)
+}
@@ -0,0 +1,163 @@
+// Package debug prints the content of stores and the pending writes of
+// transactions in a readable form, for tests and debugging sessions.
+package debug
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
+
+	"github.com/aybabtme/txkv"
+)
+
+// Format tells how values are printed. Keys are always printed quoted.
+type Format int
+
+// The formats values are printed in.
+const (
+	// FormatText prints printable values quoted, and others in hex, aligned
+	// in a column.
+	FormatText Format = iota
+	// FormatHex prints every value as a hex dump, under its key.
+	FormatHex
+	// FormatJSON prints values holding JSON indented, under their key, and
+	// others like FormatText.
+	FormatJSON
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatText:
+		return "text"
+	case FormatHex:
+		return "hex"
+	case FormatJSON:
+		return "json"
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// Dump prints the keys of `kv` under `prefix` and their values to `w`.
+func Dump(ctx context.Context, w io.Writer, kv txkv.KV, prefix txkv.Key, format Format) error {
+	keys, err := kv.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	p := newPrinter(w, format)
+	for _, key := range keys {
+		v, ok, err := kv.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+		if ok {
+			p.entry("", key, v)
+		}
+	}
+	return p.flush()
+}
+
+// DiffDump prints the pending writes of `tx` to `w`, as changes to `base`,
+// the store it's a transaction of: "+" for added keys, "~" for changed ones
+// with their former value, and "-" for deleted ones. Writes that change
+// nothing are left out. `tx` must be a txkv.PendingLister.
+func DiffDump(ctx context.Context, w io.Writer, base txkv.KV, tx txkv.TxKV, format Format) error {
+	pl, ok := tx.(txkv.PendingLister)
+	if !ok {
+		return fmt.Errorf("debug: can't list the pending writes of a %T", tx)
+	}
+	ops, err := pl.Pending(ctx)
+	if err != nil {
+		return err
+	}
+	p := newPrinter(w, format)
+	for _, op := range ops {
+		old, found, err := base.Get(ctx, op.Key)
+		if err != nil {
+			return err
+		}
+		switch {
+		case op.Delete && found:
+			p.entry("- ", op.Key, old)
+		case op.Delete:
+		case !found:
+			p.entry("+ ", op.Key, op.Value)
+		case !bytes.Equal(old, op.Value):
+			p.entry("~ ", op.Key, op.Value)
+			p.entry("  was", nil, old)
+		}
+	}
+	return p.flush()
+}
+
+type printer struct {
+	w      io.Writer
+	tw     *tabwriter.Writer
+	format Format
+	err    error
+}
+
+func newPrinter(w io.Writer, format Format) *printer {
+	return &printer{w: w, tw: tabwriter.NewWriter(w, 0, 4, 1, ' ', 0), format: format}
+}
+
+// entry prints `key`, unless nil, and `value`, after `mark`.
+func (p *printer) entry(mark string, key txkv.Key, value txkv.Value) {
+	if p.err != nil {
+		return
+	}
+	label := mark
+	if key != nil {
+		label += strconv.Quote(string(key))
+	}
+	var block string
+	switch {
+	case p.format == FormatHex:
+		block = hex.Dump(value)
+	case p.format == FormatJSON && json.Valid(value):
+		var buf bytes.Buffer
+		_ = json.Indent(&buf, value, "", "  ")
+		block = buf.String() + "\n"
+	default:
+		_, p.err = fmt.Fprintf(p.tw, "%s\t%s\n", label, text(value))
+		return
+	}
+	// blocks break the alignment, flush what's aligned so far
+	if p.err = p.tw.Flush(); p.err != nil {
+		return
+	}
+	_, p.err = fmt.Fprintf(p.w, "%s\n%s", label, indent(block))
+}
+
+func (p *printer) flush() error {
+	if p.err != nil {
+		return p.err
+	}
+	return p.tw.Flush()
+}
+
+// text returns `b` quoted if it's printable, and in hex otherwise.
+func text(b []byte) string {
+	if utf8.Valid(b) && strconv.CanBackquote(string(b)) {
+		return strconv.Quote(string(b))
+	}
+	return "0x" + hex.EncodeToString(b)
+}
+
+func indent(block string) string {
+	lines := strings.SplitAfter(block, "\n")
+	var sb strings.Builder
+	for _, line := range lines {
+		if line != "" {
+			sb.WriteString("    ")
+			sb.WriteString(line)
+		}
+	}
+	return sb.String()
+}
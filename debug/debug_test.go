@@ -0,0 +1,76 @@
+package debug_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aybabtme/txkv"
+	"github.com/aybabtme/txkv/debug"
+)
+
+func newKV(t *testing.T) txkv.TransactionalKV {
+	ctx := context.Background()
+	kv := txkv.InMem()
+	require.NoError(t, kv.Put(ctx, txkv.Key("user/ann"), txkv.Value(`{"city":"paris"}`)))
+	require.NoError(t, kv.Put(ctx, txkv.Key("user/bobby"), txkv.Value("rome")))
+	require.NoError(t, kv.Put(ctx, txkv.Key("user/raw"), txkv.Value{0x00, 0xff}))
+	require.NoError(t, kv.Put(ctx, txkv.Key("other"), txkv.Value("ignored")))
+	return kv
+}
+
+func TestDump(t *testing.T) {
+	ctx := context.Background()
+	kv := newKV(t)
+
+	var buf bytes.Buffer
+	require.NoError(t, debug.Dump(ctx, &buf, kv, txkv.Key("user/"), debug.FormatText))
+	require.Equal(t, `"user/ann"   "{\"city\":\"paris\"}"
+"user/bobby" "rome"
+"user/raw"   0x00ff
+`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, debug.Dump(ctx, &buf, kv, txkv.Key("user/"), debug.FormatJSON))
+	require.Equal(t, `"user/ann"
+    {
+      "city": "paris"
+    }
+"user/bobby" "rome"
+"user/raw"   0x00ff
+`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, debug.Dump(ctx, &buf, kv, txkv.Key("user/b"), debug.FormatHex))
+	require.Equal(t, `"user/bobby"
+    00000000  72 6f 6d 65                                       |rome|
+`, buf.String())
+}
+
+func TestDiffDump(t *testing.T) {
+	ctx := context.Background()
+	kv := newKV(t)
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Put(ctx, txkv.Key("user/bobby"), txkv.Value("oslo")))
+	require.NoError(t, tx.Put(ctx, txkv.Key("user/cid"), txkv.Value("lima")))
+	require.NoError(t, tx.Put(ctx, txkv.Key("other"), txkv.Value("ignored")))
+	require.NoError(t, tx.Delete(ctx, txkv.Key("user/raw")))
+	require.NoError(t, tx.Delete(ctx, txkv.Key("missing")))
+
+	var buf bytes.Buffer
+	require.NoError(t, debug.DiffDump(ctx, &buf, kv, tx, debug.FormatText))
+	require.Equal(t, `~ "user/bobby" "oslo"
+  was          "rome"
++ "user/cid"   "lima"
+- "user/raw"   0x00ff
+`, buf.String())
+
+	err = debug.DiffDump(ctx, &buf, kv, readOnlyTx{tx}, debug.FormatText)
+	require.Error(t, err)
+}
+
+// readOnlyTx hides the pending writes of a transaction.
+type readOnlyTx struct{ txkv.TxKV }
@@ -0,0 +1,37 @@
+package txkv
+
+import "context"
+
+// PrefixDeleter is implemented by stores that can delete a whole prefix
+// faster than one key at a time.
+type PrefixDeleter interface {
+	DeletePrefix(ctx context.Context, prefix Key) (int, error)
+}
+
+// DeletePrefix deletes all the keys of `kv` with `prefix`, returning how many
+// it deleted. Stores that are a PrefixDeleter drop the range themselves,
+// others have its keys deleted in a single transaction.
+func DeletePrefix(ctx context.Context, kv TransactionalKV, prefix Key) (int, error) {
+	if pd, ok := kv.(PrefixDeleter); ok {
+		return pd.DeletePrefix(ctx, prefix)
+	}
+	tx, err := kv.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	keys, err := tx.List(ctx, prefix)
+	if err != nil {
+		_ = tx.Rollback(ctx)
+		return 0, err
+	}
+	for _, key := range keys {
+		if err := tx.Delete(ctx, key); err != nil {
+			_ = tx.Rollback(ctx)
+			return 0, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
@@ -0,0 +1,53 @@
+package txkv_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestDeletePrefix(t *testing.T) {
+	ctx := context.Background()
+	kvs := map[string]TransactionalKV{
+		"inmem":      InMem(),
+		"comparator": InMem(WithComparator(caseInsensitive)),
+		"wrapped":    WithMaintenance(InMem()),
+	}
+	for name, kv := range kvs {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 200; i++ {
+				mustPut(ctx, t, kv, Key(fmt.Sprintf("a/%03d", i)), Value("v"))
+			}
+			mustPut(ctx, t, kv, Key("a"), Value("v"))
+			mustPut(ctx, t, kv, Key("b/1"), Value("v"))
+
+			n, err := DeletePrefix(ctx, kv, Key("a/"))
+			require.NoError(t, err)
+			require.Equal(t, 200, n)
+			mustList(ctx, t, kv, Key(""), []Key{Key("a"), Key("b/1")})
+
+			n, err = DeletePrefix(ctx, kv, Key("nope/"))
+			require.NoError(t, err)
+			require.Equal(t, 0, n)
+		})
+	}
+}
+
+func TestDeletePrefixTracksChanges(t *testing.T) {
+	ctx := context.Background()
+	kv := InMem()
+	mustPut(ctx, t, kv, Key("a/1"), Value("v"))
+	mustPut(ctx, t, kv, Key("a/2"), Value("v"))
+	seq, err := kv.(ChangeTracker).Seq(ctx)
+	require.NoError(t, err)
+
+	_, err = DeletePrefix(ctx, kv, Key("a/"))
+	require.NoError(t, err)
+	changed, err := kv.(ChangeTracker).ListModifiedSince(ctx, Key(""), seq)
+	require.NoError(t, err)
+	require.Equal(t, []Key{Key("a/1"), Key("a/2")}, changed)
+}
@@ -0,0 +1,135 @@
+package txkv
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+)
+
+// DiffKind tells how a key differs between two stores.
+type DiffKind int
+
+// The kinds of differences, going from the first store to the second.
+const (
+	// Added keys are only in the second store.
+	Added DiffKind = iota + 1
+	// Removed keys are only in the first store.
+	Removed
+	// Changed keys have different values.
+	Changed
+)
+
+func (k DiffKind) String() string {
+	switch k {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	case Changed:
+		return "changed"
+	}
+	return fmt.Sprintf("DiffKind(%d)", int(k))
+}
+
+// Difference is a key that differs between two stores. The hashes are the
+// SHA-256 of its values, zero in the store it's missing from.
+type Difference struct {
+	Key   Key
+	Kind  DiffKind
+	HashA [sha256.Size]byte
+	HashB [sha256.Size]byte
+}
+
+// DiffReport is the outcome of comparing two stores.
+type DiffReport struct {
+	Differences []Difference
+	// Same is the number of keys with the same value in both stores.
+	Same int
+}
+
+// Equal is true when the stores hold the same keys and values.
+func (r DiffReport) Equal() bool { return len(r.Differences) == 0 }
+
+// Diff compares the keys under `prefix` in `a` and `b`, to validate a
+// migration or a replica. Differences are ordered by key.
+func Diff(ctx context.Context, a, b KV, prefix Key) (DiffReport, error) {
+	var report DiffReport
+	same, err := DiffFunc(ctx, a, b, prefix, func(d Difference) error {
+		report.Differences = append(report.Differences, d)
+		return nil
+	})
+	report.Same = same
+	return report, err
+}
+
+// DiffFunc is like Diff, but calls `fn` with each difference as it's found
+// rather than collecting them, and returns the number of keys that are the
+// same. It stops at the first error `fn` returns.
+func DiffFunc(ctx context.Context, a, b KV, prefix Key, fn func(Difference) error) (int, error) {
+	keysA, err := sortedKeys(ctx, a, prefix)
+	if err != nil {
+		return 0, err
+	}
+	keysB, err := sortedKeys(ctx, b, prefix)
+	if err != nil {
+		return 0, err
+	}
+	same := 0
+	for len(keysA) > 0 || len(keysB) > 0 {
+		if err := ctx.Err(); err != nil {
+			return same, err
+		}
+		var key Key
+		switch {
+		case len(keysB) == 0 || len(keysA) > 0 && bytes.Compare(keysA[0], keysB[0]) < 0:
+			key, keysA = keysA[0], keysA[1:]
+		case len(keysA) == 0 || bytes.Compare(keysA[0], keysB[0]) > 0:
+			key, keysB = keysB[0], keysB[1:]
+		default:
+			key, keysA, keysB = keysA[0], keysA[1:], keysB[1:]
+		}
+		// values are read back rather than trusting the listings, which may
+		// be stale
+		va, okA, err := a.Get(ctx, key)
+		if err != nil {
+			return same, err
+		}
+		vb, okB, err := b.Get(ctx, key)
+		if err != nil {
+			return same, err
+		}
+		d := Difference{Key: key}
+		switch {
+		case !okA && !okB:
+			continue
+		case !okA:
+			d.Kind, d.HashB = Added, sha256.Sum256(vb)
+		case !okB:
+			d.Kind, d.HashA = Removed, sha256.Sum256(va)
+		default:
+			d.HashA, d.HashB = sha256.Sum256(va), sha256.Sum256(vb)
+			if d.HashA == d.HashB {
+				same++
+				continue
+			}
+			d.Kind = Changed
+		}
+		if err := fn(d); err != nil {
+			return same, err
+		}
+	}
+	return same, nil
+}
+
+// sortedKeys lists the keys of `kv` under `prefix` in bytewise order, which
+// stores with a custom order don't list them in.
+func sortedKeys(ctx context.Context, kv KV, prefix Key) ([]Key, error) {
+	keys, err := kv.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+	return keys, nil
+}
@@ -0,0 +1,49 @@
+package txkv_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestDiff(t *testing.T) {
+	ctx := context.Background()
+	a, b := InMem(), InMem(WithComparator(caseInsensitive))
+	mustPut(ctx, t, a, Key("p/same"), Value("1"))
+	mustPut(ctx, t, b, Key("p/same"), Value("1"))
+	mustPut(ctx, t, a, Key("p/changed"), Value("old"))
+	mustPut(ctx, t, b, Key("p/changed"), Value("new"))
+	mustPut(ctx, t, a, Key("p/removed"), Value("gone"))
+	mustPut(ctx, t, b, Key("p/Added"), Value("here"))
+	mustPut(ctx, t, b, Key("q/ignored"), Value("x"))
+
+	report, err := Diff(ctx, a, b, Key("p/"))
+	require.NoError(t, err)
+	require.False(t, report.Equal())
+	require.Equal(t, 1, report.Same)
+	require.Equal(t, []Difference{
+		{Key: Key("p/Added"), Kind: Added, HashB: sha256.Sum256([]byte("here"))},
+		{Key: Key("p/changed"), Kind: Changed, HashA: sha256.Sum256([]byte("old")), HashB: sha256.Sum256([]byte("new"))},
+		{Key: Key("p/removed"), Kind: Removed, HashA: sha256.Sum256([]byte("gone"))},
+	}, report.Differences)
+	require.Equal(t, "changed", report.Differences[1].Kind.String())
+
+	report, err = Diff(ctx, a, a, nil)
+	require.NoError(t, err)
+	require.True(t, report.Equal())
+	require.Equal(t, 3, report.Same)
+
+	stop := errors.New("stop")
+	calls := 0
+	_, err = DiffFunc(ctx, a, b, Key("p/"), func(Difference) error {
+		calls++
+		return stop
+	})
+	require.Equal(t, stop, err)
+	require.Equal(t, 1, calls)
+}
@@ -0,0 +1,441 @@
+package txkv
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	// ErrStoreClosed is returned when using a store that was closed.
+	ErrStoreClosed = errors.New("txkv: store is closed")
+	// ErrCorruptedLog is returned when opening a store whose log is damaged
+	// before its last record, which can't be caused by a crash: replaying
+	// the log past it would lose acknowledged commits.
+	ErrCorruptedLog = errors.New("txkv: log is corrupted")
+)
+
+// Disk opens the store whose write-ahead log is the file at `path`, creating
+// it if it doesn't exist. It refuses files that aren't such a log, or that
+// were written in a format it doesn't know. See DiskKV.
+func Disk(path string) (*DiskKV, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	// the store is given the buffers of the callers, who may reuse them
+	d := &DiskKV{mem: newMemKV(inMemConfig{copy: true}), path: path, f: f}
+	if err := d.replay(); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("txkv: replaying %s: %w", path, err)
+	}
+	return d, nil
+}
+
+// DiskKV is a TransactionalKV whose data survives restarts. Every commit is
+// appended to a write-ahead log, and synced, before it's applied to an
+// in-memory store that serves the reads. Opening the store replays its log.
+//
+// The log only grows: Checkpoint rewrites it with just the live entries.
+// A commit torn by a crash is dropped from the end of the log when it's
+// replayed, as it was never acknowledged. Damage anywhere else fails the
+// opening of the store with ErrCorruptedLog.
+//
+// Like those of InMem, its transactions fail to commit with ErrConflict when
+// a key they read was written since.
+type DiskKV struct {
+	mem  *memkv
+	path string
+
+	mu     sync.Mutex // held while logging and applying commits, in order
+	f      *os.File
+	size   int64 // of the log up to its last whole record
+	err    error // set when the log can't be trusted to be appended to
+	closed bool
+}
+
+// logs start with walMagic followed by the version of their format, then
+// records of `length (u32) | crc32 (u32) | ops`, with each op being
+// `kind (byte) | uvarint key length | key`, followed by `uvarint value
+// length | value` for puts. Integers are big-endian.
+const (
+	walMagic   = "TXKVWAL"
+	walVersion = 1
+	walHeader  = len(walMagic) + 1
+
+	walPut    = 0
+	walDelete = 1
+)
+
+func walHeaderBytes() []byte {
+	return append([]byte(walMagic), walVersion)
+}
+
+var walTable = crc32.MakeTable(crc32.Castagnoli)
+
+func encodeWALRecord(ops []Op) []byte {
+	rec := make([]byte, 8)
+	for _, op := range ops {
+		if op.Delete {
+			rec = append(rec, walDelete)
+		} else {
+			rec = append(rec, walPut)
+		}
+		rec = binary.AppendUvarint(rec, uint64(len(op.Key)))
+		rec = append(rec, op.Key...)
+		if !op.Delete {
+			rec = binary.AppendUvarint(rec, uint64(len(op.Value)))
+			rec = append(rec, op.Value...)
+		}
+	}
+	binary.BigEndian.PutUint32(rec[:4], uint32(len(rec)-8))
+	binary.BigEndian.PutUint32(rec[4:8], crc32.Checksum(rec[8:], walTable))
+	return rec
+}
+
+func decodeWALRecord(b []byte) ([]Op, error) {
+	var ops []Op
+	for len(b) > 0 {
+		kind := b[0]
+		key, rest, ok := readUvarintBytes(b[1:])
+		if !ok || kind > walDelete {
+			return nil, errors.New("malformed record")
+		}
+		op := Op{Key: Key(key), Delete: kind == walDelete}
+		if !op.Delete {
+			var value []byte
+			if value, rest, ok = readUvarintBytes(rest); !ok {
+				return nil, errors.New("malformed record")
+			}
+			op.Value = Value(value)
+		}
+		ops = append(ops, op)
+		b = rest
+	}
+	return ops, nil
+}
+
+// replay checks the header of the log, or writes it to a new one, then
+// applies its records. It cuts the log after the last whole record only when
+// what follows is a torn record, and fails without touching the log when
+// it's damaged anywhere else.
+func (d *DiskKV) replay() error {
+	fi, err := d.f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() == 0 {
+		if _, err := d.f.Write(walHeaderBytes()); err != nil {
+			return err
+		}
+		d.size = int64(walHeader)
+		return d.f.Sync()
+	}
+	r := bufio.NewReader(d.f)
+	header := make([]byte, walHeader)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(walMagic)]) != walMagic {
+		return errors.New("not a txkv log")
+	}
+	if v := header[len(walMagic)]; v != walVersion {
+		return fmt.Errorf("unsupported log format version %d", v)
+	}
+	d.size = int64(walHeader)
+
+	var head [8]byte
+	for {
+		if _, err := io.ReadFull(r, head[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return err
+		}
+		n := int64(binary.BigEndian.Uint32(head[:4]))
+		end := d.size + int64(len(head)) + n
+		if end > fi.Size() {
+			rest, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			if containsWALRecord(rest) {
+				return fmt.Errorf("%w: record at offset %d: length %d overruns the log", ErrCorruptedLog, d.size, n)
+			}
+			break // the last record was torn
+		}
+		rec := make([]byte, n)
+		if _, err := io.ReadFull(r, rec); err != nil {
+			return err
+		}
+		var ops []Op
+		if crc32.Checksum(rec, walTable) == binary.BigEndian.Uint32(head[4:]) {
+			ops, err = decodeWALRecord(rec)
+		} else {
+			err = errors.New("checksum mismatch")
+		}
+		if err != nil {
+			if end == fi.Size() && !containsWALRecord(rec) {
+				break // the last record was torn
+			}
+			return fmt.Errorf("%w: record at offset %d: %v", ErrCorruptedLog, d.size, err)
+		}
+		_ = d.mem.Apply(context.Background(), ops)
+		d.size = end
+	}
+	if err := d.f.Truncate(d.size); err != nil {
+		return err
+	}
+	_, err = d.f.Seek(d.size, io.SeekStart)
+	return err
+}
+
+// containsWALRecord tells if a whole record starts anywhere in `b`. A crash
+// only tears the last record, leaving a prefix of it at the end of the log:
+// when whole records follow a damaged one, the damage came from elsewhere.
+func containsWALRecord(b []byte) bool {
+	for i := 0; i+8 <= len(b); i++ {
+		n := binary.BigEndian.Uint32(b[i:])
+		if n == 0 || uint64(n) > uint64(len(b)-i-8) {
+			continue
+		}
+		rec := b[i+8 : i+8+int(n)]
+		if crc32.Checksum(rec, walTable) != binary.BigEndian.Uint32(b[i+4:]) {
+			continue
+		}
+		if _, err := decodeWALRecord(rec); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// commit logs `ops` then applies them, as a single commit, unless a key of
+// `reads` was written since its revision was read.
+func (d *DiskKV) commit(ctx context.Context, ops []Op, reads map[string][]byte) error {
+	if len(ops) == 0 {
+		return nil
+	}
+	rec := encodeWALRecord(ops)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return ErrStoreClosed
+	}
+	if d.err != nil {
+		return d.err
+	}
+	// commits all go through here, so none lands before the ops are applied
+	d.mem.lock(OpCommit)
+	err := d.mem.checkReads(reads)
+	d.mem.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := d.append(rec); err != nil {
+		return err
+	}
+	return d.mem.Apply(ctx, ops)
+}
+
+// append writes `rec` at the end of the log and syncs it. When that fails,
+// the log is cut back to where it was, so that a partial record doesn't hide
+// the records appended after it.
+func (d *DiskKV) append(rec []byte) error {
+	_, err := d.f.Write(rec)
+	if err == nil {
+		err = d.f.Sync()
+	}
+	if err == nil {
+		d.size += int64(len(rec))
+		return nil
+	}
+	if terr := d.f.Truncate(d.size); terr != nil {
+		d.err = fmt.Errorf("txkv: log %s is damaged: %v", d.path, terr)
+	} else if _, serr := d.f.Seek(d.size, io.SeekStart); serr != nil {
+		d.err = fmt.Errorf("txkv: log %s is damaged: %v", d.path, serr)
+	}
+	return err
+}
+
+// Checkpoint rewrites the log with only the live entries, dropping the
+// history of overwritten and deleted ones, and atomically replaces it.
+// Commits wait for it.
+func (d *DiskKV) Checkpoint(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return ErrStoreClosed
+	}
+	if d.err != nil {
+		return d.err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(d.path), filepath.Base(d.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	size, err := d.writeLive(ctx, tmp)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := os.Rename(tmp.Name(), d.path); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	// the new log is in place, whatever happens to the old file
+	_ = d.f.Close()
+	d.f, d.size = tmp, size
+	if dir, err := os.Open(filepath.Dir(d.path)); err == nil {
+		_ = dir.Sync()
+		_ = dir.Close()
+	}
+	_, err = d.f.Seek(size, io.SeekStart)
+	return err
+}
+
+// writeLive writes a log of the live entries to `w`, as records of puts,
+// and returns how many bytes it wrote.
+func (d *DiskKV) writeLive(ctx context.Context, w io.Writer) (int64, error) {
+	const batchSize = 1000
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(walHeaderBytes()); err != nil {
+		return 0, err
+	}
+	var (
+		size  = int64(walHeader)
+		batch []Op
+	)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		rec := encodeWALRecord(batch)
+		batch = batch[:0]
+		size += int64(len(rec))
+		_, err := bw.Write(rec)
+		return err
+	}
+	keys, err := d.mem.List(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	for _, key := range keys {
+		v, ok, err := d.mem.Get(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			continue
+		}
+		if batch = append(batch, PutOp(key, v)); len(batch) == batchSize {
+			if err := flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return 0, err
+	}
+	return size, bw.Flush()
+}
+
+// Close closes the log. The store can't be used afterwards.
+func (d *DiskKV) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+	return d.f.Close()
+}
+
+func (d *DiskKV) isClosed() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.closed
+}
+
+func (d *DiskKV) Put(ctx context.Context, key Key, value Value) error {
+	return d.commit(ctx, []Op{PutOp(key, value)}, nil)
+}
+
+func (d *DiskKV) Get(ctx context.Context, key Key) (Value, bool, error) {
+	if d.isClosed() {
+		return nil, false, ErrStoreClosed
+	}
+	return d.mem.Get(ctx, key)
+}
+
+func (d *DiskKV) Delete(ctx context.Context, key Key) error {
+	return d.commit(ctx, []Op{DeleteOp(key)}, nil)
+}
+
+func (d *DiskKV) List(ctx context.Context, prefix Key) ([]Key, error) {
+	if d.isClosed() {
+		return nil, ErrStoreClosed
+	}
+	return d.mem.List(ctx, prefix)
+}
+
+// Apply logs the ops as a single record.
+func (d *DiskKV) Apply(ctx context.Context, ops []Op) error {
+	return d.commit(ctx, ops, nil)
+}
+
+func (d *DiskKV) Begin(ctx context.Context) (TxKV, error) {
+	if d.isClosed() {
+		return nil, ErrStoreClosed
+	}
+	tx, err := d.mem.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &diskTx{d: d, tx: tx.(*txmemkv)}, nil
+}
+
+// diskTx buffers its writes in a transaction of the in-memory store, and
+// commits them through the log instead.
+type diskTx struct {
+	d  *DiskKV
+	tx *txmemkv
+}
+
+func (t *diskTx) Put(ctx context.Context, key Key, value Value) error {
+	return t.tx.Put(ctx, key, value)
+}
+
+func (t *diskTx) Get(ctx context.Context, key Key) (Value, bool, error) {
+	return t.tx.Get(ctx, key)
+}
+
+func (t *diskTx) Delete(ctx context.Context, key Key) error {
+	return t.tx.Delete(ctx, key)
+}
+
+func (t *diskTx) List(ctx context.Context, prefix Key) ([]Key, error) {
+	return t.tx.List(ctx, prefix)
+}
+
+func (t *diskTx) Pending(ctx context.Context) ([]Op, error) {
+	return t.tx.Pending(ctx)
+}
+
+func (t *diskTx) Commit(ctx context.Context) error {
+	ops, err := t.tx.Pending(ctx)
+	if err != nil {
+		return err
+	}
+	return t.d.commit(ctx, ops, t.tx.readRevs())
+}
+
+func (t *diskTx) Rollback(ctx context.Context) error { return t.tx.Rollback(ctx) }
@@ -0,0 +1,169 @@
+package txkv_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestDisk(t *testing.T) {
+	testKV(t, func(t testing.TB) TransactionalKV {
+		kv, err := Disk(filepath.Join(t.TempDir(), "wal"))
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = kv.Close() })
+		return kv
+	})
+}
+
+func TestDiskRestart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "wal")
+	kv, err := Disk(path)
+	require.NoError(t, err)
+	mustPut(ctx, t, kv, Key("a"), Value("1"))
+	mustPut(ctx, t, kv, Key("b"), Value("2"))
+	mustDelete(ctx, t, kv, Key("a"))
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Put(ctx, Key("c"), Value("3")))
+	require.NoError(t, tx.Put(ctx, Key("b"), Value("two")))
+	require.NoError(t, tx.Commit(ctx))
+	tx, err = kv.Begin(ctx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Put(ctx, Key("rolled back"), Value("x")))
+	require.NoError(t, tx.Rollback(ctx))
+	require.NoError(t, Apply(ctx, kv, []Op{PutOp(Key("d"), Value("4")), DeleteOp(Key("c"))}))
+	require.NoError(t, kv.Close())
+	require.ErrorIs(t, kv.Put(ctx, Key("e"), Value("5")), ErrStoreClosed)
+
+	kv, err = Disk(path)
+	require.NoError(t, err)
+	mustList(ctx, t, kv, nil, []Key{Key("b"), Key("d")})
+	mustFind(ctx, t, kv, Key("b"), Value("two"))
+	mustFind(ctx, t, kv, Key("d"), Value("4"))
+
+	// checkpoints shrink the log, and keep the data
+	before, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, kv.Checkpoint(ctx))
+	after, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Less(t, after.Size(), before.Size())
+	mustPut(ctx, t, kv, Key("e"), Value("5"))
+	require.NoError(t, kv.Close())
+
+	kv, err = Disk(path)
+	require.NoError(t, err)
+	mustList(ctx, t, kv, nil, []Key{Key("b"), Key("d"), Key("e")})
+	require.NoError(t, kv.Close())
+}
+
+func TestDiskTornWrite(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "wal")
+	kv, err := Disk(path)
+	require.NoError(t, err)
+	mustPut(ctx, t, kv, Key("a"), Value("1"))
+	mustPut(ctx, t, kv, Key("b"), Value("2"))
+	require.NoError(t, kv.Close())
+
+	// a crash halfway through the last record
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(path, fi.Size()-1))
+
+	kv, err = Disk(path)
+	require.NoError(t, err)
+	mustList(ctx, t, kv, nil, []Key{Key("a")})
+	// appends after the torn record are replayed
+	mustPut(ctx, t, kv, Key("c"), Value("3"))
+	require.NoError(t, kv.Close())
+	kv, err = Disk(path)
+	require.NoError(t, err)
+	mustList(ctx, t, kv, nil, []Key{Key("a"), Key("c")})
+	require.NoError(t, kv.Close())
+
+	// garbage is cut too
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("\xff\xff\xff\xffgarbage"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	kv, err = Disk(path)
+	require.NoError(t, err)
+	mustList(ctx, t, kv, nil, []Key{Key("a"), Key("c")})
+	require.NoError(t, kv.Close())
+}
+
+func TestDiskCorruption(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// files that aren't logs are left alone
+	path := filepath.Join(dir, "notes.txt")
+	require.NoError(t, os.WriteFile(path, []byte("some notes\n"), 0o644))
+	_, err := Disk(path)
+	require.Error(t, err)
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "some notes\n", string(b))
+
+	// damage before the last record isn't taken for a torn write
+	path = filepath.Join(dir, "wal")
+	kv, err := Disk(path)
+	require.NoError(t, err)
+	mustPut(ctx, t, kv, Key("a"), Value("1"))
+	mustPut(ctx, t, kv, Key("b"), Value("2"))
+	require.NoError(t, kv.Close())
+	b, err = os.ReadFile(path)
+	require.NoError(t, err)
+	size := len(b)
+	b[len("TXKVWAL")+1+8] ^= 0xff // in the ops of the first record
+	require.NoError(t, os.WriteFile(path, b, 0o644))
+	_, err = Disk(path)
+	require.ErrorIs(t, err, ErrCorruptedLog)
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, int64(size), fi.Size())
+
+	// so is a length pointing past the end of the log
+	path = filepath.Join(dir, "wal2")
+	kv, err = Disk(path)
+	require.NoError(t, err)
+	mustPut(ctx, t, kv, Key("a"), Value("1"))
+	mustPut(ctx, t, kv, Key("b"), Value("2"))
+	mustPut(ctx, t, kv, Key("c"), Value("3"))
+	require.NoError(t, kv.Close())
+	b, err = os.ReadFile(path)
+	require.NoError(t, err)
+	b[len("TXKVWAL")+1] = 0x01 // the length of the first record
+	require.NoError(t, os.WriteFile(path, b, 0o644))
+	_, err = Disk(path)
+	require.ErrorIs(t, err, ErrCorruptedLog)
+	after, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, b, after)
+}
+
+func TestDiskCopies(t *testing.T) {
+	ctx := context.Background()
+	kv, err := Disk(filepath.Join(t.TempDir(), "wal"))
+	require.NoError(t, err)
+	defer kv.Close()
+	buf := []byte("1")
+	require.NoError(t, kv.Put(ctx, Key("a"), buf))
+	buf[0] = '2'
+	mustFind(ctx, t, kv, Key("a"), Value("1"))
+}
+
+func TestDiskConflict(t *testing.T) {
+	kv, err := Disk(filepath.Join(t.TempDir(), "wal"))
+	require.NoError(t, err)
+	defer kv.Close()
+	testConflict(t, kv)
+}
@@ -0,0 +1,356 @@
+package docstorekv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+
+	"gocloud.dev/docstore/driver"
+	"gocloud.dev/gcerrors"
+)
+
+// storedDoc is a document as it's kept in the KV: a tree of nil, bool, int64,
+// float64, string, []byte, time.Time, []interface{} and map[string]interface{}.
+type storedDoc map[string]interface{}
+
+func encodeDoc(doc driver.Document) (storedDoc, error) {
+	var e encoder
+	if err := doc.Encode(&e); err != nil {
+		return nil, err
+	}
+	return storedDoc(e.val.(map[string]interface{})), nil
+}
+
+func encodeValue(v interface{}) (interface{}, error) {
+	var e encoder
+	if err := driver.Encode(reflect.ValueOf(v), &e); err != nil {
+		return nil, err
+	}
+	return e.val, nil
+}
+
+type encoder struct {
+	val interface{}
+}
+
+func (e *encoder) EncodeNil()            { e.val = nil }
+func (e *encoder) EncodeBool(x bool)     { e.val = x }
+func (e *encoder) EncodeInt(x int64)     { e.val = x }
+func (e *encoder) EncodeUint(x uint64)   { e.val = int64(x) }
+func (e *encoder) EncodeBytes(x []byte)  { e.val = x }
+func (e *encoder) EncodeFloat(x float64) { e.val = x }
+func (e *encoder) EncodeString(x string) { e.val = x }
+func (e *encoder) ListIndex(int)         { panic("impossible") }
+func (e *encoder) MapKey(string)         { panic("impossible") }
+
+var typeOfGoTime = reflect.TypeOf(time.Time{})
+
+func (e *encoder) EncodeSpecial(v reflect.Value) (bool, error) {
+	if v.Type() == typeOfGoTime {
+		e.val = v.Interface()
+		return true, nil
+	}
+	return false, nil
+}
+
+func (e *encoder) EncodeList(n int) driver.Encoder {
+	s := make([]interface{}, n)
+	e.val = s
+	return &listEncoder{s: s}
+}
+
+type listEncoder struct {
+	s []interface{}
+	encoder
+}
+
+func (e *listEncoder) ListIndex(i int) { e.s[i] = e.val }
+
+func (e *encoder) EncodeMap(n int) driver.Encoder {
+	m := make(map[string]interface{}, n)
+	e.val = m
+	return &mapEncoder{m: m}
+}
+
+type mapEncoder struct {
+	m map[string]interface{}
+	encoder
+}
+
+func (e *mapEncoder) MapKey(k string) { e.m[k] = e.val }
+
+// decodeDoc decodes `m` into `ddoc`, keeping only the field paths `fps` if
+// there are any.
+func decodeDoc(m storedDoc, ddoc driver.Document, fps [][]string) error {
+	src := map[string]interface{}(m)
+	if len(fps) > 0 {
+		src = make(map[string]interface{})
+		for _, fp := range fps {
+			val, ok, err := getAtFieldPath(m, fp)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			if err := setAtFieldPath(src, fp, val); err != nil {
+				return err
+			}
+		}
+	}
+	return ddoc.Decode(decoder{src})
+}
+
+type decoder struct {
+	val interface{}
+}
+
+func (d decoder) String() string { return fmt.Sprint(d.val) }
+func (d decoder) AsNull() bool   { return d.val == nil }
+
+func (d decoder) AsBool() (bool, bool) {
+	b, ok := d.val.(bool)
+	return b, ok
+}
+
+func (d decoder) AsString() (string, bool) {
+	s, ok := d.val.(string)
+	return s, ok
+}
+
+func (d decoder) AsInt() (int64, bool) {
+	i, ok := d.val.(int64)
+	return i, ok
+}
+
+func (d decoder) AsUint() (uint64, bool) {
+	i, ok := d.val.(int64)
+	return uint64(i), ok
+}
+
+func (d decoder) AsFloat() (float64, bool) {
+	f, ok := d.val.(float64)
+	return f, ok
+}
+
+func (d decoder) AsBytes() ([]byte, bool) {
+	bs, ok := d.val.([]byte)
+	return bs, ok
+}
+
+func (d decoder) AsInterface() (interface{}, error) { return d.val, nil }
+
+func (d decoder) ListLen() (int, bool) {
+	if s, ok := d.val.([]interface{}); ok {
+		return len(s), true
+	}
+	return 0, false
+}
+
+func (d decoder) DecodeList(f func(i int, d2 driver.Decoder) bool) {
+	for i, e := range d.val.([]interface{}) {
+		if !f(i, decoder{e}) {
+			return
+		}
+	}
+}
+
+func (d decoder) MapLen() (int, bool) {
+	if m, ok := d.val.(map[string]interface{}); ok {
+		return len(m), true
+	}
+	return 0, false
+}
+
+func (d decoder) DecodeMap(f func(key string, d2 driver.Decoder, exactMatch bool) bool) {
+	for k, v := range d.val.(map[string]interface{}) {
+		if !f(k, decoder{v}, true) {
+			return
+		}
+	}
+}
+
+func (d decoder) AsSpecial(v reflect.Value) (bool, interface{}, error) {
+	if v.Type() == typeOfGoTime {
+		return true, d.val, nil
+	}
+	return false, nil, nil
+}
+
+// binary representation of a storedDoc
+
+const (
+	tagNil byte = iota
+	tagFalse
+	tagTrue
+	tagInt
+	tagFloat
+	tagString
+	tagBytes
+	tagTime
+	tagList
+	tagMap
+)
+
+var errCorrupted = errors.New("docstorekv: corrupted document")
+
+func marshalDoc(doc storedDoc) ([]byte, error) {
+	return appendValue(nil, map[string]interface{}(doc))
+}
+
+func unmarshalDoc(data []byte) (storedDoc, error) {
+	v, rest, err := readValue(data)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok || len(rest) != 0 {
+		return nil, errCorrupted
+	}
+	return storedDoc(m), nil
+}
+
+func appendValue(buf []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(buf, tagNil), nil
+	case bool:
+		if v {
+			return append(buf, tagTrue), nil
+		}
+		return append(buf, tagFalse), nil
+	case int64:
+		return binary.AppendVarint(append(buf, tagInt), v), nil
+	case float64:
+		return binary.BigEndian.AppendUint64(append(buf, tagFloat), math.Float64bits(v)), nil
+	case string:
+		return appendBytes(append(buf, tagString), []byte(v)), nil
+	case []byte:
+		return appendBytes(append(buf, tagBytes), v), nil
+	case time.Time:
+		b, err := v.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		return appendBytes(append(buf, tagTime), b), nil
+	case []interface{}:
+		buf = binary.AppendUvarint(append(buf, tagList), uint64(len(v)))
+		var err error
+		for _, elem := range v {
+			if buf, err = appendValue(buf, elem); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case map[string]interface{}:
+		// sorted so that equal docs have equal bytes
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf = binary.AppendUvarint(append(buf, tagMap), uint64(len(keys)))
+		var err error
+		for _, k := range keys {
+			buf = appendBytes(buf, []byte(k))
+			if buf, err = appendValue(buf, v[k]); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	}
+	return nil, newError(gcerrors.InvalidArgument, "can't store value of type %T", v)
+}
+
+func appendBytes(buf, b []byte) []byte {
+	return append(binary.AppendUvarint(buf, uint64(len(b))), b...)
+}
+
+func readBytes(data []byte) ([]byte, []byte, error) {
+	n, sz := binary.Uvarint(data)
+	if sz <= 0 || uint64(len(data)-sz) < n {
+		return nil, nil, errCorrupted
+	}
+	data = data[sz:]
+	return data[:n:n], data[n:], nil
+}
+
+func readValue(data []byte) (interface{}, []byte, error) {
+	if len(data) == 0 {
+		return nil, nil, errCorrupted
+	}
+	tag, data := data[0], data[1:]
+	switch tag {
+	case tagNil:
+		return nil, data, nil
+	case tagFalse:
+		return false, data, nil
+	case tagTrue:
+		return true, data, nil
+	case tagInt:
+		v, sz := binary.Varint(data)
+		if sz <= 0 {
+			return nil, nil, errCorrupted
+		}
+		return v, data[sz:], nil
+	case tagFloat:
+		if len(data) < 8 {
+			return nil, nil, errCorrupted
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(data)), data[8:], nil
+	case tagString:
+		b, rest, err := readBytes(data)
+		return string(b), rest, err
+	case tagBytes:
+		b, rest, err := readBytes(data)
+		return b, rest, err
+	case tagTime:
+		b, rest, err := readBytes(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		var t time.Time
+		if err := t.UnmarshalBinary(b); err != nil {
+			return nil, nil, errCorrupted
+		}
+		return t, rest, nil
+	case tagList:
+		n, sz := binary.Uvarint(data)
+		if sz <= 0 || n > uint64(len(data)) {
+			return nil, nil, errCorrupted
+		}
+		data = data[sz:]
+		list := make([]interface{}, n)
+		for i := range list {
+			var err error
+			if list[i], data, err = readValue(data); err != nil {
+				return nil, nil, err
+			}
+		}
+		return list, data, nil
+	case tagMap:
+		n, sz := binary.Uvarint(data)
+		if sz <= 0 || n > uint64(len(data)) {
+			return nil, nil, errCorrupted
+		}
+		data = data[sz:]
+		m := make(map[string]interface{}, n)
+		for i := uint64(0); i < n; i++ {
+			k, rest, err := readBytes(data)
+			if err != nil {
+				return nil, nil, err
+			}
+			var v interface{}
+			if v, data, err = readValue(rest); err != nil {
+				return nil, nil, err
+			}
+			m[string(k)] = v
+		}
+		return m, data, nil
+	}
+	return nil, nil, errCorrupted
+}
@@ -0,0 +1,418 @@
+// Package docstorekv implements a gocloud.dev/docstore driver on top of a
+// txkv.TransactionalKV, so applications written against the Go CDK can store
+// their documents in any txkv backend.
+//
+// Each document is stored under `prefix + key`, where key is the document's
+// primary key. String keys are used as-is, other keys are formatted with
+// fmt.Sprint. Every write action runs in its own txkv transaction; action
+// lists as a whole are not atomic, as allowed by the docstore contract.
+package docstorekv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gocloud.dev/docstore"
+	"gocloud.dev/docstore/driver"
+	"gocloud.dev/gcerrors"
+
+	"github.com/aybabtme/txkv"
+)
+
+// Options are optional arguments to the OpenCollection functions.
+type Options struct {
+	// The name of the field holding the document revision.
+	// Defaults to docstore.DefaultRevisionField.
+	RevisionField string
+}
+
+// OpenCollection returns a collection storing its documents in `kv`, under
+// `prefix`. keyField is the document field holding the primary key.
+func OpenCollection(kv txkv.TransactionalKV, prefix txkv.Key, keyField string, opts *Options) (*docstore.Collection, error) {
+	c, err := newCollection(kv, prefix, keyField, nil, opts)
+	if err != nil {
+		return nil, err
+	}
+	return docstore.NewCollection(c), nil
+}
+
+// OpenCollectionWithKeyFunc is like OpenCollection, but the primary key of a
+// document is computed by `keyFunc`. It should return nil if the document is
+// missing the information to construct a key.
+func OpenCollectionWithKeyFunc(kv txkv.TransactionalKV, prefix txkv.Key, keyFunc func(docstore.Document) interface{}, opts *Options) (*docstore.Collection, error) {
+	c, err := newCollection(kv, prefix, "", keyFunc, opts)
+	if err != nil {
+		return nil, err
+	}
+	return docstore.NewCollection(c), nil
+}
+
+func newCollection(kv txkv.TransactionalKV, prefix txkv.Key, keyField string, keyFunc func(docstore.Document) interface{}, opts *Options) (*collection, error) {
+	if keyField == "" && keyFunc == nil {
+		return nil, newError(gcerrors.InvalidArgument, "must provide either keyField or keyFunc")
+	}
+	if opts == nil {
+		opts = &Options{}
+	}
+	revField := opts.RevisionField
+	if revField == "" {
+		revField = docstore.DefaultRevisionField
+	}
+	return &collection{
+		kv:       kv,
+		prefix:   append(txkv.Key(nil), prefix...),
+		keyField: keyField,
+		keyFunc:  keyFunc,
+		revField: revField,
+	}, nil
+}
+
+type collection struct {
+	kv       txkv.TransactionalKV
+	prefix   txkv.Key
+	keyField string
+	keyFunc  func(docstore.Document) interface{}
+	revField string
+}
+
+var (
+	_ driver.Collection    = (*collection)(nil)
+	_ driver.DeleteQueryer = (*collection)(nil)
+)
+
+// Error is returned by the driver, carrying the docstore error code that the
+// failure maps to.
+type Error struct {
+	Code gcerrors.ErrorCode
+	Msg  string
+}
+
+func (e *Error) Error() string { return "docstorekv: " + e.Msg }
+
+func newError(code gcerrors.ErrorCode, format string, args ...interface{}) error {
+	return &Error{Code: code, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (c *collection) Key(doc driver.Document) (interface{}, error) {
+	if c.keyField != "" {
+		key, _ := doc.GetField(c.keyField) // no error on missing key, it will be nil
+		return key, nil
+	}
+	key := c.keyFunc(doc.Origin)
+	if key == nil || driver.IsEmptyValue(reflect.ValueOf(key)) {
+		return nil, newError(gcerrors.InvalidArgument, "missing document key")
+	}
+	return key, nil
+}
+
+func (c *collection) kvKey(key interface{}) txkv.Key {
+	var s string
+	switch k := key.(type) {
+	case string:
+		s = k
+	default:
+		s = fmt.Sprint(k)
+	}
+	out := make(txkv.Key, 0, len(c.prefix)+len(s))
+	return append(append(out, c.prefix...), s...)
+}
+
+func (c *collection) RevisionField() string { return c.revField }
+
+func (c *collection) RunActions(ctx context.Context, actions []*driver.Action, opts *driver.RunActionsOptions) driver.ActionListError {
+	errs := make([]error, len(actions))
+	if opts.BeforeDo != nil {
+		if err := opts.BeforeDo(func(interface{}) bool { return false }); err != nil {
+			for i := range errs {
+				errs[i] = err
+			}
+			return driver.NewActionListError(errs)
+		}
+	}
+	// gets that must see the state before the writes run first, then the
+	// writes, then the gets that must see their result
+	beforeGets, gets, writes, afterGets := driver.GroupActions(actions)
+	for _, group := range [][]*driver.Action{beforeGets, gets, writes, afterGets} {
+		for _, a := range group {
+			errs[a.Index] = c.runAction(ctx, a)
+		}
+	}
+	return driver.NewActionListError(errs)
+}
+
+func (c *collection) runAction(ctx context.Context, a *driver.Action) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if a.Kind == driver.Get {
+		current, ok, err := c.get(ctx, c.kv, a.Key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return newError(gcerrors.NotFound, "document with key %v does not exist", a.Key)
+		}
+		return decodeDoc(current, a.Doc, a.FieldPaths)
+	}
+
+	tx, err := c.kv.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	if err := c.write(ctx, tx, a); err != nil {
+		_ = tx.Rollback(ctx)
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (c *collection) write(ctx context.Context, tx txkv.TxKV, a *driver.Action) error {
+	var (
+		current storedDoc
+		exists  bool
+		err     error
+	)
+	if a.Key != nil {
+		if current, exists, err = c.get(ctx, tx, a.Key); err != nil {
+			return err
+		}
+	}
+	if !exists && (a.Kind == driver.Replace || a.Kind == driver.Update) {
+		return newError(gcerrors.NotFound, "document with key %v does not exist", a.Key)
+	}
+	switch a.Kind {
+	case driver.Create:
+		if exists {
+			return newError(gcerrors.AlreadyExists, "document with key %v exists", a.Key)
+		}
+		if a.Key == nil {
+			if c.keyField == "" {
+				return newError(gcerrors.InvalidArgument, "missing document key")
+			}
+			a.Key = driver.UniqueString()
+			if err := a.Doc.SetField(c.keyField, a.Key); err != nil {
+				return newError(gcerrors.InvalidArgument, "cannot set key field %q", c.keyField)
+			}
+		}
+		fallthrough
+
+	case driver.Replace, driver.Put:
+		if err := c.checkRevision(a.Doc, current); err != nil {
+			return err
+		}
+		doc, err := encodeDoc(a.Doc)
+		if err != nil {
+			return err
+		}
+		if a.Doc.HasField(c.revField) {
+			rev := driver.UniqueString()
+			doc[c.revField] = rev
+			if err := a.Doc.SetField(c.revField, rev); err != nil {
+				return err
+			}
+		}
+		return c.put(ctx, tx, a.Key, doc)
+
+	case driver.Delete:
+		if err := c.checkRevision(a.Doc, current); err != nil {
+			return err
+		}
+		return tx.Delete(ctx, c.kvKey(a.Key))
+
+	case driver.Update:
+		if err := c.checkRevision(a.Doc, current); err != nil {
+			return err
+		}
+		if err := update(current, a.Mods); err != nil {
+			return err
+		}
+		if a.Doc.HasField(c.revField) {
+			rev := driver.UniqueString()
+			current[c.revField] = rev
+			if err := a.Doc.SetField(c.revField, rev); err != nil {
+				return err
+			}
+		}
+		return c.put(ctx, tx, a.Key, current)
+	}
+	return newError(gcerrors.Internal, "unknown action kind %v", a.Kind)
+}
+
+func (c *collection) get(ctx context.Context, kv txkv.KV, key interface{}) (storedDoc, bool, error) {
+	data, ok, err := kv.Get(ctx, c.kvKey(key))
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	doc, err := unmarshalDoc(data)
+	if err != nil {
+		return nil, false, err
+	}
+	return doc, true, nil
+}
+
+func (c *collection) put(ctx context.Context, kv txkv.KV, key interface{}, doc storedDoc) error {
+	data, err := marshalDoc(doc)
+	if err != nil {
+		return err
+	}
+	return kv.Put(ctx, c.kvKey(key), data)
+}
+
+func (c *collection) checkRevision(arg driver.Document, current storedDoc) error {
+	if current == nil {
+		return nil
+	}
+	curRev, ok := current[c.revField]
+	if !ok {
+		return nil // there is no revision to check
+	}
+	wantRev, err := arg.GetField(c.revField)
+	if err != nil || wantRev == nil {
+		return nil // no incoming revision: nothing to check
+	}
+	if _, ok := wantRev.(string); !ok {
+		return newError(gcerrors.InvalidArgument, "revision field %s is not a string", c.revField)
+	}
+	if wantRev != curRev {
+		return newError(gcerrors.FailedPrecondition, "mismatched revisions: want %v, current %v", wantRev, curRev)
+	}
+	return nil
+}
+
+// update applies `mods` to `doc`. Either all of them are applied, or none.
+func update(doc storedDoc, mods []driver.Mod) error {
+	type guaranteedMod struct {
+		parent  map[string]interface{}
+		key     string
+		encoded interface{}
+	}
+	gmods := make([]guaranteedMod, len(mods))
+	for i, mod := range mods {
+		parent, err := getParentMap(doc, mod.FieldPath, true)
+		if err != nil {
+			return err
+		}
+		gmod := guaranteedMod{parent: parent, key: mod.FieldPath[len(mod.FieldPath)-1]}
+		if inc, ok := mod.Value.(driver.IncOp); ok {
+			amt, err := encodeValue(inc.Amount)
+			if err != nil {
+				return err
+			}
+			if gmod.encoded, err = add(parent[gmod.key], amt); err != nil {
+				return err
+			}
+		} else if mod.Value != nil {
+			if gmod.encoded, err = encodeValue(mod.Value); err != nil {
+				return err
+			}
+		}
+		gmods[i] = gmod
+	}
+	for _, m := range gmods {
+		if m.encoded == nil {
+			delete(m.parent, m.key)
+		} else {
+			m.parent[m.key] = m.encoded
+		}
+	}
+	return nil
+}
+
+// add two encoded numbers, which are either int64 or float64.
+func add(x, y interface{}) (interface{}, error) {
+	if x == nil {
+		return y, nil
+	}
+	switch x := x.(type) {
+	case int64:
+		switch y := y.(type) {
+		case int64:
+			return x + y, nil
+		case float64:
+			return float64(x) + y, nil
+		}
+	case float64:
+		switch y := y.(type) {
+		case int64:
+			return x + float64(y), nil
+		case float64:
+			return x + y, nil
+		}
+	default:
+		return nil, newError(gcerrors.InvalidArgument, "value %v being incremented is not a number", x)
+	}
+	return nil, newError(gcerrors.InvalidArgument, "bad increment amount type %T", y)
+}
+
+// getAtFieldPath gets the value of `m` at `fp`, telling if it was there.
+func getAtFieldPath(m map[string]interface{}, fp []string) (interface{}, bool, error) {
+	parent, err := getParentMap(m, fp, false)
+	if err != nil || parent == nil {
+		return nil, false, err
+	}
+	v, ok := parent[fp[len(fp)-1]]
+	return v, ok, nil
+}
+
+func setAtFieldPath(m map[string]interface{}, fp []string, val interface{}) error {
+	parent, err := getParentMap(m, fp, true)
+	if err != nil {
+		return err
+	}
+	parent[fp[len(fp)-1]] = val
+	return nil
+}
+
+// getParentMap returns the map that directly contains the field path `fp`. If
+// nil is encountered along the way, nil is returned unless `create` is true,
+// in which case the intermediate maps are created.
+func getParentMap(m map[string]interface{}, fp []string, create bool) (map[string]interface{}, error) {
+	for _, k := range fp[:len(fp)-1] {
+		if m[k] == nil {
+			if !create {
+				return nil, nil
+			}
+			m[k] = make(map[string]interface{})
+		}
+		next, ok := m[k].(map[string]interface{})
+		if !ok {
+			return nil, newError(gcerrors.InvalidArgument, "invalid field path %q at %q", strings.Join(fp, "."), k)
+		}
+		m = next
+	}
+	return m, nil
+}
+
+func (c *collection) RevisionToBytes(rev interface{}) ([]byte, error) {
+	r, ok := rev.(string)
+	if !ok {
+		return nil, newError(gcerrors.InvalidArgument, "revision %v of type %[1]T is not a string", rev)
+	}
+	return []byte(r), nil
+}
+
+func (c *collection) BytesToRevision(b []byte) (interface{}, error) {
+	return string(b), nil
+}
+
+func (c *collection) As(i interface{}) bool { return false }
+
+func (c *collection) ErrorAs(err error, i interface{}) bool { return false }
+
+func (c *collection) ErrorCode(err error) gcerrors.ErrorCode {
+	var derr *Error
+	switch {
+	case errors.As(err, &derr):
+		return derr.Code
+	case errors.Is(err, context.Canceled):
+		return gcerrors.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return gcerrors.DeadlineExceeded
+	}
+	return gcerrors.Unknown
+}
+
+func (c *collection) Close() error { return nil }
@@ -0,0 +1,113 @@
+package docstorekv
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gocloud.dev/docstore/driver"
+	"gocloud.dev/docstore/drivertest"
+
+	"github.com/aybabtme/txkv"
+)
+
+type harness struct {
+	kv txkv.TransactionalKV
+}
+
+func newHarness(ctx context.Context, t *testing.T) (drivertest.Harness, error) {
+	return &harness{kv: txkv.InMem()}, nil
+}
+
+func (h *harness) MakeCollection(_ context.Context, kind drivertest.CollectionKind) (driver.Collection, error) {
+	switch kind {
+	case drivertest.SingleKey, drivertest.NoRev:
+		return newCollection(h.kv, txkv.Key("single/"), drivertest.KeyField, nil, nil)
+	case drivertest.TwoKey:
+		return newCollection(h.kv, txkv.Key("two/"), "", drivertest.HighScoreKey, nil)
+	case drivertest.AltRev:
+		return newCollection(h.kv, txkv.Key("altrev/"), drivertest.KeyField, nil, &Options{
+			RevisionField: drivertest.AlternateRevisionField,
+		})
+	}
+	panic("bad kind")
+}
+
+func (*harness) BeforeDoTypes() []interface{}    { return nil }
+func (*harness) BeforeQueryTypes() []interface{} { return nil }
+
+func (*harness) RevisionsEqual(rev1, rev2 interface{}) bool { return rev1 == rev2 }
+
+func (*harness) Close() {}
+
+func TestConformance(t *testing.T) {
+	drivertest.RunConformanceTests(t, newHarness, nil, nil)
+}
+
+func TestCodecRoundtrip(t *testing.T) {
+	doc := storedDoc{
+		"nil":   nil,
+		"bool":  true,
+		"int":   int64(-42),
+		"float": 3.5,
+		"str":   "hello",
+		"bytes": []byte("world"),
+		"time":  time.Date(2020, 1, 2, 3, 4, 5, 6, time.FixedZone("here", 3600)),
+		"list":  []interface{}{int64(1), "two", nil},
+		"map":   map[string]interface{}{"nested": false},
+	}
+	data, err := marshalDoc(doc)
+	require.NoError(t, err)
+	got, err := unmarshalDoc(data)
+	require.NoError(t, err)
+
+	wantTime := doc["time"].(time.Time)
+	gotTime := got["time"].(time.Time)
+	require.True(t, wantTime.Equal(gotTime))
+	delete(doc, "time")
+	delete(got, "time")
+	require.Equal(t, doc, got)
+
+	_, err = unmarshalDoc(data[:len(data)-1])
+	require.Error(t, err)
+}
+
+func TestStoredInKV(t *testing.T) {
+	ctx := context.Background()
+	kv := txkv.InMem()
+	coll, err := OpenCollection(kv, txkv.Key("people/"), "name", nil)
+	require.NoError(t, err)
+	defer coll.Close()
+
+	type person struct {
+		Name             string
+		Age              int
+		DocstoreRevision interface{}
+	}
+	require.NoError(t, coll.Create(ctx, &person{Name: "alice", Age: 30}))
+	require.NoError(t, coll.Create(ctx, &person{Name: "bob", Age: 40}))
+
+	keys, err := kv.List(ctx, txkv.Key("people/"))
+	require.NoError(t, err)
+	require.Equal(t, []txkv.Key{txkv.Key("people/alice"), txkv.Key("people/bob")}, keys)
+
+	got := &person{Name: "bob"}
+	require.NoError(t, coll.Get(ctx, got))
+	require.Equal(t, 40, got.Age)
+
+	iter := coll.Query().Where("Age", ">", 35).Get(ctx)
+	defer iter.Stop()
+	var found []string
+	for {
+		var p person
+		if err := iter.Next(ctx, &p); err != nil {
+			break
+		}
+		found = append(found, p.Name)
+	}
+	require.Equal(t, []string{"bob"}, found)
+
+	stale := &person{Name: "bob", Age: 41, DocstoreRevision: "not-the-revision"}
+	require.Error(t, coll.Replace(ctx, stale))
+}
@@ -0,0 +1,218 @@
+package docstorekv
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"gocloud.dev/docstore/driver"
+
+	"github.com/aybabtme/txkv"
+)
+
+func (c *collection) RunGetQuery(ctx context.Context, q *driver.Query) (driver.DocumentIterator, error) {
+	if q.BeforeQuery != nil {
+		if err := q.BeforeQuery(func(interface{}) bool { return false }); err != nil {
+			return nil, err
+		}
+	}
+	docs, err := c.query(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	if q.OrderByField != "" {
+		sortDocs(docs, q.OrderByField, q.OrderAscending)
+	}
+	if q.Offset > 0 {
+		if q.Offset >= len(docs) {
+			docs = nil
+		} else {
+			docs = docs[q.Offset:]
+		}
+	}
+	if q.Limit > 0 && len(docs) > q.Limit {
+		docs = docs[:q.Limit]
+	}
+	// the key field is always returned
+	fps := q.FieldPaths
+	if len(fps) > 0 && c.keyField != "" {
+		fps = append([][]string{{c.keyField}}, fps...)
+	}
+	return &docIterator{docs: docs, fieldPaths: fps}, nil
+}
+
+func (c *collection) RunDeleteQuery(ctx context.Context, q *driver.Query) error {
+	tx, err := c.kv.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	keys, err := c.matchingKeys(ctx, tx, q)
+	if err == nil {
+		for _, key := range keys {
+			if err = tx.Delete(ctx, key); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		_ = tx.Rollback(ctx)
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// query returns the documents matching the filters of `q`.
+func (c *collection) query(ctx context.Context, q *driver.Query) ([]storedDoc, error) {
+	var docs []storedDoc
+	err := c.scan(ctx, c.kv, q, func(_ []byte, doc storedDoc) {
+		docs = append(docs, doc)
+	})
+	return docs, err
+}
+
+// matchingKeys returns the KV keys of the documents matching the filters of
+// `q`.
+func (c *collection) matchingKeys(ctx context.Context, kv txkv.KV, q *driver.Query) ([][]byte, error) {
+	var keys [][]byte
+	err := c.scan(ctx, kv, q, func(key []byte, _ storedDoc) {
+		keys = append(keys, key)
+	})
+	return keys, err
+}
+
+func (c *collection) scan(ctx context.Context, kv txkv.KV, q *driver.Query, visit func([]byte, storedDoc)) error {
+	keys, err := kv.List(ctx, c.prefix)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		data, ok, err := kv.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue // deleted since we listed it
+		}
+		doc, err := unmarshalDoc(data)
+		if err != nil {
+			return err
+		}
+		if filtersMatch(q.Filters, doc) {
+			visit(key, doc)
+		}
+	}
+	return nil
+}
+
+func (c *collection) QueryPlan(q *driver.Query) (string, error) {
+	return "prefix scan of " + string(c.prefix), nil
+}
+
+func filtersMatch(fs []driver.Filter, doc storedDoc) bool {
+	for _, f := range fs {
+		docval, ok, err := getAtFieldPath(doc, f.FieldPath)
+		if err != nil || !ok {
+			return false
+		}
+		cmp, ok := compare(docval, f.Value)
+		if !ok || !applyComparison(f.Op, cmp) {
+			return false
+		}
+	}
+	return true
+}
+
+func applyComparison(op string, c int) bool {
+	switch op {
+	case driver.EqualOp, "in":
+		return c == 0
+	case "not-in":
+		return c != 0
+	case ">":
+		return c > 0
+	case "<":
+		return c < 0
+	case ">=":
+		return c >= 0
+	case "<=":
+		return c <= 0
+	}
+	return false
+}
+
+// compare `x1` and `x2`, telling if they could be compared at all. When `x2`
+// is a slice, compare tells whether `x1` is in it (0) or not (-1).
+func compare(x1, x2 interface{}) (int, bool) {
+	v1 := reflect.ValueOf(x1)
+	v2 := reflect.ValueOf(x2)
+	if v2.Kind() == reflect.Slice {
+		for i := 0; i < v2.Len(); i++ {
+			if c, ok := compare(x1, v2.Index(i).Interface()); ok && c == 0 {
+				return 0, true
+			}
+		}
+		return -1, true
+	}
+	if v1.Kind() == reflect.String && v2.Kind() == reflect.String {
+		return strings.Compare(v1.String(), v2.String()), true
+	}
+	if cmp, err := driver.CompareNumbers(v1, v2); err == nil {
+		return cmp, true
+	}
+	if t1, ok := x1.(time.Time); ok {
+		if t2, ok := x2.(time.Time); ok {
+			return driver.CompareTimes(t1, t2), true
+		}
+	}
+	if v1.Kind() == reflect.Bool && v2.Kind() == reflect.Bool {
+		if v1.Bool() == v2.Bool() {
+			return 0, true
+		}
+		return -1, true
+	}
+	return 0, false
+}
+
+// sortDocs orders docs by `field`, docs that can't be compared are left
+// where they are.
+func sortDocs(docs []storedDoc, field string, asc bool) {
+	sort.SliceStable(docs, func(i, j int) bool {
+		c, ok := compare(docs[i][field], docs[j][field])
+		if !ok {
+			return false
+		}
+		if asc {
+			return c < 0
+		}
+		return c > 0
+	})
+}
+
+type docIterator struct {
+	docs       []storedDoc
+	fieldPaths [][]string
+	err        error
+}
+
+func (it *docIterator) Next(ctx context.Context, doc driver.Document) error {
+	if it.err != nil {
+		return it.err
+	}
+	if len(it.docs) == 0 {
+		it.err = io.EOF
+		return it.err
+	}
+	if err := decodeDoc(it.docs[0], doc, it.fieldPaths); err != nil {
+		it.err = err
+		return it.err
+	}
+	it.docs = it.docs[1:]
+	return nil
+}
+
+func (it *docIterator) Stop() { it.err = io.EOF }
+
+func (it *docIterator) As(i interface{}) bool { return false }
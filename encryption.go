@@ -0,0 +1,464 @@
+package txkv
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Keyring provides the key-encryption keys (KEKs) protecting values. Each key
+// is identified by an ID that is recorded alongside the values it protects, so
+// that old keys keep working after a new one becomes primary.
+type Keyring interface {
+	// Primary returns the ID and AES key (16, 24 or 32 bytes long) that new
+	// values are protected with.
+	Primary(ctx context.Context) (id string, secret []byte, err error)
+	// Lookup returns the AES key with the given ID. It returns an error
+	// wrapping ErrKeyNotFound if the keyring doesn't know about it.
+	Lookup(ctx context.Context, id string) ([]byte, error)
+}
+
+// ErrKeyNotFound is returned by keyrings that don't know a key ID.
+var ErrKeyNotFound = errors.New("txkv: encryption key not found")
+
+// StaticKeyring is a Keyring holding a single AES key, with an empty ID.
+type StaticKeyring []byte
+
+// Primary returns the static key.
+func (k StaticKeyring) Primary(context.Context) (string, []byte, error) { return "", k, nil }
+
+// Lookup returns the static key if `id` is empty.
+func (k StaticKeyring) Lookup(_ context.Context, id string) ([]byte, error) {
+	if id != "" {
+		return nil, fmt.Errorf("%w: %q", ErrKeyNotFound, id)
+	}
+	return k, nil
+}
+
+// MultiKeyring is a Keyring holding several keys, one of which is primary.
+// It's safe for concurrent use, so keys can be added and promoted while the
+// store is in use.
+type MultiKeyring struct {
+	mu      sync.RWMutex
+	primary string
+	keys    map[string][]byte
+}
+
+// NewMultiKeyring creates a keyring where `secret` is the primary key.
+func NewMultiKeyring(id string, secret []byte) *MultiKeyring {
+	return &MultiKeyring{primary: id, keys: map[string][]byte{id: secret}}
+}
+
+// Add a key to the keyring. It can decrypt values right away, but only
+// protects new values once made primary.
+func (k *MultiKeyring) Add(id string, secret []byte) {
+	k.mu.Lock()
+	k.keys[id] = secret
+	k.mu.Unlock()
+}
+
+// SetPrimary makes the key `id` the one protecting new values.
+func (k *MultiKeyring) SetPrimary(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.keys[id]; !ok {
+		return fmt.Errorf("%w: %q", ErrKeyNotFound, id)
+	}
+	k.primary = id
+	return nil
+}
+
+// Remove a key from the keyring. Values still protected by it can't be read
+// anymore, see EncryptedKV.Rotate. The primary key can't be removed.
+func (k *MultiKeyring) Remove(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if id == k.primary {
+		return fmt.Errorf("txkv: can't remove primary key %q", id)
+	}
+	delete(k.keys, id)
+	return nil
+}
+
+// Primary returns the primary key.
+func (k *MultiKeyring) Primary(context.Context) (string, []byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.primary, k.keys[k.primary], nil
+}
+
+// Lookup returns the key `id`.
+func (k *MultiKeyring) Lookup(_ context.Context, id string) ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	secret, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrKeyNotFound, id)
+	}
+	return secret, nil
+}
+
+// DecryptionError is returned when a value can't be authenticated and
+// decrypted, either because it was tampered with, because its key isn't in
+// the keyring, or because it was never encrypted.
+type DecryptionError struct {
+	Key Key
+	Err error
+}
+
+func (e *DecryptionError) Error() string {
+	return fmt.Sprintf("txkv: can't decrypt value at key %q: %v", e.Key, e.Err)
+}
+
+func (e *DecryptionError) Unwrap() error { return e.Err }
+
+var errMalformedEnvelope = errors.New("malformed envelope")
+
+// WithEncryption returns a TransactionalKV that encrypts values with AES-GCM
+// before they reach `kv`, and decrypts them when they are read.
+//
+// Values are envelope encrypted: each one is sealed with its own random data
+// key, and that data key is itself sealed with the keyring's primary key. The
+// ID of the primary key is recorded in the envelope, so values protected by
+// older keys stay readable as long as the keyring knows about them. Both
+// layers authenticate the value's key, so a value can't be moved to another
+// key undetected. Keys themselves are stored in the clear, so that List keeps
+// working.
+func WithEncryption(kv TransactionalKV, keyring Keyring) *EncryptedKV {
+	return &EncryptedKV{kv: kv, keyring: keyring}
+}
+
+// EncryptedKV is a TransactionalKV encrypting the values of another one.
+type EncryptedKV struct {
+	kv      TransactionalKV
+	keyring Keyring
+
+	// writes hold it shared, rotation holds it exclusively so that it
+	// never re-seals a value that's being overwritten or deleted
+	rotating sync.RWMutex
+}
+
+var _ TransactionalKV = (*EncryptedKV)(nil)
+
+func (e *EncryptedKV) Put(ctx context.Context, key Key, value Value) error {
+	sealed, err := e.seal(ctx, key, value)
+	if err != nil {
+		return err
+	}
+	e.rotating.RLock()
+	defer e.rotating.RUnlock()
+	return e.kv.Put(ctx, key, sealed)
+}
+
+func (e *EncryptedKV) Get(ctx context.Context, key Key) (Value, bool, error) {
+	return e.get(ctx, e.kv, key)
+}
+
+func (e *EncryptedKV) Delete(ctx context.Context, key Key) error {
+	e.rotating.RLock()
+	defer e.rotating.RUnlock()
+	return e.kv.Delete(ctx, key)
+}
+
+func (e *EncryptedKV) List(ctx context.Context, prefix Key) ([]Key, error) {
+	return e.kv.List(ctx, prefix)
+}
+
+func (e *EncryptedKV) Begin(ctx context.Context) (TxKV, error) {
+	tx, err := e.kv.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedTx{e: e, tx: tx}, nil
+}
+
+// rotateBatchSize is how many keys are re-sealed per transaction by Rotate.
+const rotateBatchSize = 100
+
+// Rotate re-seals the data keys of all values that aren't protected by the
+// keyring's primary key, so that older keys can eventually be removed from
+// the keyring. Since values are envelope encrypted, only their data keys are
+// re-sealed; the values themselves are left as is.
+//
+// Rotate works in small transactional batches and can run in the background
+// while the store is in use. Writes made through this EncryptedKV are never
+// lost to a concurrent rotation, but writes made directly to the underlying
+// store are not coordinated with it. It returns how many values were
+// re-sealed.
+func (e *EncryptedKV) Rotate(ctx context.Context) (int, error) {
+	keys, err := e.kv.List(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	rotated := 0
+	for len(keys) > 0 {
+		n := rotateBatchSize
+		if n > len(keys) {
+			n = len(keys)
+		}
+		batch := keys[:n]
+		keys = keys[n:]
+
+		count, err := e.rotateBatch(ctx, batch)
+		rotated += count
+		if err != nil {
+			return rotated, err
+		}
+	}
+	return rotated, nil
+}
+
+func (e *EncryptedKV) rotateBatch(ctx context.Context, keys []Key) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	primaryID, primary, err := e.keyring.Primary(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	e.rotating.Lock()
+	defer e.rotating.Unlock()
+
+	tx, err := e.kv.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	rotated := 0
+	for _, key := range keys {
+		sealed, ok, err := tx.Get(ctx, key)
+		if err != nil {
+			_ = tx.Rollback(ctx)
+			return 0, err
+		}
+		if !ok {
+			continue // deleted since we listed it
+		}
+		env, err := parseEnvelope(sealed)
+		if err != nil {
+			_ = tx.Rollback(ctx)
+			return 0, &DecryptionError{Key: key, Err: err}
+		}
+		if env.keyID == primaryID {
+			continue
+		}
+		dataKey, err := e.openDataKey(ctx, key, env)
+		if err != nil {
+			_ = tx.Rollback(ctx)
+			return 0, err
+		}
+		resealed, err := sealEnvelope(primaryID, primary, dataKey, key, env.nonce, env.ciphertext)
+		if err != nil {
+			_ = tx.Rollback(ctx)
+			return 0, err
+		}
+		if err := tx.Put(ctx, key, resealed); err != nil {
+			_ = tx.Rollback(ctx)
+			return 0, err
+		}
+		rotated++
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return rotated, nil
+}
+
+func (e *EncryptedKV) get(ctx context.Context, kv KV, key Key) (Value, bool, error) {
+	sealed, ok, err := kv.Get(ctx, key)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	value, err := e.open(ctx, key, sealed)
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (e *EncryptedKV) seal(ctx context.Context, key Key, value Value) (Value, error) {
+	keyID, kek, err := e.keyring.Primary(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomNonce(aead)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext := aead.Seal(nil, nonce, value, key)
+	return sealEnvelope(keyID, kek, dataKey, key, nonce, ciphertext)
+}
+
+func (e *EncryptedKV) open(ctx context.Context, key Key, sealed Value) (Value, error) {
+	env, err := parseEnvelope(sealed)
+	if err != nil {
+		return nil, &DecryptionError{Key: key, Err: err}
+	}
+	dataKey, err := e.openDataKey(ctx, key, env)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	value, err := aead.Open(nil, env.nonce, env.ciphertext, key)
+	if err != nil {
+		return nil, &DecryptionError{Key: key, Err: err}
+	}
+	return value, nil
+}
+
+func (e *EncryptedKV) openDataKey(ctx context.Context, key Key, env envelope) ([]byte, error) {
+	kek, err := e.keyring.Lookup(ctx, env.keyID)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, &DecryptionError{Key: key, Err: err}
+	} else if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(env.sealedDataKey) < aead.NonceSize() {
+		return nil, &DecryptionError{Key: key, Err: errMalformedEnvelope}
+	}
+	nonce, sealedDataKey := env.sealedDataKey[:aead.NonceSize()], env.sealedDataKey[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, sealedDataKey, key)
+	if err != nil {
+		return nil, &DecryptionError{Key: key, Err: err}
+	}
+	return dataKey, nil
+}
+
+// envelopeVersion is the first byte of every sealed value. An envelope is:
+//
+//	version | uvarint len | key ID | uvarint len | sealed data key | nonce | ciphertext
+//
+// where the sealed data key is itself `nonce | ciphertext`, sealed with the
+// key-encryption key.
+const envelopeVersion = 1
+
+type envelope struct {
+	keyID         string
+	sealedDataKey []byte
+	nonce         []byte
+	ciphertext    []byte
+}
+
+func sealEnvelope(keyID string, kek, dataKey []byte, key Key, nonce, ciphertext []byte) (Value, error) {
+	aead, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	dkNonce, err := randomNonce(aead)
+	if err != nil {
+		return nil, err
+	}
+	sealedDataKey := aead.Seal(dkNonce, dkNonce, dataKey, key)
+
+	out := make(Value, 0, 1+2*binary.MaxVarintLen64+len(keyID)+len(sealedDataKey)+len(nonce)+len(ciphertext))
+	out = append(out, envelopeVersion)
+	out = binary.AppendUvarint(out, uint64(len(keyID)))
+	out = append(out, keyID...)
+	out = binary.AppendUvarint(out, uint64(len(sealedDataKey)))
+	out = append(out, sealedDataKey...)
+	out = append(out, nonce...)
+	return append(out, ciphertext...), nil
+}
+
+func parseEnvelope(sealed []byte) (envelope, error) {
+	var env envelope
+	if len(sealed) == 0 || sealed[0] != envelopeVersion {
+		return env, errMalformedEnvelope
+	}
+	rest := sealed[1:]
+	keyID, rest, ok := readUvarintBytes(rest)
+	if !ok {
+		return env, errMalformedEnvelope
+	}
+	sealedDataKey, rest, ok := readUvarintBytes(rest)
+	if !ok {
+		return env, errMalformedEnvelope
+	}
+	// data keys are always sealed with AES-GCM's standard nonce size
+	const nonceSize = 12
+	if len(rest) < nonceSize {
+		return env, errMalformedEnvelope
+	}
+	env.keyID = string(keyID)
+	env.sealedDataKey = sealedDataKey
+	env.nonce = rest[:nonceSize]
+	env.ciphertext = rest[nonceSize:]
+	return env, nil
+}
+
+func readUvarintBytes(b []byte) (field, rest []byte, ok bool) {
+	n, sz := binary.Uvarint(b)
+	if sz <= 0 || uint64(len(b)-sz) < n {
+		return nil, nil, false
+	}
+	b = b[sz:]
+	return b[:n], b[n:], true
+}
+
+func newGCM(secret []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func randomNonce(aead cipher.AEAD) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	_, err := io.ReadFull(rand.Reader, nonce)
+	return nonce, err
+}
+
+type encryptedTx struct {
+	e  *EncryptedKV
+	tx TxKV
+}
+
+func (t *encryptedTx) Put(ctx context.Context, key Key, value Value) error {
+	sealed, err := t.e.seal(ctx, key, value)
+	if err != nil {
+		return err
+	}
+	return t.tx.Put(ctx, key, sealed)
+}
+
+func (t *encryptedTx) Get(ctx context.Context, key Key) (Value, bool, error) {
+	return t.e.get(ctx, t.tx, key)
+}
+
+func (t *encryptedTx) Delete(ctx context.Context, key Key) error {
+	return t.tx.Delete(ctx, key)
+}
+
+func (t *encryptedTx) List(ctx context.Context, prefix Key) ([]Key, error) {
+	return t.tx.List(ctx, prefix)
+}
+
+func (t *encryptedTx) Commit(ctx context.Context) error {
+	t.e.rotating.RLock()
+	defer t.e.rotating.RUnlock()
+	return t.tx.Commit(ctx)
+}
+
+func (t *encryptedTx) Rollback(ctx context.Context) error { return t.tx.Rollback(ctx) }
@@ -0,0 +1,103 @@
+package txkv_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+var testAESKey = bytes.Repeat([]byte{0x42}, 32)
+
+func TestEncryption(t *testing.T) {
+	testKV(t, func(t testing.TB) TransactionalKV {
+		return WithEncryption(InMem(), StaticKeyring(testAESKey))
+	})
+}
+
+func TestEncryptionAtRest(t *testing.T) {
+	ctx := context.Background()
+	raw := InMem()
+	kv := WithEncryption(raw, StaticKeyring(testAESKey))
+
+	key, want := Key("hello"), Value("world")
+	mustPut(ctx, t, kv, key, want)
+	mustFind(ctx, t, kv, key, want)
+
+	// the underlying store never sees the value
+	sealed, ok, err := raw.Get(ctx, key)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.False(t, bytes.Contains(sealed, want))
+
+	// the same value doesn't encrypt to the same bytes twice
+	mustPut(ctx, t, kv, key, want)
+	resealed, _, err := raw.Get(ctx, key)
+	require.NoError(t, err)
+	require.NotEqual(t, sealed, resealed)
+
+	var derr *DecryptionError
+
+	// tampering is detected
+	tampered := append(Value(nil), resealed...)
+	tampered[len(tampered)-1] ^= 0xff
+	mustPut(ctx, t, raw, key, tampered)
+	_, _, err = kv.Get(ctx, key)
+	require.True(t, errors.As(err, &derr), "%v", err)
+	require.Equal(t, key, derr.Key)
+
+	// moving a value to another key is detected
+	mustPut(ctx, t, raw, Key("moved"), resealed)
+	_, _, err = kv.Get(ctx, Key("moved"))
+	require.True(t, errors.As(err, &derr), "%v", err)
+
+	// so is using the wrong key
+	mustPut(ctx, t, raw, key, resealed)
+	other := WithEncryption(raw, StaticKeyring(bytes.Repeat([]byte{0x24}, 32)))
+	_, _, err = other.Get(ctx, key)
+	require.True(t, errors.As(err, &derr), "%v", err)
+}
+
+func TestEncryptionRotate(t *testing.T) {
+	ctx := context.Background()
+	raw := InMem()
+	keyring := NewMultiKeyring("v1", testAESKey)
+	kv := WithEncryption(raw, keyring)
+
+	for _, k := range []string{"a", "b", "c"} {
+		mustPut(ctx, t, kv, Key(k), Value("value-"+k))
+	}
+
+	// a new key protects new values, old ones are still readable
+	keyring.Add("v2", bytes.Repeat([]byte{0x24}, 32))
+	require.NoError(t, keyring.SetPrimary("v2"))
+	mustPut(ctx, t, kv, Key("d"), Value("value-d"))
+	mustFind(ctx, t, kv, Key("a"), Value("value-a"))
+
+	rotated, err := kv.Rotate(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 3, rotated)
+
+	// once rotated, the old key can go away
+	require.NoError(t, keyring.Remove("v1"))
+	for _, k := range []string{"a", "b", "c", "d"} {
+		mustFind(ctx, t, kv, Key(k), Value("value-"+k))
+	}
+
+	// nothing left to do
+	rotated, err = kv.Rotate(ctx)
+	require.NoError(t, err)
+	require.Zero(t, rotated)
+
+	// a value protected by an unknown key is reported as such
+	other := WithEncryption(raw, NewMultiKeyring("v3", testAESKey))
+	mustPut(ctx, t, other, Key("e"), Value("value-e"))
+	var derr *DecryptionError
+	_, _, err = kv.Get(ctx, Key("e"))
+	require.True(t, errors.As(err, &derr), "%v", err)
+	require.ErrorIs(t, err, ErrKeyNotFound)
+}
@@ -0,0 +1,218 @@
+package txkv
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Format is a text format to export to and import from.
+type Format int
+
+// The supported formats. Keys and values that are valid UTF-8 are written as
+// is, others in base64, which is noted next to them.
+const (
+	// FormatJSONL writes one JSON object per line, with fields "key" and
+	// "value", and "key_encoding" or "value_encoding" set to "base64" when
+	// needed.
+	FormatJSONL Format = iota
+	// FormatCSV writes a header line, then one record per key with columns
+	// key, value, key_encoding and value_encoding. Keys and values holding a
+	// carriage return are written in base64 too, as CSV readers turn line
+	// breaks into "\n".
+	FormatCSV
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatJSONL:
+		return "jsonl"
+	case FormatCSV:
+		return "csv"
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+const encodingBase64 = "base64"
+
+var csvHeader = []string{"key", "value", "key_encoding", "value_encoding"}
+
+type record struct {
+	Key           string `json:"key"`
+	Value         string `json:"value"`
+	KeyEncoding   string `json:"key_encoding,omitempty"`
+	ValueEncoding string `json:"value_encoding,omitempty"`
+}
+
+func newRecord(key Key, value Value) record {
+	var r record
+	r.Key, r.KeyEncoding = encodeText(key)
+	r.Value, r.ValueEncoding = encodeText(value)
+	return r
+}
+
+func encodeText(b []byte) (text, encoding string) {
+	if utf8.Valid(b) {
+		return string(b), ""
+	}
+	return base64.StdEncoding.EncodeToString(b), encodingBase64
+}
+
+// csvSafe returns `r` with the fields that wouldn't survive a round trip
+// through CSV in base64.
+func (r record) csvSafe() record {
+	if r.KeyEncoding == "" && strings.ContainsRune(r.Key, '\r') {
+		r.Key, r.KeyEncoding = base64.StdEncoding.EncodeToString([]byte(r.Key)), encodingBase64
+	}
+	if r.ValueEncoding == "" && strings.ContainsRune(r.Value, '\r') {
+		r.Value, r.ValueEncoding = base64.StdEncoding.EncodeToString([]byte(r.Value)), encodingBase64
+	}
+	return r
+}
+
+func decodeText(text, encoding string) ([]byte, error) {
+	switch encoding {
+	case "":
+		return []byte(text), nil
+	case encodingBase64:
+		return base64.StdEncoding.DecodeString(text)
+	}
+	return nil, fmt.Errorf("unknown encoding %q", encoding)
+}
+
+func (r record) decode() (Key, Value, error) {
+	key, err := decodeText(r.Key, r.KeyEncoding)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid key %q: %v", r.Key, err)
+	}
+	value, err := decodeText(r.Value, r.ValueEncoding)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid value of key %q: %v", r.Key, err)
+	}
+	return key, value, nil
+}
+
+// Export writes the keys of `kv` under `prefix` and their values to `w` in
+// `format`, in key order, returning how many it wrote. Keys are read as the
+// export progresses, so it's not a snapshot of the store.
+func Export(ctx context.Context, kv KV, prefix Key, w io.Writer, format Format) (int, error) {
+	its, err := ScanPartitions(ctx, kv, prefix, 1)
+	if err != nil {
+		return 0, err
+	}
+	it := its[0]
+	defer it.Close()
+
+	bw := bufio.NewWriter(w)
+	var (
+		write func(record) error
+		flush = func() error { return nil }
+	)
+	switch format {
+	case FormatJSONL:
+		enc := json.NewEncoder(bw)
+		enc.SetEscapeHTML(false)
+		write = func(r record) error { return enc.Encode(r) }
+	case FormatCSV:
+		cw := csv.NewWriter(bw)
+		if err := cw.Write(csvHeader); err != nil {
+			return 0, err
+		}
+		write = func(r record) error {
+			r = r.csvSafe()
+			return cw.Write([]string{r.Key, r.Value, r.KeyEncoding, r.ValueEncoding})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	default:
+		return 0, fmt.Errorf("txkv: unsupported format %v", format)
+	}
+
+	n := 0
+	for it.Next(ctx) {
+		if err := write(newRecord(it.Key(), it.Value())); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := it.Err(); err != nil {
+		return n, err
+	}
+	if err := flush(); err != nil {
+		return n, err
+	}
+	return n, bw.Flush()
+}
+
+// Import reads keys and values from `r` in `format`, as written by Export,
+// and loads them into `kv` with BulkLoad, returning how many it loaded.
+func Import(ctx context.Context, kv TransactionalKV, r io.Reader, format Format, opts BulkLoadOptions) (int, error) {
+	var it *importIterator
+	switch format {
+	case FormatJSONL:
+		dec := json.NewDecoder(bufio.NewReader(r))
+		it = &importIterator{read: func() (record, error) {
+			var rec record
+			err := dec.Decode(&rec)
+			return rec, err
+		}}
+	case FormatCSV:
+		cr := csv.NewReader(bufio.NewReader(r))
+		cr.FieldsPerRecord = len(csvHeader)
+		header := true
+		it = &importIterator{read: func() (record, error) {
+			fields, err := cr.Read()
+			if err == nil && header {
+				header = false
+				fields, err = cr.Read()
+			}
+			if err != nil {
+				return record{}, err
+			}
+			return record{Key: fields[0], Value: fields[1], KeyEncoding: fields[2], ValueEncoding: fields[3]}, nil
+		}}
+	default:
+		return 0, fmt.Errorf("txkv: unsupported format %v", format)
+	}
+	return BulkLoad(ctx, kv, it, opts)
+}
+
+// importIterator decodes records as they're read.
+type importIterator struct {
+	read    func() (record, error)
+	records int
+	key     Key
+	value   Value
+	err     error
+}
+
+func (it *importIterator) Next(ctx context.Context) bool {
+	if it.err != nil {
+		return false
+	}
+	rec, err := it.read()
+	if err == io.EOF {
+		return false
+	}
+	it.records++
+	if err == nil {
+		it.key, it.value, err = rec.decode()
+	}
+	if err != nil {
+		it.err = fmt.Errorf("txkv: can't import record %d: %v", it.records, err)
+		return false
+	}
+	return true
+}
+
+func (it *importIterator) Key() Key     { return it.key }
+func (it *importIterator) Value() Value { return it.value }
+func (it *importIterator) Err() error   { return it.err }
@@ -0,0 +1,78 @@
+package txkv_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	src := InMem()
+	mustPut(ctx, t, src, Key("app/greeting"), Value("hello, \"world\"\nbye"))
+	mustPut(ctx, t, src, Key("app/binary"), Value{0xff, 0x00, 0xfe})
+	mustPut(ctx, t, src, Key{'a', 'p', 'p', '/', 0xff}, Value("binary key"))
+	mustPut(ctx, t, src, Key("app/crlf\r\n"), Value("line1\r\nline2"))
+	mustPut(ctx, t, src, Key("other"), Value("not exported"))
+
+	for _, format := range []Format{FormatJSONL, FormatCSV} {
+		t.Run(format.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			n, err := Export(ctx, src, Key("app/"), &buf, format)
+			require.NoError(t, err)
+			require.Equal(t, 4, n)
+
+			dst := InMem()
+			n, err = Import(ctx, dst, &buf, format, BulkLoadOptions{})
+			require.NoError(t, err)
+			require.Equal(t, 4, n)
+
+			mustList(ctx, t, dst, Key(""), []Key{Key("app/binary"), Key("app/crlf\r\n"), Key("app/greeting"), {'a', 'p', 'p', '/', 0xff}})
+			mustFind(ctx, t, dst, Key("app/crlf\r\n"), Value("line1\r\nline2"))
+			mustFind(ctx, t, dst, Key("app/greeting"), Value("hello, \"world\"\nbye"))
+			mustFind(ctx, t, dst, Key("app/binary"), Value{0xff, 0x00, 0xfe})
+			mustFind(ctx, t, dst, Key{'a', 'p', 'p', '/', 0xff}, Value("binary key"))
+		})
+	}
+}
+
+func TestExportFormats(t *testing.T) {
+	ctx := context.Background()
+	kv := InMem()
+	mustPut(ctx, t, kv, Key("a"), Value("<text>"))
+	mustPut(ctx, t, kv, Key("b"), Value{0xff})
+
+	var buf bytes.Buffer
+	_, err := Export(ctx, kv, Key(""), &buf, FormatJSONL)
+	require.NoError(t, err)
+	require.Equal(t, `{"key":"a","value":"<text>"}
+{"key":"b","value":"/w==","value_encoding":"base64"}
+`, buf.String())
+
+	buf.Reset()
+	_, err = Export(ctx, kv, Key(""), &buf, FormatCSV)
+	require.NoError(t, err)
+	require.Equal(t, `key,value,key_encoding,value_encoding
+a,<text>,,
+b,/w==,,base64
+`, buf.String())
+}
+
+func TestImportInvalid(t *testing.T) {
+	ctx := context.Background()
+	kv := InMem()
+	n, err := Import(ctx, kv, strings.NewReader(`{"key":"a","value":"1"}
+{"key":"b","value":"!!","value_encoding":"base64"}
+`), FormatJSONL, BulkLoadOptions{})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "record 2")
+	require.Zero(t, n)
+
+	_, err = Import(ctx, kv, strings.NewReader("key,value\na,1\n"), FormatCSV, BulkLoadOptions{})
+	require.Error(t, err)
+}
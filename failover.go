@@ -0,0 +1,348 @@
+package txkv
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// FailoverOptions tune a FailoverKV.
+type FailoverOptions struct {
+	// IsFailure tells which errors of the primary store mean it's unhealthy.
+	// Defaults to transient errors and exceeded deadlines, like
+	// CircuitBreakerOptions.
+	IsFailure func(error) bool
+	// Probe checks whether the primary store is healthy again. Defaults to
+	// a Get of a key.
+	Probe func(ctx context.Context, primary TransactionalKV) error
+	// ProbeInterval is how often the primary store is probed while failed
+	// over. Defaults to 5s.
+	ProbeInterval time.Duration
+	// OnFailover, if set, is called when the store fails over to the
+	// standby, and when it fails back to the primary.
+	OnFailover func(toStandby bool)
+}
+
+func probeGet(ctx context.Context, primary TransactionalKV) error {
+	_, _, err := primary.Get(ctx, Key("\x00failover/probe"))
+	return err
+}
+
+// WithFailover returns a FailoverKV over `primary` and `standby`.
+func WithFailover(primary, standby TransactionalKV, opts FailoverOptions) *FailoverKV {
+	if opts.IsFailure == nil {
+		opts.IsFailure = isBackendFailure
+	}
+	if opts.Probe == nil {
+		opts.Probe = probeGet
+	}
+	if opts.ProbeInterval <= 0 {
+		opts.ProbeInterval = 5 * time.Second
+	}
+	return &FailoverKV{
+		primary:      primary,
+		standby:      standby,
+		opts:         opts,
+		primaryDirty: make(map[string]Key),
+		standbyDirty: make(map[string]Key),
+	}
+}
+
+// FailoverKV is a TransactionalKV writing to a primary store and a standby
+// one, which takes over when the primary store fails: reads and writes go to
+// the standby store until the primary one is healthy again. The keys written
+// meanwhile are then copied back to the primary store before it takes over
+// again. Likewise, the keys that couldn't be written to the standby store are
+// copied to it when reconciling.
+//
+// The keys to reconcile are only tracked in memory: they're lost if the
+// process stops while failed over. Transactions stay on the store they
+// began on: those of the standby store committing once the primary one took
+// over again have their writes copied to it right away.
+type FailoverKV struct {
+	primary TransactionalKV
+	standby TransactionalKV
+	opts    FailoverOptions
+
+	// held shared by operations, exclusively to reconcile the stores
+	rw sync.RWMutex
+
+	mu           sync.Mutex
+	failedOver   bool
+	lastProbe    time.Time
+	primaryDirty map[string]Key // written to the standby only
+	standbyDirty map[string]Key // written to the primary only
+}
+
+// FailedOver tells whether the standby store serves operations.
+func (f *FailoverKV) FailedOver() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.failedOver
+}
+
+// probe fails back to the primary store if it's due for a probe and the
+// probe finds it healthy. It must be called without holding `rw`.
+func (f *FailoverKV) probe(ctx context.Context) {
+	f.mu.Lock()
+	due := f.failedOver && time.Since(f.lastProbe) >= f.opts.ProbeInterval
+	if due {
+		f.lastProbe = time.Now()
+	}
+	f.mu.Unlock()
+	if due && f.opts.Probe(ctx, f.primary) == nil {
+		f.failBack(ctx)
+	}
+}
+
+// active returns the store serving operations.
+func (f *FailoverKV) active() (TransactionalKV, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failedOver {
+		return f.standby, false
+	}
+	return f.primary, true
+}
+
+func (f *FailoverKV) failOver() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failedOver {
+		return
+	}
+	f.failedOver = true
+	f.lastProbe = time.Now()
+	if f.opts.OnFailover != nil {
+		f.opts.OnFailover(true)
+	}
+}
+
+// failBack reconciles the stores, and has the primary take over again if
+// it could.
+func (f *FailoverKV) failBack(ctx context.Context) {
+	f.rw.Lock()
+	defer f.rw.Unlock()
+	if !f.FailedOver() {
+		return
+	}
+	if _, err := f.reconcile(ctx); err != nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failedOver = false
+	if f.opts.OnFailover != nil {
+		f.opts.OnFailover(false)
+	}
+}
+
+// Reconcile copies the keys written to a single store to the other, and
+// returns how many it copied. It's done when failing back, and can be done
+// anytime the primary store is healthy.
+func (f *FailoverKV) Reconcile(ctx context.Context) (int, error) {
+	f.rw.Lock()
+	defer f.rw.Unlock()
+	return f.reconcile(ctx)
+}
+
+func (f *FailoverKV) reconcile(ctx context.Context) (int, error) {
+	n1, err := f.copyDirty(ctx, f.primaryDirty, f.standby, f.primary)
+	if err != nil {
+		return n1, err
+	}
+	n2, err := f.copyDirty(ctx, f.standbyDirty, f.primary, f.standby)
+	return n1 + n2, err
+}
+
+// copyDirty copies the `dirty` keys from `src` to `dst`.
+func (f *FailoverKV) copyDirty(ctx context.Context, dirty map[string]Key, src, dst TransactionalKV) (int, error) {
+	f.mu.Lock()
+	keys := make([]Key, 0, len(dirty))
+	for _, key := range dirty {
+		keys = append(keys, key)
+	}
+	f.mu.Unlock()
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	ops := make([]Op, 0, len(keys))
+	for _, key := range keys {
+		v, ok, err := src.Get(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			ops = append(ops, PutOp(key, v))
+		} else {
+			ops = append(ops, DeleteOp(key))
+		}
+	}
+	if err := Apply(ctx, dst, ops); err != nil {
+		return 0, err
+	}
+	f.mu.Lock()
+	for _, key := range keys {
+		delete(dirty, string(key))
+	}
+	f.mu.Unlock()
+	return len(keys), nil
+}
+
+// wrote keeps the other store in sync with `ops`, which succeeded on the
+// primary store if `onPrimary`, on the standby otherwise.
+func (f *FailoverKV) wrote(ctx context.Context, onPrimary bool, ops []Op) {
+	dirty := f.primaryDirty
+	if onPrimary {
+		if err := Apply(ctx, f.standby, ops); err == nil {
+			return
+		}
+		dirty = f.standbyDirty
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, op := range ops {
+		dirty[string(op.Key)] = op.Key
+	}
+}
+
+// wroteLate copies `ops`, committed on the standby store after failing back,
+// to the primary store. If it can't, the standby store takes over again
+// until they're reconciled, as it's the only one with them.
+func (f *FailoverKV) wroteLate(ctx context.Context, ops []Op) {
+	if err := Apply(ctx, f.primary, ops); err == nil {
+		return
+	}
+	f.wrote(ctx, false, ops)
+	f.failOver()
+}
+
+// do runs `fn` on the active store, failing over if it's the primary and it
+// fails.
+func (f *FailoverKV) do(ctx context.Context, fn func(kv TransactionalKV) error) (bool, error) {
+	kv, onPrimary := f.active()
+	err := fn(kv)
+	if onPrimary && err != nil && f.opts.IsFailure(err) {
+		f.failOver()
+		return false, fn(f.standby)
+	}
+	return onPrimary, err
+}
+
+func (f *FailoverKV) write(ctx context.Context, ops []Op, fn func(kv TransactionalKV) error) error {
+	f.probe(ctx)
+	f.rw.RLock()
+	defer f.rw.RUnlock()
+	onPrimary, err := f.do(ctx, fn)
+	if err != nil {
+		return err
+	}
+	f.wrote(ctx, onPrimary, ops)
+	return nil
+}
+
+func (f *FailoverKV) Put(ctx context.Context, key Key, value Value) error {
+	return f.write(ctx, []Op{PutOp(key, value)}, func(kv TransactionalKV) error {
+		return kv.Put(ctx, key, value)
+	})
+}
+
+func (f *FailoverKV) Get(ctx context.Context, key Key) (v Value, ok bool, err error) {
+	f.probe(ctx)
+	f.rw.RLock()
+	defer f.rw.RUnlock()
+	_, err = f.do(ctx, func(kv TransactionalKV) (err error) {
+		v, ok, err = kv.Get(ctx, key)
+		return err
+	})
+	return v, ok, err
+}
+
+func (f *FailoverKV) Delete(ctx context.Context, key Key) error {
+	return f.write(ctx, []Op{DeleteOp(key)}, func(kv TransactionalKV) error {
+		return kv.Delete(ctx, key)
+	})
+}
+
+func (f *FailoverKV) List(ctx context.Context, prefix Key) (keys []Key, err error) {
+	f.probe(ctx)
+	f.rw.RLock()
+	defer f.rw.RUnlock()
+	_, err = f.do(ctx, func(kv TransactionalKV) (err error) {
+		keys, err = kv.List(ctx, prefix)
+		return err
+	})
+	return keys, err
+}
+
+func (f *FailoverKV) Begin(ctx context.Context) (TxKV, error) {
+	f.probe(ctx)
+	f.rw.RLock()
+	defer f.rw.RUnlock()
+	var tx TxKV
+	onPrimary, err := f.do(ctx, func(kv TransactionalKV) (err error) {
+		tx, err = kv.Begin(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &failoverTx{f: f, tx: tx, onPrimary: onPrimary}, nil
+}
+
+type failoverTx struct {
+	f         *FailoverKV
+	tx        TxKV
+	onPrimary bool
+	ops       []Op
+}
+
+func (t *failoverTx) Put(ctx context.Context, key Key, value Value) error {
+	if err := t.tx.Put(ctx, key, value); err != nil {
+		return err
+	}
+	t.ops = append(t.ops, PutOp(key, value))
+	return nil
+}
+
+func (t *failoverTx) Get(ctx context.Context, key Key) (Value, bool, error) {
+	return t.tx.Get(ctx, key)
+}
+
+func (t *failoverTx) Delete(ctx context.Context, key Key) error {
+	if err := t.tx.Delete(ctx, key); err != nil {
+		return err
+	}
+	t.ops = append(t.ops, DeleteOp(key))
+	return nil
+}
+
+func (t *failoverTx) List(ctx context.Context, prefix Key) ([]Key, error) {
+	return t.tx.List(ctx, prefix)
+}
+
+// Commit commits on the store the transaction began on. A transaction of the
+// primary store failing to commit has the store fail over, but fails: it's
+// up to the caller to run it again. A transaction of the standby store
+// committing after failing back has its writes copied to the primary store.
+func (t *failoverTx) Commit(ctx context.Context) error {
+	t.f.rw.RLock()
+	defer t.f.rw.RUnlock()
+	if err := t.tx.Commit(ctx); err != nil {
+		if t.onPrimary && t.f.opts.IsFailure(err) {
+			t.f.failOver()
+		}
+		return err
+	}
+	if len(t.ops) == 0 {
+		return nil
+	}
+	if !t.onPrimary && !t.f.FailedOver() {
+		t.f.wroteLate(ctx, t.ops)
+		return nil
+	}
+	t.f.wrote(ctx, t.onPrimary, t.ops)
+	return nil
+}
+
+func (t *failoverTx) Rollback(ctx context.Context) error { return t.tx.Rollback(ctx) }
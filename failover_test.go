@@ -0,0 +1,155 @@
+package txkv_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+// downKV fails every operation with ErrTransient while it's down.
+type downKV struct {
+	TransactionalKV
+	down bool
+}
+
+func (d *downKV) Put(ctx context.Context, key Key, value Value) error {
+	if d.down {
+		return ErrTransient
+	}
+	return d.TransactionalKV.Put(ctx, key, value)
+}
+
+func (d *downKV) Get(ctx context.Context, key Key) (Value, bool, error) {
+	if d.down {
+		return nil, false, ErrTransient
+	}
+	return d.TransactionalKV.Get(ctx, key)
+}
+
+func (d *downKV) Delete(ctx context.Context, key Key) error {
+	if d.down {
+		return ErrTransient
+	}
+	return d.TransactionalKV.Delete(ctx, key)
+}
+
+func (d *downKV) List(ctx context.Context, prefix Key) ([]Key, error) {
+	if d.down {
+		return nil, ErrTransient
+	}
+	return d.TransactionalKV.List(ctx, prefix)
+}
+
+func (d *downKV) Begin(ctx context.Context) (TxKV, error) {
+	if d.down {
+		return nil, ErrTransient
+	}
+	return d.TransactionalKV.Begin(ctx)
+}
+
+func TestFailover(t *testing.T) {
+	testKV(t, func(t testing.TB) TransactionalKV {
+		return WithFailover(InMem(), InMem(), FailoverOptions{})
+	})
+}
+
+func TestFailoverAndBack(t *testing.T) {
+	ctx := context.Background()
+	primary := &downKV{TransactionalKV: InMem()}
+	standby := InMem()
+	var events []bool
+	kv := WithFailover(primary, standby, FailoverOptions{
+		ProbeInterval: time.Nanosecond,
+		OnFailover:    func(toStandby bool) { events = append(events, toStandby) },
+	})
+
+	// writes go to both
+	mustPut(ctx, t, kv, Key("a"), Value("1"))
+	mustPut(ctx, t, kv, Key("b"), Value("2"))
+	mustFind(ctx, t, standby, Key("a"), Value("1"))
+
+	// the standby takes over while the primary is down
+	primary.down = true
+	mustFind(ctx, t, kv, Key("a"), Value("1"))
+	require.True(t, kv.FailedOver())
+	mustPut(ctx, t, kv, Key("a"), Value("changed"))
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Delete(ctx, Key("b")))
+	require.NoError(t, tx.Put(ctx, Key("c"), Value("3")))
+	require.NoError(t, tx.Commit(ctx))
+	mustFind(ctx, t, kv, Key("c"), Value("3"))
+
+	// the primary catches up before taking over again
+	primary.down = false
+	mustFind(ctx, t, kv, Key("a"), Value("changed"))
+	require.False(t, kv.FailedOver())
+	mustFind(ctx, t, primary, Key("a"), Value("changed"))
+	mustNotFind(ctx, t, primary, Key("b"))
+	mustFind(ctx, t, primary, Key("c"), Value("3"))
+	require.Equal(t, []bool{true, false}, events)
+
+	n, err := kv.Reconcile(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+}
+
+func TestFailoverStandbyDown(t *testing.T) {
+	ctx := context.Background()
+	primary := InMem()
+	standby := &downKV{TransactionalKV: InMem(), down: true}
+	kv := WithFailover(primary, standby, FailoverOptions{})
+
+	// the standby missing writes doesn't fail them, it's caught up later
+	mustPut(ctx, t, kv, Key("a"), Value("1"))
+	require.False(t, kv.FailedOver())
+	mustNotFind(ctx, t, standby.TransactionalKV, Key("a"))
+
+	standby.down = false
+	n, err := kv.Reconcile(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	mustFind(ctx, t, standby, Key("a"), Value("1"))
+}
+
+func TestFailoverCommitAfterFailBack(t *testing.T) {
+	ctx := context.Background()
+	primary := &downKV{TransactionalKV: InMem()}
+	standby := InMem()
+	kv := WithFailover(primary, standby, FailoverOptions{ProbeInterval: time.Nanosecond})
+	mustPut(ctx, t, kv, Key("a"), Value("1"))
+
+	// a transaction begun on the standby commits after failing back
+	primary.down = true
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	require.True(t, kv.FailedOver())
+	require.NoError(t, tx.Put(ctx, Key("a"), Value("late")))
+	primary.down = false
+	mustFind(ctx, t, kv, Key("a"), Value("1"))
+	require.False(t, kv.FailedOver())
+	require.NoError(t, tx.Commit(ctx))
+	mustFind(ctx, t, kv, Key("a"), Value("late"))
+	mustFind(ctx, t, primary, Key("a"), Value("late"))
+
+	// the standby takes over again if the primary can't have them
+	primary.down = true
+	tx, err = kv.Begin(ctx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Put(ctx, Key("b"), Value("2")))
+	primary.down = false
+	mustFind(ctx, t, kv, Key("a"), Value("late"))
+	require.False(t, kv.FailedOver())
+	primary.down = true
+	require.NoError(t, tx.Commit(ctx))
+	require.True(t, kv.FailedOver())
+	mustFind(ctx, t, kv, Key("b"), Value("2"))
+	primary.down = false
+	mustFind(ctx, t, kv, Key("b"), Value("2"))
+	require.False(t, kv.FailedOver())
+	mustFind(ctx, t, primary, Key("b"), Value("2"))
+}
@@ -0,0 +1,61 @@
+package txkv
+
+import (
+	"bytes"
+	"context"
+)
+
+// Bucket is the size of the keys sharing a prefix.
+type Bucket struct {
+	Prefix Key
+	SizeStats
+}
+
+// Histogram buckets the keys of `kv` under `prefix` by their first `depth`
+// segments after `prefix`, segments being delimited by `sep`, and reports the
+// size of each bucket, in the order of their first key in the store: that's
+// bytewise unless the store orders keys with a custom comparator. It helps
+// find bloated parts of a keyspace. For instance with sep '/' and depth 1,
+// "users/1" and "users/2" go in bucket "users/", while "config" goes in its
+// own bucket.
+func Histogram(ctx context.Context, kv KV, prefix Key, sep byte, depth int) ([]Bucket, error) {
+	its, err := ScanPartitions(ctx, kv, prefix, 1)
+	if err != nil {
+		return nil, err
+	}
+	it := its[0]
+	defer it.Close()
+
+	var buckets []Bucket
+	// keys of a bucket needn't be together in stores with a custom order
+	index := make(map[string]int)
+	for it.Next(ctx) {
+		key, value := it.Key(), it.Value()
+		bucket := bucketOf(key, len(prefix), sep, depth)
+		i, ok := index[string(bucket)]
+		if !ok {
+			i = len(buckets)
+			index[string(bucket)] = i
+			buckets = append(buckets, Bucket{Prefix: append(Key(nil), bucket...)})
+		}
+		b := &buckets[i]
+		b.Keys++
+		b.KeyBytes += int64(len(key))
+		b.ValueBytes += int64(len(value))
+	}
+	return buckets, it.Err()
+}
+
+// bucketOf returns the prefix of `key` ending with the `depth`-th `sep`
+// found after `from`, or `key` if there are not that many.
+func bucketOf(key Key, from int, sep byte, depth int) Key {
+	end := from
+	for i := 0; i < depth; i++ {
+		j := bytes.IndexByte(key[end:], sep)
+		if j < 0 {
+			return key
+		}
+		end += j + 1
+	}
+	return key[:end]
+}
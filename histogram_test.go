@@ -0,0 +1,43 @@
+package txkv_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestHistogram(t *testing.T) {
+	ctx := context.Background()
+	kv := InMem()
+	for _, key := range []string{
+		"app/config",
+		"app/users/1", "app/users/2", "app/users/3",
+		"app/sessions/a/1", "app/sessions/b/1",
+		"other/thing",
+	} {
+		mustPut(ctx, t, kv, Key(key), Value("12"))
+	}
+
+	buckets, err := Histogram(ctx, kv, Key("app/"), '/', 1)
+	require.NoError(t, err)
+	require.Equal(t, []Bucket{
+		{Prefix: Key("app/config"), SizeStats: SizeStats{Keys: 1, KeyBytes: 10, ValueBytes: 2}},
+		{Prefix: Key("app/sessions/"), SizeStats: SizeStats{Keys: 2, KeyBytes: 32, ValueBytes: 4}},
+		{Prefix: Key("app/users/"), SizeStats: SizeStats{Keys: 3, KeyBytes: 33, ValueBytes: 6}},
+	}, buckets)
+
+	buckets, err = Histogram(ctx, kv, Key(""), '/', 2)
+	require.NoError(t, err)
+	var prefixes []string
+	for _, b := range buckets {
+		prefixes = append(prefixes, string(b.Prefix))
+	}
+	require.Equal(t, []string{"app/config", "app/sessions/", "app/users/", "other/thing"}, prefixes)
+
+	buckets, err = Histogram(ctx, kv, Key("nothing/"), '/', 1)
+	require.NoError(t, err)
+	require.Empty(t, buckets)
+}
@@ -0,0 +1,156 @@
+package txkv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrImmutable is matched by the errors returned when a write would rewrite
+// or delete an entry of a write-once prefix.
+var ErrImmutable = errors.New("txkv: entry is immutable")
+
+// ImmutableError is returned when a write would rewrite or delete Key, which
+// falls under the write-once Prefix.
+type ImmutableError struct {
+	Prefix Key
+	Key    Key
+	Delete bool
+}
+
+func (e *ImmutableError) Error() string {
+	op := "rewrite"
+	if e.Delete {
+		op = "delete"
+	}
+	return fmt.Sprintf("txkv: can't %s key %q of write-once prefix %q", op, e.Key, e.Prefix)
+}
+
+func (e *ImmutableError) Is(target error) bool { return target == ErrImmutable }
+
+// WithImmutablePrefixes returns a TransactionalKV over `kv` where the keys
+// with one of `prefixes` are write-once, like audit logs or events: they can
+// be created, but neither rewritten nor deleted. Transactions are checked when
+// they commit, and fail as a whole. It's only enforced as long as all writes
+// go through the returned store.
+func WithImmutablePrefixes(kv TransactionalKV, prefixes ...Key) TransactionalKV {
+	return &immutableKV{kv: kv, prefixes: prefixes}
+}
+
+type immutableKV struct {
+	kv       TransactionalKV
+	prefixes []Key
+
+	// held while writes are checked and committed, so that concurrent
+	// writes can't both create a key
+	mu sync.Mutex
+}
+
+func (m *immutableKV) Put(ctx context.Context, key Key, value Value) error {
+	return m.commit(ctx, []Op{PutOp(key, value)}, func() error {
+		return m.kv.Put(ctx, key, value)
+	})
+}
+
+func (m *immutableKV) Get(ctx context.Context, key Key) (Value, bool, error) {
+	return m.kv.Get(ctx, key)
+}
+
+func (m *immutableKV) Delete(ctx context.Context, key Key) error {
+	return m.commit(ctx, []Op{DeleteOp(key)}, func() error {
+		return m.kv.Delete(ctx, key)
+	})
+}
+
+func (m *immutableKV) List(ctx context.Context, prefix Key) ([]Key, error) {
+	return m.kv.List(ctx, prefix)
+}
+
+func (m *immutableKV) Begin(ctx context.Context) (TxKV, error) {
+	tx, err := m.kv.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &immutableTx{m: m, tx: tx, writes: make(map[string]Op)}, nil
+}
+
+// prefix returns the write-once prefix of `key`, if any.
+func (m *immutableKV) prefix(key Key) (Key, bool) {
+	for _, prefix := range m.prefixes {
+		if bytes.HasPrefix(key, prefix) {
+			return prefix, true
+		}
+	}
+	return nil, false
+}
+
+// commit checks that `writes` leave the existing write-once entries alone,
+// then performs them with `do`.
+func (m *immutableKV) commit(ctx context.Context, writes []Op, do func() error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, w := range writes {
+		prefix, ok := m.prefix(w.Key)
+		if !ok {
+			continue
+		}
+		_, exists, err := m.kv.Get(ctx, w.Key)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return &ImmutableError{Prefix: prefix, Key: w.Key, Delete: w.Delete}
+		}
+	}
+	return do()
+}
+
+type immutableTx struct {
+	m      *immutableKV
+	tx     TxKV
+	writes map[string]Op
+}
+
+func (t *immutableTx) Put(ctx context.Context, key Key, value Value) error {
+	if err := t.tx.Put(ctx, key, value); err != nil {
+		return err
+	}
+	if _, ok := t.m.prefix(key); ok {
+		t.writes[string(key)] = PutOp(key, value)
+	}
+	return nil
+}
+
+func (t *immutableTx) Get(ctx context.Context, key Key) (Value, bool, error) {
+	return t.tx.Get(ctx, key)
+}
+
+func (t *immutableTx) Delete(ctx context.Context, key Key) error {
+	if err := t.tx.Delete(ctx, key); err != nil {
+		return err
+	}
+	if _, ok := t.m.prefix(key); ok {
+		t.writes[string(key)] = DeleteOp(key)
+	}
+	return nil
+}
+
+func (t *immutableTx) List(ctx context.Context, prefix Key) ([]Key, error) {
+	return t.tx.List(ctx, prefix)
+}
+
+func (t *immutableTx) Commit(ctx context.Context) error {
+	writes := make([]Op, 0, len(t.writes))
+	for _, w := range t.writes {
+		writes = append(writes, w)
+	}
+	err := t.m.commit(ctx, writes, func() error { return t.tx.Commit(ctx) })
+	if errors.Is(err, ErrImmutable) {
+		_ = t.tx.Rollback(ctx)
+	}
+	return err
+}
+
+func (t *immutableTx) Rollback(ctx context.Context) error { return t.tx.Rollback(ctx) }
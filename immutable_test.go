@@ -0,0 +1,54 @@
+package txkv_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestImmutablePrefixes(t *testing.T) {
+	testKV(t, func(t testing.TB) TransactionalKV {
+		return WithImmutablePrefixes(InMem(), Key("audit/"))
+	})
+}
+
+func TestImmutablePrefixesEnforced(t *testing.T) {
+	ctx := context.Background()
+	kv := WithImmutablePrefixes(InMem(), Key("audit/"), Key("events/"))
+
+	mustPut(ctx, t, kv, Key("audit/1"), Value("login"))
+	err := kv.Put(ctx, Key("audit/1"), Value("nothing to see"))
+	require.ErrorIs(t, err, ErrImmutable)
+	var immutable *ImmutableError
+	require.ErrorAs(t, err, &immutable)
+	require.Equal(t, &ImmutableError{Prefix: Key("audit/"), Key: Key("audit/1")}, immutable)
+	require.ErrorIs(t, kv.Delete(ctx, Key("audit/1")), ErrImmutable)
+	mustFind(ctx, t, kv, Key("audit/1"), Value("login"))
+
+	// other keys are left alone
+	mustPut(ctx, t, kv, Key("users/1"), Value("alice"))
+	mustPut(ctx, t, kv, Key("users/1"), Value("bob"))
+	mustDelete(ctx, t, kv, Key("users/1"))
+
+	// transactions are checked when they commit, as a whole
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Put(ctx, Key("users/2"), Value("carol")))
+	require.NoError(t, tx.Put(ctx, Key("events/1"), Value("created")))
+	require.NoError(t, tx.Delete(ctx, Key("audit/1")))
+	require.ErrorIs(t, tx.Commit(ctx), ErrImmutable)
+	mustNotFind(ctx, t, kv, Key("users/2"))
+	mustNotFind(ctx, t, kv, Key("events/1"))
+	mustFind(ctx, t, kv, Key("audit/1"), Value("login"))
+
+	// keys can be written more than once before they're created
+	tx, err = kv.Begin(ctx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Put(ctx, Key("events/1"), Value("draft")))
+	require.NoError(t, tx.Put(ctx, Key("events/1"), Value("created")))
+	require.NoError(t, tx.Commit(ctx))
+	mustFind(ctx, t, kv, Key("events/1"), Value("created"))
+}
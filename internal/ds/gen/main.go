@@ -0,0 +1,81 @@
+// Command gen generates the sorted maps of package ds from smap.go.tmpl, the
+// sorted map of github.com/aybabtme/datagen extended with bulk operations,
+// iterators and a key comparator. Keys are []byte, values are of the type
+// given with -val.
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"flag"
+	"fmt"
+	"go/format"
+	"go/token"
+	"log"
+	"os"
+	"strings"
+	"text/template"
+)
+
+//go:embed smap.go.tmpl
+var smapTmpl string
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("gen: ")
+	val := flag.String("val", "[]byte", "type of the values")
+	out := flag.String("o", "", "file to write, instead of stdout")
+	flag.Parse()
+
+	src, err := generate(*val, "go run ./gen "+strings.Join(os.Args[1:], " "))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *out == "" {
+		_, err = os.Stdout.Write(src)
+	} else {
+		err = os.WriteFile(*out, src, 0644)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// generate returns the source of the sorted map of []byte to `val`, noting
+// that it was generated with `command`.
+func generate(val, command string) ([]byte, error) {
+	name, err := typeName(val)
+	if err != nil {
+		return nil, err
+	}
+	params := struct {
+		Command, Val, Map, Node, Iterator string
+	}{
+		Command:  command,
+		Val:      val,
+		Map:      "SortedBytesTo" + name + "Map",
+		Node:     "nodeBytesTo" + name,
+		Iterator: "BytesTo" + name + "Iterator",
+	}
+	tmpl, err := template.New("smap").Parse(smapTmpl)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, params); err != nil {
+		return nil, err
+	}
+	return format.Source(buf.Bytes())
+}
+
+// typeName returns the name of type `typ` as it appears in the names of the
+// generated types.
+func typeName(typ string) (string, error) {
+	if typ == "[]byte" {
+		return "Bytes", nil
+	}
+	if !token.IsIdentifier(typ) {
+		return "", fmt.Errorf("can't name type %q, only []byte and named types are supported", typ)
+	}
+	return strings.ToUpper(typ[:1]) + typ[1:], nil
+}
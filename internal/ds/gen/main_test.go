@@ -0,0 +1,16 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateUpToDate(t *testing.T) {
+	want, err := os.ReadFile("../smap.go")
+	require.NoError(t, err)
+	got, err := generate("[]byte", "go run ./gen -val []byte -o smap.go")
+	require.NoError(t, err)
+	require.Equal(t, string(want), string(got), "smap.go is stale, run go generate")
+}
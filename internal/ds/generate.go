@@ -0,0 +1,3 @@
+package ds
+
+//go:generate go run ./gen -val []byte -o smap.go
@@ -3,27 +3,42 @@ package ds
 // GENERATED CODE, DO NOT EDIT
 // This code was generated by a tool.
 //
-// 	github.com/aybabtme/datagen
+// 	github.com/aybabtme/txkv/internal/ds/gen
 //
 // The command that generated this was:
 //
-//	datagen smap --key []byte --val []byte
+//	go run ./gen -val []byte -o smap.go
 
-import "bytes"
+import (
+	"bytes"
+	"math/bits"
+)
 
 // WARNING: using []byte as keys can lead to undefined behavior if the
 // []byte are modified after insertion!!!
-func (r SortedBytesToBytesMap) compare(a, b []byte) int { return bytes.Compare(a, b) }
+func (r SortedBytesToBytesMap) compare(a, b []byte) int {
+	if r.cmp != nil {
+		return r.cmp(a, b)
+	}
+	return bytes.Compare(a, b)
+}
 
 // SortedBytesToBytesMap is a sorted map built on a left leaning red black balanced
 // search sorted map. It stores []byte values, keyed by []byte.
 type SortedBytesToBytesMap struct {
 	root *nodeBytesToBytes
+	cmp  func(a, b []byte) int
 }
 
 // NewSortedBytesToBytesMap creates a sorted map.
 func NewSortedBytesToBytesMap() *SortedBytesToBytesMap { return &SortedBytesToBytesMap{} }
 
+// NewSortedBytesToBytesMapFunc creates a sorted map ordering keys with `cmp`
+// instead of bytewise.
+func NewSortedBytesToBytesMapFunc(cmp func(a, b []byte) int) *SortedBytesToBytesMap {
+	return &SortedBytesToBytesMap{cmp: cmp}
+}
+
 // IsEmpty tells if the sorted map contains no key/value.
 func (r SortedBytesToBytesMap) IsEmpty() bool {
 	return r.root == nil
@@ -39,12 +54,14 @@ func (r *SortedBytesToBytesMap) Clear() { r.root = nil }
 // if the key was already present.
 func (r *SortedBytesToBytesMap) Put(k []byte, v []byte) (old []byte, overwrite bool) {
 	r.root, old, overwrite = r.put(r.root, k, func() []byte { return v }, func(_ []byte) []byte { return v })
+	r.root.colorRed = false
 	return
 }
 
 // Mutate is like a Put when `k` isn't defined, but allows you to create or mutate the value found at the location of `k`.
 func (r *SortedBytesToBytesMap) Mutate(k []byte, creator func() []byte, mutator func(old []byte) []byte) {
 	r.root, _, _ = r.put(r.root, k, creator, mutator)
+	r.root.colorRed = false
 }
 
 func (r *SortedBytesToBytesMap) put(h *nodeBytesToBytes, k []byte, create func() []byte, mutate func(old []byte) []byte) (_ *nodeBytesToBytes, old []byte, overwrite bool) {
@@ -77,6 +94,141 @@ func (r *SortedBytesToBytesMap) put(h *nodeBytesToBytes, k []byte, create func()
 	return h, old, overwrite
 }
 
+// Entry is a key and its value.
+type Entry struct {
+	Key []byte
+	Val []byte
+}
+
+// PutAll puts all the entries of `sorted`, which must be in key order and
+// without duplicate keys. Large batches are merged with the sorted map in a
+// single pass, rebuilding the tree, rather than inserted one at a time.
+func (r *SortedBytesToBytesMap) PutAll(sorted []Entry) {
+	if !r.worthRebuilding(len(sorted)) {
+		for _, e := range sorted {
+			r.Put(e.Key, e.Val)
+		}
+		return
+	}
+	old := r.nodes()
+	merged := make([]*nodeBytesToBytes, 0, len(old)+len(sorted))
+	i := 0
+	for _, e := range sorted {
+		for i < len(old) && r.compare(old[i].key, e.Key) < 0 {
+			merged = append(merged, old[i])
+			i++
+		}
+		if i < len(old) && r.compare(old[i].key, e.Key) == 0 {
+			old[i].val = e.Val
+			merged = append(merged, old[i])
+			i++
+			continue
+		}
+		merged = append(merged, &nodeBytesToBytes{key: e.Key, val: e.Val})
+	}
+	merged = append(merged, old[i:]...)
+	r.root = r.build(merged)
+}
+
+// DeleteRange removes the keys in [start, end), or from `start` on if `end`
+// is nil, and returns how many it removed. Large ranges are cut out in a
+// single pass, rebuilding the tree, rather than deleted one at a time.
+func (r *SortedBytesToBytesMap) DeleteRange(start, end []byte) int {
+	lo, hi := r.Rank(start), r.Size()
+	if end != nil {
+		hi = r.Rank(end)
+	}
+	if hi <= lo {
+		return 0
+	}
+	if !r.worthRebuilding(hi - lo) {
+		for i := lo; i < hi; i++ {
+			k, _, _ := r.Select(lo)
+			r.Delete(k)
+		}
+		return hi - lo
+	}
+	nodes := r.nodes()
+	r.root = r.build(append(nodes[:lo], nodes[hi:]...))
+	return hi - lo
+}
+
+// worthRebuilding tells if changing `m` keys is cheaper done by rebuilding
+// the tree, in O(n+m), than one key at a time, in O(m log n).
+func (r SortedBytesToBytesMap) worthRebuilding(m int) bool {
+	n := r.Size()
+	return m*bits.Len(uint(n)) > n+m
+}
+
+// nodes returns the nodes of the tree, in order.
+func (r SortedBytesToBytesMap) nodes() []*nodeBytesToBytes {
+	nodes := make([]*nodeBytesToBytes, 0, r.Size())
+	var walk func(h *nodeBytesToBytes)
+	walk = func(h *nodeBytesToBytes) {
+		if h == nil {
+			return
+		}
+		walk(h.left)
+		nodes = append(nodes, h)
+		walk(h.right)
+	}
+	walk(r.root)
+	return nodes
+}
+
+// build links sorted nodes into a balanced tree, and returns its root.
+func (r *SortedBytesToBytesMap) build(nodes []*nodeBytesToBytes) *nodeBytesToBytes {
+	h := 0
+	for maxNodes(h) < len(nodes) {
+		h++
+	}
+	return r.link(nodes, h)
+}
+
+// maxNodes is the most nodes a tree of black height `h` can hold, when made
+// only of 3-nodes: 3^h - 1.
+func maxNodes(h int) int {
+	n := 1
+	for i := 0; i < h; i++ {
+		n *= 3
+	}
+	return n - 1
+}
+
+// link builds a tree of black height `h` out of sorted nodes, seen as a 2-3
+// tree: when the nodes don't fit under a 2-node, the root is a 3-node, a
+// black node with a red left child. len(nodes) must be between 2^h-1 and
+// 3^h-1.
+func (r *SortedBytesToBytesMap) link(nodes []*nodeBytesToBytes, h int) *nodeBytesToBytes {
+	c := len(nodes)
+	if c == 0 {
+		return nil
+	}
+	if c-1 <= 2*maxNodes(h-1) {
+		mid := (c - 1) / 2
+		x := nodes[mid]
+		x.left = r.link(nodes[:mid], h-1)
+		x.right = r.link(nodes[mid+1:], h-1)
+		x.colorRed = false
+		x.n = c
+		return x
+	}
+	// split the rest in three about equal subtrees
+	rest := c - 2
+	a := rest / 3
+	b := (rest - a) / 2
+	red, black := nodes[a], nodes[a+1+b]
+	red.left = r.link(nodes[:a], h-1)
+	red.right = r.link(nodes[a+1:a+1+b], h-1)
+	red.colorRed = true
+	red.n = a + b + 1
+	black.left = red
+	black.right = r.link(nodes[a+2+b:], h-1)
+	black.colorRed = false
+	black.n = c
+	return black
+}
+
 // Get a value from the sorted map at key `k`. Returns false
 // if the key doesn't exist.
 func (r SortedBytesToBytesMap) Get(k []byte) ([]byte, bool) {
@@ -275,6 +427,61 @@ func (r SortedBytesToBytesMap) keys(h *nodeBytesToBytes, visit func([]byte, []by
 	return true
 }
 
+// Iter returns an iterator over the keys in [start, end), or from `start` on
+// if `end` is nil. The iterator is invalidated by changes to the sorted map.
+func (r SortedBytesToBytesMap) Iter(start, end []byte) *BytesToBytesIterator {
+	it := &BytesToBytesIterator{r: r, end: end}
+	it.Seek(start)
+	return it
+}
+
+// BytesToBytesIterator walks over a range of a sorted map, in order.
+type BytesToBytesIterator struct {
+	r     SortedBytesToBytesMap
+	end   []byte
+	stack []*nodeBytesToBytes
+	cur   *nodeBytesToBytes
+}
+
+// Seek positions the iterator so that Next moves to the first key greater
+// or equal to `k`.
+func (it *BytesToBytesIterator) Seek(k []byte) {
+	it.stack, it.cur = it.stack[:0], nil
+	for h := it.r.root; h != nil; {
+		if it.r.compare(h.key, k) >= 0 {
+			it.stack = append(it.stack, h)
+			h = h.left
+		} else {
+			h = h.right
+		}
+	}
+}
+
+// Next moves to the next key, returning false past the end of the range.
+func (it *BytesToBytesIterator) Next() bool {
+	if len(it.stack) == 0 {
+		it.cur = nil
+		return false
+	}
+	h := it.stack[len(it.stack)-1]
+	it.stack = it.stack[:len(it.stack)-1]
+	if it.end != nil && it.r.compare(h.key, it.end) >= 0 {
+		it.stack, it.cur = it.stack[:0], nil
+		return false
+	}
+	for x := h.right; x != nil; x = x.left {
+		it.stack = append(it.stack, x)
+	}
+	it.cur = h
+	return true
+}
+
+// Key at the current position.
+func (it *BytesToBytesIterator) Key() []byte { return it.cur.key }
+
+// Val at the current position.
+func (it *BytesToBytesIterator) Val() []byte { return it.cur.val }
+
 // DeleteMin removes the smallest key and its value from the sorted map.
 func (r *SortedBytesToBytesMap) DeleteMin() (oldk []byte, oldv []byte, ok bool) {
 	r.root, oldk, oldv, ok = r.deleteMin(r.root)
@@ -466,4 +673,3 @@ func (x *nodeBytesToBytes) size() int {
 	}
 	return x.n
 }
-
@@ -0,0 +1,114 @@
+package ds
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPutAllDeleteRange(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	for _, size := range []int{0, 1, 2, 3, 10, 100, 1000} {
+		for _, batch := range []int{1, 5, 50, 2000} {
+			m := NewSortedBytesToBytesMap()
+			want := make(map[string]string)
+			for i := 0; i < size; i++ {
+				k := fmt.Sprintf("%05d", r.Intn(3000))
+				m.Put([]byte(k), []byte("old"))
+				want[k] = "old"
+			}
+
+			var entries []Entry
+			for i := 0; i < 3000 && len(entries) < batch; i += 1 + r.Intn(5) {
+				k := fmt.Sprintf("%05d", i)
+				entries = append(entries, Entry{Key: []byte(k), Val: []byte("new")})
+				want[k] = "new"
+			}
+			m.PutAll(entries)
+			requireMap(t, m, want)
+
+			lo, hi := r.Intn(3000), r.Intn(3000)
+			start, end := []byte(fmt.Sprintf("%05d", lo)), []byte(fmt.Sprintf("%05d", hi))
+			removed := 0
+			for k := range want {
+				if k >= string(start) && k < string(end) {
+					delete(want, k)
+					removed++
+				}
+			}
+			require.Equal(t, removed, m.DeleteRange(start, end))
+			requireMap(t, m, want)
+
+			// the tree is still usable
+			m.Put([]byte("99999"), []byte("last"))
+			want["99999"] = "last"
+			m.DeleteRange([]byte("00000"), []byte("00100"))
+			for k := range want {
+				if k < "00100" {
+					delete(want, k)
+				}
+			}
+			requireMap(t, m, want)
+			require.Equal(t, len(want), m.DeleteRange(nil, nil))
+			require.True(t, m.IsEmpty())
+		}
+	}
+}
+
+// requireMap checks `m` holds `want`, and is a valid left-leaning red-black
+// tree.
+func requireMap(t *testing.T, m *SortedBytesToBytesMap, want map[string]string) {
+	t.Helper()
+	require.Equal(t, len(want), m.Size())
+	var prev []byte
+	m.Keys(func(k, v []byte) bool {
+		if prev != nil {
+			require.Less(t, string(prev), string(k))
+		}
+		prev = k
+		require.Equal(t, want[string(k)], string(v))
+		return true
+	})
+	require.False(t, m.root.isRed())
+	checkNode(t, m.root)
+}
+
+// checkNode returns the black height of `h`.
+func checkNode(t *testing.T, h *nodeBytesToBytes) int {
+	if h == nil {
+		return 0
+	}
+	require.False(t, h.right.isRed(), "red right link")
+	require.False(t, h.isRed() && h.left.isRed(), "two reds in a row")
+	require.Equal(t, 1+h.left.size()+h.right.size(), h.n)
+	l, r := checkNode(t, h.left), checkNode(t, h.right)
+	require.Equal(t, l, r, "unbalanced")
+	if h.isRed() {
+		return l
+	}
+	return l + 1
+}
+
+func TestIter(t *testing.T) {
+	m := NewSortedBytesToBytesMap()
+	for i := 0; i < 100; i += 2 {
+		m.Put([]byte(fmt.Sprintf("%03d", i)), []byte("v"))
+	}
+	collect := func(it *BytesToBytesIterator) []string {
+		var keys []string
+		for it.Next() {
+			keys = append(keys, string(it.Key()))
+		}
+		return keys
+	}
+	require.Equal(t, []string{"010", "012", "014"}, collect(m.Iter([]byte("009"), []byte("016"))))
+	require.Equal(t, []string{"096", "098"}, collect(m.Iter([]byte("096"), nil)))
+	require.Empty(t, collect(m.Iter([]byte("099"), nil)))
+	require.Len(t, collect(m.Iter(nil, nil)), 50)
+
+	it := m.Iter(nil, []byte("004"))
+	it.Seek([]byte("001"))
+	require.Equal(t, []string{"002"}, collect(it))
+}
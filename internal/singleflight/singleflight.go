@@ -0,0 +1,82 @@
+// Package singleflight collapses concurrent calls for the same key into a
+// single execution.
+package singleflight
+
+import (
+	"errors"
+	"sync"
+)
+
+// errGoexit is returned to the callers waiting on a call whose function
+// called runtime.Goexit.
+var errGoexit = errors.New("singleflight: function called runtime.Goexit")
+
+type call struct {
+	wg   sync.WaitGroup
+	val  interface{}
+	err  error
+	dups int
+	// panicked tells the function panicked, with panicValue.
+	panicked   bool
+	panicValue interface{}
+}
+
+// Group deduplicates calls by key. The zero value is ready to use.
+type Group struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// Do runs `fn` for `key`, unless a call for `key` is already in flight, in
+// which case it waits for it and returns its results. `shared` is true when
+// the results were handed to more than one caller. If `fn` panics, the panic
+// is propagated to every caller.
+func (g *Group) Do(key string, fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+	if c, ok := g.calls[key]; ok {
+		c.dups++
+		g.mu.Unlock()
+		c.wg.Wait()
+		if c.panicked {
+			panic(c.panicValue)
+		}
+		return c.val, c.err, true
+	}
+	c := new(call)
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	g.doCall(c, key, fn)
+	g.mu.Lock()
+	shared = c.dups > 0
+	g.mu.Unlock()
+	return c.val, c.err, shared
+}
+
+// doCall runs `fn`, then releases the callers waiting on `c` however it
+// returned: normally, by panicking, or by calling runtime.Goexit.
+func (g *Group) doCall(c *call, key string, fn func() (interface{}, error)) {
+	returned := false
+	defer func() {
+		if !returned {
+			if r := recover(); r != nil {
+				c.panicked, c.panicValue = true, r
+			} else {
+				c.err = errGoexit
+			}
+		}
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		c.wg.Done()
+		if c.panicked {
+			panic(c.panicValue)
+		}
+	}()
+	c.val, c.err = fn()
+	returned = true
+}
@@ -0,0 +1,86 @@
+package singleflight
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDo(t *testing.T) {
+	var g Group
+	var calls int32
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	var wg sync.WaitGroup
+	results := make([]interface{}, 10)
+	errs := make([]error, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i], _ = g.Do("key", func() (interface{}, error) {
+				if atomic.AddInt32(&calls, 1) == 1 {
+					close(started)
+				}
+				<-release
+				return "value", nil
+			})
+		}(i)
+	}
+	<-started
+	close(release)
+	wg.Wait()
+
+	for i, v := range results {
+		require.NoError(t, errs[i])
+		require.Equal(t, "value", v)
+	}
+	// callers that arrived after the first call ended ran their own
+	require.GreaterOrEqual(t, atomic.LoadInt32(&calls), int32(1))
+
+	v, err, shared := g.Do("key", func() (interface{}, error) { return "again", nil })
+	require.NoError(t, err)
+	require.False(t, shared)
+	require.Equal(t, "again", v)
+}
+
+func TestDoPanic(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	recovered := make(chan interface{}, 2)
+	do := func(fn func() (interface{}, error)) {
+		defer func() { recovered <- recover() }()
+		g.Do("key", fn)
+	}
+	go do(func() (interface{}, error) {
+		close(started)
+		<-release
+		panic("boom")
+	})
+	<-started
+	go do(func() (interface{}, error) { return "unused", nil })
+	// wait for the second caller to join the call in flight
+	for {
+		g.mu.Lock()
+		dups := g.calls["key"].dups
+		g.mu.Unlock()
+		if dups == 1 {
+			break
+		}
+		runtime.Gosched()
+	}
+	close(release)
+	require.Equal(t, "boom", <-recovered)
+	require.Equal(t, "boom", <-recovered)
+
+	// the key is usable again
+	v, err, _ := g.Do("key", func() (interface{}, error) { return "value", nil })
+	require.NoError(t, err)
+	require.Equal(t, "value", v)
+}
@@ -0,0 +1,182 @@
+package txkv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrKeyPolicy is matched by the errors returned when a key breaks a
+// KeyPolicy.
+var ErrKeyPolicy = errors.New("txkv: key breaks the naming policy")
+
+// KeyPolicyError is returned when Key breaks a KeyPolicy, for Reason.
+type KeyPolicyError struct {
+	Key    Key
+	Reason string
+}
+
+func (e *KeyPolicyError) Error() string {
+	return fmt.Sprintf("txkv: key %q breaks the naming policy: %s", e.Key, e.Reason)
+}
+
+func (e *KeyPolicyError) Is(target error) bool { return target == ErrKeyPolicy }
+
+// KeyRules constrain the shape of keys.
+type KeyRules struct {
+	// Prefix is where the rules apply, for overrides.
+	Prefix Key
+	// MaxDepth, if set, is the most segments a key can have.
+	MaxDepth int
+	// Charset, if set, is the bytes keys can be made of, the separator
+	// aside.
+	Charset string
+}
+
+// KeyPolicy is a naming policy for the keys of a shared keyspace.
+type KeyPolicy struct {
+	// Allowed, if set, are the only prefixes keys can have.
+	Allowed []Key
+	// Reserved are prefixes no key can have, like those of the state of other
+	// layers.
+	Reserved []Key
+	// Separator splits keys into segments. Defaults to '/'.
+	Separator byte
+	// Rules apply to the keys no override applies to.
+	Rules KeyRules
+	// Overrides replace Rules for the keys with their prefix. The one with the
+	// longest prefix applies.
+	Overrides []KeyRules
+	// ReportOnly lets writes breaking the policy through, only reporting them
+	// to OnViolation, to roll a policy out.
+	ReportOnly bool
+	// OnViolation, if set, is called with every key breaking the policy.
+	OnViolation func(ctx context.Context, err *KeyPolicyError)
+}
+
+// Check returns a *KeyPolicyError if `key` breaks the policy.
+func (p KeyPolicy) Check(key Key) error {
+	if len(p.Allowed) > 0 && !hasAnyPrefix(key, p.Allowed) {
+		return &KeyPolicyError{Key: key, Reason: "not under an allowed prefix"}
+	}
+	for _, prefix := range p.Reserved {
+		if bytes.HasPrefix(key, prefix) {
+			return &KeyPolicyError{Key: key, Reason: fmt.Sprintf("prefix %q is reserved", prefix)}
+		}
+	}
+	rules := p.Rules
+	for _, o := range p.Overrides {
+		if bytes.HasPrefix(key, o.Prefix) && (rules.Prefix == nil || len(o.Prefix) > len(rules.Prefix)) {
+			rules = o
+		}
+	}
+	sep := p.Separator
+	if sep == 0 {
+		sep = '/'
+	}
+	if rules.MaxDepth > 0 {
+		depth := bytes.Count(bytes.TrimSuffix(key, []byte{sep}), []byte{sep}) + 1
+		if depth > rules.MaxDepth {
+			return &KeyPolicyError{Key: key, Reason: fmt.Sprintf("%d segments, at most %d allowed", depth, rules.MaxDepth)}
+		}
+	}
+	if rules.Charset != "" {
+		for _, c := range key {
+			if c != sep && bytes.IndexByte([]byte(rules.Charset), c) < 0 {
+				return &KeyPolicyError{Key: key, Reason: fmt.Sprintf("byte %q isn't allowed", c)}
+			}
+		}
+	}
+	return nil
+}
+
+func hasAnyPrefix(key Key, prefixes []Key) bool {
+	for _, prefix := range prefixes {
+		if bytes.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithKeyPolicy returns a TransactionalKV over `kv` enforcing `policy` on the
+// keys it Puts. Deletes aren't checked, so that keys predating the policy can
+// be cleaned up.
+func WithKeyPolicy(kv TransactionalKV, policy KeyPolicy) TransactionalKV {
+	return &keyPolicyKV{kv: kv, policy: policy}
+}
+
+type keyPolicyKV struct {
+	kv     TransactionalKV
+	policy KeyPolicy
+}
+
+// check returns the error a Put of `key` fails with, if any.
+func (k *keyPolicyKV) check(ctx context.Context, key Key) error {
+	err := k.policy.Check(key)
+	if err == nil {
+		return nil
+	}
+	if k.policy.OnViolation != nil {
+		k.policy.OnViolation(ctx, err.(*KeyPolicyError))
+	}
+	if k.policy.ReportOnly {
+		return nil
+	}
+	return err
+}
+
+func (k *keyPolicyKV) Put(ctx context.Context, key Key, value Value) error {
+	if err := k.check(ctx, key); err != nil {
+		return err
+	}
+	return k.kv.Put(ctx, key, value)
+}
+
+func (k *keyPolicyKV) Get(ctx context.Context, key Key) (Value, bool, error) {
+	return k.kv.Get(ctx, key)
+}
+
+func (k *keyPolicyKV) Delete(ctx context.Context, key Key) error {
+	return k.kv.Delete(ctx, key)
+}
+
+func (k *keyPolicyKV) List(ctx context.Context, prefix Key) ([]Key, error) {
+	return k.kv.List(ctx, prefix)
+}
+
+func (k *keyPolicyKV) Begin(ctx context.Context) (TxKV, error) {
+	tx, err := k.kv.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &keyPolicyTx{k: k, tx: tx}, nil
+}
+
+type keyPolicyTx struct {
+	k  *keyPolicyKV
+	tx TxKV
+}
+
+func (t *keyPolicyTx) Put(ctx context.Context, key Key, value Value) error {
+	if err := t.k.check(ctx, key); err != nil {
+		return err
+	}
+	return t.tx.Put(ctx, key, value)
+}
+
+func (t *keyPolicyTx) Get(ctx context.Context, key Key) (Value, bool, error) {
+	return t.tx.Get(ctx, key)
+}
+
+func (t *keyPolicyTx) Delete(ctx context.Context, key Key) error {
+	return t.tx.Delete(ctx, key)
+}
+
+func (t *keyPolicyTx) List(ctx context.Context, prefix Key) ([]Key, error) {
+	return t.tx.List(ctx, prefix)
+}
+
+func (t *keyPolicyTx) Commit(ctx context.Context) error   { return t.tx.Commit(ctx) }
+func (t *keyPolicyTx) Rollback(ctx context.Context) error { return t.tx.Rollback(ctx) }
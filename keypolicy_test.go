@@ -0,0 +1,74 @@
+package txkv_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+const lowerAlnum = "abcdefghijklmnopqrstuvwxyz0123456789-_"
+
+func TestKeyPolicyCheck(t *testing.T) {
+	policy := KeyPolicy{
+		Allowed:  []Key{Key("app/"), Key("tmp/"), Key("__sys/")},
+		Reserved: []Key{Key("__sys/")},
+		Rules:    KeyRules{MaxDepth: 3, Charset: lowerAlnum},
+		Overrides: []KeyRules{
+			{Prefix: Key("tmp/"), MaxDepth: 2},
+			{Prefix: Key("tmp/deep/")},
+		},
+	}
+	for key, ok := range map[string]bool{
+		"app/users/1":     true,
+		"app/users/":      true,
+		"app/users/1/x":   false,
+		"app/Users/1":     false,
+		"other/1":         false,
+		"__sys/lock":      false,
+		"tmp/X Y":         true,
+		"tmp/a/b":         false,
+		"tmp/deep/a/b/c/": true,
+	} {
+		err := policy.Check(Key(key))
+		if ok {
+			require.NoError(t, err, key)
+		} else {
+			require.ErrorIs(t, err, ErrKeyPolicy, key)
+		}
+	}
+}
+
+func TestKeyPolicy(t *testing.T) {
+	ctx := context.Background()
+	var violations []Key
+	policy := KeyPolicy{
+		Rules: KeyRules{MaxDepth: 2},
+		OnViolation: func(_ context.Context, err *KeyPolicyError) {
+			violations = append(violations, err.Key)
+		},
+	}
+	raw := InMem()
+	mustPut(ctx, t, raw, Key("a/b/c"), Value("legacy"))
+	kv := WithKeyPolicy(raw, policy)
+
+	mustPut(ctx, t, kv, Key("a/b"), Value("ok"))
+	require.ErrorIs(t, kv.Put(ctx, Key("a/b/d"), Value("no")), ErrKeyPolicy)
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	require.ErrorIs(t, tx.Put(ctx, Key("a/b/e"), Value("no")), ErrKeyPolicy)
+	require.NoError(t, tx.Rollback(ctx))
+	// keys predating the policy can be deleted
+	mustDelete(ctx, t, kv, Key("a/b/c"))
+	mustNotFind(ctx, t, kv, Key("a/b/d"))
+	require.Equal(t, []Key{Key("a/b/d"), Key("a/b/e")}, violations)
+
+	// in report mode, writes go through
+	violations = nil
+	policy.ReportOnly = true
+	kv = WithKeyPolicy(raw, policy)
+	mustPut(ctx, t, kv, Key("a/b/d"), Value("reported"))
+	require.Equal(t, []Key{Key("a/b/d")}, violations)
+}
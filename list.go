@@ -0,0 +1,81 @@
+package txkv
+
+import (
+	"bytes"
+	"context"
+	"sort"
+)
+
+// ListOptions bound a listing.
+type ListOptions struct {
+	// Limit caps the number of keys listed. 0 means no limit.
+	Limit int
+	// After only lists the keys after it, to carry on a truncated listing
+	// from its Cursor.
+	After Key
+}
+
+// ListResult is a bounded listing.
+type ListResult struct {
+	Keys []Key
+	// Truncated tells there are more keys than the limit.
+	Truncated bool
+	// Cursor is the After to list the next keys with, when truncated.
+	Cursor Key
+}
+
+// LimitedLister is implemented by stores that can stop listing at a limit,
+// rather than listing the whole prefix.
+type LimitedLister interface {
+	ListWithOptions(ctx context.Context, prefix Key, opts ListOptions) (ListResult, error)
+}
+
+// ListWithOptions lists the keys of `kv` with `prefix` within `opts`, so that
+// a prefix broader than expected doesn't bring millions of keys into memory.
+// Keys are listed and resumed from bytewise, like migrate.ForEachKey does,
+// whatever the order of the store. Stores that are a LimitedLister stop at
+// the limit, and those that are a FilteredLister only keep the keys within
+// it while they list in bytewise order; others have the whole prefix listed.
+func ListWithOptions(ctx context.Context, kv KV, prefix Key, opts ListOptions) (ListResult, error) {
+	if ll, ok := kv.(LimitedLister); ok {
+		return ll.ListWithOptions(ctx, prefix, opts)
+	}
+	var (
+		kept    int
+		last    Key
+		ordered = true
+	)
+	keys, err := ListFiltered(ctx, kv, prefix, func(key Key) bool {
+		if opts.After != nil && bytes.Compare(key, opts.After) <= 0 {
+			return false
+		}
+		// the keys past the limit can only be left out while they come
+		// bytewise, as those after them can't be any smaller
+		if ordered && last != nil && bytes.Compare(key, last) < 0 {
+			ordered = false
+		}
+		last = key
+		// one more than the limit tells if it's truncated
+		if ordered && opts.Limit > 0 && kept > opts.Limit {
+			return false
+		}
+		kept++
+		return true
+	})
+	if err != nil {
+		return ListResult{}, err
+	}
+	if !ordered {
+		sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+	}
+	return truncateList(keys, opts.Limit), nil
+}
+
+// truncateList cuts `keys` down to `limit`, if over it.
+func truncateList(keys []Key, limit int) ListResult {
+	if limit <= 0 || len(keys) <= limit {
+		return ListResult{Keys: keys}
+	}
+	keys = keys[:limit]
+	return ListResult{Keys: keys, Truncated: true, Cursor: keys[limit-1]}
+}
@@ -0,0 +1,90 @@
+package txkv_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestListWithOptions(t *testing.T) {
+	ctx := context.Background()
+	kvs := map[string]TransactionalKV{
+		"inmem":      InMem(),
+		"comparator": InMem(WithComparator(caseInsensitive)),
+		"wrapped":    WithMaintenance(InMem()),
+	}
+	for name, kv := range kvs {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 5; i++ {
+				mustPut(ctx, t, kv, Key(fmt.Sprintf("p/%d", i)), Value("v"))
+			}
+			mustPut(ctx, t, kv, Key("P/upper"), Value("v"))
+			mustPut(ctx, t, kv, Key("q"), Value("v"))
+
+			res, err := ListWithOptions(ctx, kv, Key("p/"), ListOptions{Limit: 2})
+			require.NoError(t, err)
+			require.Equal(t, ListResult{
+				Keys:      []Key{Key("p/0"), Key("p/1")},
+				Truncated: true,
+				Cursor:    Key("p/1"),
+			}, res)
+
+			res, err = ListWithOptions(ctx, kv, Key("p/"), ListOptions{Limit: 2, After: res.Cursor})
+			require.NoError(t, err)
+			require.Equal(t, []Key{Key("p/2"), Key("p/3")}, res.Keys)
+			require.True(t, res.Truncated)
+
+			res, err = ListWithOptions(ctx, kv, Key("p/"), ListOptions{Limit: 2, After: res.Cursor})
+			require.NoError(t, err)
+			require.Equal(t, ListResult{Keys: []Key{Key("p/4")}}, res)
+
+			res, err = ListWithOptions(ctx, kv, Key("p/"), ListOptions{Limit: 5})
+			require.NoError(t, err)
+			require.Len(t, res.Keys, 5)
+			require.False(t, res.Truncated)
+
+			res, err = ListWithOptions(ctx, kv, Key(""), ListOptions{After: Key("p/3")})
+			require.NoError(t, err)
+			require.Equal(t, []Key{Key("p/4"), Key("q")}, res.Keys)
+		})
+	}
+}
+
+func TestListWithOptionsPendingWrites(t *testing.T) {
+	ctx := context.Background()
+	reverse := func(a, b Key) int { return bytes.Compare(b, a) }
+	for name, kv := range map[string]TransactionalKV{
+		"inmem":   InMem(),
+		"reverse": InMem(WithComparator(reverse)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			for _, k := range []string{"a", "b", "c", "d", "e"} {
+				mustPut(ctx, t, kv, Key(k), Value("v"))
+			}
+			tx, err := kv.Begin(ctx)
+			require.NoError(t, err)
+			defer tx.Rollback(ctx)
+			require.NoError(t, tx.Put(ctx, Key("f"), Value("v")))
+			require.NoError(t, tx.Delete(ctx, Key("c")))
+
+			// pages come bytewise, whatever the order of the store
+			var got []Key
+			opts := ListOptions{Limit: 2}
+			for i := 0; i < 5; i++ {
+				res, err := ListWithOptions(ctx, tx, nil, opts)
+				require.NoError(t, err)
+				got = append(got, res.Keys...)
+				if !res.Truncated {
+					break
+				}
+				opts.After = res.Cursor
+			}
+			require.Equal(t, []Key{Key("a"), Key("b"), Key("d"), Key("e"), Key("f")}, got)
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package txkv
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aybabtme/txkv/internal/singleflight"
+)
+
+// Loader uses a KV as a read-through cache: values missing from it are
+// loaded once, however many callers want them at the same time, and stored
+// for the next ones.
+//
+// Values are stored along with their expiry, so the keys of a Loader must
+// only be read and written through it.
+type Loader struct {
+	kv    KV
+	loads singleflight.Group
+}
+
+// NewLoader returns a Loader caching values in `kv`.
+func NewLoader(kv KV) *Loader {
+	return &Loader{kv: kv}
+}
+
+// GetOrLoad returns the value of `key`, calling `load` to get it if it's
+// missing or expired, and storing it for `ttl`, or forever if `ttl` is 0.
+// Concurrent calls for the same key share a single call to `load`, made with
+// the context of the first caller. Errors from `load` are returned, and not
+// cached; panics are propagated to every caller sharing the call.
+func (l *Loader) GetOrLoad(ctx context.Context, key Key, load func(ctx context.Context) (Value, error), ttl time.Duration) (Value, error) {
+	v, err, shared := l.loads.Do(string(key), func() (interface{}, error) {
+		stored, ok, err := l.kv.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			value, expires, err := decodeCached(stored)
+			if err != nil {
+				return nil, fmt.Errorf("txkv: cached key %q: %w", key, err)
+			}
+			if expires == 0 || time.Now().UnixNano() < expires {
+				return value, nil
+			}
+		}
+		value, err := load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var expires int64
+		if ttl > 0 {
+			expires = time.Now().Add(ttl).UnixNano()
+		}
+		if err := l.kv.Put(ctx, key, encodeCached(value, expires)); err != nil {
+			return nil, err
+		}
+		return value, nil
+	})
+	value, _ := v.(Value)
+	if shared && value != nil {
+		value = append(Value(nil), value...)
+	}
+	return value, err
+}
+
+// encodeCached prefixes `value` with its expiry in Unix nanoseconds, or 0 if
+// it never expires.
+func encodeCached(value Value, expires int64) Value {
+	return append(binary.AppendUvarint(nil, uint64(expires)), value...)
+}
+
+func decodeCached(stored Value) (Value, int64, error) {
+	expires, n := binary.Uvarint(stored)
+	if n <= 0 {
+		return nil, 0, errors.New("malformed cache entry")
+	}
+	return stored[n:], int64(expires), nil
+}
@@ -0,0 +1,81 @@
+package txkv_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestGetOrLoad(t *testing.T) {
+	ctx := context.Background()
+	l := NewLoader(InMem())
+
+	var loads int32
+	load := func(ctx context.Context) (Value, error) {
+		atomic.AddInt32(&loads, 1)
+		time.Sleep(20 * time.Millisecond)
+		return Value("loaded"), nil
+	}
+
+	// concurrent misses load once
+	const callers = 10
+	values := make([]Value, callers)
+	errs := make([]error, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i], errs[i] = l.GetOrLoad(ctx, Key("k"), load, 50*time.Millisecond)
+		}(i)
+	}
+	wg.Wait()
+	for i := range values {
+		require.NoError(t, errs[i])
+		require.Equal(t, Value("loaded"), values[i])
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&loads))
+
+	// hits don't load
+	v, err := l.GetOrLoad(ctx, Key("k"), load, 50*time.Millisecond)
+	require.NoError(t, err)
+	require.Equal(t, Value("loaded"), v)
+	require.Equal(t, int32(1), atomic.LoadInt32(&loads))
+
+	// expired values are loaded again
+	time.Sleep(60 * time.Millisecond)
+	_, err = l.GetOrLoad(ctx, Key("k"), load, 0)
+	require.NoError(t, err)
+	require.Equal(t, int32(2), atomic.LoadInt32(&loads))
+
+	// errors aren't cached
+	boom := errors.New("boom")
+	_, err = l.GetOrLoad(ctx, Key("other"), func(ctx context.Context) (Value, error) { return nil, boom }, 0)
+	require.ErrorIs(t, err, boom)
+	v, err = l.GetOrLoad(ctx, Key("other"), load, 0)
+	require.NoError(t, err)
+	require.Equal(t, Value("loaded"), v)
+}
+
+func TestGetOrLoadPanic(t *testing.T) {
+	ctx := context.Background()
+	l := NewLoader(InMem())
+	require.PanicsWithValue(t, "boom", func() {
+		_, _ = l.GetOrLoad(ctx, Key("k"), func(ctx context.Context) (Value, error) {
+			panic("boom")
+		}, 0)
+	})
+	// later calls aren't stuck behind the one that panicked
+	v, err := l.GetOrLoad(ctx, Key("k"), func(ctx context.Context) (Value, error) {
+		return Value("v"), nil
+	}, 0)
+	require.NoError(t, err)
+	require.Equal(t, Value("v"), v)
+}
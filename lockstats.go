@@ -0,0 +1,145 @@
+package txkv
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// LockWait sums up how long the acquisitions of a lock waited.
+type LockWait struct {
+	Count int64
+	Total time.Duration
+	Max   time.Duration
+}
+
+// Mean returns the mean wait.
+func (w LockWait) Mean() time.Duration {
+	if w.Count == 0 {
+		return 0
+	}
+	return w.Total / time.Duration(w.Count)
+}
+
+// LockWaits are the waits on the locks of a store, by operation.
+type LockWaits struct {
+	// Store are the waits on the lock of the store, held by every operation
+	// on it, and by transactions when they list and commit.
+	Store map[string]LockWait
+	// Tx are the waits on the locks of transactions, summed across them.
+	Tx map[string]LockWait
+}
+
+// LockProfiler is implemented by stores that record how long operations wait
+// on their locks, to diagnose contention. The in-memory store implements it
+// when made WithLockProfiling.
+type LockProfiler interface {
+	LockWaits() LockWaits
+}
+
+// WithLockProfiling has the store time how long each operation waits on its
+// locks, as reported by LockWaits. It costs a couple clock reads per
+// operation.
+func WithLockProfiling() InMemOption {
+	return func(cfg *inMemConfig) {
+		cfg.lockProfiling = true
+	}
+}
+
+// The operations of the in-memory store other than those ProfiledKV
+// accounts for, as reported in LockWaits.
+const (
+	opSeq          = "seq"
+	opChanges      = "changes"
+	opScan         = "scan"
+	opSample       = "sample"
+	opSize         = "size"
+	opDeletePrefix = "delete-prefix"
+	opPatch        = "patch"
+	opApply        = "apply"
+	opBulkLoad     = "bulk-load"
+	opIterate      = "iterate"
+	opPending      = "pending"
+	opCompact      = "compact"
+)
+
+// lockWaits records the waits on the locks of a store. Its maps are made
+// upfront, with every operation, so they're only ever read afterwards.
+type lockWaits struct {
+	store, tx map[string]*lockWait
+}
+
+type lockWait struct {
+	count, total, max atomic.Int64
+}
+
+func newLockWaits() *lockWaits {
+	w := &lockWaits{store: make(map[string]*lockWait), tx: make(map[string]*lockWait)}
+	for _, op := range []string{
+		OpGet, OpPut, OpDelete, OpList, OpCommit, opSeq, opChanges, opScan,
+		opSample, opSize, opDeletePrefix, opPatch, opApply, opBulkLoad,
+		opIterate, opCompact,
+	} {
+		w.store[op] = new(lockWait)
+	}
+	for _, op := range []string{OpGet, OpPut, OpDelete, OpList, OpCommit, opPending} {
+		w.tx[op] = new(lockWait)
+	}
+	return w
+}
+
+func (w *lockWait) record(d time.Duration) {
+	w.count.Add(1)
+	w.total.Add(int64(d))
+	for {
+		max := w.max.Load()
+		if int64(d) <= max || w.max.CompareAndSwap(max, int64(d)) {
+			return
+		}
+	}
+}
+
+func (w *lockWaits) snapshot() LockWaits {
+	return LockWaits{Store: snapshotWaits(w.store), Tx: snapshotWaits(w.tx)}
+}
+
+func snapshotWaits(waits map[string]*lockWait) map[string]LockWait {
+	out := make(map[string]LockWait)
+	for op, w := range waits {
+		if n := w.count.Load(); n > 0 {
+			out[op] = LockWait{Count: n, Total: time.Duration(w.total.Load()), Max: time.Duration(w.max.Load())}
+		}
+	}
+	return out
+}
+
+// lock takes the lock of the store for `op`, timing the wait if profiling.
+func (k *memkv) lock(op string) {
+	if k.waits == nil {
+		k.mu.Lock()
+		return
+	}
+	start := time.Now()
+	k.mu.Lock()
+	k.waits.store[op].record(time.Since(start))
+}
+
+// lock takes the lock of the transaction for `op`, timing the wait if
+// profiling.
+func (k *txmemkv) lock(op string) {
+	if k.root.waits == nil {
+		k.mu.Lock()
+		return
+	}
+	start := time.Now()
+	k.mu.Lock()
+	k.root.waits.tx[op].record(time.Since(start))
+}
+
+// LockWaits returns the waits so far, with only the operations that waited.
+// They're empty unless the store was made WithLockProfiling.
+func (k *memkv) LockWaits() LockWaits {
+	if k.waits == nil {
+		return LockWaits{Store: map[string]LockWait{}, Tx: map[string]LockWait{}}
+	}
+	return k.waits.snapshot()
+}
@@ -0,0 +1,53 @@
+package txkv_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestLockProfiling(t *testing.T) {
+	testKV(t, func(t testing.TB) TransactionalKV {
+		return InMem(WithLockProfiling())
+	})
+
+	ctx := context.Background()
+	kv := InMem(WithLockProfiling())
+	lp := kv.(LockProfiler)
+	require.Empty(t, lp.LockWaits().Store)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				mustPut(ctx, t, kv, Key("k"), Value("v"))
+				tx, err := kv.Begin(ctx)
+				require.NoError(t, err)
+				require.NoError(t, tx.Delete(ctx, Key("k")))
+				require.NoError(t, tx.Commit(ctx))
+			}
+		}()
+	}
+	wg.Wait()
+
+	waits := lp.LockWaits()
+	require.EqualValues(t, 800, waits.Store[OpPut].Count)
+	require.EqualValues(t, 800, waits.Store[OpCommit].Count)
+	require.EqualValues(t, 800, waits.Tx[OpDelete].Count)
+	require.EqualValues(t, 800, waits.Tx[OpCommit].Count)
+	require.NotContains(t, waits.Store, OpGet)
+	put := waits.Store[OpPut]
+	require.LessOrEqual(t, put.Mean(), put.Max)
+	require.LessOrEqual(t, put.Max, put.Total)
+
+	// stores made without it record nothing
+	kv = InMem()
+	mustPut(ctx, t, kv, Key("k"), Value("v"))
+	require.Empty(t, kv.(LockProfiler).LockWaits().Store)
+}
@@ -0,0 +1,212 @@
+package txkv
+
+import (
+	"context"
+	"errors"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// ErrTxAborted is returned when using a transaction that a LongTxKV aborted
+// for running too long.
+var ErrTxAborted = errors.New("txkv: transaction aborted for running too long")
+
+// DefaultLongTxThreshold is how long transactions can stay open before
+// they're flagged when LongTxOptions.Threshold is 0.
+const DefaultLongTxThreshold = time.Minute
+
+// LongTxOptions tune a LongTxKV.
+type LongTxOptions struct {
+	// Threshold is how long a transaction can stay open before it's flagged.
+	// Defaults to DefaultLongTxThreshold.
+	Threshold time.Duration
+	// Abort has flagged transactions rolled back, to release what they
+	// hold in the store.
+	Abort bool
+	// OnLongTx, if set, is called with every flagged transaction, to log it.
+	OnLongTx func(LongTx)
+	// RecordStacks has the stack of the code beginning each transaction
+	// recorded, to find where leaked ones come from. It costs an allocation
+	// per transaction.
+	RecordStacks bool
+}
+
+// LongTx is a transaction open longer than the threshold.
+type LongTx struct {
+	Began time.Time
+	// Aborted tells whether the transaction was aborted, or failed to be.
+	Aborted  bool
+	AbortErr error
+	// Stack is where the transaction began, if recorded.
+	Stack []byte
+}
+
+// LongTxStats count the transactions of a LongTxKV.
+type LongTxStats struct {
+	// Open is the number of transactions currently open.
+	Open    int64
+	Flagged uint64
+	Aborted uint64
+}
+
+// WithLongTxPolicy returns a LongTxKV over `kv`.
+func WithLongTxPolicy(kv TransactionalKV, opts LongTxOptions) *LongTxKV {
+	if opts.Threshold <= 0 {
+		opts.Threshold = DefaultLongTxThreshold
+	}
+	return &LongTxKV{kv: kv, opts: opts}
+}
+
+// LongTxKV is a TransactionalKV flagging the transactions that stay open
+// longer than a threshold, and optionally aborting them, so that leaked
+// transactions don't hold the resources of the store forever, like the
+// snapshot of a Badger transaction or the write lock of a bbolt one.
+// Aborted transactions fail with ErrTxAborted from then on.
+type LongTxKV struct {
+	kv   TransactionalKV
+	opts LongTxOptions
+
+	mu    sync.Mutex
+	stats LongTxStats
+}
+
+// Stats returns the counts of transactions so far.
+func (l *LongTxKV) Stats() LongTxStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stats
+}
+
+func (l *LongTxKV) Put(ctx context.Context, key Key, value Value) error {
+	return l.kv.Put(ctx, key, value)
+}
+
+func (l *LongTxKV) Get(ctx context.Context, key Key) (Value, bool, error) {
+	return l.kv.Get(ctx, key)
+}
+
+func (l *LongTxKV) Delete(ctx context.Context, key Key) error {
+	return l.kv.Delete(ctx, key)
+}
+
+func (l *LongTxKV) List(ctx context.Context, prefix Key) ([]Key, error) {
+	return l.kv.List(ctx, prefix)
+}
+
+func (l *LongTxKV) Begin(ctx context.Context) (TxKV, error) {
+	tx, err := l.kv.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	t := &longTx{l: l, tx: tx, began: time.Now()}
+	if l.opts.RecordStacks {
+		t.stack = debug.Stack()
+	}
+	l.mu.Lock()
+	l.stats.Open++
+	l.mu.Unlock()
+	t.timer = time.AfterFunc(l.opts.Threshold, t.flag)
+	return t, nil
+}
+
+// longTx holds its lock during operations, so that it's never aborted
+// halfway through one.
+type longTx struct {
+	l     *LongTxKV
+	tx    TxKV
+	began time.Time
+	stack []byte
+	timer *time.Timer
+
+	mu      sync.Mutex
+	ended   bool
+	aborted bool
+}
+
+// flag reports the transaction, and aborts it if required.
+func (t *longTx) flag() {
+	t.mu.Lock()
+	if t.ended {
+		t.mu.Unlock()
+		return
+	}
+	long := LongTx{Began: t.began, Stack: t.stack}
+	if t.l.opts.Abort {
+		t.ended, t.aborted = true, true
+		long.Aborted = true
+		long.AbortErr = t.tx.Rollback(context.Background())
+	}
+	t.mu.Unlock()
+
+	t.l.mu.Lock()
+	t.l.stats.Flagged++
+	if long.Aborted {
+		t.l.stats.Aborted++
+		t.l.stats.Open--
+	}
+	t.l.mu.Unlock()
+	if t.l.opts.OnLongTx != nil {
+		t.l.opts.OnLongTx(long)
+	}
+}
+
+func (t *longTx) Put(ctx context.Context, key Key, value Value) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.aborted {
+		return ErrTxAborted
+	}
+	return t.tx.Put(ctx, key, value)
+}
+
+func (t *longTx) Get(ctx context.Context, key Key) (Value, bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.aborted {
+		return nil, false, ErrTxAborted
+	}
+	return t.tx.Get(ctx, key)
+}
+
+func (t *longTx) Delete(ctx context.Context, key Key) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.aborted {
+		return ErrTxAborted
+	}
+	return t.tx.Delete(ctx, key)
+}
+
+func (t *longTx) List(ctx context.Context, prefix Key) ([]Key, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.aborted {
+		return nil, ErrTxAborted
+	}
+	return t.tx.List(ctx, prefix)
+}
+
+func (t *longTx) Commit(ctx context.Context) error {
+	return t.end(ctx, t.tx.Commit)
+}
+
+func (t *longTx) Rollback(ctx context.Context) error {
+	return t.end(ctx, t.tx.Rollback)
+}
+
+func (t *longTx) end(ctx context.Context, end func(context.Context) error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.aborted {
+		return ErrTxAborted
+	}
+	if !t.ended {
+		t.ended = true
+		t.timer.Stop()
+		t.l.mu.Lock()
+		t.l.stats.Open--
+		t.l.mu.Unlock()
+	}
+	return end(ctx)
+}
@@ -0,0 +1,87 @@
+package txkv_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestLongTxPolicy(t *testing.T) {
+	testKV(t, func(t testing.TB) TransactionalKV {
+		return WithLongTxPolicy(InMem(), LongTxOptions{Threshold: time.Hour, Abort: true})
+	})
+}
+
+func TestLongTxPolicyFlags(t *testing.T) {
+	ctx := context.Background()
+	flagged := make(chan LongTx, 1)
+	kv := WithLongTxPolicy(InMem(), LongTxOptions{
+		Threshold:    10 * time.Millisecond,
+		RecordStacks: true,
+		OnLongTx:     func(tx LongTx) { flagged <- tx },
+	})
+
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), kv.Stats().Open)
+
+	long := <-flagged
+	require.False(t, long.Aborted)
+	require.Contains(t, string(long.Stack), "TestLongTxPolicyFlags")
+
+	// it isn't aborted
+	mustPut(ctx, t, tx, Key("a"), Value("1"))
+	require.NoError(t, tx.Commit(ctx))
+	mustFind(ctx, t, kv, Key("a"), Value("1"))
+	require.Equal(t, LongTxStats{Open: 0, Flagged: 1}, kv.Stats())
+}
+
+func TestLongTxPolicyAborts(t *testing.T) {
+	ctx := context.Background()
+	flagged := make(chan LongTx, 1)
+	kv := WithLongTxPolicy(InMem(), LongTxOptions{
+		Threshold: 10 * time.Millisecond,
+		Abort:     true,
+		OnLongTx:  func(tx LongTx) { flagged <- tx },
+	})
+
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	mustPut(ctx, t, tx, Key("a"), Value("1"))
+
+	long := <-flagged
+	require.True(t, long.Aborted)
+	require.NoError(t, long.AbortErr)
+
+	_, _, err = tx.Get(ctx, Key("a"))
+	require.ErrorIs(t, err, ErrTxAborted)
+	require.ErrorIs(t, tx.Put(ctx, Key("b"), Value("2")), ErrTxAborted)
+	require.ErrorIs(t, tx.Commit(ctx), ErrTxAborted)
+	require.ErrorIs(t, tx.Rollback(ctx), ErrTxAborted)
+	mustNotFind(ctx, t, kv, Key("a"))
+	require.Equal(t, LongTxStats{Open: 0, Flagged: 1, Aborted: 1}, kv.Stats())
+
+	// transactions ending in time aren't flagged
+	tx, err = kv.Begin(ctx)
+	require.NoError(t, err)
+	mustPut(ctx, t, tx, Key("a"), Value("1"))
+	require.NoError(t, tx.Commit(ctx))
+	time.Sleep(20 * time.Millisecond)
+	mustFind(ctx, t, kv, Key("a"), Value("1"))
+	require.Equal(t, uint64(1), kv.Stats().Flagged)
+}
+
+func TestLongTxPolicyDefaultThreshold(t *testing.T) {
+	ctx := context.Background()
+	kv := WithLongTxPolicy(InMem(), LongTxOptions{Abort: true})
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	mustPut(ctx, t, tx, Key("a"), Value("1"))
+	require.NoError(t, tx.Commit(ctx))
+	require.Equal(t, LongTxStats{}, kv.Stats())
+}
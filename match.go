@@ -0,0 +1,77 @@
+package txkv
+
+import (
+	"context"
+	"path"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+)
+
+// FilteredLister is implemented by stores that can filter keys while listing
+// them, close to the data, instead of returning the whole prefix.
+type FilteredLister interface {
+	// ListFiltered lists the keys with `prefix` for which `keep` is true.
+	ListFiltered(ctx context.Context, prefix Key, keep func(Key) bool) ([]Key, error)
+}
+
+// ListFiltered lists the keys of `kv` with `prefix` for which `keep` is true.
+// The filtering happens within the store if it's a FilteredLister, and after
+// listing the whole prefix otherwise.
+func ListFiltered(ctx context.Context, kv KV, prefix Key, keep func(Key) bool) ([]Key, error) {
+	if fl, ok := kv.(FilteredLister); ok {
+		return fl.ListFiltered(ctx, prefix, keep)
+	}
+	keys, err := kv.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	filtered := keys[:0]
+	for _, key := range keys {
+		if keep(key) {
+			filtered = append(filtered, key)
+		}
+	}
+	return filtered, nil
+}
+
+// ListMatch lists the keys of `kv` matching the glob `pattern`, with the
+// syntax of path.Match: keys are treated as slash-separated paths, so `*`
+// doesn't match a `/`. Only the keys sharing the literal prefix of `pattern`
+// are considered.
+func ListMatch(ctx context.Context, kv KV, pattern string) ([]Key, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	prefix := pattern
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		prefix = pattern[:i]
+	}
+	return ListFiltered(ctx, kv, Key(prefix), func(key Key) bool {
+		ok, _ := path.Match(pattern, string(key))
+		return ok
+	})
+}
+
+// ListRegexp lists the keys of `kv` matching `re`. Expressions anchored with
+// `^` and starting with a literal only consider the keys with that prefix, and
+// so are much cheaper than others.
+func ListRegexp(ctx context.Context, kv KV, re *regexp.Regexp) ([]Key, error) {
+	return ListFiltered(ctx, kv, regexpPrefix(re), func(key Key) bool {
+		return re.Match(key)
+	})
+}
+
+// regexpPrefix returns the literal every match of `re` starts with, if `re`
+// is anchored at the beginning of the text.
+func regexpPrefix(re *regexp.Regexp) Key {
+	parsed, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil || parsed.Op != syntax.OpConcat || len(parsed.Sub) < 2 {
+		return nil
+	}
+	begin, lit := parsed.Sub[0], parsed.Sub[1]
+	if begin.Op != syntax.OpBeginText || lit.Op != syntax.OpLiteral || lit.Flags&syntax.FoldCase != 0 {
+		return nil
+	}
+	return Key(string(lit.Rune))
+}
@@ -0,0 +1,58 @@
+package txkv_test
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestListMatch(t *testing.T) {
+	ctx := context.Background()
+	kvs := map[string]TransactionalKV{
+		"inmem":   InMem(),
+		"wrapped": WithMaintenance(InMem()),
+	}
+	for name, kv := range kvs {
+		t.Run(name, func(t *testing.T) {
+			for _, k := range []string{
+				"users/alice/name", "users/alice/email", "users/bob/name",
+				"users/bob/friends/alice", "groups/admins",
+			} {
+				mustPut(ctx, t, kv, Key(k), Value("v"))
+			}
+
+			keys, err := ListMatch(ctx, kv, "users/*/name")
+			require.NoError(t, err)
+			require.Equal(t, []Key{Key("users/alice/name"), Key("users/bob/name")}, keys)
+
+			keys, err = ListMatch(ctx, kv, "users/[ab]*/e?ail")
+			require.NoError(t, err)
+			require.Equal(t, []Key{Key("users/alice/email")}, keys)
+
+			_, err = ListMatch(ctx, kv, "users/[")
+			require.Error(t, err)
+
+			keys, err = ListRegexp(ctx, kv, regexp.MustCompile(`^users/\w+/(name|email)$`))
+			require.NoError(t, err)
+			require.Equal(t, []Key{Key("users/alice/email"), Key("users/alice/name"), Key("users/bob/name")}, keys)
+
+			keys, err = ListRegexp(ctx, kv, regexp.MustCompile(`alice`))
+			require.NoError(t, err)
+			require.Equal(t, []Key{Key("users/alice/email"), Key("users/alice/name"), Key("users/bob/friends/alice")}, keys)
+
+			// within a transaction, its own writes are matched too
+			tx, err := kv.Begin(ctx)
+			require.NoError(t, err)
+			mustPut(ctx, t, tx, Key("users/carol/name"), Value("v"))
+			mustDelete(ctx, t, tx, Key("users/alice/name"))
+			keys, err = ListMatch(ctx, tx, "users/*/name")
+			require.NoError(t, err)
+			require.Equal(t, []Key{Key("users/bob/name"), Key("users/carol/name")}, keys)
+			require.NoError(t, tx.Rollback(ctx))
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package txkv
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// MaxMerkleDepth is the deepest Merkle tree, with 2^MaxMerkleDepth buckets.
+const MaxMerkleDepth = 24
+
+// Merkle is a Merkle tree over the keys of a store, to find where two stores
+// diverge by comparing hashes rather than every key and value: with the trees
+// of both sides, only the buckets whose hashes differ need to be repaired.
+//
+// Keys are spread over 2^depth buckets by the hash of the key, so two stores
+// agree on the bucket of every key. The hash of a bucket combines the hashes
+// of its entries with XOR, which doesn't depend on their order, and lets a
+// tree be kept up to date with Add and Remove as keys change instead of being
+// built again.
+type Merkle struct {
+	depth int
+	// nodes is the tree in heap order: the root is at 1, the children of i
+	// at 2i and 2i+1, and the buckets at 2^depth and on.
+	nodes [][sha256.Size]byte
+}
+
+// NewMerkle returns the tree of an empty store, with 2^depth buckets.
+func NewMerkle(depth int) (*Merkle, error) {
+	if depth < 0 || depth > MaxMerkleDepth {
+		return nil, fmt.Errorf("txkv: Merkle depth must be within 0 and %d, got %d", MaxMerkleDepth, depth)
+	}
+	m := &Merkle{depth: depth, nodes: make([][sha256.Size]byte, 2<<depth)}
+	for i := len(m.nodes)/2 - 1; i >= 1; i-- {
+		m.rehash(i)
+	}
+	return m, nil
+}
+
+// BuildMerkle reads the keys of `kv` under `prefix` into a tree with 2^depth
+// buckets.
+func BuildMerkle(ctx context.Context, kv KV, prefix Key, depth int) (*Merkle, error) {
+	m, err := NewMerkle(depth)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := kv.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		v, ok, err := kv.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			m.toggle(key, v, false)
+		}
+	}
+	for i := len(m.nodes)/2 - 1; i >= 1; i-- {
+		m.rehash(i)
+	}
+	return m, nil
+}
+
+// Depth is the depth of the tree.
+func (m *Merkle) Depth() int { return m.depth }
+
+// Root is the hash of the whole tree. Stores holding the same keys and values
+// have the same root.
+func (m *Merkle) Root() [sha256.Size]byte { return m.nodes[1] }
+
+// Bucket returns the bucket of `key`.
+func (m *Merkle) Bucket(key Key) int {
+	h := sha256.Sum256(key)
+	return int(binary.BigEndian.Uint32(h[:4]) >> (32 - m.depth))
+}
+
+// Add adds `key` with `value` to the tree.
+func (m *Merkle) Add(key Key, value Value) { m.toggle(key, value, true) }
+
+// Remove removes `key` with `value` from the tree. `value` must be the one
+// it was added with.
+func (m *Merkle) Remove(key Key, value Value) { m.toggle(key, value, true) }
+
+// Diverging returns the buckets whose hashes differ in `other`, walking down
+// only the subtrees that differ.
+func (m *Merkle) Diverging(other *Merkle) ([]int, error) {
+	if m.depth != other.depth {
+		return nil, errors.New("txkv: can't compare Merkle trees of different depths")
+	}
+	var buckets []int
+	first := len(m.nodes) / 2
+	var walk func(i int)
+	walk = func(i int) {
+		if m.nodes[i] == other.nodes[i] {
+			return
+		}
+		if i >= first {
+			buckets = append(buckets, i-first)
+			return
+		}
+		walk(2 * i)
+		walk(2*i + 1)
+	}
+	walk(1)
+	return buckets, nil
+}
+
+// toggle adds or removes an entry in its bucket, which are the same with XOR,
+// updating the hashes up to the root if `propagate`.
+func (m *Merkle) toggle(key Key, value Value, propagate bool) {
+	h := sha256.New()
+	var n [binary.MaxVarintLen64]byte
+	h.Write(n[:binary.PutUvarint(n[:], uint64(len(key)))])
+	h.Write(key)
+	h.Write(value)
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+
+	i := len(m.nodes)/2 + m.Bucket(key)
+	for j := range sum {
+		m.nodes[i][j] ^= sum[j]
+	}
+	if propagate {
+		for i /= 2; i >= 1; i /= 2 {
+			m.rehash(i)
+		}
+	}
+}
+
+func (m *Merkle) rehash(i int) {
+	if 2*i >= len(m.nodes) {
+		return
+	}
+	h := sha256.New()
+	h.Write(m.nodes[2*i][:])
+	h.Write(m.nodes[2*i+1][:])
+	h.Sum(m.nodes[i][:0])
+}
+
+// Repair makes the keys of `dst` under `prefix` the same as those of `src`,
+// only copying and deleting keys in the buckets where the Merkle trees of
+// both sides diverge. It returns the number of keys it wrote or deleted.
+// Trees of depth `depth` are built for both sides; callers keeping trees up
+// to date can compare them with Diverging and use RepairBuckets instead.
+func Repair(ctx context.Context, src, dst KV, prefix Key, depth int) (int, error) {
+	srcTree, err := BuildMerkle(ctx, src, prefix, depth)
+	if err != nil {
+		return 0, err
+	}
+	dstTree, err := BuildMerkle(ctx, dst, prefix, depth)
+	if err != nil {
+		return 0, err
+	}
+	buckets, err := srcTree.Diverging(dstTree)
+	if err != nil || len(buckets) == 0 {
+		return 0, err
+	}
+	return RepairBuckets(ctx, src, dst, prefix, srcTree, buckets)
+}
+
+// RepairBuckets makes the keys of `dst` under `prefix` that fall in
+// `buckets` of `tree` the same as those of `src`. It returns the number of
+// keys it wrote or deleted.
+func RepairBuckets(ctx context.Context, src, dst KV, prefix Key, tree *Merkle, buckets []int) (int, error) {
+	want := make(map[int]bool, len(buckets))
+	for _, b := range buckets {
+		want[b] = true
+	}
+	repaired := 0
+	srcKeys, err := src.List(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+	inSrc := make(map[string]bool)
+	for _, key := range srcKeys {
+		if !want[tree.Bucket(key)] {
+			continue
+		}
+		inSrc[string(key)] = true
+		v, ok, err := src.Get(ctx, key)
+		if err != nil {
+			return repaired, err
+		}
+		if !ok {
+			delete(inSrc, string(key))
+			continue
+		}
+		old, found, err := dst.Get(ctx, key)
+		if err != nil {
+			return repaired, err
+		}
+		if found && string(old) == string(v) {
+			continue
+		}
+		if err := dst.Put(ctx, key, v); err != nil {
+			return repaired, err
+		}
+		repaired++
+	}
+	dstKeys, err := dst.List(ctx, prefix)
+	if err != nil {
+		return repaired, err
+	}
+	for _, key := range dstKeys {
+		if !want[tree.Bucket(key)] || inSrc[string(key)] {
+			continue
+		}
+		if err := dst.Delete(ctx, key); err != nil {
+			return repaired, err
+		}
+		repaired++
+	}
+	return repaired, nil
+}
@@ -0,0 +1,71 @@
+package txkv_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestMerkle(t *testing.T) {
+	ctx := context.Background()
+	src, dst := InMem(), InMem()
+	for i := 0; i < 200; i++ {
+		key, value := Key(fmt.Sprintf("p/%03d", i)), Value(fmt.Sprintf("v%d", i))
+		mustPut(ctx, t, src, key, value)
+		mustPut(ctx, t, dst, key, value)
+	}
+	mustPut(ctx, t, dst, Key("other"), Value("ignored"))
+
+	a, err := BuildMerkle(ctx, src, Key("p/"), 6)
+	require.NoError(t, err)
+	b, err := BuildMerkle(ctx, dst, Key("p/"), 6)
+	require.NoError(t, err)
+	require.Equal(t, a.Root(), b.Root())
+
+	mustPut(ctx, t, src, Key("p/007"), Value("changed"))
+	mustPut(ctx, t, src, Key("p/new"), Value("added"))
+	mustDelete(ctx, t, src, Key("p/100"))
+
+	// a tree kept up to date matches one built again
+	a.Remove(Key("p/007"), Value("v7"))
+	a.Add(Key("p/007"), Value("changed"))
+	a.Add(Key("p/new"), Value("added"))
+	a.Remove(Key("p/100"), Value("v100"))
+	built, err := BuildMerkle(ctx, src, Key("p/"), 6)
+	require.NoError(t, err)
+	require.Equal(t, built.Root(), a.Root())
+
+	buckets, err := a.Diverging(b)
+	require.NoError(t, err)
+	require.NotEmpty(t, buckets)
+	require.LessOrEqual(t, len(buckets), 3)
+	require.Contains(t, buckets, a.Bucket(Key("p/new")))
+
+	n, err := Repair(ctx, src, dst, Key("p/"), 6)
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+	report, err := Diff(ctx, src, dst, Key("p/"))
+	require.NoError(t, err)
+	require.True(t, report.Equal())
+	mustFind(ctx, t, dst, Key("other"), Value("ignored"))
+
+	n, err = Repair(ctx, src, dst, Key("p/"), 6)
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	_, err = NewMerkle(MaxMerkleDepth + 1)
+	require.Error(t, err)
+	_, err = a.Diverging(mustMerkle(t, 2))
+	require.Error(t, err)
+	require.Equal(t, 0, mustMerkle(t, 0).Bucket(Key("any")))
+}
+
+func mustMerkle(t *testing.T, depth int) *Merkle {
+	m, err := NewMerkle(depth)
+	require.NoError(t, err)
+	return m
+}
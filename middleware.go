@@ -0,0 +1,21 @@
+package txkv
+
+// Middleware wraps a TransactionalKV into another, like WithRetry or
+// WithEncryption do.
+type Middleware func(TransactionalKV) TransactionalKV
+
+// Chain returns a Middleware applying `middlewares` in order, the first being
+// the outermost: Chain(a, b)(kv) is a(b(kv)), so operations go through `a`
+// first and reach `kv` last.
+//
+// The order matters: WithRetry outside of WithProfiling has every attempt
+// accounted for, inside it only the operation; WithSigning outside of
+// WithEncryption signs the plaintexts, inside it the ciphertexts.
+func Chain(middlewares ...Middleware) Middleware {
+	return func(kv TransactionalKV) TransactionalKV {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			kv = middlewares[i](kv)
+		}
+		return kv
+	}
+}
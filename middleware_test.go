@@ -0,0 +1,124 @@
+package txkv_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+// tracingKV records the order in which it sees Puts.
+type tracingKV struct {
+	TransactionalKV
+	name  string
+	trace *[]string
+}
+
+func (k *tracingKV) Put(ctx context.Context, key Key, value Value) error {
+	*k.trace = append(*k.trace, k.name)
+	return k.TransactionalKV.Put(ctx, key, value)
+}
+
+func tracing(name string, trace *[]string) Middleware {
+	return func(kv TransactionalKV) TransactionalKV {
+		return &tracingKV{TransactionalKV: kv, name: name, trace: trace}
+	}
+}
+
+func encrypting(kv TransactionalKV) TransactionalKV {
+	return WithEncryption(kv, StaticKeyring(testAESKey))
+}
+
+func signing(kv TransactionalKV) TransactionalKV {
+	return WithSigning(kv, HMACSigner([]byte("secret")))
+}
+
+func retrying(kv TransactionalKV) TransactionalKV {
+	return WithRetry(kv, fastRetries)
+}
+
+func TestChainOrder(t *testing.T) {
+	ctx := context.Background()
+	var trace []string
+	kv := Chain(tracing("a", &trace), tracing("b", &trace), tracing("c", &trace))(InMem())
+	mustPut(ctx, t, kv, Key("hello"), Value("world"))
+	require.Equal(t, []string{"a", "b", "c"}, trace)
+
+	// no middleware is the store itself
+	raw := InMem()
+	require.Equal(t, raw, Chain()(raw))
+}
+
+func TestChainPairs(t *testing.T) {
+	pairs := map[string][]Middleware{
+		"encryption, signing": {encrypting, signing},
+		"signing, encryption": {signing, encrypting},
+		"retry, encryption":   {retrying, encrypting},
+		"encryption, retry":   {encrypting, retrying},
+		"retry, signing":      {retrying, signing},
+		"signing, retry":      {signing, retrying},
+	}
+	for name, middlewares := range pairs {
+		middlewares := middlewares
+		t.Run(name, func(t *testing.T) {
+			testKV(t, func(t testing.TB) TransactionalKV {
+				return Chain(middlewares...)(InMem())
+			})
+		})
+	}
+}
+
+func TestChainRetryProfiling(t *testing.T) {
+	ctx := context.Background()
+	opStats := func(p *ProfiledKV, op string) OpStats {
+		for _, s := range p.Stats() {
+			if s.Op == op {
+				return s
+			}
+		}
+		return OpStats{}
+	}
+
+	// profiled outside of the retries, the operation counts once
+	flaky := &flakyKV{TransactionalKV: InMem(), failures: 2, err: ErrTransient}
+	var outer *ProfiledKV
+	kv := Chain(func(kv TransactionalKV) TransactionalKV {
+		outer = WithProfiling(kv, ProfilingOptions{})
+		return outer
+	}, retrying)(flaky)
+	mustPut(ctx, t, kv, Key("hello"), Value("world"))
+	require.Equal(t, OpStats{Op: OpPut, Count: 1}, withoutDuration(opStats(outer, OpPut)))
+
+	// profiled inside of them, every attempt counts
+	flaky = &flakyKV{TransactionalKV: InMem(), failures: 2, err: ErrTransient}
+	var inner *ProfiledKV
+	kv = Chain(retrying, func(kv TransactionalKV) TransactionalKV {
+		inner = WithProfiling(kv, ProfilingOptions{})
+		return inner
+	})(flaky)
+	mustPut(ctx, t, kv, Key("hello"), Value("world"))
+	require.Equal(t, OpStats{Op: OpPut, Count: 3, Errors: 2}, withoutDuration(opStats(inner, OpPut)))
+}
+
+func TestChainSigningEncryption(t *testing.T) {
+	ctx := context.Background()
+	raw := InMem()
+	// signatures are checked before decrypting, so tampered ciphertexts are
+	// caught as such
+	kv := Chain(encrypting, signing)(raw)
+	mustPut(ctx, t, kv, Key("hello"), Value("world"))
+	sealed, _, err := raw.Get(ctx, Key("hello"))
+	require.NoError(t, err)
+	sealed[len(sealed)-1] ^= 0xff
+	require.NoError(t, raw.Put(ctx, Key("hello"), sealed))
+	_, _, err = kv.Get(ctx, Key("hello"))
+	var sigErr *SignatureError
+	require.ErrorAs(t, err, &sigErr)
+}
+
+func withoutDuration(s OpStats) OpStats {
+	s.Duration = 0
+	return s
+}
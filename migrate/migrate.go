@@ -0,0 +1,217 @@
+// Package migrate applies versioned migrations to a txkv keyspace.
+//
+// Migrations are applied in order, each one in bounded steps. Every step runs
+// in its own transaction along with a checkpoint of its progress, so a
+// migration interrupted by a crash resumes where it left off instead of
+// starting over. The schema version reached so far is stored in the KV.
+package migrate
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/aybabtme/txkv"
+)
+
+// StepFunc performs a bounded chunk of a migration within `tx`, starting at
+// `cursor`, which is empty on the first call. It returns the cursor the next
+// step should start from, and whether the migration is done. The cursor is
+// saved in the same transaction as the step's writes.
+type StepFunc func(ctx context.Context, tx txkv.TxKV, cursor txkv.Key) (next txkv.Key, done bool, err error)
+
+// Migration brings the keyspace from Version-1 to Version.
+type Migration struct {
+	Version int
+	Name    string
+	Step    StepFunc
+}
+
+// Progress is reported after each step.
+type Progress struct {
+	Version int
+	Name    string
+	Cursor  txkv.Key
+	Steps   int // steps performed by this run of the migration
+	Done    bool
+}
+
+// Options tune a Migrator.
+type Options struct {
+	// StatePrefix is where the schema version and checkpoints are stored.
+	// Defaults to DefaultStatePrefix.
+	StatePrefix txkv.Key
+	// OnProgress, if set, is called after each step.
+	OnProgress func(Progress)
+}
+
+// DefaultStatePrefix is the default location of the migration state.
+var DefaultStatePrefix = txkv.Key("__migrate/")
+
+// ErrUnknownVersion is returned when the store is at a version newer than
+// any known migration, meaning it was migrated by a newer program.
+var ErrUnknownVersion = errors.New("migrate: store is at an unknown version")
+
+// Migrator applies migrations to a store.
+type Migrator struct {
+	kv         txkv.TransactionalKV
+	migrations []Migration
+	opts       Options
+}
+
+// New returns a Migrator applying `migrations` to `kv`. Migrations must be
+// numbered from 1, without gaps.
+func New(kv txkv.TransactionalKV, migrations []Migration, opts Options) (*Migrator, error) {
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migrate: migration %q has version %d, want %d", m.Name, m.Version, i+1)
+		}
+		if m.Step == nil {
+			return nil, fmt.Errorf("migrate: migration %q has no step", m.Name)
+		}
+	}
+	if opts.StatePrefix == nil {
+		opts.StatePrefix = DefaultStatePrefix
+	}
+	return &Migrator{kv: kv, migrations: migrations, opts: opts}, nil
+}
+
+func (m *Migrator) versionKey() txkv.Key    { return m.stateKey("version") }
+func (m *Migrator) checkpointKey() txkv.Key { return m.stateKey("checkpoint") }
+
+func (m *Migrator) stateKey(name string) txkv.Key {
+	return append(append(txkv.Key(nil), m.opts.StatePrefix...), name...)
+}
+
+// Version returns the schema version of the store, 0 if it was never
+// migrated.
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	return m.version(ctx, m.kv)
+}
+
+func (m *Migrator) version(ctx context.Context, kv txkv.KV) (int, error) {
+	v, ok, err := kv.Get(ctx, m.versionKey())
+	if err != nil || !ok {
+		return 0, err
+	}
+	version, err := strconv.Atoi(string(v))
+	if err != nil {
+		return 0, fmt.Errorf("migrate: invalid schema version %q: %v", v, err)
+	}
+	return version, nil
+}
+
+// Up applies all pending migrations, resuming an interrupted one if any.
+func (m *Migrator) Up(ctx context.Context) error {
+	version, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+	if version > len(m.migrations) {
+		return fmt.Errorf("%w: %d, latest known is %d", ErrUnknownVersion, version, len(m.migrations))
+	}
+	for _, mig := range m.migrations[version:] {
+		if err := m.apply(ctx, mig); err != nil {
+			return fmt.Errorf("migrate: applying migration %d (%s): %w", mig.Version, mig.Name, err)
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) apply(ctx context.Context, mig Migration) error {
+	cursor, err := m.checkpoint(ctx, mig.Version)
+	if err != nil {
+		return err
+	}
+	for steps := 1; ; steps++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		next, done, err := m.step(ctx, mig, cursor)
+		if err != nil {
+			return err
+		}
+		cursor = next
+		if m.opts.OnProgress != nil {
+			m.opts.OnProgress(Progress{
+				Version: mig.Version,
+				Name:    mig.Name,
+				Cursor:  cursor,
+				Steps:   steps,
+				Done:    done,
+			})
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// step runs one step, and saves its outcome in the same transaction.
+func (m *Migrator) step(ctx context.Context, mig Migration, cursor txkv.Key) (txkv.Key, bool, error) {
+	tx, err := m.kv.Begin(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	next, done, err := m.stepTx(ctx, tx, mig, cursor)
+	if err != nil {
+		_ = tx.Rollback(ctx)
+		return nil, false, err
+	}
+	return next, done, tx.Commit(ctx)
+}
+
+func (m *Migrator) stepTx(ctx context.Context, tx txkv.TxKV, mig Migration, cursor txkv.Key) (txkv.Key, bool, error) {
+	// someone else may have moved things along since we looked
+	version, err := m.version(ctx, tx)
+	if err != nil {
+		return nil, false, err
+	}
+	if version != mig.Version-1 {
+		return nil, false, fmt.Errorf("store is at version %d, concurrent migration?", version)
+	}
+	next, done, err := mig.Step(ctx, tx, cursor)
+	if err != nil {
+		return nil, false, err
+	}
+	if done {
+		if err := tx.Delete(ctx, m.checkpointKey()); err != nil {
+			return nil, false, err
+		}
+		err = tx.Put(ctx, m.versionKey(), txkv.Value(strconv.Itoa(mig.Version)))
+		return next, true, err
+	}
+	return next, false, tx.Put(ctx, m.checkpointKey(), encodeCheckpoint(mig.Version, next))
+}
+
+// checkpoint returns the cursor to resume migration `version` from, nil if
+// it wasn't started.
+func (m *Migrator) checkpoint(ctx context.Context, version int) (txkv.Key, error) {
+	v, ok, err := m.kv.Get(ctx, m.checkpointKey())
+	if err != nil || !ok {
+		return nil, err
+	}
+	cpVersion, cursor, err := decodeCheckpoint(v)
+	if err != nil {
+		return nil, err
+	}
+	if cpVersion != version {
+		return nil, fmt.Errorf("found a checkpoint for migration %d", cpVersion)
+	}
+	return cursor, nil
+}
+
+func encodeCheckpoint(version int, cursor txkv.Key) txkv.Value {
+	v := binary.AppendUvarint(nil, uint64(version))
+	return append(v, cursor...)
+}
+
+func decodeCheckpoint(v txkv.Value) (int, txkv.Key, error) {
+	version, n := binary.Uvarint(v)
+	if n <= 0 {
+		return 0, nil, errors.New("migrate: corrupted checkpoint")
+	}
+	return int(version), txkv.Key(v[n:]), nil
+}
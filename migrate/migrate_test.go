@@ -0,0 +1,145 @@
+package migrate_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aybabtme/txkv"
+	"github.com/aybabtme/txkv/migrate"
+)
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	kv := txkv.InMem()
+	for i := 0; i < 25; i++ {
+		require.NoError(t, kv.Put(ctx, txkv.Key(fmt.Sprintf("users/%02d", i)), txkv.Value("name")))
+	}
+
+	migrations := []migrate.Migration{
+		{
+			Version: 1,
+			Name:    "uppercase names",
+			Step: migrate.RewriteValues(txkv.Key("users/"), 10, func(_ txkv.Key, v txkv.Value) (txkv.Value, error) {
+				return txkv.Value(strings.ToUpper(string(v))), nil
+			}),
+		},
+		{
+			Version: 2,
+			Name:    "move users",
+			Step:    migrate.MovePrefix(txkv.Key("users/"), txkv.Key("accounts/"), 10),
+		},
+	}
+	var progress []migrate.Progress
+	m, err := migrate.New(kv, migrations, migrate.Options{
+		OnProgress: func(p migrate.Progress) { progress = append(progress, p) },
+	})
+	require.NoError(t, err)
+
+	version, err := m.Version(ctx)
+	require.NoError(t, err)
+	require.Zero(t, version)
+
+	require.NoError(t, m.Up(ctx))
+
+	version, err = m.Version(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, version)
+
+	// 3 steps per migration, since there are 25 keys in batches of 10
+	require.Len(t, progress, 6)
+	require.True(t, progress[2].Done)
+	require.True(t, progress[5].Done)
+
+	users, err := kv.List(ctx, txkv.Key("users/"))
+	require.NoError(t, err)
+	require.Empty(t, users)
+	accounts, err := kv.List(ctx, txkv.Key("accounts/"))
+	require.NoError(t, err)
+	require.Len(t, accounts, 25)
+	v, _, err := kv.Get(ctx, txkv.Key("accounts/07"))
+	require.NoError(t, err)
+	require.Equal(t, txkv.Value("NAME"), v)
+
+	// running again is a no-op
+	progress = nil
+	require.NoError(t, m.Up(ctx))
+	require.Empty(t, progress)
+}
+
+func TestMigrateResumes(t *testing.T) {
+	ctx := context.Background()
+	kv := txkv.InMem()
+	for i := 0; i < 30; i++ {
+		require.NoError(t, kv.Put(ctx, txkv.Key(fmt.Sprintf("k/%02d", i)), txkv.Value("0")))
+	}
+
+	crash := errors.New("crash")
+	visits := make(map[string]int)
+	crashAt := "k/15"
+	step := migrate.ForEachKey(txkv.Key("k/"), 10, func(ctx context.Context, tx txkv.TxKV, key txkv.Key, value txkv.Value) error {
+		if string(key) == crashAt {
+			return crash
+		}
+		visits[string(key)]++
+		return tx.Put(ctx, key, txkv.Value("1"))
+	})
+	migrations := []migrate.Migration{{Version: 1, Name: "set to 1", Step: step}}
+
+	m, err := migrate.New(kv, migrations, migrate.Options{})
+	require.NoError(t, err)
+	require.ErrorIs(t, m.Up(ctx), crash)
+
+	// the first batch went through, the second was rolled back
+	version, err := m.Version(ctx)
+	require.NoError(t, err)
+	require.Zero(t, version)
+
+	// after a restart, it picks up from the last checkpoint
+	crashAt = ""
+	m, err = migrate.New(kv, migrations, migrate.Options{})
+	require.NoError(t, err)
+	require.NoError(t, m.Up(ctx))
+
+	version, err = m.Version(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, version)
+
+	require.Len(t, visits, 30)
+	for key, n := range visits {
+		switch {
+		case key < "k/10":
+			require.Equal(t, 1, n, key) // checkpointed, not redone
+		case key < "k/15":
+			require.Equal(t, 2, n, key) // rolled back, redone
+		default:
+			require.Equal(t, 1, n, key)
+		}
+	}
+}
+
+func TestMigrateUnknownVersion(t *testing.T) {
+	ctx := context.Background()
+	kv := txkv.InMem()
+	noop := func(context.Context, txkv.TxKV, txkv.Key) (txkv.Key, bool, error) { return nil, true, nil }
+
+	newer, err := migrate.New(kv, []migrate.Migration{
+		{Version: 1, Name: "one", Step: noop},
+		{Version: 2, Name: "two", Step: noop},
+	}, migrate.Options{})
+	require.NoError(t, err)
+	require.NoError(t, newer.Up(ctx))
+
+	older, err := migrate.New(kv, []migrate.Migration{
+		{Version: 1, Name: "one", Step: noop},
+	}, migrate.Options{})
+	require.NoError(t, err)
+	require.ErrorIs(t, older.Up(ctx), migrate.ErrUnknownVersion)
+
+	_, err = migrate.New(kv, []migrate.Migration{{Version: 2, Name: "gap", Step: noop}}, migrate.Options{})
+	require.Error(t, err)
+}
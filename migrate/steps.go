@@ -0,0 +1,77 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/aybabtme/txkv"
+)
+
+// DefaultBatchSize is the number of keys handled per step by the helpers of
+// this package when given a batch size of 0.
+const DefaultBatchSize = 100
+
+// ForEachKey returns a StepFunc calling `fn` on every key under `prefix`, in
+// order, `batchSize` keys per step. It covers the common migrations: values
+// can be re-encoded in place, moved elsewhere, or used to backfill indexes.
+//
+// The cursor is the last key handled, so keys that `fn` creates under
+// `prefix` after the cursor will be visited too.
+func ForEachKey(prefix txkv.Key, batchSize int, fn func(ctx context.Context, tx txkv.TxKV, key txkv.Key, value txkv.Value) error) StepFunc {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	return func(ctx context.Context, tx txkv.TxKV, cursor txkv.Key) (txkv.Key, bool, error) {
+		keys, err := tx.List(ctx, prefix)
+		if err != nil {
+			return nil, false, err
+		}
+		if len(cursor) > 0 {
+			for len(keys) > 0 && bytes.Compare(keys[0], cursor) <= 0 {
+				keys = keys[1:]
+			}
+		}
+		done := len(keys) <= batchSize
+		if !done {
+			keys = keys[:batchSize]
+		}
+		for _, key := range keys {
+			value, ok, err := tx.Get(ctx, key)
+			if err != nil {
+				return nil, false, err
+			}
+			if !ok {
+				continue
+			}
+			if err := fn(ctx, tx, key, value); err != nil {
+				return nil, false, err
+			}
+			cursor = key
+		}
+		return cursor, done, nil
+	}
+}
+
+// RewriteValues returns a StepFunc replacing every value under `prefix` with
+// the result of `fn`, for instance to change their encoding.
+func RewriteValues(prefix txkv.Key, batchSize int, fn func(key txkv.Key, value txkv.Value) (txkv.Value, error)) StepFunc {
+	return ForEachKey(prefix, batchSize, func(ctx context.Context, tx txkv.TxKV, key txkv.Key, value txkv.Value) error {
+		v, err := fn(key, value)
+		if err != nil {
+			return err
+		}
+		return tx.Put(ctx, key, v)
+	})
+}
+
+// MovePrefix returns a StepFunc moving every key under `from` to the same
+// key under `to`. `to` must not be under `from`.
+func MovePrefix(from, to txkv.Key, batchSize int) StepFunc {
+	return ForEachKey(from, batchSize, func(ctx context.Context, tx txkv.TxKV, key txkv.Key, value txkv.Value) error {
+		moved := append(append(txkv.Key(nil), to...), key[len(from):]...)
+		if err := tx.Put(ctx, moved, value); err != nil {
+			return err
+		}
+		return tx.Delete(ctx, key)
+	})
+}
@@ -0,0 +1,136 @@
+package txkv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+)
+
+// DefaultMoveBatchSize is the number of keys MovePrefix moves per transaction
+// when MoveOptions.BatchSize is 0.
+const DefaultMoveBatchSize = 100
+
+// MoveOptions tune MovePrefix.
+type MoveOptions struct {
+	// BatchSize is the number of keys moved per transaction. Defaults to
+	// DefaultMoveBatchSize.
+	BatchSize int
+	// KeysPerSecond caps the pace of the move, to leave room for live
+	// traffic. 0 means no limit.
+	KeysPerSecond float64
+	// DryRun only counts the keys that would be moved, without writing.
+	DryRun bool
+	// OnBatch, if set, is called after each committed batch with the number
+	// of keys moved so far.
+	OnBatch func(moved int)
+}
+
+// MovePrefix moves every key under `from` to the same key under `to`,
+// overwriting keys already present there. It returns the number of keys moved,
+// or that would be moved in a dry run.
+//
+// Keys are moved in batches, each one copied then deleted in its own
+// transaction, so readers never see a key missing from both places. Each
+// batch resumes after the last key moved, and the prefix is gone through
+// again once done, so writers adding keys under `from` during the move will
+// see them moved too. If the move fails midway, the keys already moved stay
+// moved; calling MovePrefix again finishes the job.
+//
+// `from` and `to` can't be under one another, as keys could then be moved
+// onto keys still to be moved.
+func MovePrefix(ctx context.Context, kv TransactionalKV, from, to Key, opts MoveOptions) (int, error) {
+	if bytes.HasPrefix(to, from) || bytes.HasPrefix(from, to) {
+		return 0, errors.New("txkv: can't move a prefix to an overlapping one")
+	}
+	if opts.DryRun {
+		keys, err := kv.List(ctx, from)
+		return len(keys), err
+	}
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultMoveBatchSize
+	}
+	var (
+		moved int
+		after Key // the last key moved by this pass
+	)
+	for {
+		start := time.Now()
+		n, last, err := moveBatch(ctx, kv, from, to, after, batchSize)
+		moved += n
+		if err != nil {
+			return moved, err
+		}
+		if n == 0 {
+			if after == nil {
+				return moved, nil
+			}
+			after = nil // another pass, for the keys added behind
+			continue
+		}
+		after = last
+		if opts.OnBatch != nil {
+			opts.OnBatch(moved)
+		}
+		if opts.KeysPerSecond > 0 {
+			budget := time.Duration(float64(n) / opts.KeysPerSecond * float64(time.Second))
+			if err := sleep(ctx, budget-time.Since(start)); err != nil {
+				return moved, err
+			}
+		}
+	}
+}
+
+// moveBatch moves up to `batchSize` keys from `from` to `to`, starting after
+// `after`, returning how many it moved and the last one.
+func moveBatch(ctx context.Context, kv TransactionalKV, from, to, after Key, batchSize int) (int, Key, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, nil, err
+	}
+	tx, err := kv.Begin(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	res, err := ListWithOptions(ctx, tx, from, ListOptions{Limit: batchSize, After: after})
+	if err != nil {
+		_ = tx.Rollback(ctx)
+		return 0, nil, err
+	}
+	keys := res.Keys
+	for _, key := range keys {
+		value, ok, err := tx.Get(ctx, key)
+		if err == nil && ok {
+			dst := append(append(Key(nil), to...), key[len(from):]...)
+			err = tx.Put(ctx, dst, value)
+		}
+		if err == nil {
+			err = tx.Delete(ctx, key)
+		}
+		if err != nil {
+			_ = tx.Rollback(ctx)
+			return 0, nil, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, nil, err
+	}
+	if len(keys) == 0 {
+		return 0, nil, nil
+	}
+	return len(keys), keys[len(keys)-1], nil
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,96 @@
+package txkv_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestMovePrefix(t *testing.T) {
+	ctx := context.Background()
+	kv := InMem()
+	var want []Key
+	for i := 0; i < 25; i++ {
+		mustPut(ctx, t, kv, Key(fmt.Sprintf("old/%02d", i)), Value(fmt.Sprint(i)))
+		want = append(want, Key(fmt.Sprintf("new/%02d", i)))
+	}
+	mustPut(ctx, t, kv, Key("other"), Value("untouched"))
+
+	n, err := MovePrefix(ctx, kv, Key("old/"), Key("new/"), MoveOptions{DryRun: true})
+	require.NoError(t, err)
+	require.Equal(t, 25, n)
+	mustList(ctx, t, kv, Key("new/"), nil)
+
+	var batches []int
+	n, err = MovePrefix(ctx, kv, Key("old/"), Key("new/"), MoveOptions{
+		BatchSize: 10,
+		OnBatch:   func(moved int) { batches = append(batches, moved) },
+	})
+	require.NoError(t, err)
+	require.Equal(t, 25, n)
+	require.Equal(t, []int{10, 20, 25}, batches)
+
+	mustList(ctx, t, kv, Key("old/"), nil)
+	mustList(ctx, t, kv, Key("new/"), want)
+	mustFind(ctx, t, kv, Key("new/07"), Value("7"))
+	mustFind(ctx, t, kv, Key("other"), Value("untouched"))
+
+	// prefixes can't overlap, either way
+	mustPut(ctx, t, kv, Key("a/b/b/x"), Value("deep"))
+	mustPut(ctx, t, kv, Key("a/b/x"), Value("shallow"))
+	_, err = MovePrefix(ctx, kv, Key("new/"), Key("new/sub/"), MoveOptions{})
+	require.Error(t, err)
+	_, err = MovePrefix(ctx, kv, Key("a/b/"), Key("a/"), MoveOptions{})
+	require.Error(t, err)
+	mustFind(ctx, t, kv, Key("a/b/x"), Value("shallow"))
+}
+
+func TestMovePrefixRateLimit(t *testing.T) {
+	ctx := context.Background()
+	kv := InMem()
+	for i := 0; i < 20; i++ {
+		mustPut(ctx, t, kv, Key(fmt.Sprintf("old/%02d", i)), Value("v"))
+	}
+
+	start := time.Now()
+	n, err := MovePrefix(ctx, kv, Key("old/"), Key("new/"), MoveOptions{BatchSize: 5, KeysPerSecond: 200})
+	require.NoError(t, err)
+	require.Equal(t, 20, n)
+	require.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+
+	// cancellation stops the move between batches
+	ctx, cancel := context.WithCancel(ctx)
+	n, err = MovePrefix(ctx, kv, Key("new/"), Key("old/"), MoveOptions{
+		BatchSize:     5,
+		KeysPerSecond: 1,
+		OnBatch:       func(int) { cancel() },
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 5, n)
+}
+
+func TestMovePrefixWritesBehind(t *testing.T) {
+	ctx := context.Background()
+	kv := InMem()
+	for i := 0; i < 10; i++ {
+		mustPut(ctx, t, kv, Key(fmt.Sprintf("old/%02d", i)), Value("v"))
+	}
+	// a key lands behind the cursor of the move
+	n, err := MovePrefix(ctx, kv, Key("old/"), Key("new/"), MoveOptions{
+		BatchSize: 4,
+		OnBatch: func(moved int) {
+			if moved == 4 {
+				mustPut(ctx, t, kv, Key("old/00a"), Value("late"))
+			}
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 11, n)
+	mustList(ctx, t, kv, Key("old/"), nil)
+	mustFind(ctx, t, kv, Key("new/00a"), Value("late"))
+}
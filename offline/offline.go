@@ -0,0 +1,309 @@
+// Package offline provides a store that works offline, for CLI tools and edge
+// agents: writes go to a local store, which is synced with a remote one when
+// it can be reached.
+//
+// Syncing finds the keys changed on either side since the last sync, using
+// ChangeTracker when the stores are one, and keeps the hash of the value each
+// key had when last synced. A key that only changed on one side is copied to
+// the other; a key that changed on both sides to different values is a
+// conflict, settled by a Resolver.
+package offline
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/aybabtme/txkv"
+)
+
+// DefaultStatePrefix is the default location of the sync state in the local
+// store.
+var DefaultStatePrefix = txkv.Key("\x00offline/")
+
+// DefaultSyncInterval is how often Run syncs when given an interval of 0.
+const DefaultSyncInterval = 10 * time.Second
+
+// Conflict is a key that changed on both sides to different values since the
+// last sync.
+type Conflict struct {
+	Key           txkv.Key
+	Local         txkv.Value
+	LocalDeleted  bool
+	Remote        txkv.Value
+	RemoteDeleted bool
+}
+
+// Resolver settles a conflict, returning the value the key must have on both
+// sides, or deleted if it must be deleted. Resolvers merging values, like
+// those of CRDTs, let no write get lost.
+type Resolver func(ctx context.Context, c Conflict) (value txkv.Value, deleted bool, err error)
+
+// LocalWins resolves conflicts in favor of the local changes.
+func LocalWins(_ context.Context, c Conflict) (txkv.Value, bool, error) {
+	return c.Local, c.LocalDeleted, nil
+}
+
+// RemoteWins resolves conflicts in favor of the remote changes.
+func RemoteWins(_ context.Context, c Conflict) (txkv.Value, bool, error) {
+	return c.Remote, c.RemoteDeleted, nil
+}
+
+// Options tune a Store.
+type Options struct {
+	// Prefix is the keys synced. Defaults to all of them.
+	Prefix txkv.Key
+	// StatePrefix is where the sync state is kept in the local store. It
+	// mustn't overlap the keys synced. Defaults to DefaultStatePrefix.
+	StatePrefix txkv.Key
+	// Resolve settles conflicts. Defaults to RemoteWins.
+	Resolve Resolver
+}
+
+// SyncStats tell what a sync did.
+type SyncStats struct {
+	// Pushed is the number of keys copied to the remote store.
+	Pushed int
+	// Pulled is the number of keys copied to the local store.
+	Pulled int
+	// Conflicts is the number of keys resolved.
+	Conflicts int
+}
+
+// Store is a TransactionalKV over a local store, synced with a remote one.
+type Store struct {
+	local  txkv.TransactionalKV
+	remote txkv.TransactionalKV
+	opts   Options
+}
+
+// New returns a Store writing to `local`, synced with `remote`.
+func New(local, remote txkv.TransactionalKV, opts Options) *Store {
+	if opts.StatePrefix == nil {
+		opts.StatePrefix = DefaultStatePrefix
+	}
+	if opts.Resolve == nil {
+		opts.Resolve = RemoteWins
+	}
+	return &Store{local: local, remote: remote, opts: opts}
+}
+
+func (s *Store) Put(ctx context.Context, key txkv.Key, value txkv.Value) error {
+	return s.local.Put(ctx, key, value)
+}
+
+func (s *Store) Get(ctx context.Context, key txkv.Key) (txkv.Value, bool, error) {
+	return s.local.Get(ctx, key)
+}
+
+func (s *Store) Delete(ctx context.Context, key txkv.Key) error {
+	return s.local.Delete(ctx, key)
+}
+
+func (s *Store) List(ctx context.Context, prefix txkv.Key) ([]txkv.Key, error) {
+	return s.local.List(ctx, prefix)
+}
+
+func (s *Store) Begin(ctx context.Context) (txkv.TxKV, error) {
+	return s.local.Begin(ctx)
+}
+
+// Sync exchanges the changes made on either side since the last sync. It
+// must not run concurrently with itself, and keys written locally while it
+// runs may be overwritten by remote changes.
+//
+// The remote store is written to first, then the local one along with the
+// sync state: a sync that fails midway is resumed by the next one.
+func (s *Store) Sync(ctx context.Context) (SyncStats, error) {
+	var stats SyncStats
+	localSeq, localKeys, err := s.changed(ctx, s.local, "local")
+	if err != nil {
+		return stats, err
+	}
+	remoteSeq, remoteKeys, err := s.changed(ctx, s.remote, "remote")
+	if err != nil {
+		return stats, err
+	}
+	candidates := make(map[string]txkv.Key)
+	for _, key := range append(localKeys, remoteKeys...) {
+		if !bytes.HasPrefix(key, s.opts.StatePrefix) {
+			candidates[string(key)] = key
+		}
+	}
+
+	var localOps, remoteOps []txkv.Op
+	for _, key := range candidates {
+		lv, lok, err := s.local.Get(ctx, key)
+		if err != nil {
+			return stats, err
+		}
+		rv, rok, err := s.remote.Get(ctx, key)
+		if err != nil {
+			return stats, err
+		}
+		base, baseOK, err := s.local.Get(ctx, s.baseKey(key))
+		if err != nil {
+			return stats, err
+		}
+		localChanged := !sameState(lv, lok, base, baseOK)
+		remoteChanged := !sameState(rv, rok, base, baseOK)
+
+		var value txkv.Value
+		var deleted bool
+		switch {
+		case !localChanged && !remoteChanged:
+			continue
+		case !remoteChanged:
+			value, deleted = lv, !lok
+			remoteOps = append(remoteOps, writeOp(key, value, deleted))
+			stats.Pushed++
+		case !localChanged:
+			value, deleted = rv, !rok
+			localOps = append(localOps, writeOp(key, value, deleted))
+			stats.Pulled++
+		case lok == rok && bytes.Equal(lv, rv):
+			// both made the same change
+			value, deleted = lv, !lok
+		default:
+			value, deleted, err = s.opts.Resolve(ctx, Conflict{
+				Key: key, Local: lv, LocalDeleted: !lok, Remote: rv, RemoteDeleted: !rok,
+			})
+			if err != nil {
+				return stats, err
+			}
+			localOps = append(localOps, writeOp(key, value, deleted))
+			remoteOps = append(remoteOps, writeOp(key, value, deleted))
+			stats.Conflicts++
+		}
+		if deleted {
+			localOps = append(localOps, txkv.DeleteOp(s.baseKey(key)))
+		} else {
+			localOps = append(localOps, txkv.PutOp(s.baseKey(key), hashValue(value)))
+		}
+	}
+	if len(remoteOps) > 0 {
+		if err := txkv.Apply(ctx, s.remote, remoteOps); err != nil {
+			return stats, err
+		}
+	}
+	// the keys pulled show up as changed next time, but match their base
+	localOps = append(localOps,
+		txkv.PutOp(s.stateKey("seq/local"), binary.AppendUvarint(nil, localSeq)),
+		txkv.PutOp(s.stateKey("seq/remote"), binary.AppendUvarint(nil, remoteSeq)),
+	)
+	return stats, txkv.Apply(ctx, s.local, localOps)
+}
+
+// Run syncs every `interval` until `ctx` is done. Transient errors, like
+// those of an unreachable remote store, are retried at the next interval;
+// other errors stop it. An `interval` of 0 means DefaultSyncInterval.
+func (s *Store) Run(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		interval = DefaultSyncInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := s.Sync(ctx); err != nil && !txkv.IsTransient(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// changed returns the seq of `kv`, if it's a ChangeTracker, and the keys that
+// may have changed in it since the last sync: those modified since then, or
+// all of them and those synced before, when that isn't known.
+func (s *Store) changed(ctx context.Context, kv txkv.KV, side string) (uint64, []txkv.Key, error) {
+	tracker, ok := kv.(txkv.ChangeTracker)
+	if ok {
+		seq, err := tracker.Seq(ctx)
+		if err != nil {
+			return 0, nil, err
+		}
+		since, synced, err := s.seq(ctx, side)
+		if err != nil {
+			return 0, nil, err
+		}
+		if synced {
+			keys, err := tracker.ListModifiedSince(ctx, s.opts.Prefix, since)
+			if err == nil {
+				return seq, keys, nil
+			}
+			if !errors.Is(err, txkv.ErrCompacted) {
+				return 0, nil, err
+			}
+		}
+		keys, err := s.allKeys(ctx, kv)
+		return seq, keys, err
+	}
+	keys, err := s.allKeys(ctx, kv)
+	return 0, keys, err
+}
+
+// allKeys returns the keys of `kv` and those synced before.
+func (s *Store) allKeys(ctx context.Context, kv txkv.KV) ([]txkv.Key, error) {
+	keys, err := kv.List(ctx, s.opts.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	basePrefix := s.baseKey(s.opts.Prefix)
+	bases, err := s.local.List(ctx, basePrefix)
+	if err != nil {
+		return nil, err
+	}
+	prefixLen := len(s.stateKey("base/"))
+	for _, base := range bases {
+		keys = append(keys, base[prefixLen:])
+	}
+	return keys, nil
+}
+
+func (s *Store) seq(ctx context.Context, side string) (uint64, bool, error) {
+	b, ok, err := s.local.Get(ctx, s.stateKey("seq/"+side))
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	seq, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, false, errors.New("offline: corrupted sync state")
+	}
+	return seq, true, nil
+}
+
+func (s *Store) stateKey(name string) txkv.Key {
+	return append(append(txkv.Key(nil), s.opts.StatePrefix...), name...)
+}
+
+// baseKey is where the hash of the value `key` had when last synced is kept.
+func (s *Store) baseKey(key txkv.Key) txkv.Key {
+	return append(s.stateKey("base/"), key...)
+}
+
+func hashValue(v txkv.Value) txkv.Value {
+	h := sha256.Sum256(v)
+	return h[:]
+}
+
+// sameState tells whether a value is the one last synced, whose hash is
+// `base`.
+func sameState(v txkv.Value, ok bool, base txkv.Value, baseOK bool) bool {
+	if !ok || !baseOK {
+		return ok == baseOK
+	}
+	return bytes.Equal(hashValue(v), base)
+}
+
+func writeOp(key txkv.Key, value txkv.Value, deleted bool) txkv.Op {
+	if deleted {
+		return txkv.DeleteOp(key)
+	}
+	return txkv.PutOp(key, value)
+}
@@ -0,0 +1,133 @@
+package offline_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aybabtme/txkv"
+	"github.com/aybabtme/txkv/offline"
+)
+
+func value(t *testing.T, kv txkv.KV, key string) string {
+	t.Helper()
+	v, ok, err := kv.Get(context.Background(), txkv.Key(key))
+	require.NoError(t, err)
+	if !ok {
+		return "<deleted>"
+	}
+	return string(v)
+}
+
+func TestSync(t *testing.T) {
+	ctx := context.Background()
+	remote := txkv.InMem()
+	require.NoError(t, remote.Put(ctx, txkv.Key("app/a"), txkv.Value("remote")))
+	require.NoError(t, remote.Put(ctx, txkv.Key("other"), txkv.Value("not synced")))
+
+	var conflicts []offline.Conflict
+	store := offline.New(txkv.InMem(), remote, offline.Options{
+		Prefix: txkv.Key("app/"),
+		Resolve: func(ctx context.Context, c offline.Conflict) (txkv.Value, bool, error) {
+			conflicts = append(conflicts, c)
+			return txkv.Value(fmt.Sprintf("%s+%s", c.Local, c.Remote)), false, nil
+		},
+	})
+
+	// the first sync pulls everything
+	stats, err := store.Sync(ctx)
+	require.NoError(t, err)
+	require.Equal(t, offline.SyncStats{Pulled: 1}, stats)
+	require.Equal(t, "remote", value(t, store, "app/a"))
+	require.Equal(t, "<deleted>", value(t, store, "other"))
+
+	// offline changes are pushed, remote ones pulled
+	require.NoError(t, store.Put(ctx, txkv.Key("app/b"), txkv.Value("local")))
+	require.NoError(t, remote.Put(ctx, txkv.Key("app/c"), txkv.Value("remote")))
+	stats, err = store.Sync(ctx)
+	require.NoError(t, err)
+	require.Equal(t, offline.SyncStats{Pushed: 1, Pulled: 1}, stats)
+	require.Equal(t, "local", value(t, remote, "app/b"))
+	require.Equal(t, "remote", value(t, store, "app/c"))
+
+	// nothing changed, nothing to do
+	stats, err = store.Sync(ctx)
+	require.NoError(t, err)
+	require.Equal(t, offline.SyncStats{}, stats)
+
+	// deletions too
+	require.NoError(t, store.Delete(ctx, txkv.Key("app/b")))
+	require.NoError(t, remote.Delete(ctx, txkv.Key("app/c")))
+	stats, err = store.Sync(ctx)
+	require.NoError(t, err)
+	require.Equal(t, offline.SyncStats{Pushed: 1, Pulled: 1}, stats)
+	require.Equal(t, "<deleted>", value(t, remote, "app/b"))
+	require.Equal(t, "<deleted>", value(t, store, "app/c"))
+
+	// changes on both sides are resolved
+	require.NoError(t, store.Put(ctx, txkv.Key("app/a"), txkv.Value("L")))
+	require.NoError(t, remote.Put(ctx, txkv.Key("app/a"), txkv.Value("R")))
+	require.NoError(t, store.Put(ctx, txkv.Key("app/d"), txkv.Value("same")))
+	require.NoError(t, remote.Put(ctx, txkv.Key("app/d"), txkv.Value("same")))
+	stats, err = store.Sync(ctx)
+	require.NoError(t, err)
+	require.Equal(t, offline.SyncStats{Conflicts: 1}, stats)
+	require.Equal(t, []offline.Conflict{{Key: txkv.Key("app/a"), Local: txkv.Value("L"), Remote: txkv.Value("R")}}, conflicts)
+	require.Equal(t, "L+R", value(t, store, "app/a"))
+	require.Equal(t, "L+R", value(t, remote, "app/a"))
+
+	stats, err = store.Sync(ctx)
+	require.NoError(t, err)
+	require.Equal(t, offline.SyncStats{}, stats)
+}
+
+// offlineKV fails while it's offline.
+type offlineKV struct {
+	txkv.TransactionalKV
+	offline bool
+}
+
+func (o *offlineKV) Get(ctx context.Context, key txkv.Key) (txkv.Value, bool, error) {
+	if o.offline {
+		return nil, false, txkv.ErrTransient
+	}
+	return o.TransactionalKV.Get(ctx, key)
+}
+
+func TestSyncOffline(t *testing.T) {
+	ctx := context.Background()
+	remote := &offlineKV{TransactionalKV: txkv.InMem(), offline: true}
+	store := offline.New(txkv.InMem(), remote, offline.Options{Resolve: offline.LocalWins})
+
+	// remote isn't a ChangeTracker here: everything is compared every time
+	require.NoError(t, store.Put(ctx, txkv.Key("a"), txkv.Value("1")))
+	_, err := store.Sync(ctx)
+	require.ErrorIs(t, err, txkv.ErrTransient)
+	require.Equal(t, "1", value(t, store, "a"))
+
+	remote.offline = false
+	stats, err := store.Sync(ctx)
+	require.NoError(t, err)
+	require.Equal(t, offline.SyncStats{Pushed: 1}, stats)
+	require.Equal(t, "1", value(t, remote, "a"))
+
+	require.NoError(t, remote.Delete(ctx, txkv.Key("a")))
+	stats, err = store.Sync(ctx)
+	require.NoError(t, err)
+	require.Equal(t, offline.SyncStats{Pulled: 1}, stats)
+	require.Equal(t, "<deleted>", value(t, store, "a"))
+}
+
+func TestRunDefaultInterval(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	remote := txkv.InMem()
+	store := offline.New(txkv.InMem(), remote, offline.Options{})
+	require.NoError(t, store.Put(ctx, txkv.Key("a"), txkv.Value("1")))
+
+	require.ErrorIs(t, store.Run(ctx, 0), context.DeadlineExceeded)
+	require.Equal(t, "1", value(t, remote, "a"))
+}
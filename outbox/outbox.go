@@ -0,0 +1,158 @@
+// Package outbox implements the transactional outbox pattern on a txkv store.
+//
+// Messages are appended to an outbox prefix within the same transaction as
+// the data they're about, so they're recorded if and only if the data is. A
+// relay then drains the outbox to a publisher, deleting each message once
+// published. Delivery is at-least-once: a relay stopped between publishing
+// a message and deleting it publishes it again, so consumers should dedupe
+// on the message ID.
+package outbox
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aybabtme/txkv"
+)
+
+// DefaultPrefix is the default location of the outbox.
+var DefaultPrefix = txkv.Key("__outbox/")
+
+// DefaultRelayInterval is how often Relay drains the outbox when given an
+// interval of 0.
+const DefaultRelayInterval = time.Second
+
+// Message is an entry of the outbox.
+type Message struct {
+	// ID identifies the message, for consumers to dedupe deliveries. Append
+	// assigns one if it's empty. IDs assigned by Append sort by time of
+	// appending.
+	ID      string `json:"id"`
+	Topic   string `json:"topic,omitempty"`
+	Payload []byte `json:"payload,omitempty"`
+}
+
+// PublishFunc delivers a message. The message is only removed from the
+// outbox once it returns nil.
+type PublishFunc func(ctx context.Context, msg Message) error
+
+// Outbox is a prefix of a store holding messages to publish.
+type Outbox struct {
+	prefix txkv.Key
+}
+
+// New returns the outbox stored under `prefix`, or DefaultPrefix if it's nil.
+func New(prefix txkv.Key) *Outbox {
+	if prefix == nil {
+		prefix = DefaultPrefix
+	}
+	return &Outbox{prefix: prefix}
+}
+
+// Append adds `msg` to the outbox as part of `tx`, returning its ID.
+func (o *Outbox) Append(ctx context.Context, tx txkv.TxKV, msg Message) (string, error) {
+	if msg.ID == "" {
+		id, err := newID()
+		if err != nil {
+			return "", err
+		}
+		msg.ID = id
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+	return msg.ID, tx.Put(ctx, o.key(msg.ID), data)
+}
+
+// Drain publishes the messages in the outbox, in order of ID, deleting each
+// once published. It returns how many it published, and stops at the first
+// message that fails to publish, leaving it for the next drain, with a
+// PublishError.
+func (o *Outbox) Drain(ctx context.Context, kv txkv.KV, publish PublishFunc) (int, error) {
+	keys, err := kv.List(ctx, o.prefix)
+	if err != nil {
+		return 0, err
+	}
+	published := 0
+	for _, key := range keys {
+		data, ok, err := kv.Get(ctx, key)
+		if err != nil {
+			return published, err
+		}
+		if !ok {
+			continue // drained by another relay
+		}
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return published, fmt.Errorf("outbox: can't decode message %q: %w", key, err)
+		}
+		if err := publish(ctx, msg); err != nil {
+			return published, &PublishError{ID: msg.ID, Err: err}
+		}
+		if err := kv.Delete(ctx, key); err != nil {
+			return published, err
+		}
+		published++
+	}
+	return published, nil
+}
+
+// Relay drains the outbox every `interval` until `ctx` is done. Publishing
+// errors are handed to `onError`, if set, and the relay carries on; other
+// errors stop it. An `interval` of 0 means DefaultRelayInterval.
+func (o *Outbox) Relay(ctx context.Context, kv txkv.KV, publish PublishFunc, interval time.Duration, onError func(error)) error {
+	if interval <= 0 {
+		interval = DefaultRelayInterval
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if _, err := o.Drain(ctx, kv, publish); err != nil {
+			var pe *PublishError
+			if !errors.As(err, &pe) {
+				return err
+			}
+			if onError != nil {
+				onError(err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// PublishError is returned when a message fails to publish.
+type PublishError struct {
+	ID  string
+	Err error
+}
+
+func (e *PublishError) Error() string {
+	return fmt.Sprintf("outbox: can't publish message %q: %v", e.ID, e.Err)
+}
+
+func (e *PublishError) Unwrap() error { return e.Err }
+
+func (o *Outbox) key(id string) txkv.Key {
+	return append(append(txkv.Key(nil), o.prefix...), id...)
+}
+
+// newID returns a time-ordered ID, made unique by random bits.
+func newID() (string, error) {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(time.Now().UnixNano()))
+	if _, err := rand.Read(b[8:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
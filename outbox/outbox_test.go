@@ -0,0 +1,117 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aybabtme/txkv"
+	"github.com/aybabtme/txkv/outbox"
+)
+
+func TestOutbox(t *testing.T) {
+	ctx := context.Background()
+	kv := txkv.InMem()
+	ob := outbox.New(nil)
+
+	// messages of rolled back transactions are never seen
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	_, err = ob.Append(ctx, tx, outbox.Message{Topic: "orders", Payload: []byte("lost")})
+	require.NoError(t, err)
+	require.NoError(t, tx.Rollback(ctx))
+
+	tx, err = kv.Begin(ctx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Put(ctx, txkv.Key("order/1"), txkv.Value("paid")))
+	id1, err := ob.Append(ctx, tx, outbox.Message{Topic: "orders", Payload: []byte("1 paid")})
+	require.NoError(t, err)
+	id2, err := ob.Append(ctx, tx, outbox.Message{ID: "zz-custom", Topic: "orders", Payload: []byte("2 paid")})
+	require.NoError(t, err)
+	require.Equal(t, "zz-custom", id2)
+	require.NoError(t, tx.Commit(ctx))
+
+	// a failing publisher leaves messages in place
+	boom := errors.New("boom")
+	n, err := ob.Drain(ctx, kv, func(ctx context.Context, msg outbox.Message) error { return boom })
+	require.ErrorIs(t, err, boom)
+	var pe *outbox.PublishError
+	require.ErrorAs(t, err, &pe)
+	require.Equal(t, id1, pe.ID)
+	require.Equal(t, 0, n)
+
+	var got []outbox.Message
+	n, err = ob.Drain(ctx, kv, func(ctx context.Context, msg outbox.Message) error {
+		got = append(got, msg)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.Equal(t, []outbox.Message{
+		{ID: id1, Topic: "orders", Payload: []byte("1 paid")},
+		{ID: id2, Topic: "orders", Payload: []byte("2 paid")},
+	}, got)
+
+	keys, err := kv.List(ctx, outbox.DefaultPrefix)
+	require.NoError(t, err)
+	require.Empty(t, keys)
+}
+
+func TestRelay(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	kv := txkv.InMem()
+	ob := outbox.New(txkv.Key("out/"))
+
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	_, err = ob.Append(ctx, tx, outbox.Message{Payload: []byte("hello")})
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit(ctx))
+
+	failures := 2
+	var errs []error
+	published := make(chan outbox.Message, 1)
+	go func() {
+		_ = ob.Relay(ctx, kv, func(ctx context.Context, msg outbox.Message) error {
+			if failures > 0 {
+				failures--
+				return errors.New("unavailable")
+			}
+			published <- msg
+			return nil
+		}, time.Millisecond, func(err error) { errs = append(errs, err) })
+	}()
+
+	select {
+	case msg := <-published:
+		require.Equal(t, []byte("hello"), msg.Payload)
+	case <-ctx.Done():
+		t.Fatal("message never published")
+	}
+	cancel()
+	require.Len(t, errs, 2)
+}
+
+func TestRelayDefaultInterval(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	kv := txkv.InMem()
+	ob := outbox.New(nil)
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	_, err = ob.Append(ctx, tx, outbox.Message{Payload: []byte("hello")})
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit(ctx))
+
+	var published int
+	err = ob.Relay(ctx, kv, func(ctx context.Context, msg outbox.Message) error {
+		published++
+		return nil
+	}, 0, nil)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, 1, published)
+}
@@ -0,0 +1,150 @@
+package txkv
+
+import (
+	"context"
+	"fmt"
+)
+
+// PatchKind is a kind of in-place change of a value.
+type PatchKind int
+
+// The kinds of patches.
+const (
+	// PatchAppend appends Data to the value.
+	PatchAppend PatchKind = iota + 1
+	// PatchSetRange overwrites the value with Data from Offset.
+	PatchSetRange
+	// PatchSetBit sets the bit Offset of the value to Bit. Bits are numbered
+	// from the most significant bit of the first byte.
+	PatchSetBit
+)
+
+func (k PatchKind) String() string {
+	switch k {
+	case PatchAppend:
+		return "append"
+	case PatchSetRange:
+		return "set-range"
+	case PatchSetBit:
+		return "set-bit"
+	}
+	return fmt.Sprintf("PatchKind(%d)", int(k))
+}
+
+// PatchOp is an in-place change of a value. Values are padded with zeros as
+// needed, and missing ones are patched as empty ones.
+type PatchOp struct {
+	Kind   PatchKind
+	Offset int64
+	Data   []byte
+	Bit    bool
+}
+
+// AppendOp returns a PatchOp appending `data` to a value.
+func AppendOp(data []byte) PatchOp { return PatchOp{Kind: PatchAppend, Data: data} }
+
+// SetRangeOp returns a PatchOp overwriting a value with `data` from `offset`.
+func SetRangeOp(offset int64, data []byte) PatchOp {
+	return PatchOp{Kind: PatchSetRange, Offset: offset, Data: data}
+}
+
+// SetBitOp returns a PatchOp setting the bit `bit` of a value to `on`.
+func SetBitOp(bit int64, on bool) PatchOp { return PatchOp{Kind: PatchSetBit, Offset: bit, Bit: on} }
+
+// patchValue returns a new value, `v` patched with `ops`.
+func patchValue(v Value, ops []PatchOp) (Value, error) {
+	size := int64(len(v))
+	for _, op := range ops {
+		if op.Offset < 0 {
+			return nil, fmt.Errorf("txkv: negative offset in %s patch", op.Kind)
+		}
+		switch op.Kind {
+		case PatchAppend:
+			size += int64(len(op.Data))
+		case PatchSetRange:
+			if end := op.Offset + int64(len(op.Data)); end > size {
+				size = end
+			}
+		case PatchSetBit:
+			if end := op.Offset/8 + 1; end > size {
+				size = end
+			}
+		default:
+			return nil, fmt.Errorf("txkv: unknown patch %s", op.Kind)
+		}
+	}
+	out := make(Value, len(v), size)
+	copy(out, v)
+	for _, op := range ops {
+		switch op.Kind {
+		case PatchAppend:
+			out = append(out, op.Data...)
+		case PatchSetRange:
+			out = grow(out, op.Offset+int64(len(op.Data)))
+			copy(out[op.Offset:], op.Data)
+		case PatchSetBit:
+			out = grow(out, op.Offset/8+1)
+			mask := byte(0x80) >> (op.Offset % 8)
+			if op.Bit {
+				out[op.Offset/8] |= mask
+			} else {
+				out[op.Offset/8] &^= mask
+			}
+		}
+	}
+	return out, nil
+}
+
+// grow extends `v` with zeros up to `n` bytes, within its capacity.
+func grow(v Value, n int64) Value {
+	for int64(len(v)) < n {
+		v = append(v, 0)
+	}
+	return v
+}
+
+// Patcher is implemented by stores that can patch values in place, sparing
+// large values a round trip through the caller. The in-memory store
+// implements it.
+type Patcher interface {
+	Patch(ctx context.Context, key Key, ops ...PatchOp) (int, error)
+}
+
+// Patch applies `ops` to the value of `key` atomically, in order, and returns
+// the length of the patched value. Stores that are a Patcher apply them
+// themselves. Others have them applied in a transaction reading and writing
+// the value, retried on conflicts as per DefaultRetryPolicy: that's only
+// atomic on stores whose transactions fail with an error matching
+// ErrConflict when a key they read was written since, like InMem and Disk,
+// or are serialized. On others, concurrent patches can overwrite each other.
+func Patch(ctx context.Context, kv TransactionalKV, key Key, ops ...PatchOp) (int, error) {
+	if p, ok := kv.(Patcher); ok {
+		return p.Patch(ctx, key, ops...)
+	}
+	var n int
+	err := RetryTx(ctx, kv, RetryPolicy{}, func(ctx context.Context, tx TxKV) error {
+		v, _, err := tx.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+		patched, err := patchValue(v, ops)
+		if err != nil {
+			return err
+		}
+		n = len(patched)
+		return tx.Put(ctx, key, patched)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// GetBit returns the bit `bit` of the value of `key`, false past its end.
+func GetBit(ctx context.Context, kv KV, key Key, bit int64) (bool, error) {
+	v, _, err := kv.Get(ctx, key)
+	if err != nil || bit < 0 || bit/8 >= int64(len(v)) {
+		return false, err
+	}
+	return v[bit/8]&(byte(0x80)>>(bit%8)) != 0, nil
+}
@@ -0,0 +1,89 @@
+package txkv_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+	"github.com/aybabtme/txkv/txkvmock"
+)
+
+func TestPatch(t *testing.T) {
+	for name, kv := range map[string]TransactionalKV{
+		"inmem":   InMem(),
+		"wrapped": WithMaintenance(InMem()),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			// logs grow by appends, from nothing
+			n, err := Patch(ctx, kv, Key("log"), AppendOp([]byte("a\n")))
+			require.NoError(t, err)
+			require.Equal(t, 2, n)
+			n, err = Patch(ctx, kv, Key("log"), AppendOp([]byte("b\n")), AppendOp([]byte("c\n")))
+			require.NoError(t, err)
+			require.Equal(t, 6, n)
+			mustFind(ctx, t, kv, Key("log"), Value("a\nb\nc\n"))
+
+			// ranges are overwritten, padded with zeros
+			mustPut(ctx, t, kv, Key("blob"), Value("hello world"))
+			_, err = Patch(ctx, kv, Key("blob"), SetRangeOp(6, []byte("WORLD")))
+			require.NoError(t, err)
+			mustFind(ctx, t, kv, Key("blob"), Value("hello WORLD"))
+			n, err = Patch(ctx, kv, Key("blob"), SetRangeOp(13, []byte("!")))
+			require.NoError(t, err)
+			require.Equal(t, 14, n)
+			mustFind(ctx, t, kv, Key("blob"), Value("hello WORLD\x00\x00!"))
+
+			// bitmaps
+			_, err = Patch(ctx, kv, Key("bits"), SetBitOp(0, true), SetBitOp(9, true), SetBitOp(10, true))
+			require.NoError(t, err)
+			mustFind(ctx, t, kv, Key("bits"), Value{0x80, 0x60})
+			_, err = Patch(ctx, kv, Key("bits"), SetBitOp(9, false))
+			require.NoError(t, err)
+			mustFind(ctx, t, kv, Key("bits"), Value{0x80, 0x20})
+			for bit, want := range map[int64]bool{0: true, 1: false, 9: false, 10: true, 100: false} {
+				got, err := GetBit(ctx, kv, Key("bits"), bit)
+				require.NoError(t, err)
+				require.Equal(t, want, got, bit)
+			}
+
+			_, err = Patch(ctx, kv, Key("bits"), SetRangeOp(-1, []byte("x")))
+			require.Error(t, err)
+			mustFind(ctx, t, kv, Key("bits"), Value{0x80, 0x20})
+		})
+	}
+}
+
+func TestPatchRetriesConflicts(t *testing.T) {
+	ctx := context.Background()
+	mock := txkvmock.New(t)
+	// a write lands between the read and the commit of the first attempt
+	mock.ExpectBegin()
+	mock.ExpectGet(Key("log")).Returns(Value("a"))
+	mock.ExpectPut(Key("log"), Value("ax"))
+	mock.ExpectCommit().Fails(ErrConflict)
+	mock.ExpectBegin()
+	mock.ExpectGet(Key("log")).Returns(Value("ab"))
+	mock.ExpectPut(Key("log"), Value("abx"))
+	mock.ExpectCommit()
+
+	n, err := Patch(ctx, mock, Key("log"), AppendOp([]byte("x")))
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+}
+
+func TestPatchCopies(t *testing.T) {
+	ctx := context.Background()
+	kv := InMem()
+	mustPut(ctx, t, kv, Key("blob"), Value("abc"))
+	before, _, err := kv.Get(ctx, Key("blob"))
+	require.NoError(t, err)
+	_, err = Patch(ctx, kv, Key("blob"), SetRangeOp(0, []byte("x")))
+	require.NoError(t, err)
+	// values handed out before aren't changed under their holders
+	require.Equal(t, Value("abc"), before)
+	require.Equal(t, "set-bit", PatchSetBit.String())
+}
@@ -0,0 +1,108 @@
+package txkv
+
+import (
+	"bytes"
+
+	"github.com/aybabtme/txkv/internal/ds"
+)
+
+// WithPrefixIndex has a store made WithComparator index the keys of the `n`
+// prefixes it lists most often, so that listing them costs in the number of
+// keys listed instead of the size of the store. Stores in a custom order go
+// through all their keys to list a prefix otherwise; bytewise stores seek to
+// their prefixes already, so it doesn't apply to them.
+//
+// A prefix is indexed once it's been listed a few times, in place of the
+// least listed indexed prefix if there are already `n`. Indexes are kept up
+// to date by every write, which costs in the number of indexed prefixes.
+func WithPrefixIndex(n int) InMemOption {
+	return func(cfg *inMemConfig) {
+		cfg.prefixIndexes = n
+	}
+}
+
+const (
+	// minIndexHits is how many times a prefix is listed before it's
+	// indexed.
+	minIndexHits = 3
+	// maxIndexHits bounds the prefixes whose hits are counted; past it,
+	// counts decay so that only the prefixes still listed stay.
+	maxIndexHits = 1024
+)
+
+// prefixIndex indexes the keys of the hottest prefixes of a store in a
+// custom order. It's guarded by the lock of the store.
+type prefixIndex struct {
+	n       int
+	hits    map[string]int
+	indexes map[string]*ds.SortedBytesToBytesMap
+}
+
+func newPrefixIndex(n int) *prefixIndex {
+	return &prefixIndex{
+		n:       n,
+		hits:    make(map[string]int),
+		indexes: make(map[string]*ds.SortedBytesToBytesMap),
+	}
+}
+
+// lookupIndex counts a listing of `prefix`, and returns its index if it has one,
+// making it if it's become hot enough.
+func (k *memkv) lookupIndex(prefix Key) *ds.SortedBytesToBytesMap {
+	x := k.index
+	x.hits[string(prefix)]++
+	if len(x.hits) > maxIndexHits {
+		for p, n := range x.hits {
+			if n /= 2; n == 0 && x.indexes[p] == nil {
+				delete(x.hits, p)
+			} else {
+				x.hits[p] = n
+			}
+		}
+	}
+	if idx, ok := x.indexes[string(prefix)]; ok {
+		return idx
+	}
+	hits := x.hits[string(prefix)]
+	if hits < minIndexHits {
+		return nil
+	}
+	if len(x.indexes) >= x.n {
+		coldest, coldestHits := "", hits
+		for p := range x.indexes {
+			if x.hits[p] < coldestHits {
+				coldest, coldestHits = p, x.hits[p]
+			}
+		}
+		if coldestHits == hits {
+			return nil
+		}
+		delete(x.indexes, coldest)
+	}
+	idx := k.newMap()
+	k.smap.Keys(func(key, _ []byte) bool {
+		if bytes.HasPrefix(key, prefix) {
+			idx.Put(key, nil)
+		}
+		return true
+	})
+	x.indexes[string(prefix)] = idx
+	return idx
+}
+
+// indexWrite keeps the indexes up to date with a write of `key`.
+func (k *memkv) indexWrite(key Key, deleted bool) {
+	if k.index == nil {
+		return
+	}
+	for p, idx := range k.index.indexes {
+		if !bytes.HasPrefix(key, Key(p)) {
+			continue
+		}
+		if deleted {
+			idx.Delete(key)
+		} else {
+			idx.Put(key, nil)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package txkv_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestPrefixIndex(t *testing.T) {
+	testKV(t, func(t testing.TB) TransactionalKV {
+		return InMem(WithComparator(caseInsensitive), WithPrefixIndex(4))
+	})
+
+	ctx := context.Background()
+	kv := InMem(WithComparator(caseInsensitive), WithPrefixIndex(1))
+	mustPut(ctx, t, kv, Key("a/1"), Value("v"))
+	mustPut(ctx, t, kv, Key("B/1"), Value("v"))
+	mustPut(ctx, t, kv, Key("b/2"), Value("v"))
+	mustPut(ctx, t, kv, Key("c/1"), Value("v"))
+
+	// listed enough to be indexed
+	for i := 0; i < 5; i++ {
+		mustList(ctx, t, kv, Key("b/"), []Key{Key("b/2")})
+	}
+	// writes keep the index up to date, however they're made
+	mustPut(ctx, t, kv, Key("b/1"), Value("v"))
+	mustDelete(ctx, t, kv, Key("b/2"))
+	require.NoError(t, Apply(ctx, kv, []Op{PutOp(Key("b/3"), Value("v")), PutOp(Key("a/2"), Value("v"))}))
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Put(ctx, Key("b/0"), Value("v")))
+	mustList(ctx, t, tx, Key("b/"), []Key{Key("b/0"), Key("b/1"), Key("b/3")})
+	require.NoError(t, tx.Commit(ctx))
+	mustList(ctx, t, kv, Key("b/"), []Key{Key("b/0"), Key("b/1"), Key("b/3")})
+	size, err := SizeOf(ctx, kv, Key("b/"))
+	require.NoError(t, err)
+	require.Equal(t, 3, size.Keys)
+
+	// a hotter prefix takes the place of a colder one
+	for i := 0; i < 20; i++ {
+		mustList(ctx, t, kv, Key("a/"), []Key{Key("a/1"), Key("a/2")})
+	}
+	_, err = DeletePrefix(ctx, kv, Key("a/"))
+	require.NoError(t, err)
+	mustList(ctx, t, kv, Key("a/"), nil)
+	mustList(ctx, t, kv, Key("b/"), []Key{Key("b/0"), Key("b/1"), Key("b/3")})
+}
+
+func BenchmarkListPrefix(b *testing.B) {
+	for _, index := range []int{0, 1} {
+		b.Run(fmt.Sprintf("indexes=%d", index), func(b *testing.B) {
+			ctx := context.Background()
+			kv := InMem(WithComparator(caseInsensitive), WithPrefixIndex(index))
+			for i := 0; i < 10000; i++ {
+				_ = kv.Put(ctx, Key(fmt.Sprintf("%d/%d", i%100, i)), Value("v"))
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := kv.List(ctx, Key("42/")); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
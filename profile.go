@@ -0,0 +1,222 @@
+package txkv
+
+import (
+	"context"
+	"runtime/metrics"
+	"runtime/pprof"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The operations ProfiledKV accounts for.
+const (
+	OpGet      = "get"
+	OpPut      = "put"
+	OpDelete   = "delete"
+	OpList     = "list"
+	OpBegin    = "begin"
+	OpCommit   = "commit"
+	OpRollback = "rollback"
+)
+
+// ProfilingOptions tune a ProfiledKV.
+type ProfilingOptions struct {
+	// MeasureAllocs has the bytes and objects allocated during operations
+	// accounted. The runtime only counts allocations process-wide, so those
+	// made by other goroutines meanwhile are counted too: the figures are
+	// only accurate when the store is the main thing running, like in a
+	// benchmark.
+	MeasureAllocs bool
+}
+
+// OpStats are the figures of an operation.
+type OpStats struct {
+	Op       string
+	Count    uint64
+	Errors   uint64
+	Duration time.Duration // total
+	// AllocBytes and AllocObjects are only measured with MeasureAllocs.
+	AllocBytes   uint64
+	AllocObjects uint64
+}
+
+// BytesPerOp is the average number of bytes allocated per operation.
+func (s OpStats) BytesPerOp() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return float64(s.AllocBytes) / float64(s.Count)
+}
+
+// AllocsPerOp is the average number of objects allocated per operation.
+func (s OpStats) AllocsPerOp() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return float64(s.AllocObjects) / float64(s.Count)
+}
+
+// WithProfiling returns a ProfiledKV over `kv`.
+func WithProfiling(kv TransactionalKV, opts ProfilingOptions) *ProfiledKV {
+	return &ProfiledKV{kv: kv, opts: opts, stats: make(map[string]*OpStats)}
+}
+
+// ProfiledKV is a TransactionalKV running its operations under pprof labels,
+// so CPU and heap profiles attribute them to txkv: "txkv.op" is the
+// operation, like "get", and "txkv.tx" is "true" within transactions. It also
+// keeps figures per operation, for Stats.
+type ProfiledKV struct {
+	kv   TransactionalKV
+	opts ProfilingOptions
+
+	mu    sync.Mutex
+	stats map[string]*OpStats
+}
+
+// Stats returns the figures of the operations run so far, by name.
+func (p *ProfiledKV) Stats() []OpStats {
+	p.mu.Lock()
+	stats := make([]OpStats, 0, len(p.stats))
+	for _, s := range p.stats {
+		stats = append(stats, *s)
+	}
+	p.mu.Unlock()
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Op < stats[j].Op })
+	return stats
+}
+
+var allocSamples = []metrics.Sample{
+	{Name: "/gc/heap/allocs:bytes"},
+	{Name: "/gc/heap/allocs:objects"},
+}
+
+func readAllocs() (bytes, objects uint64) {
+	samples := make([]metrics.Sample, len(allocSamples))
+	copy(samples, allocSamples)
+	metrics.Read(samples)
+	return samples[0].Value.Uint64(), samples[1].Value.Uint64()
+}
+
+// do runs `fn` as operation `op`, labeled and accounted for.
+func (p *ProfiledKV) do(ctx context.Context, op string, inTx bool, fn func(ctx context.Context) error) error {
+	tx := "false"
+	if inTx {
+		tx = "true"
+	}
+	var bytes0, objects0 uint64
+	if p.opts.MeasureAllocs {
+		bytes0, objects0 = readAllocs()
+	}
+	start := time.Now()
+	var err error
+	pprof.Do(ctx, pprof.Labels("txkv.op", op, "txkv.tx", tx), func(ctx context.Context) {
+		err = fn(ctx)
+	})
+	elapsed := time.Since(start)
+	var bytes1, objects1 uint64
+	if p.opts.MeasureAllocs {
+		bytes1, objects1 = readAllocs()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, ok := p.stats[op]
+	if !ok {
+		s = &OpStats{Op: op}
+		p.stats[op] = s
+	}
+	s.Count++
+	if err != nil {
+		s.Errors++
+	}
+	s.Duration += elapsed
+	s.AllocBytes += bytes1 - bytes0
+	s.AllocObjects += objects1 - objects0
+	return err
+}
+
+func (p *ProfiledKV) Put(ctx context.Context, key Key, value Value) error {
+	return profiledPut(ctx, p, false, p.kv, key, value)
+}
+
+func (p *ProfiledKV) Get(ctx context.Context, key Key) (Value, bool, error) {
+	return profiledGet(ctx, p, false, p.kv, key)
+}
+
+func (p *ProfiledKV) Delete(ctx context.Context, key Key) error {
+	return profiledDelete(ctx, p, false, p.kv, key)
+}
+
+func (p *ProfiledKV) List(ctx context.Context, prefix Key) ([]Key, error) {
+	return profiledList(ctx, p, false, p.kv, prefix)
+}
+
+func (p *ProfiledKV) Begin(ctx context.Context) (TxKV, error) {
+	var tx TxKV
+	err := p.do(ctx, OpBegin, false, func(ctx context.Context) (err error) {
+		tx, err = p.kv.Begin(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &profiledTx{p: p, tx: tx}, nil
+}
+
+type profiledTx struct {
+	p  *ProfiledKV
+	tx TxKV
+}
+
+func (t *profiledTx) Put(ctx context.Context, key Key, value Value) error {
+	return profiledPut(ctx, t.p, true, t.tx, key, value)
+}
+
+func (t *profiledTx) Get(ctx context.Context, key Key) (Value, bool, error) {
+	return profiledGet(ctx, t.p, true, t.tx, key)
+}
+
+func (t *profiledTx) Delete(ctx context.Context, key Key) error {
+	return profiledDelete(ctx, t.p, true, t.tx, key)
+}
+
+func (t *profiledTx) List(ctx context.Context, prefix Key) ([]Key, error) {
+	return profiledList(ctx, t.p, true, t.tx, prefix)
+}
+
+func (t *profiledTx) Commit(ctx context.Context) error {
+	return t.p.do(ctx, OpCommit, true, t.tx.Commit)
+}
+
+func (t *profiledTx) Rollback(ctx context.Context) error {
+	return t.p.do(ctx, OpRollback, true, t.tx.Rollback)
+}
+
+func profiledPut(ctx context.Context, p *ProfiledKV, inTx bool, kv KV, key Key, value Value) error {
+	return p.do(ctx, OpPut, inTx, func(ctx context.Context) error {
+		return kv.Put(ctx, key, value)
+	})
+}
+
+func profiledGet(ctx context.Context, p *ProfiledKV, inTx bool, kv KV, key Key) (v Value, ok bool, err error) {
+	err = p.do(ctx, OpGet, inTx, func(ctx context.Context) (err error) {
+		v, ok, err = kv.Get(ctx, key)
+		return err
+	})
+	return v, ok, err
+}
+
+func profiledDelete(ctx context.Context, p *ProfiledKV, inTx bool, kv KV, key Key) error {
+	return p.do(ctx, OpDelete, inTx, func(ctx context.Context) error {
+		return kv.Delete(ctx, key)
+	})
+}
+
+func profiledList(ctx context.Context, p *ProfiledKV, inTx bool, kv KV, prefix Key) (keys []Key, err error) {
+	err = p.do(ctx, OpList, inTx, func(ctx context.Context) (err error) {
+		keys, err = kv.List(ctx, prefix)
+		return err
+	})
+	return keys, err
+}
@@ -0,0 +1,55 @@
+package txkv_test
+
+import (
+	"context"
+	"runtime/pprof"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+// labelingKV records the pprof labels its operations run under.
+type labelingKV struct {
+	TransactionalKV
+	labels []string
+}
+
+func (l *labelingKV) Get(ctx context.Context, key Key) (Value, bool, error) {
+	op, _ := pprof.Label(ctx, "txkv.op")
+	tx, _ := pprof.Label(ctx, "txkv.tx")
+	l.labels = append(l.labels, op+"/"+tx)
+	return l.TransactionalKV.Get(ctx, key)
+}
+
+func TestProfiling(t *testing.T) {
+	ctx := context.Background()
+	inner := &labelingKV{TransactionalKV: InMem(WithCopy())}
+	kv := WithProfiling(inner, ProfilingOptions{MeasureAllocs: true})
+
+	mustPut(ctx, t, kv, Key("a"), make(Value, 1<<20))
+	mustPut(ctx, t, kv, Key("b"), Value("v"))
+	_, _, err := kv.Get(ctx, Key("a"))
+	require.NoError(t, err)
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	_, _, err = tx.Get(ctx, Key("a"))
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit(ctx))
+
+	require.Equal(t, []string{"get/false"}, inner.labels)
+
+	stats := kv.Stats()
+	ops := make(map[string]OpStats)
+	for _, s := range stats {
+		ops[s.Op] = s
+	}
+	require.Equal(t, []string{OpBegin, OpCommit, OpGet, OpPut}, []string{stats[0].Op, stats[1].Op, stats[2].Op, stats[3].Op})
+	require.EqualValues(t, 2, ops[OpPut].Count)
+	require.EqualValues(t, 2, ops[OpGet].Count)
+	require.EqualValues(t, 1, ops[OpCommit].Count)
+	require.Positive(t, ops[OpGet].Duration)
+	require.Positive(t, ops[OpPut].BytesPerOp())
+	require.Zero(t, OpStats{}.AllocsPerOp())
+}
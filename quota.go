@@ -0,0 +1,308 @@
+package txkv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Quota caps the keys stored under a prefix. A zero limit means no limit.
+// Sizes count both keys and values.
+type Quota struct {
+	Prefix   Key
+	MaxKeys  int
+	MaxBytes int64
+}
+
+// QuotaUsage reports how much of a Quota is used.
+type QuotaUsage struct {
+	Quota
+	Keys  int
+	Bytes int64
+}
+
+// ErrQuotaExceeded is matched by the errors returned when a write would
+// exceed a quota.
+var ErrQuotaExceeded = errors.New("txkv: quota exceeded")
+
+// QuotaExceededError is returned when writing Key would bring the usage of
+// Quota to Keys and Bytes, beyond its limits.
+type QuotaExceededError struct {
+	Quota Quota
+	Key   Key
+	Keys  int
+	Bytes int64
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("txkv: writing key %q would exceed the quota of prefix %q: %d/%d keys, %d/%d bytes",
+		e.Key, e.Quota.Prefix, e.Keys, e.Quota.MaxKeys, e.Bytes, e.Quota.MaxBytes)
+}
+
+func (e *QuotaExceededError) Is(target error) bool { return target == ErrQuotaExceeded }
+
+// QuotaKV enforces quotas on the keys written through it. A key falls under
+// every quota whose prefix it has.
+//
+// Usage is accounted in memory, and updated when writes are committed: a
+// transaction that would exceed a quota fails to commit, and leaves no trace.
+// Writes that reduce usage are always allowed, even when a quota is already
+// exceeded. Usage is only accurate as long as all writes go through the same
+// QuotaKV.
+//
+// Writes to different keys are performed concurrently: the usage they add is
+// reserved while they're in flight, so that they can't both squeeze in, and
+// what they free is only accounted for once they're done. Writes to the same
+// keys wait for one another, so that they're accounted for in order.
+type QuotaKV struct {
+	kv TransactionalKV
+
+	// held while writes are checked and accounted for, not while they're
+	// performed
+	mu       sync.Mutex
+	usage    []QuotaUsage
+	inFlight map[string]chan struct{} // closed once the write is done
+}
+
+// WithQuotas returns a QuotaKV enforcing `quotas` over `kv`. The current usage
+// of each quota is computed by scanning its prefix.
+func WithQuotas(ctx context.Context, kv TransactionalKV, quotas ...Quota) (*QuotaKV, error) {
+	q := &QuotaKV{kv: kv, inFlight: make(map[string]chan struct{})}
+	for _, quota := range quotas {
+		usage := QuotaUsage{Quota: quota}
+		keys, err := kv.List(ctx, quota.Prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			v, ok, err := kv.Get(ctx, key)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				usage.Keys++
+				usage.Bytes += int64(len(key) + len(v))
+			}
+		}
+		q.usage = append(q.usage, usage)
+	}
+	return q, nil
+}
+
+// Usage reports the current usage of every quota.
+func (q *QuotaKV) Usage() []QuotaUsage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]QuotaUsage(nil), q.usage...)
+}
+
+func (q *QuotaKV) Put(ctx context.Context, key Key, value Value) error {
+	return q.commit(ctx, []quotaWrite{{key: key, value: value}}, func() error {
+		return q.kv.Put(ctx, key, value)
+	})
+}
+
+func (q *QuotaKV) Get(ctx context.Context, key Key) (Value, bool, error) {
+	return q.kv.Get(ctx, key)
+}
+
+func (q *QuotaKV) Delete(ctx context.Context, key Key) error {
+	return q.commit(ctx, []quotaWrite{{key: key, deleted: true}}, func() error {
+		return q.kv.Delete(ctx, key)
+	})
+}
+
+func (q *QuotaKV) List(ctx context.Context, prefix Key) ([]Key, error) {
+	return q.kv.List(ctx, prefix)
+}
+
+func (q *QuotaKV) Begin(ctx context.Context) (TxKV, error) {
+	tx, err := q.kv.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &quotaTx{q: q, tx: tx, writes: make(map[string]quotaWrite)}, nil
+}
+
+type quotaWrite struct {
+	key     Key
+	value   Value
+	deleted bool
+}
+
+// commit checks that `writes` fit within the quotas, then performs them with
+// `do` and accounts for them.
+func (q *QuotaKV) commit(ctx context.Context, writes []quotaWrite, do func() error) error {
+	done := make(chan struct{})
+	if err := q.claim(ctx, writes, done); err != nil {
+		return err
+	}
+	deltas, culprits, err := q.deltas(ctx, writes)
+	if err != nil {
+		q.settle(writes, done, nil, nil)
+		return err
+	}
+
+	q.mu.Lock()
+	// checked on the net change, as a transaction may free space before it
+	// takes some
+	for i, usage := range q.usage {
+		delta := deltas[i]
+		keys, size := usage.Keys+delta.Keys, usage.Bytes+delta.Bytes
+		if (usage.MaxKeys > 0 && delta.Keys > 0 && keys > usage.MaxKeys) ||
+			(usage.MaxBytes > 0 && delta.Bytes > 0 && size > usage.MaxBytes) {
+			q.mu.Unlock()
+			q.settle(writes, done, nil, nil)
+			return &QuotaExceededError{Quota: usage.Quota, Key: culprits[i], Keys: keys, Bytes: size}
+		}
+	}
+	reserved := make([]QuotaUsage, len(deltas))
+	for i, delta := range deltas {
+		if delta.Keys > 0 {
+			reserved[i].Keys = delta.Keys
+		}
+		if delta.Bytes > 0 {
+			reserved[i].Bytes = delta.Bytes
+		}
+		q.usage[i].Keys += reserved[i].Keys
+		q.usage[i].Bytes += reserved[i].Bytes
+	}
+	q.mu.Unlock()
+
+	if err := do(); err != nil {
+		q.settle(writes, done, reserved, nil)
+		return err
+	}
+	q.settle(writes, done, reserved, deltas)
+	return nil
+}
+
+// claim marks the keys of `writes` in flight, once no other write of them is.
+func (q *QuotaKV) claim(ctx context.Context, writes []quotaWrite, done chan struct{}) error {
+	for {
+		q.mu.Lock()
+		var busy chan struct{}
+		for _, w := range writes {
+			if c, ok := q.inFlight[string(w.key)]; ok {
+				busy = c
+				break
+			}
+		}
+		if busy == nil {
+			for _, w := range writes {
+				q.inFlight[string(w.key)] = done
+			}
+			q.mu.Unlock()
+			return nil
+		}
+		q.mu.Unlock()
+		select {
+		case <-busy:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// deltas returns how `writes` change the usage of each quota, and the last
+// key adding to it.
+func (q *QuotaKV) deltas(ctx context.Context, writes []quotaWrite) ([]QuotaUsage, []Key, error) {
+	deltas := make([]QuotaUsage, len(q.usage))
+	culprits := make([]Key, len(q.usage))
+	for _, w := range writes {
+		old, existed, err := q.kv.Get(ctx, w.key)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i, usage := range q.usage {
+			if !bytes.HasPrefix(w.key, usage.Prefix) {
+				continue
+			}
+			if existed {
+				deltas[i].Keys--
+				deltas[i].Bytes -= int64(len(w.key) + len(old))
+			}
+			if !w.deleted {
+				deltas[i].Keys++
+				deltas[i].Bytes += int64(len(w.key) + len(w.value))
+				culprits[i] = w.key
+			}
+		}
+	}
+	return deltas, culprits, nil
+}
+
+// settle replaces the usage `reserved` for `writes` with their `deltas`, nil
+// if they failed, and lets the writes waiting on their keys go.
+func (q *QuotaKV) settle(writes []quotaWrite, done chan struct{}, reserved, deltas []QuotaUsage) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i := range reserved {
+		q.usage[i].Keys -= reserved[i].Keys
+		q.usage[i].Bytes -= reserved[i].Bytes
+	}
+	for i := range deltas {
+		q.usage[i].Keys += deltas[i].Keys
+		q.usage[i].Bytes += deltas[i].Bytes
+	}
+	for _, w := range writes {
+		delete(q.inFlight, string(w.key))
+	}
+	close(done)
+}
+
+type quotaTx struct {
+	q  *QuotaKV
+	tx TxKV
+
+	mu     sync.Mutex
+	writes map[string]quotaWrite
+}
+
+func (t *quotaTx) write(w quotaWrite) {
+	t.mu.Lock()
+	t.writes[string(w.key)] = w
+	t.mu.Unlock()
+}
+
+func (t *quotaTx) Put(ctx context.Context, key Key, value Value) error {
+	if err := t.tx.Put(ctx, key, value); err != nil {
+		return err
+	}
+	t.write(quotaWrite{key: key, value: value})
+	return nil
+}
+
+func (t *quotaTx) Get(ctx context.Context, key Key) (Value, bool, error) {
+	return t.tx.Get(ctx, key)
+}
+
+func (t *quotaTx) Delete(ctx context.Context, key Key) error {
+	if err := t.tx.Delete(ctx, key); err != nil {
+		return err
+	}
+	t.write(quotaWrite{key: key, deleted: true})
+	return nil
+}
+
+func (t *quotaTx) List(ctx context.Context, prefix Key) ([]Key, error) {
+	return t.tx.List(ctx, prefix)
+}
+
+func (t *quotaTx) Commit(ctx context.Context) error {
+	t.mu.Lock()
+	writes := make([]quotaWrite, 0, len(t.writes))
+	for _, w := range t.writes {
+		writes = append(writes, w)
+	}
+	t.mu.Unlock()
+	err := t.q.commit(ctx, writes, func() error { return t.tx.Commit(ctx) })
+	if errors.Is(err, ErrQuotaExceeded) {
+		_ = t.tx.Rollback(ctx)
+	}
+	return err
+}
+
+func (t *quotaTx) Rollback(ctx context.Context) error { return t.tx.Rollback(ctx) }
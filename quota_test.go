@@ -0,0 +1,136 @@
+package txkv_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestQuota(t *testing.T) {
+	testKV(t, func(t testing.TB) TransactionalKV {
+		kv, err := WithQuotas(context.Background(), InMem(), Quota{Prefix: Key("limited/"), MaxKeys: 1})
+		require.NoError(t, err)
+		return kv
+	})
+}
+
+func TestQuotaEnforced(t *testing.T) {
+	ctx := context.Background()
+	raw := InMem()
+	mustPut(ctx, t, raw, Key("team-a/existing"), Value("12345"))
+
+	kv, err := WithQuotas(ctx, raw,
+		Quota{Prefix: Key("team-a/"), MaxKeys: 2},
+		Quota{Prefix: Key("team-b/"), MaxBytes: 20},
+	)
+	require.NoError(t, err)
+	require.Equal(t, []QuotaUsage{
+		{Quota: Quota{Prefix: Key("team-a/"), MaxKeys: 2}, Keys: 1, Bytes: 20},
+		{Quota: Quota{Prefix: Key("team-b/"), MaxBytes: 20}},
+	}, kv.Usage())
+
+	mustPut(ctx, t, kv, Key("team-a/second"), Value("v"))
+	// overwriting doesn't take a new key
+	mustPut(ctx, t, kv, Key("team-a/second"), Value("v2"))
+
+	err = kv.Put(ctx, Key("team-a/third"), Value("v"))
+	var qerr *QuotaExceededError
+	require.True(t, errors.As(err, &qerr), "%v", err)
+	require.ErrorIs(t, err, ErrQuotaExceeded)
+	require.Equal(t, Key("team-a/third"), qerr.Key)
+	require.Equal(t, 3, qerr.Keys)
+	mustNotFind(ctx, t, kv, Key("team-a/third"))
+
+	// bytes count keys and values: (7+1)+(8+4) fits, one more byte doesn't
+	mustPut(ctx, t, kv, Key("team-b/"), Value("1"))
+	mustPut(ctx, t, kv, Key("team-b/x"), Value("1234"))
+	require.ErrorIs(t, kv.Put(ctx, Key("team-b/x"), Value("12345")), ErrQuotaExceeded)
+
+	// freeing space makes room again
+	mustDelete(ctx, t, kv, Key("team-a/existing"))
+	mustPut(ctx, t, kv, Key("team-a/third"), Value("v"))
+
+	// unrelated keys aren't limited
+	mustPut(ctx, t, kv, Key("team-c/big"), make(Value, 100))
+
+	usage := kv.Usage()
+	require.Equal(t, 2, usage[0].Keys)
+	require.Equal(t, 2, usage[1].Keys)
+	require.Equal(t, int64(20), usage[1].Bytes)
+}
+
+func TestQuotaTransactions(t *testing.T) {
+	ctx := context.Background()
+	kv, err := WithQuotas(ctx, InMem(), Quota{Prefix: Key("q/"), MaxKeys: 2})
+	require.NoError(t, err)
+	mustPut(ctx, t, kv, Key("q/a"), Value("v"))
+	mustPut(ctx, t, kv, Key("q/b"), Value("v"))
+
+	// a transaction exceeding the quota doesn't commit at all
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	mustPut(ctx, t, tx, Key("other"), Value("v"))
+	mustPut(ctx, t, tx, Key("q/c"), Value("v"))
+	require.ErrorIs(t, tx.Commit(ctx), ErrQuotaExceeded)
+	mustNotFind(ctx, t, kv, Key("other"))
+	mustNotFind(ctx, t, kv, Key("q/c"))
+
+	// but one that frees as much as it takes does
+	tx, err = kv.Begin(ctx)
+	require.NoError(t, err)
+	mustPut(ctx, t, tx, Key("q/c"), Value("v"))
+	mustDelete(ctx, t, tx, Key("q/a"))
+	require.NoError(t, tx.Commit(ctx))
+	mustList(ctx, t, kv, Key("q/"), []Key{Key("q/b"), Key("q/c")})
+	require.Equal(t, 2, kv.Usage()[0].Keys)
+
+	// rolled back writes aren't accounted
+	tx, err = kv.Begin(ctx)
+	require.NoError(t, err)
+	mustDelete(ctx, t, tx, Key("q/b"))
+	require.NoError(t, tx.Rollback(ctx))
+	require.Equal(t, 2, kv.Usage()[0].Keys)
+}
+
+func TestQuotaConcurrent(t *testing.T) {
+	ctx := context.Background()
+	kv, err := WithQuotas(ctx, InMem(), Quota{Prefix: Key("limited/"), MaxKeys: 10})
+	require.NoError(t, err)
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		written int
+	)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// half of them write the same keys
+			key := Key(fmt.Sprintf("limited/%d", i%25))
+			tx, err := kv.Begin(ctx)
+			require.NoError(t, err)
+			require.NoError(t, tx.Put(ctx, key, Value("v")))
+			if err := tx.Commit(ctx); err == nil {
+				mu.Lock()
+				written++
+				mu.Unlock()
+			} else {
+				require.ErrorIs(t, err, ErrQuotaExceeded)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	keys, err := kv.List(ctx, Key("limited/"))
+	require.NoError(t, err)
+	require.LessOrEqual(t, len(keys), 10)
+	require.GreaterOrEqual(t, written, 10)
+	require.Equal(t, len(keys), kv.Usage()[0].Keys)
+}
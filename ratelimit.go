@@ -0,0 +1,187 @@
+package txkv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrThrottled is matched by the errors returned when an operation is
+// refused for going over a rate limit.
+var ErrThrottled = errors.New("txkv: throttled")
+
+// RateLimit allows Rate operations per second on keys with Prefix, with
+// bursts of up to Burst operations. An empty prefix limits the whole store.
+// Burst defaults to Rate, rounded up, and at least 1.
+type RateLimit struct {
+	Prefix Key
+	Rate   float64
+	Burst  int
+}
+
+// WithRateLimits returns a TransactionalKV admitting operations on `kv` only
+// within `limits`, to protect a shared backend from a misbehaving caller.
+// Operations over a limit fail right away with an error matching
+// ErrThrottled.
+//
+// Get, Put and Delete count against every limit whose prefix their key has,
+// including within transactions. List counts against every limit whose keys
+// it can list: those whose prefix the listed prefix has, and those whose
+// prefix is under it, so that listing everything counts against every limit.
+// Begin, Commit and Rollback aren't limited.
+func WithRateLimits(kv TransactionalKV, limits ...RateLimit) TransactionalKV {
+	r := &rateLimitedKV{kv: kv}
+	now := time.Now()
+	for _, l := range limits {
+		if l.Burst <= 0 {
+			// with no burst, not a single operation would be admitted
+			l.Burst = int(math.Ceil(l.Rate))
+			if l.Burst < 1 {
+				l.Burst = 1
+			}
+		}
+		r.buckets = append(r.buckets, &tokenBucket{limit: l, tokens: float64(l.Burst), last: now})
+	}
+	return r
+}
+
+type rateLimitedKV struct {
+	kv      TransactionalKV
+	buckets []*tokenBucket
+}
+
+// admit takes a token from every bucket covering `key`, or from none. A
+// listed prefix is covered by the buckets of the prefixes it overlaps.
+func (r *rateLimitedKV) admit(key Key, list bool) error {
+	covers := func(b *tokenBucket) bool {
+		return bytes.HasPrefix(key, b.limit.Prefix) || (list && bytes.HasPrefix(b.limit.Prefix, key))
+	}
+	for i, b := range r.buckets {
+		if !covers(b) {
+			continue
+		}
+		if !b.take() {
+			for _, taken := range r.buckets[:i] {
+				if covers(taken) {
+					taken.refund()
+				}
+			}
+			return fmt.Errorf("%w: over %v ops/s on prefix %q", ErrThrottled, b.limit.Rate, b.limit.Prefix)
+		}
+	}
+	return nil
+}
+
+func (r *rateLimitedKV) Put(ctx context.Context, key Key, value Value) error {
+	return rateLimitedPut(ctx, r, r.kv, key, value)
+}
+
+func (r *rateLimitedKV) Get(ctx context.Context, key Key) (Value, bool, error) {
+	return rateLimitedGet(ctx, r, r.kv, key)
+}
+
+func (r *rateLimitedKV) Delete(ctx context.Context, key Key) error {
+	return rateLimitedDelete(ctx, r, r.kv, key)
+}
+
+func (r *rateLimitedKV) List(ctx context.Context, prefix Key) ([]Key, error) {
+	return rateLimitedList(ctx, r, r.kv, prefix)
+}
+
+func (r *rateLimitedKV) Begin(ctx context.Context) (TxKV, error) {
+	tx, err := r.kv.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &rateLimitedTx{r: r, tx: tx}, nil
+}
+
+type rateLimitedTx struct {
+	r  *rateLimitedKV
+	tx TxKV
+}
+
+func (t *rateLimitedTx) Put(ctx context.Context, key Key, value Value) error {
+	return rateLimitedPut(ctx, t.r, t.tx, key, value)
+}
+
+func (t *rateLimitedTx) Get(ctx context.Context, key Key) (Value, bool, error) {
+	return rateLimitedGet(ctx, t.r, t.tx, key)
+}
+
+func (t *rateLimitedTx) Delete(ctx context.Context, key Key) error {
+	return rateLimitedDelete(ctx, t.r, t.tx, key)
+}
+
+func (t *rateLimitedTx) List(ctx context.Context, prefix Key) ([]Key, error) {
+	return rateLimitedList(ctx, t.r, t.tx, prefix)
+}
+
+func (t *rateLimitedTx) Commit(ctx context.Context) error   { return t.tx.Commit(ctx) }
+func (t *rateLimitedTx) Rollback(ctx context.Context) error { return t.tx.Rollback(ctx) }
+
+func rateLimitedPut(ctx context.Context, r *rateLimitedKV, kv KV, key Key, value Value) error {
+	if err := r.admit(key, false); err != nil {
+		return err
+	}
+	return kv.Put(ctx, key, value)
+}
+
+func rateLimitedGet(ctx context.Context, r *rateLimitedKV, kv KV, key Key) (Value, bool, error) {
+	if err := r.admit(key, false); err != nil {
+		return nil, false, err
+	}
+	return kv.Get(ctx, key)
+}
+
+func rateLimitedDelete(ctx context.Context, r *rateLimitedKV, kv KV, key Key) error {
+	if err := r.admit(key, false); err != nil {
+		return err
+	}
+	return kv.Delete(ctx, key)
+}
+
+func rateLimitedList(ctx context.Context, r *rateLimitedKV, kv KV, prefix Key) ([]Key, error) {
+	if err := r.admit(prefix, true); err != nil {
+		return nil, err
+	}
+	return kv.List(ctx, prefix)
+}
+
+// tokenBucket holds up to Burst tokens, refilled at Rate per second.
+type tokenBucket struct {
+	limit RateLimit
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) take() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// read under the lock, so that time doesn't go back between takes
+	now := time.Now()
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.limit.Rate
+		b.last = now
+	}
+	if max := float64(b.limit.Burst); b.tokens > max {
+		b.tokens = max
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (b *tokenBucket) refund() {
+	b.mu.Lock()
+	b.tokens++
+	b.mu.Unlock()
+}
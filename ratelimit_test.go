@@ -0,0 +1,81 @@
+package txkv_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestRateLimits(t *testing.T) {
+	testKV(t, func(t testing.TB) TransactionalKV {
+		return WithRateLimits(InMem(), RateLimit{Rate: 1e6, Burst: 1e6})
+	})
+}
+
+func TestRateLimitsThrottle(t *testing.T) {
+	ctx := context.Background()
+	kv := WithRateLimits(InMem(),
+		RateLimit{Prefix: Key(""), Rate: 20, Burst: 10},
+		RateLimit{Prefix: Key("noisy/"), Rate: 50, Burst: 3},
+	)
+
+	// the noisy prefix gets its burst, then is throttled
+	for i := 0; i < 3; i++ {
+		mustPut(ctx, t, kv, Key("noisy/k"), Value("v"))
+	}
+	require.ErrorIs(t, kv.Put(ctx, Key("noisy/k"), Value("v")), ErrThrottled)
+	_, _, err := kv.Get(ctx, Key("noisy/k"))
+	require.ErrorIs(t, err, ErrThrottled)
+
+	// while other callers aren't, and weren't charged for the refusals
+	for i := 0; i < 7; i++ {
+		mustPut(ctx, t, kv, Key("quiet/k"), Value("v"))
+	}
+	require.ErrorIs(t, kv.Put(ctx, Key("quiet/k"), Value("v")), ErrThrottled)
+
+	// within transactions too
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	require.ErrorIs(t, tx.Put(ctx, Key("noisy/k"), Value("v")), ErrThrottled)
+	require.NoError(t, tx.Rollback(ctx))
+
+	// tokens come back with time
+	time.Sleep(60 * time.Millisecond)
+	mustPut(ctx, t, kv, Key("noisy/k"), Value("v"))
+}
+
+func TestRateLimitsDefaultBurst(t *testing.T) {
+	ctx := context.Background()
+	kv := WithRateLimits(InMem(),
+		RateLimit{Prefix: Key("a/"), Rate: 2.5},
+		RateLimit{Prefix: Key("b/"), Rate: 0.1},
+	)
+	for i := 0; i < 3; i++ {
+		mustPut(ctx, t, kv, Key("a/k"), Value("v"))
+	}
+	require.ErrorIs(t, kv.Put(ctx, Key("a/k"), Value("v")), ErrThrottled)
+	mustPut(ctx, t, kv, Key("b/k"), Value("v"))
+	require.ErrorIs(t, kv.Put(ctx, Key("b/k"), Value("v")), ErrThrottled)
+}
+
+func TestRateLimitsList(t *testing.T) {
+	ctx := context.Background()
+	kv := WithRateLimits(InMem(), RateLimit{Prefix: Key("noisy/"), Rate: 1, Burst: 2})
+
+	// listing a broader prefix lists the limited keys too
+	_, err := kv.List(ctx, Key(""))
+	require.NoError(t, err)
+	_, err = kv.List(ctx, Key("noi"))
+	require.NoError(t, err)
+	_, err = kv.List(ctx, Key(""))
+	require.ErrorIs(t, err, ErrThrottled)
+	_, err = kv.List(ctx, Key("noisy/sub/"))
+	require.ErrorIs(t, err, ErrThrottled)
+	// unlike prefixes that don't overlap
+	_, err = kv.List(ctx, Key("quiet/"))
+	require.NoError(t, err)
+}
@@ -0,0 +1,139 @@
+package txkv
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrReadOnly is returned by writes to a store in read-only mode.
+var ErrReadOnly = errors.New("txkv: store is in read-only mode")
+
+// Admin is implemented by stores that operators can act upon.
+type Admin interface {
+	// SetReadOnly freezes or unfreezes writes. Once it returns, no write is
+	// in flight and further writes fail with ErrReadOnly, until writes are
+	// unfrozen. If `ctx` is done first, the mode is left as it was.
+	SetReadOnly(ctx context.Context, readOnly bool) error
+}
+
+// WithMaintenance returns a TransactionalKV that can be put in read-only
+// mode, for instance to freeze writes during migrations or incident response.
+// Reads, and thus backups, keep working while writes fail fast.
+func WithMaintenance(kv TransactionalKV) *MaintenanceKV {
+	return &MaintenanceKV{kv: kv}
+}
+
+var _ Admin = (*MaintenanceKV)(nil)
+
+// MaintenanceKV is a TransactionalKV that can be put in read-only mode.
+type MaintenanceKV struct {
+	kv TransactionalKV
+
+	// held shared by writes, exclusively to toggle the mode
+	mu       sync.RWMutex
+	readOnly bool
+}
+
+// SetReadOnly implements Admin. It waits for the writes in flight to
+// complete, and gives up if `ctx` is done first.
+func (m *MaintenanceKV) SetReadOnly(ctx context.Context, readOnly bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	locked := make(chan struct{})
+	go func() {
+		m.mu.Lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-ctx.Done():
+		// released as soon as it's acquired, leaving the mode as it was
+		go func() {
+			<-locked
+			m.mu.Unlock()
+		}()
+		return ctx.Err()
+	}
+	m.readOnly = readOnly
+	m.mu.Unlock()
+	return nil
+}
+
+// ReadOnly tells whether the store is in read-only mode.
+func (m *MaintenanceKV) ReadOnly() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.readOnly
+}
+
+// write runs `fn` unless the store is read-only.
+func (m *MaintenanceKV) write(fn func() error) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.readOnly {
+		return ErrReadOnly
+	}
+	return fn()
+}
+
+func (m *MaintenanceKV) Put(ctx context.Context, key Key, value Value) error {
+	return m.write(func() error { return m.kv.Put(ctx, key, value) })
+}
+
+func (m *MaintenanceKV) Get(ctx context.Context, key Key) (Value, bool, error) {
+	return m.kv.Get(ctx, key)
+}
+
+func (m *MaintenanceKV) Delete(ctx context.Context, key Key) error {
+	return m.write(func() error { return m.kv.Delete(ctx, key) })
+}
+
+func (m *MaintenanceKV) List(ctx context.Context, prefix Key) ([]Key, error) {
+	return m.kv.List(ctx, prefix)
+}
+
+// Begin starts a transaction even in read-only mode, so that it can be used
+// for reads. Its writes fail while the store is read-only, and so does its
+// commit.
+func (m *MaintenanceKV) Begin(ctx context.Context) (TxKV, error) {
+	tx, err := m.kv.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &maintenanceTx{m: m, tx: tx}, nil
+}
+
+type maintenanceTx struct {
+	m  *MaintenanceKV
+	tx TxKV
+}
+
+func (t *maintenanceTx) Put(ctx context.Context, key Key, value Value) error {
+	return t.m.write(func() error { return t.tx.Put(ctx, key, value) })
+}
+
+func (t *maintenanceTx) Get(ctx context.Context, key Key) (Value, bool, error) {
+	return t.tx.Get(ctx, key)
+}
+
+func (t *maintenanceTx) Delete(ctx context.Context, key Key) error {
+	return t.m.write(func() error { return t.tx.Delete(ctx, key) })
+}
+
+func (t *maintenanceTx) List(ctx context.Context, prefix Key) ([]Key, error) {
+	return t.tx.List(ctx, prefix)
+}
+
+// Commit rolls the transaction back when the store is read-only, so that it
+// doesn't hold on to what it took in the store.
+func (t *maintenanceTx) Commit(ctx context.Context) error {
+	err := t.m.write(func() error { return t.tx.Commit(ctx) })
+	if err == ErrReadOnly {
+		_ = t.tx.Rollback(ctx)
+	}
+	return err
+}
+
+func (t *maintenanceTx) Rollback(ctx context.Context) error { return t.tx.Rollback(ctx) }
@@ -0,0 +1,102 @@
+package txkv_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+	"github.com/aybabtme/txkv/txkvmock"
+)
+
+func TestMaintenance(t *testing.T) {
+	testKV(t, func(t testing.TB) TransactionalKV {
+		return WithMaintenance(InMem())
+	})
+}
+
+func TestMaintenanceReadOnly(t *testing.T) {
+	ctx := context.Background()
+	kv := WithMaintenance(InMem())
+	mustPut(ctx, t, kv, Key("hello"), Value("world"))
+
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	mustPut(ctx, t, tx, Key("pending"), Value("write"))
+
+	var admin Admin = kv
+	require.NoError(t, admin.SetReadOnly(ctx, true))
+	require.True(t, kv.ReadOnly())
+
+	require.ErrorIs(t, kv.Put(ctx, Key("hello"), Value("other")), ErrReadOnly)
+	require.ErrorIs(t, kv.Delete(ctx, Key("hello")), ErrReadOnly)
+	mustFind(ctx, t, kv, Key("hello"), Value("world"))
+	mustList(ctx, t, kv, Key(""), []Key{Key("hello")})
+
+	// transactions begun before the freeze can't commit either
+	require.ErrorIs(t, tx.Commit(ctx), ErrReadOnly)
+	require.NoError(t, tx.Rollback(ctx))
+
+	// new ones can read but not write
+	tx, err = kv.Begin(ctx)
+	require.NoError(t, err)
+	mustFind(ctx, t, tx, Key("hello"), Value("world"))
+	require.ErrorIs(t, tx.Put(ctx, Key("hello"), Value("other")), ErrReadOnly)
+	require.NoError(t, tx.Rollback(ctx))
+
+	require.NoError(t, admin.SetReadOnly(ctx, false))
+	mustPut(ctx, t, kv, Key("hello"), Value("again"))
+	mustFind(ctx, t, kv, Key("hello"), Value("again"))
+}
+
+func TestMaintenanceReadOnlyCommit(t *testing.T) {
+	ctx := context.Background()
+	mock := txkvmock.New(t)
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+	kv := WithMaintenance(mock)
+
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	require.NoError(t, kv.SetReadOnly(ctx, true))
+	// the commit fails, and releases the transaction
+	require.ErrorIs(t, tx.Commit(ctx), ErrReadOnly)
+}
+
+// stuckKV blocks Put until `release` is closed.
+type stuckKV struct {
+	TransactionalKV
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *stuckKV) Put(ctx context.Context, key Key, value Value) error {
+	close(s.started)
+	<-s.release
+	return s.TransactionalKV.Put(ctx, key, value)
+}
+
+func TestMaintenanceSetReadOnlyCanceled(t *testing.T) {
+	ctx := context.Background()
+	stuck := &stuckKV{TransactionalKV: InMem(), started: make(chan struct{}), release: make(chan struct{})}
+	kv := WithMaintenance(stuck)
+	done := make(chan error)
+	go func() { done <- kv.Put(ctx, Key("a"), Value("1")) }()
+	<-stuck.started
+
+	// a write in flight that doesn't complete in time leaves it writable
+	tctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, kv.SetReadOnly(tctx, true), context.DeadlineExceeded)
+	close(stuck.release)
+	require.NoError(t, <-done)
+	require.False(t, kv.ReadOnly())
+	mustDelete(ctx, t, kv, Key("a"))
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	require.ErrorIs(t, kv.SetReadOnly(canceled, true), context.Canceled)
+	require.False(t, kv.ReadOnly())
+}
@@ -0,0 +1,151 @@
+package txkv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrSnapshotStale is returned by a ReadSnapshot when what's read was
+// modified after the snapshot was taken, so that it can't be read
+// consistently with the rest. Callers should start over with a new snapshot.
+var ErrSnapshotStale = errors.New("txkv: read snapshot is stale")
+
+// ReadSnapshot is a read-only view of a store, pinned at the time it was
+// taken, so that all the reads of a unit of work, like a request, are
+// consistent with each other. Stores only offer read-committed transactions,
+// so it's emulated:
+//
+//   - every value and listing read is remembered, so reading them again
+//     gives the same result;
+//   - on stores that are a ChangeTracker, reading what was modified after
+//     the snapshot was taken fails with ErrSnapshotStale, rather than mixing
+//     the new with the old.
+//
+// Other stores only get the former. Writes fail with ErrReadOnly.
+type ReadSnapshot struct {
+	kv  KV
+	ct  ChangeTracker
+	seq uint64
+
+	mu     sync.Mutex
+	values map[string]snapshotValue
+	lists  map[string][]Key
+}
+
+type snapshotValue struct {
+	value Value
+	found bool
+}
+
+// NewReadSnapshot pins a read snapshot of `kv`.
+func NewReadSnapshot(ctx context.Context, kv KV) (*ReadSnapshot, error) {
+	s := &ReadSnapshot{
+		kv:     kv,
+		values: make(map[string]snapshotValue),
+		lists:  make(map[string][]Key),
+	}
+	if ct, ok := kv.(ChangeTracker); ok {
+		seq, err := ct.Seq(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.ct, s.seq = ct, seq
+	}
+	return s, nil
+}
+
+func (s *ReadSnapshot) Put(ctx context.Context, key Key, value Value) error { return ErrReadOnly }
+func (s *ReadSnapshot) Delete(ctx context.Context, key Key) error           { return ErrReadOnly }
+
+func (s *ReadSnapshot) Get(ctx context.Context, key Key) (Value, bool, error) {
+	s.mu.Lock()
+	v, ok := s.values[string(key)]
+	s.mu.Unlock()
+	if ok {
+		return v.value, v.found, nil
+	}
+	value, found, err := s.kv.Get(ctx, key)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := s.checkUnmodified(ctx, key, true); err != nil {
+		return nil, false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// a concurrent read of the same key may have been remembered first
+	if v, ok := s.values[string(key)]; ok {
+		return v.value, v.found, nil
+	}
+	s.values[string(key)] = snapshotValue{value: value, found: found}
+	return value, found, nil
+}
+
+func (s *ReadSnapshot) List(ctx context.Context, prefix Key) ([]Key, error) {
+	s.mu.Lock()
+	keys, ok := s.lists[string(prefix)]
+	s.mu.Unlock()
+	if ok {
+		return append([]Key(nil), keys...), nil
+	}
+	keys, err := s.kv.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkUnmodified(ctx, prefix, false); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if remembered, ok := s.lists[string(prefix)]; ok {
+		keys = remembered
+	} else {
+		s.lists[string(prefix)] = keys
+	}
+	return append([]Key(nil), keys...), nil
+}
+
+// checkUnmodified fails if `key`, or any key under it unless `exact`, was
+// modified since the snapshot was taken. It's checked after reading, so
+// that what was read can't predate a modification it misses.
+func (s *ReadSnapshot) checkUnmodified(ctx context.Context, key Key, exact bool) error {
+	if s.ct == nil {
+		return nil
+	}
+	modified, err := s.ct.ListModifiedSince(ctx, key, s.seq)
+	if errors.Is(err, ErrCompacted) {
+		return ErrSnapshotStale
+	}
+	if err != nil {
+		return err
+	}
+	for _, k := range modified {
+		if !exact || bytes.Equal(k, key) {
+			return ErrSnapshotStale
+		}
+	}
+	return nil
+}
+
+type snapshotKey struct{}
+
+// ContextWithReadSnapshot returns a context carrying a read snapshot of `kv`,
+// which SnapshotFromContext hands to the code it's passed to.
+func ContextWithReadSnapshot(ctx context.Context, kv KV) (context.Context, error) {
+	s, err := NewReadSnapshot(ctx, kv)
+	if err != nil {
+		return nil, err
+	}
+	return context.WithValue(ctx, snapshotKey{}, s), nil
+}
+
+// SnapshotFromContext returns the read snapshot carried by `ctx`, or `kv` if
+// there's none. The snapshot is expected to be one of `kv`.
+func SnapshotFromContext(ctx context.Context, kv KV) KV {
+	if s, ok := ctx.Value(snapshotKey{}).(*ReadSnapshot); ok {
+		return s
+	}
+	return kv
+}
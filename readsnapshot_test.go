@@ -0,0 +1,67 @@
+package txkv_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestReadSnapshot(t *testing.T) {
+	ctx := context.Background()
+	kv := InMem()
+	mustPut(ctx, t, kv, Key("acct/a"), Value("50"))
+	mustPut(ctx, t, kv, Key("acct/b"), Value("50"))
+	mustPut(ctx, t, kv, Key("log/1"), Value("open"))
+
+	snap, err := NewReadSnapshot(ctx, kv)
+	require.NoError(t, err)
+	mustFind(ctx, t, snap, Key("acct/a"), Value("50"))
+	mustList(ctx, t, snap, Key("log/"), []Key{Key("log/1")})
+	require.ErrorIs(t, snap.Put(ctx, Key("acct/a"), Value("0")), ErrReadOnly)
+	require.ErrorIs(t, snap.Delete(ctx, Key("acct/a")), ErrReadOnly)
+
+	// a transfer commits midway through the reads
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Put(ctx, Key("acct/a"), Value("0")))
+	require.NoError(t, tx.Put(ctx, Key("acct/b"), Value("100")))
+	require.NoError(t, tx.Put(ctx, Key("log/2"), Value("transfer")))
+	require.NoError(t, tx.Commit(ctx))
+
+	// what was read is read again
+	mustFind(ctx, t, snap, Key("acct/a"), Value("50"))
+	mustList(ctx, t, snap, Key("log/"), []Key{Key("log/1")})
+	// what wasn't can't be read consistently anymore
+	_, _, err = snap.Get(ctx, Key("acct/b"))
+	require.ErrorIs(t, err, ErrSnapshotStale)
+	_, err = snap.List(ctx, Key("acct/"))
+	require.ErrorIs(t, err, ErrSnapshotStale)
+	// unless it's untouched
+	mustNotFind(ctx, t, snap, Key("acct/c"))
+	mustList(ctx, t, snap, Key("other/"), nil)
+
+	// stores that don't track changes only get repeatable reads
+	snap, err = NewReadSnapshot(ctx, WithMaintenance(kv))
+	require.NoError(t, err)
+	mustFind(ctx, t, snap, Key("acct/a"), Value("0"))
+	mustPut(ctx, t, kv, Key("acct/a"), Value("10"))
+	mustFind(ctx, t, snap, Key("acct/a"), Value("0"))
+}
+
+func TestSnapshotFromContext(t *testing.T) {
+	ctx := context.Background()
+	kv := InMem()
+	require.Equal(t, KV(kv), SnapshotFromContext(ctx, kv))
+
+	mustPut(ctx, t, kv, Key("k"), Value("v1"))
+	ctx, err := ContextWithReadSnapshot(ctx, kv)
+	require.NoError(t, err)
+	mustFind(ctx, t, SnapshotFromContext(ctx, kv), Key("k"), Value("v1"))
+	mustPut(ctx, t, kv, Key("k"), Value("v2"))
+	mustFind(ctx, t, SnapshotFromContext(ctx, kv), Key("k"), Value("v1"))
+	_, err = SnapshotFromContext(ctx, kv).List(ctx, nil)
+	require.ErrorIs(t, err, ErrSnapshotStale)
+}
@@ -0,0 +1,169 @@
+package txkv
+
+import (
+	"context"
+	"fmt"
+)
+
+// RefRule describes references between keys, like those of an index to its
+// entries, of documents to their blobs or of the edges of a graph to its
+// nodes.
+type RefRule struct {
+	// Name identifies the rule in problems.
+	Name string
+	// From is the prefix of the referring entries.
+	From Key
+	// Refs returns the keys an entry under From refers to.
+	Refs func(key Key, value Value) ([]Key, error)
+	// To, if set, is the prefix of entries that only live as long as they're
+	// referred to, like blobs: those no entry under From refers to are
+	// orphans.
+	To Key
+}
+
+// RefProblemKind tells what's wrong with a reference.
+type RefProblemKind int
+
+// The kinds of reference problems.
+const (
+	// DanglingRef is a reference to a missing key.
+	DanglingRef RefProblemKind = iota + 1
+	// OrphanedEntry is an entry nothing refers to.
+	OrphanedEntry
+)
+
+func (k RefProblemKind) String() string {
+	switch k {
+	case DanglingRef:
+		return "dangling"
+	case OrphanedEntry:
+		return "orphaned"
+	}
+	return fmt.Sprintf("RefProblemKind(%d)", int(k))
+}
+
+// RefProblem is a broken reference found by ScanRefs.
+type RefProblem struct {
+	Rule string
+	Kind RefProblemKind
+	// Key is the referring entry of a dangling reference, or the orphan.
+	Key Key
+	// Ref is the missing key of a dangling reference.
+	Ref Key
+}
+
+// RefScanOptions tune ScanRefs.
+type RefScanOptions struct {
+	// Repair, if set, returns the writes fixing a problem, which are applied
+	// in batches once the scan is done. DeleteBroken is a common choice.
+	Repair func(p RefProblem) []Op
+	// BatchSize is the number of writes per transaction of repairs. Defaults
+	// to 100.
+	BatchSize int
+}
+
+// DeleteBroken is a repair deleting the key at fault: the entry with a
+// dangling reference, or the orphan.
+func DeleteBroken(p RefProblem) []Op { return []Op{DeleteOp(p.Key)} }
+
+// RefReport is the outcome of ScanRefs.
+type RefReport struct {
+	Problems []RefProblem
+	// Scanned is the number of referring entries checked.
+	Scanned int
+	// Repaired is the number of repair writes applied.
+	Repaired int
+}
+
+// ScanRefs checks the references described by `rules` in `kv`, reporting
+// dangling references and orphaned entries, and repairs them if asked to.
+//
+// The scan is offline: entries written while it runs may be reported as
+// broken, and repaired as such, so the store must not be written to
+// meanwhile.
+func ScanRefs(ctx context.Context, kv TransactionalKV, rules []RefRule, opts RefScanOptions) (RefReport, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	var report RefReport
+	for _, rule := range rules {
+		problems, scanned, err := scanRule(ctx, kv, rule)
+		if err != nil {
+			return report, fmt.Errorf("txkv: scanning references of %q: %w", rule.Name, err)
+		}
+		report.Problems = append(report.Problems, problems...)
+		report.Scanned += scanned
+	}
+	if opts.Repair == nil {
+		return report, nil
+	}
+	var ops []Op
+	for _, p := range report.Problems {
+		ops = append(ops, opts.Repair(p)...)
+	}
+	for len(ops) > 0 {
+		n := opts.BatchSize
+		if n > len(ops) {
+			n = len(ops)
+		}
+		if err := Apply(ctx, kv, ops[:n]); err != nil {
+			return report, err
+		}
+		report.Repaired += n
+		ops = ops[n:]
+	}
+	return report, nil
+}
+
+func scanRule(ctx context.Context, kv KV, rule RefRule) ([]RefProblem, int, error) {
+	var (
+		problems []RefProblem
+		scanned  int
+		exists   = make(map[string]bool)
+	)
+	its, err := ScanPartitions(ctx, kv, rule.From, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, it := range its {
+		for it.Next(ctx) {
+			scanned++
+			refs, err := rule.Refs(it.Key(), it.Value())
+			if err != nil {
+				_ = it.Close()
+				return nil, 0, fmt.Errorf("references of %q: %w", it.Key(), err)
+			}
+			for _, ref := range refs {
+				found, checked := exists[string(ref)]
+				if !checked {
+					_, found, err = kv.Get(ctx, ref)
+					if err != nil {
+						_ = it.Close()
+						return nil, 0, err
+					}
+					exists[string(ref)] = found
+				}
+				if !found {
+					problems = append(problems, RefProblem{Rule: rule.Name, Kind: DanglingRef, Key: it.Key(), Ref: ref})
+				}
+			}
+		}
+		if err := it.Err(); err != nil {
+			return nil, 0, err
+		}
+		_ = it.Close()
+	}
+	if rule.To == nil {
+		return problems, scanned, nil
+	}
+	targets, err := kv.List(ctx, rule.To)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, key := range targets {
+		if !exists[string(key)] {
+			problems = append(problems, RefProblem{Rule: rule.Name, Kind: OrphanedEntry, Key: key})
+		}
+	}
+	return problems, scanned, nil
+}
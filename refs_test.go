@@ -0,0 +1,67 @@
+package txkv_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestScanRefs(t *testing.T) {
+	ctx := context.Background()
+	kv := InMem()
+	// documents refer to their blobs, the index by name refers to documents
+	mustPut(ctx, t, kv, Key("doc/1"), Value("blob/a,blob/b"))
+	mustPut(ctx, t, kv, Key("doc/2"), Value("blob/c"))
+	mustPut(ctx, t, kv, Key("blob/a"), Value("..."))
+	mustPut(ctx, t, kv, Key("blob/b"), Value("..."))
+	mustPut(ctx, t, kv, Key("blob/z"), Value("..."))
+	mustPut(ctx, t, kv, Key("idx/name/alice"), Value("doc/1"))
+	mustPut(ctx, t, kv, Key("idx/name/bob"), Value("doc/3"))
+
+	rules := []RefRule{
+		{
+			Name: "blobs",
+			From: Key("doc/"),
+			To:   Key("blob/"),
+			Refs: func(_ Key, value Value) ([]Key, error) {
+				var refs []Key
+				for _, ref := range strings.Split(string(value), ",") {
+					refs = append(refs, Key(ref))
+				}
+				return refs, nil
+			},
+		},
+		{
+			Name: "names",
+			From: Key("idx/name/"),
+			Refs: func(_ Key, value Value) ([]Key, error) { return []Key{Key(value)}, nil },
+		},
+	}
+	want := []RefProblem{
+		{Rule: "blobs", Kind: DanglingRef, Key: Key("doc/2"), Ref: Key("blob/c")},
+		{Rule: "blobs", Kind: OrphanedEntry, Key: Key("blob/z")},
+		{Rule: "names", Kind: DanglingRef, Key: Key("idx/name/bob"), Ref: Key("doc/3")},
+	}
+
+	report, err := ScanRefs(ctx, kv, rules, RefScanOptions{})
+	require.NoError(t, err)
+	require.Equal(t, RefReport{Problems: want, Scanned: 4}, report)
+	mustFind(ctx, t, kv, Key("blob/z"), Value("..."))
+
+	report, err = ScanRefs(ctx, kv, rules, RefScanOptions{Repair: DeleteBroken, BatchSize: 2})
+	require.NoError(t, err)
+	require.Equal(t, RefReport{Problems: want, Scanned: 4, Repaired: 3}, report)
+	mustNotFind(ctx, t, kv, Key("doc/2"))
+	mustNotFind(ctx, t, kv, Key("blob/z"))
+	mustNotFind(ctx, t, kv, Key("idx/name/bob"))
+	mustFind(ctx, t, kv, Key("doc/1"), Value("blob/a,blob/b"))
+
+	report, err = ScanRefs(ctx, kv, rules, RefScanOptions{})
+	require.NoError(t, err)
+	require.Empty(t, report.Problems)
+	require.Equal(t, "dangling", DanglingRef.String())
+}
@@ -0,0 +1,139 @@
+package txkv
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// RetentionRule expires the entries under a prefix past an age.
+type RetentionRule struct {
+	Prefix Key
+	MaxAge time.Duration
+	// Timestamp returns when an entry was written, from its key or its
+	// value. Entries it can't date are kept.
+	Timestamp func(key Key, value Value) (t time.Time, ok bool, err error)
+}
+
+// TimestampInKey returns a RetentionRule.Timestamp reading the time from
+// segment `i` of keys built by KeyOf, an integer counting `unit`s since the
+// Unix epoch, like KeyOf("logs", time.Now().Unix(), id) with time.Second.
+func TimestampInKey(i int, unit time.Duration) func(Key, Value) (time.Time, bool, error) {
+	return func(key Key, _ Value) (time.Time, bool, error) {
+		segments, err := ParseKey(key)
+		if err != nil || i >= len(segments) {
+			return time.Time{}, false, nil
+		}
+		var n int64
+		switch v := segments[i].Value.(type) {
+		case int64:
+			n = v
+		case uint64:
+			n = int64(v)
+		default:
+			return time.Time{}, false, nil
+		}
+		return time.Unix(0, 0).Add(time.Duration(n) * unit), true, nil
+	}
+}
+
+// RetentionOptions tune a Retention.
+type RetentionOptions struct {
+	// DryRun only reports the expired entries, without deleting them.
+	DryRun bool
+	// BatchSize is the number of entries deleted per transaction. Defaults
+	// to 100.
+	BatchSize int
+	// Interval is how often Run enforces the rules. Defaults to an hour.
+	Interval time.Duration
+	// Now is the clock. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// RetentionReport is the outcome of enforcing retention rules.
+type RetentionReport struct {
+	// Scanned is the number of entries the rules apply to.
+	Scanned int
+	// Expired are the entries past their age, deleted unless in a dry run.
+	Expired []Key
+}
+
+// Retention enforces retention rules on a store, so cleaning up old entries
+// doesn't take bespoke jobs.
+type Retention struct {
+	kv    TransactionalKV
+	rules []RetentionRule
+	opts  RetentionOptions
+}
+
+// NewRetention returns a Retention enforcing `rules` on `kv`. Nothing happens
+// until it's enforced.
+func NewRetention(kv TransactionalKV, rules []RetentionRule, opts RetentionOptions) *Retention {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Hour
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &Retention{kv: kv, rules: rules, opts: opts}
+}
+
+// Enforce deletes the entries past the age of their rule, or only reports
+// them in a dry run.
+func (r *Retention) Enforce(ctx context.Context) (RetentionReport, error) {
+	var report RetentionReport
+	now := r.opts.Now()
+	for _, rule := range r.rules {
+		its, err := ScanPartitions(ctx, r.kv, rule.Prefix, 1)
+		if err != nil {
+			return report, err
+		}
+		cutoff := now.Add(-rule.MaxAge)
+		for _, it := range its {
+			for it.Next(ctx) {
+				report.Scanned++
+				t, ok, err := rule.Timestamp(it.Key(), it.Value())
+				if err != nil {
+					_ = it.Close()
+					return report, fmt.Errorf("txkv: dating %q: %w", it.Key(), err)
+				}
+				if ok && t.Before(cutoff) {
+					report.Expired = append(report.Expired, it.Key())
+				}
+			}
+			if err := it.Err(); err != nil {
+				return report, err
+			}
+			_ = it.Close()
+		}
+	}
+	if r.opts.DryRun {
+		return report, nil
+	}
+	for i := 0; i < len(report.Expired); i += r.opts.BatchSize {
+		end := i + r.opts.BatchSize
+		if end > len(report.Expired) {
+			end = len(report.Expired)
+		}
+		if err := DeleteMany(ctx, r.kv, report.Expired[i:end]); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// Run enforces the rules every interval, until `ctx` is done or enforcing
+// them fails.
+func (r *Retention) Run(ctx context.Context) error {
+	for {
+		if _, err := r.Enforce(ctx); err != nil {
+			return err
+		}
+		if err := sleep(ctx, r.opts.Interval); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package txkv_test
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestRetention(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1_000_000, 0)
+	day := 24 * time.Hour
+	kv := InMem()
+
+	oldLog := KeyOf("logs", now.Add(-40*day).Unix(), "a")
+	newLog := KeyOf("logs", now.Add(-10*day).Unix(), "b")
+	mustPut(ctx, t, kv, oldLog, Value("old"))
+	mustPut(ctx, t, kv, newLog, Value("new"))
+	mustPut(ctx, t, kv, KeyOf("logs", "undated"), Value("kept"))
+	// sessions carry their time in their value
+	session := func(at time.Time) Value {
+		return binary.BigEndian.AppendUint64(nil, uint64(at.Unix()))
+	}
+	mustPut(ctx, t, kv, Key("session/old"), session(now.Add(-2*time.Hour)))
+	mustPut(ctx, t, kv, Key("session/new"), session(now.Add(-time.Minute)))
+	mustPut(ctx, t, kv, Key("users/1"), Value("forever"))
+
+	rules := []RetentionRule{
+		{Prefix: KeyOf("logs"), MaxAge: 30 * day, Timestamp: TimestampInKey(1, time.Second)},
+		{Prefix: Key("session/"), MaxAge: time.Hour, Timestamp: func(_ Key, v Value) (time.Time, bool, error) {
+			return time.Unix(int64(binary.BigEndian.Uint64(v)), 0), true, nil
+		}},
+	}
+	want := RetentionReport{Scanned: 5, Expired: []Key{oldLog, Key("session/old")}}
+
+	dry := NewRetention(kv, rules, RetentionOptions{DryRun: true, Now: func() time.Time { return now }})
+	report, err := dry.Enforce(ctx)
+	require.NoError(t, err)
+	require.Equal(t, want, report)
+	mustFind(ctx, t, kv, oldLog, Value("old"))
+
+	retention := NewRetention(kv, rules, RetentionOptions{BatchSize: 1, Now: func() time.Time { return now }})
+	report, err = retention.Enforce(ctx)
+	require.NoError(t, err)
+	require.Equal(t, want, report)
+	mustNotFind(ctx, t, kv, oldLog)
+	mustNotFind(ctx, t, kv, Key("session/old"))
+	mustFind(ctx, t, kv, newLog, Value("new"))
+	mustFind(ctx, t, kv, KeyOf("logs", "undated"), Value("kept"))
+	mustFind(ctx, t, kv, Key("session/new"), session(now.Add(-time.Minute)))
+	mustFind(ctx, t, kv, Key("users/1"), Value("forever"))
+
+	report, err = retention.Enforce(ctx)
+	require.NoError(t, err)
+	require.Empty(t, report.Expired)
+}
@@ -0,0 +1,164 @@
+package txkv
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// ErrTransient is matched by errors from which retrying the operation may
+// recover, like a timeout talking to a remote backend. Backends can wrap it,
+// or return errors with a `Temporary() bool` method.
+var ErrTransient = errors.New("txkv: transient error")
+
+// ErrConflict is matched by the errors returned when a transaction can't
+// commit because of concurrent transactions. Running the transaction again
+// from the start may succeed.
+var ErrConflict = errors.New("txkv: transaction conflict")
+
+// IsTransient tells whether `err` is transient.
+func IsTransient(err error) bool {
+	if errors.Is(err, ErrTransient) {
+		return true
+	}
+	var t interface{ Temporary() bool }
+	return errors.As(err, &t) && t.Temporary()
+}
+
+// RetryPolicy tells what to retry, how many times, and how long to wait
+// between attempts. Delays grow exponentially from BaseDelay up to MaxDelay,
+// and are jittered.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one.
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// Retryable tells which errors to retry. Defaults to IsTransient for
+	// single operations, and to also retrying ErrConflict for transactions.
+	Retryable func(error) bool
+}
+
+// DefaultRetryPolicy is used for the fields of a RetryPolicy left to zero.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   10 * time.Millisecond,
+	MaxDelay:    time.Second,
+}
+
+func (p RetryPolicy) withDefaults(retryable func(error) bool) RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = DefaultRetryPolicy.BaseDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = DefaultRetryPolicy.MaxDelay
+	}
+	if p.Retryable == nil {
+		p.Retryable = retryable
+	}
+	return p
+}
+
+// backoff returns how long to wait after failed attempt number `attempt`,
+// counting from 0, with full jitter.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.MaxDelay
+	// shifting only when it can't go past MaxDelay, so it can't overflow
+	if attempt < 32 && p.BaseDelay <= p.MaxDelay>>attempt {
+		d = p.BaseDelay << attempt
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// do runs `fn` until it succeeds, fails with an error that isn't retryable,
+// or runs out of attempts.
+func (p RetryPolicy) do(ctx context.Context, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt+1 >= p.MaxAttempts || !p.Retryable(err) {
+			return err
+		}
+		if serr := sleep(ctx, p.backoff(attempt)); serr != nil {
+			return err
+		}
+	}
+}
+
+// Retry runs `fn` until it succeeds, fails with an error that isn't
+// retryable, or runs out of attempts as per `policy`, whose Retryable
+// defaults to IsTransient. It's for work outside of a store that should be
+// retried like the store's operations.
+func Retry(ctx context.Context, policy RetryPolicy, fn func(ctx context.Context) error) error {
+	return policy.withDefaults(IsTransient).do(ctx, func() error { return fn(ctx) })
+}
+
+func isTransientOrConflict(err error) bool {
+	return IsTransient(err) || errors.Is(err, ErrConflict)
+}
+
+// WithRetry returns a TransactionalKV retrying the operations of `kv` that
+// fail with a retryable error, as per `policy`. Operations within a
+// transaction aren't retried, since a failed transaction is better run again
+// as a whole: see RetryTx.
+func WithRetry(kv TransactionalKV, policy RetryPolicy) TransactionalKV {
+	return &retryKV{kv: kv, policy: policy.withDefaults(IsTransient)}
+}
+
+type retryKV struct {
+	kv     TransactionalKV
+	policy RetryPolicy
+}
+
+func (r *retryKV) Put(ctx context.Context, key Key, value Value) error {
+	return r.policy.do(ctx, func() error { return r.kv.Put(ctx, key, value) })
+}
+
+func (r *retryKV) Get(ctx context.Context, key Key) (v Value, ok bool, err error) {
+	err = r.policy.do(ctx, func() (err error) {
+		v, ok, err = r.kv.Get(ctx, key)
+		return err
+	})
+	return v, ok, err
+}
+
+func (r *retryKV) Delete(ctx context.Context, key Key) error {
+	return r.policy.do(ctx, func() error { return r.kv.Delete(ctx, key) })
+}
+
+func (r *retryKV) List(ctx context.Context, prefix Key) (keys []Key, err error) {
+	err = r.policy.do(ctx, func() (err error) {
+		keys, err = r.kv.List(ctx, prefix)
+		return err
+	})
+	return keys, err
+}
+
+func (r *retryKV) Begin(ctx context.Context) (tx TxKV, err error) {
+	err = r.policy.do(ctx, func() (err error) {
+		tx, err = r.kv.Begin(ctx)
+		return err
+	})
+	return tx, err
+}
+
+// RetryTx runs `fn` in a transaction of `kv` and commits it, running it again
+// in a new transaction if it fails with a retryable error, as per `policy`.
+// By default, transient errors and conflicts are retried. `fn` must be safe
+// to run more than once.
+func RetryTx(ctx context.Context, kv TransactionalKV, policy RetryPolicy, fn func(ctx context.Context, tx TxKV) error) error {
+	policy = policy.withDefaults(isTransientOrConflict)
+	return policy.do(ctx, func() error {
+		tx, err := kv.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		if err := fn(ctx, tx); err != nil {
+			_ = tx.Rollback(ctx)
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+}
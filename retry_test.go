@@ -0,0 +1,163 @@
+package txkv_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+// flakyKV fails the first `failures` operations, and commits, with `err`.
+type flakyKV struct {
+	TransactionalKV
+	failures int
+	err      error
+	calls    int
+}
+
+func (f *flakyKV) fail() error {
+	f.calls++
+	if f.failures > 0 {
+		f.failures--
+		return f.err
+	}
+	return nil
+}
+
+func (f *flakyKV) Put(ctx context.Context, key Key, value Value) error {
+	if err := f.fail(); err != nil {
+		return err
+	}
+	return f.TransactionalKV.Put(ctx, key, value)
+}
+
+func (f *flakyKV) Get(ctx context.Context, key Key) (Value, bool, error) {
+	if err := f.fail(); err != nil {
+		return nil, false, err
+	}
+	return f.TransactionalKV.Get(ctx, key)
+}
+
+func (f *flakyKV) Begin(ctx context.Context) (TxKV, error) {
+	tx, err := f.TransactionalKV.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &flakyTx{TxKV: tx, kv: f}, nil
+}
+
+type flakyTx struct {
+	TxKV
+	kv *flakyKV
+}
+
+func (f *flakyTx) Commit(ctx context.Context) error {
+	if err := f.kv.fail(); err != nil {
+		_ = f.TxKV.Rollback(ctx)
+		return err
+	}
+	return f.TxKV.Commit(ctx)
+}
+
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "try again" }
+func (temporaryError) Temporary() bool { return true }
+
+var fastRetries = RetryPolicy{BaseDelay: time.Microsecond, MaxDelay: time.Millisecond}
+
+func TestRetry(t *testing.T) {
+	testKV(t, func(t testing.TB) TransactionalKV {
+		return WithRetry(InMem(), fastRetries)
+	})
+}
+
+func TestRetryTransient(t *testing.T) {
+	ctx := context.Background()
+	for _, transient := range []error{
+		fmt.Errorf("timeout: %w", ErrTransient),
+		temporaryError{},
+	} {
+		flaky := &flakyKV{TransactionalKV: InMem(), failures: 2, err: transient}
+		kv := WithRetry(flaky, fastRetries)
+		mustPut(ctx, t, kv, Key("hello"), Value("world"))
+		require.Equal(t, 3, flaky.calls)
+
+		// gives up after MaxAttempts
+		flaky.failures, flaky.calls = 10, 0
+		kv = WithRetry(flaky, RetryPolicy{MaxAttempts: 3, BaseDelay: time.Microsecond})
+		_, _, err := kv.Get(ctx, Key("hello"))
+		require.ErrorIs(t, err, transient)
+		require.Equal(t, 3, flaky.calls)
+	}
+
+	// other errors aren't retried
+	permanent := errors.New("permanent")
+	flaky := &flakyKV{TransactionalKV: InMem(), failures: 1, err: permanent}
+	kv := WithRetry(flaky, fastRetries)
+	require.ErrorIs(t, kv.Put(ctx, Key("hello"), Value("world")), permanent)
+	require.Equal(t, 1, flaky.calls)
+}
+
+func TestRetryTx(t *testing.T) {
+	ctx := context.Background()
+	flaky := &flakyKV{TransactionalKV: InMem(), failures: 2, err: ErrConflict}
+	runs := 0
+	err := RetryTx(ctx, flaky, fastRetries, func(ctx context.Context, tx TxKV) error {
+		runs++
+		return tx.Put(ctx, Key("counter"), Value(fmt.Sprint(runs)))
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, runs)
+	mustFind(ctx, t, flaky, Key("counter"), Value("3"))
+
+	// errors from the transaction itself aren't retried
+	permanent := errors.New("permanent")
+	runs = 0
+	err = RetryTx(ctx, flaky, fastRetries, func(ctx context.Context, tx TxKV) error {
+		runs++
+		return permanent
+	})
+	require.ErrorIs(t, err, permanent)
+	require.Equal(t, 1, runs)
+}
+
+func TestRetryFunc(t *testing.T) {
+	ctx := context.Background()
+	calls := 0
+	err := Retry(ctx, fastRetries, func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return temporaryError{}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, calls)
+
+	calls = 0
+	permanent := errors.New("permanent")
+	err = Retry(ctx, fastRetries, func(ctx context.Context) error {
+		calls++
+		return permanent
+	})
+	require.ErrorIs(t, err, permanent)
+	require.Equal(t, 1, calls)
+}
+
+func TestRetryLongBackoff(t *testing.T) {
+	// the delays of late attempts would overflow if not capped first
+	policy := RetryPolicy{MaxAttempts: 40, BaseDelay: 10 * time.Second, MaxDelay: time.Microsecond}
+	calls := 0
+	err := Retry(context.Background(), policy, func(ctx context.Context) error {
+		calls++
+		return temporaryError{}
+	})
+	require.Error(t, err)
+	require.Equal(t, 40, calls)
+}
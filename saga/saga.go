@@ -0,0 +1,213 @@
+// Package saga runs multi-step workflows spanning several systems, undoing
+// the completed steps with compensating actions when a step fails.
+//
+// The progress of each run is stored in a txkv store after every step, so a
+// run interrupted by a crash resumes where it was, going forward or undoing
+// steps. Steps and compensations are retried, and may be run again when a
+// crash happens before their completion is recorded: they must be
+// idempotent.
+package saga
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aybabtme/txkv"
+)
+
+// Step is a step of a saga.
+type Step struct {
+	Name string
+	// Do performs the step.
+	Do func(ctx context.Context) error
+	// Compensate undoes the step, once it was done, when a later step fails.
+	// It can be nil for steps that need no undoing.
+	Compensate func(ctx context.Context) error
+}
+
+// Saga is a sequence of steps.
+type Saga struct {
+	Name  string
+	Steps []Step
+}
+
+// Options tune a Runner.
+type Options struct {
+	// StatePrefix is where the state of the runs is stored. Defaults to
+	// DefaultStatePrefix.
+	StatePrefix txkv.Key
+	// Retry tells how to retry the steps and compensations. Its Retryable
+	// defaults to txkv.IsTransient.
+	Retry txkv.RetryPolicy
+}
+
+// DefaultStatePrefix is the default location of the state of the runs.
+var DefaultStatePrefix = txkv.Key("__saga/")
+
+// ErrMismatch is returned when resuming a run of a different saga, or of a
+// saga whose steps changed.
+var ErrMismatch = errors.New("saga: run doesn't match the saga")
+
+// Error is returned when a run fails.
+type Error struct {
+	Step string // the step that failed
+	Err  error
+	// Compensated tells if the steps done were all compensated.
+	Compensated bool
+	// CompensationErr is why compensating failed, if it did. The run is then
+	// stuck, waiting for a human.
+	CompensationErr error
+}
+
+func (e *Error) Error() string {
+	if e.CompensationErr != nil {
+		return fmt.Sprintf("saga: step %q failed: %v, and compensating failed: %v", e.Step, e.Err, e.CompensationErr)
+	}
+	return fmt.Sprintf("saga: step %q failed: %v", e.Step, e.Err)
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Runner runs sagas, storing their progress in a store.
+type Runner struct {
+	kv   txkv.KV
+	opts Options
+}
+
+// NewRunner returns a Runner storing the progress of runs in `kv`.
+func NewRunner(kv txkv.KV, opts Options) *Runner {
+	if opts.StatePrefix == nil {
+		opts.StatePrefix = DefaultStatePrefix
+	}
+	return &Runner{kv: kv, opts: opts}
+}
+
+type status string
+
+const (
+	running      status = "running"
+	compensating status = "compensating"
+	done         status = "done"
+	compensated  status = "compensated"
+	stuck        status = "stuck"
+)
+
+// state is the progress of a run. While running, Next is the next step to
+// do. While compensating, Next is one past the next step to compensate.
+type state struct {
+	Saga            string `json:"saga"`
+	Steps           int    `json:"steps"`
+	Status          status `json:"status"`
+	Next            int    `json:"next"`
+	FailedStep      string `json:"failed_step,omitempty"`
+	Err             string `json:"error,omitempty"`
+	CompensationErr string `json:"compensation_error,omitempty"`
+}
+
+// Run runs the saga `s` under the ID `id`, or resumes the run of that ID. It
+// returns nil once all the steps are done, and an *Error if a step failed.
+// Running a finished run again returns its outcome, except for runs stuck
+// compensating, which try compensating again.
+func (r *Runner) Run(ctx context.Context, id string, s Saga) error {
+	st, err := r.load(ctx, id)
+	if err != nil {
+		return err
+	}
+	if st == nil {
+		st = &state{Saga: s.Name, Steps: len(s.Steps), Status: running}
+	} else if st.Saga != s.Name || st.Steps != len(s.Steps) {
+		return fmt.Errorf("%w: run %q is of saga %q with %d steps", ErrMismatch, id, st.Saga, st.Steps)
+	} else if st.Status == stuck {
+		st.Status, st.CompensationErr = compensating, ""
+	}
+
+	var stepErr error // as returned by the step, when it fails in this run
+	for st.Status == running && st.Next < len(s.Steps) {
+		step := s.Steps[st.Next]
+		if err := txkv.Retry(ctx, r.opts.Retry, step.Do); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err() // not the step's fault, resume later
+			}
+			stepErr = err
+			st.Status, st.FailedStep, st.Err = compensating, step.Name, err.Error()
+		} else {
+			st.Next++
+		}
+		if err := r.save(ctx, id, st); err != nil {
+			return err
+		}
+	}
+	if st.Status == running {
+		st.Status = done
+		return r.save(ctx, id, st)
+	}
+
+	for st.Status == compensating && st.Next > 0 {
+		step := s.Steps[st.Next-1]
+		if step.Compensate != nil {
+			if err := txkv.Retry(ctx, r.opts.Retry, step.Compensate); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				st.Status, st.CompensationErr = stuck, fmt.Sprintf("compensating %q: %v", step.Name, err)
+				if err := r.save(ctx, id, st); err != nil {
+					return err
+				}
+				break
+			}
+		}
+		st.Next--
+		if err := r.save(ctx, id, st); err != nil {
+			return err
+		}
+	}
+	if st.Status == compensating {
+		st.Status = compensated
+		if err := r.save(ctx, id, st); err != nil {
+			return err
+		}
+	}
+	return st.err(stepErr)
+}
+
+// err returns the outcome of a finished run, whose step failed with
+// `stepErr` if it failed in this run.
+func (st *state) err(stepErr error) error {
+	if st.Status == done {
+		return nil
+	}
+	if stepErr == nil {
+		stepErr = errors.New(st.Err)
+	}
+	e := &Error{Step: st.FailedStep, Err: stepErr, Compensated: st.Status == compensated}
+	if st.CompensationErr != "" {
+		e.CompensationErr = errors.New(st.CompensationErr)
+	}
+	return e
+}
+
+func (r *Runner) load(ctx context.Context, id string) (*state, error) {
+	data, ok, err := r.kv.Get(ctx, r.key(id))
+	if err != nil || !ok {
+		return nil, err
+	}
+	var st state
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("saga: can't decode the state of run %q: %w", id, err)
+	}
+	return &st, nil
+}
+
+func (r *Runner) save(ctx context.Context, id string, st *state) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return r.kv.Put(ctx, r.key(id), data)
+}
+
+func (r *Runner) key(id string) txkv.Key {
+	return append(append(txkv.Key(nil), r.opts.StatePrefix...), id...)
+}
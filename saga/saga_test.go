@@ -0,0 +1,121 @@
+package saga_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aybabtme/txkv"
+	"github.com/aybabtme/txkv/saga"
+)
+
+var fastRetries = txkv.RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   time.Microsecond,
+	MaxDelay:    time.Millisecond,
+	Retryable:   func(error) bool { return true },
+}
+
+// recorder makes steps that log what they do.
+type recorder struct {
+	log   []string
+	fails map[string]int // how many times an action fails
+}
+
+func (r *recorder) action(name string) func(context.Context) error {
+	return func(ctx context.Context) error {
+		if r.fails[name] > 0 {
+			r.fails[name]--
+			return errors.New(name + " failed")
+		}
+		r.log = append(r.log, name)
+		return nil
+	}
+}
+
+func (r *recorder) saga() saga.Saga {
+	var steps []saga.Step
+	for _, name := range []string{"reserve", "charge", "ship"} {
+		steps = append(steps, saga.Step{
+			Name:       name,
+			Do:         r.action(name),
+			Compensate: r.action("undo " + name),
+		})
+	}
+	return saga.Saga{Name: "order", Steps: steps}
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	runner := saga.NewRunner(txkv.InMem(), saga.Options{Retry: fastRetries})
+
+	// transient failures are retried
+	rec := &recorder{fails: map[string]int{"charge": 2}}
+	require.NoError(t, runner.Run(ctx, "1", rec.saga()))
+	require.Equal(t, []string{"reserve", "charge", "ship"}, rec.log)
+
+	// finished runs aren't run again
+	require.NoError(t, runner.Run(ctx, "1", rec.saga()))
+	require.Len(t, rec.log, 3)
+
+	// persistent failures are compensated
+	rec = &recorder{fails: map[string]int{"ship": 10}}
+	err := runner.Run(ctx, "2", rec.saga())
+	var serr *saga.Error
+	require.ErrorAs(t, err, &serr)
+	require.Equal(t, "ship", serr.Step)
+	require.True(t, serr.Compensated)
+	require.Equal(t, []string{"reserve", "charge", "undo charge", "undo reserve"}, rec.log)
+
+	// and the outcome is remembered
+	err = runner.Run(ctx, "2", rec.saga())
+	require.ErrorAs(t, err, &serr)
+	require.Equal(t, "ship", serr.Step)
+	require.Len(t, rec.log, 4)
+
+	err = runner.Run(ctx, "2", saga.Saga{Name: "other"})
+	require.ErrorIs(t, err, saga.ErrMismatch)
+}
+
+func TestRunStuck(t *testing.T) {
+	ctx := context.Background()
+	runner := saga.NewRunner(txkv.InMem(), saga.Options{Retry: fastRetries})
+
+	rec := &recorder{fails: map[string]int{"ship": 10, "undo charge": 10}}
+	err := runner.Run(ctx, "1", rec.saga())
+	var serr *saga.Error
+	require.ErrorAs(t, err, &serr)
+	require.False(t, serr.Compensated)
+	require.Error(t, serr.CompensationErr)
+	require.Equal(t, []string{"reserve", "charge"}, rec.log)
+
+	// once fixed, running again finishes compensating
+	rec.fails["undo charge"] = 0
+	err = runner.Run(ctx, "1", rec.saga())
+	require.ErrorAs(t, err, &serr)
+	require.True(t, serr.Compensated)
+	require.Equal(t, []string{"reserve", "charge", "undo charge", "undo reserve"}, rec.log)
+}
+
+func TestRunResumes(t *testing.T) {
+	kv := txkv.InMem()
+	runner := saga.NewRunner(kv, saga.Options{Retry: fastRetries})
+	rec := &recorder{}
+	s := rec.saga()
+
+	// the run is interrupted while charging
+	ctx, cancel := context.WithCancel(context.Background())
+	s.Steps[1].Do = func(context.Context) error {
+		cancel()
+		return ctx.Err()
+	}
+	require.ErrorIs(t, runner.Run(ctx, "1", s), context.Canceled)
+	require.Equal(t, []string{"reserve"}, rec.log)
+
+	// resuming picks up at the step it was at
+	require.NoError(t, saga.NewRunner(kv, saga.Options{Retry: fastRetries}).Run(context.Background(), "1", rec.saga()))
+	require.Equal(t, []string{"reserve", "charge", "ship"}, rec.log)
+}
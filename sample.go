@@ -0,0 +1,65 @@
+package txkv
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sort"
+)
+
+var errNegativeSample = errors.New("txkv: can't sample a negative number of keys")
+
+// Sampler is implemented by stores that can pick random keys without listing
+// all of them.
+type Sampler interface {
+	// Sample returns up to `n` distinct keys with `prefix`, picked uniformly
+	// at random, in key order. A negative `n` is an error.
+	Sample(ctx context.Context, prefix Key, n int) ([]Key, error)
+}
+
+// Sample returns up to `n` distinct keys of `kv` with `prefix`, picked
+// uniformly at random, in key order. All the keys are returned if there are
+// `n` or less. It's useful for cache warming, consistency spot checks and
+// analytics.
+//
+// Stores that are a Sampler pick keys efficiently, others have the prefix
+// listed.
+func Sample(ctx context.Context, kv KV, prefix Key, n int) ([]Key, error) {
+	if n < 0 {
+		return nil, errNegativeSample
+	}
+	if s, ok := kv.(Sampler); ok {
+		return s.Sample(ctx, prefix, n)
+	}
+	keys, err := kv.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if n >= len(keys) {
+		return keys, nil
+	}
+	sample := make([]Key, 0, n)
+	for _, i := range sampleIndexes(len(keys), n) {
+		sample = append(sample, keys[i])
+	}
+	return sample, nil
+}
+
+// sampleIndexes picks `n` distinct integers in [0, total), in increasing
+// order, using Floyd's algorithm.
+func sampleIndexes(total, n int) []int {
+	picked := make(map[int]struct{}, n)
+	for j := total - n; j < total; j++ {
+		i := rand.Intn(j + 1)
+		if _, ok := picked[i]; ok {
+			i = j
+		}
+		picked[i] = struct{}{}
+	}
+	indexes := make([]int, 0, n)
+	for i := range picked {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
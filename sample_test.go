@@ -0,0 +1,70 @@
+package txkv_test
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestSample(t *testing.T) {
+	ctx := context.Background()
+	kvs := map[string]TransactionalKV{
+		"inmem":   InMem(),
+		"wrapped": WithMaintenance(InMem()),
+	}
+	for name, kv := range kvs {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 20; i++ {
+				mustPut(ctx, t, kv, Key(fmt.Sprintf("p/%02d", i)), Value("v"))
+			}
+			mustPut(ctx, t, kv, Key("o"), Value("v"))
+			mustPut(ctx, t, kv, Key("q"), Value("v"))
+
+			// every key is about as likely to be picked
+			seen := make(map[string]int)
+			for i := 0; i < 2000; i++ {
+				keys, err := Sample(ctx, kv, Key("p/"), 5)
+				require.NoError(t, err)
+				require.Len(t, keys, 5)
+				require.True(t, sort.SliceIsSorted(keys, func(i, j int) bool {
+					return string(keys[i]) < string(keys[j])
+				}))
+				for j, key := range keys {
+					require.Regexp(t, `^p/\d\d$`, string(key))
+					if j > 0 {
+						require.NotEqual(t, keys[j-1], key)
+					}
+					seen[string(key)]++
+				}
+			}
+			require.Len(t, seen, 20)
+			for key, n := range seen {
+				// expecting 500
+				require.InDelta(t, 500, n, 150, key)
+			}
+
+			keys, err := Sample(ctx, kv, Key("p/1"), 50)
+			require.NoError(t, err)
+			require.Len(t, keys, 10)
+
+			keys, err = Sample(ctx, kv, Key("nothing"), 5)
+			require.NoError(t, err)
+			require.Empty(t, keys)
+
+			keys, err = Sample(ctx, kv, Key("p/"), 0)
+			require.NoError(t, err)
+			require.Empty(t, keys)
+			_, err = Sample(ctx, kv, Key("p/"), -1)
+			require.Error(t, err)
+			if s, ok := kv.(Sampler); ok {
+				_, err = s.Sample(ctx, Key("p/"), -1)
+				require.Error(t, err)
+			}
+		})
+	}
+}
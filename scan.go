@@ -0,0 +1,129 @@
+package txkv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+)
+
+// Iterator walks over key-value pairs in key order:
+//
+//	for it.Next(ctx) {
+//		use(it.Key(), it.Value())
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//	it.Close()
+type Iterator interface {
+	// Next advances to the next pair, returning false when there are none
+	// left or an error occurred.
+	Next(ctx context.Context) bool
+	Key() Key
+	Value() Value
+	// Err returns the error that stopped the iteration, if any.
+	Err() error
+	// Close releases the resources held by the iterator.
+	Close() error
+}
+
+// Partitioner is implemented by stores that know how to split a key range
+// into partitions of about the same size.
+type Partitioner interface {
+	// ScanPartitions returns up to `n` iterators over disjoint, consecutive
+	// ranges covering the keys with `prefix`.
+	ScanPartitions(ctx context.Context, prefix Key, n int) ([]Iterator, error)
+}
+
+// ScanPartitions splits the keys of `kv` with `prefix` into up to `n`
+// disjoint iterators of about the same size, to process large scans with a
+// pool of workers. Stores that are a Partitioner split the range themselves,
+// others have the prefix listed once and split on the keys found.
+//
+// Iterators read values lazily: keys deleted after the split are skipped, and
+// values are as of when they're reached.
+func ScanPartitions(ctx context.Context, kv KV, prefix Key, n int) ([]Iterator, error) {
+	if n <= 0 {
+		return nil, errors.New("txkv: need at least one partition")
+	}
+	if p, ok := kv.(Partitioner); ok {
+		return p.ScanPartitions(ctx, prefix, n)
+	}
+	keys, err := kv.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	return splitKeys(kv, keys, n), nil
+}
+
+// splitKeys returns up to `n` iterators over consecutive slices of `keys`,
+// getting their values from `kv`.
+func splitKeys(kv KV, keys []Key, n int) []Iterator {
+	if n > len(keys) {
+		n = len(keys)
+	}
+	if n <= 0 {
+		return []Iterator{&keysIterator{}}
+	}
+	its := make([]Iterator, 0, n)
+	for i := 0; i < n; i++ {
+		lo, hi := i*len(keys)/n, (i+1)*len(keys)/n
+		its = append(its, &keysIterator{kv: kv, keys: keys[lo:hi]})
+	}
+	return its
+}
+
+// keysIterator gets the values of a list of keys.
+type keysIterator struct {
+	kv    KV
+	keys  []Key
+	key   Key
+	value Value
+	err   error
+}
+
+func (it *keysIterator) Next(ctx context.Context) bool {
+	for it.err == nil && len(it.keys) > 0 {
+		key := it.keys[0]
+		it.keys = it.keys[1:]
+		v, ok, err := it.kv.Get(ctx, key)
+		if err != nil {
+			it.err = err
+			break
+		}
+		if ok {
+			it.key, it.value = key, v
+			return true
+		}
+	}
+	it.key, it.value = nil, nil
+	return false
+}
+
+func (it *keysIterator) Key() Key     { return it.key }
+func (it *keysIterator) Value() Value { return it.value }
+func (it *keysIterator) Err() error   { return it.err }
+func (it *keysIterator) Close() error { it.keys = nil; return nil }
+
+// PrefixSuccessor returns the smallest key greater than all the keys with
+// `prefix`, or nil if there's none, as when `prefix` is empty or all 0xff.
+// The keys with `prefix` are those in [prefix, PrefixSuccessor(prefix)).
+func PrefixSuccessor(prefix Key) Key {
+	end := append(Key(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// keySuccessor returns the smallest key greater than `key`.
+func keySuccessor(key Key) Key {
+	return append(append(Key(nil), key...), 0)
+}
+
+func inRange(key, end Key) bool {
+	return end == nil || bytes.Compare(key, end) < 0
+}
@@ -0,0 +1,92 @@
+package txkv_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestScanPartitions(t *testing.T) {
+	ctx := context.Background()
+	kvs := map[string]TransactionalKV{
+		"inmem":   InMem(),
+		"wrapped": WithMaintenance(InMem()),
+	}
+	for name, kv := range kvs {
+		t.Run(name, func(t *testing.T) {
+			var want []Key
+			for i := 0; i < 100; i++ {
+				key := Key(fmt.Sprintf("p/%03d", i))
+				mustPut(ctx, t, kv, key, Value(fmt.Sprint(i)))
+				want = append(want, key)
+			}
+			mustPut(ctx, t, kv, Key("o/before"), Value("v"))
+			mustPut(ctx, t, kv, Key("q/after"), Value("v"))
+
+			its, err := ScanPartitions(ctx, kv, Key("p/"), 8)
+			require.NoError(t, err)
+			require.Len(t, its, 8)
+
+			// partitions are disjoint, balanced and in order
+			parts := make([][]Key, len(its))
+			var wg sync.WaitGroup
+			for i, it := range its {
+				wg.Add(1)
+				go func(i int, it Iterator) {
+					defer wg.Done()
+					for it.Next(ctx) {
+						parts[i] = append(parts[i], it.Key())
+					}
+				}(i, it)
+			}
+			wg.Wait()
+			for _, it := range its {
+				require.NoError(t, it.Err())
+				require.NoError(t, it.Close())
+			}
+			var got []Key
+			for _, part := range parts {
+				require.InDelta(t, 100/8, len(part), 1)
+				got = append(got, part...)
+			}
+			require.Equal(t, want, got)
+
+			// no more partitions than keys
+			its, err = ScanPartitions(ctx, kv, Key("q/"), 4)
+			require.NoError(t, err)
+			require.Len(t, its, 1)
+			require.True(t, its[0].Next(ctx))
+			require.Equal(t, Key("q/after"), its[0].Key())
+			require.Equal(t, Value("v"), its[0].Value())
+			require.False(t, its[0].Next(ctx))
+
+			its, err = ScanPartitions(ctx, kv, Key("nothing/"), 4)
+			require.NoError(t, err)
+			require.Len(t, its, 1)
+			require.False(t, its[0].Next(ctx))
+
+			_, err = ScanPartitions(ctx, kv, Key("p/"), 0)
+			require.Error(t, err)
+
+			// keys deleted after the split are skipped
+			its, err = ScanPartitions(ctx, kv, Key(""), 1)
+			require.NoError(t, err)
+			mustDelete(ctx, t, kv, Key("o/before"))
+			require.True(t, its[0].Next(ctx))
+			require.Equal(t, Key("p/000"), its[0].Key())
+		})
+	}
+}
+
+func TestPrefixSuccessor(t *testing.T) {
+	require.Equal(t, Key("b"), PrefixSuccessor(Key("a")))
+	require.Equal(t, Key("b"), PrefixSuccessor(Key("a\xff")))
+	require.Equal(t, Key("a\x01"), PrefixSuccessor(Key("a\x00")))
+	require.Nil(t, PrefixSuccessor(Key("\xff\xff")))
+	require.Nil(t, PrefixSuccessor(Key("")))
+}
@@ -0,0 +1,190 @@
+package txkv
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TxPriority is the priority of a transaction's commit.
+type TxPriority int
+
+// Common priorities. Any int works: greater ones commit first.
+const (
+	PriorityLow    TxPriority = -1
+	PriorityNormal TxPriority = 0
+	PriorityHigh   TxPriority = 1
+)
+
+// TxOptions tune a transaction.
+type TxOptions struct {
+	Priority TxPriority
+}
+
+// TxBeginner is implemented by stores whose transactions take options.
+type TxBeginner interface {
+	BeginTx(ctx context.Context, opts TxOptions) (TxKV, error)
+}
+
+// BeginTx begins a transaction on `kv` with `opts`, which are ignored by
+// stores that aren't a TxBeginner.
+func BeginTx(ctx context.Context, kv TransactionalKV, opts TxOptions) (TxKV, error) {
+	if b, ok := kv.(TxBeginner); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return kv.Begin(ctx)
+}
+
+// CommitSchedulerOptions tune WithCommitScheduler.
+type CommitSchedulerOptions struct {
+	// Concurrency is the number of commits let through at once. Defaults
+	// to 1.
+	Concurrency int
+	// Aging is how long a commit waits to gain a level of priority, so that
+	// none waits forever behind a stream of commits of higher priority.
+	// Defaults to 10ms.
+	Aging time.Duration
+}
+
+// WithCommitScheduler returns a TransactionalKV letting at most Concurrency
+// commits reach `kv` at once, and ordering those waiting by priority, set
+// with BeginTx. Waiting raises the priority of a commit, so a large
+// transaction of low priority isn't starved by a stream of small ones, nor
+// the other way around. Between commits of the same priority, the first
+// to wait goes first. Begin starts transactions of PriorityNormal.
+func WithCommitScheduler(kv TransactionalKV, opts CommitSchedulerOptions) TransactionalKV {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	if opts.Aging <= 0 {
+		opts.Aging = 10 * time.Millisecond
+	}
+	return &scheduledKV{kv: kv, opts: opts}
+}
+
+type scheduledKV struct {
+	kv   TransactionalKV
+	opts CommitSchedulerOptions
+
+	mu      sync.Mutex
+	running int
+	waiting []*commitWaiter
+}
+
+type commitWaiter struct {
+	priority TxPriority
+	since    time.Time
+	ready    chan struct{}
+}
+
+func (s *scheduledKV) Put(ctx context.Context, key Key, value Value) error {
+	return s.kv.Put(ctx, key, value)
+}
+
+func (s *scheduledKV) Get(ctx context.Context, key Key) (Value, bool, error) {
+	return s.kv.Get(ctx, key)
+}
+
+func (s *scheduledKV) Delete(ctx context.Context, key Key) error {
+	return s.kv.Delete(ctx, key)
+}
+
+func (s *scheduledKV) List(ctx context.Context, prefix Key) ([]Key, error) {
+	return s.kv.List(ctx, prefix)
+}
+
+func (s *scheduledKV) Begin(ctx context.Context) (TxKV, error) {
+	return s.BeginTx(ctx, TxOptions{})
+}
+
+func (s *scheduledKV) BeginTx(ctx context.Context, opts TxOptions) (TxKV, error) {
+	tx, err := BeginTx(ctx, s.kv, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &scheduledTx{tx: tx, s: s, priority: opts.Priority}, nil
+}
+
+// acquire waits for a commit slot.
+func (s *scheduledKV) acquire(ctx context.Context, priority TxPriority) error {
+	s.mu.Lock()
+	if s.running < s.opts.Concurrency && len(s.waiting) == 0 {
+		s.running++
+		s.mu.Unlock()
+		return nil
+	}
+	w := &commitWaiter{priority: priority, since: time.Now(), ready: make(chan struct{})}
+	s.waiting = append(s.waiting, w)
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		for i, other := range s.waiting {
+			if other == w {
+				s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+				s.mu.Unlock()
+				return ctx.Err()
+			}
+		}
+		s.mu.Unlock()
+		// it was handed the slot meanwhile, pass it on
+		s.release()
+		return ctx.Err()
+	}
+}
+
+// release hands the slot to the waiting commit of highest priority, aged.
+func (s *scheduledKV) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.waiting) == 0 {
+		s.running--
+		return
+	}
+	now := time.Now()
+	best, bestPriority := 0, int64(0)
+	for i, w := range s.waiting {
+		p := int64(w.priority) + int64(now.Sub(w.since)/s.opts.Aging)
+		if i == 0 || p > bestPriority {
+			best, bestPriority = i, p
+		}
+	}
+	w := s.waiting[best]
+	s.waiting = append(s.waiting[:best], s.waiting[best+1:]...)
+	close(w.ready)
+}
+
+type scheduledTx struct {
+	tx       TxKV
+	s        *scheduledKV
+	priority TxPriority
+}
+
+func (t *scheduledTx) Put(ctx context.Context, key Key, value Value) error {
+	return t.tx.Put(ctx, key, value)
+}
+
+func (t *scheduledTx) Get(ctx context.Context, key Key) (Value, bool, error) {
+	return t.tx.Get(ctx, key)
+}
+
+func (t *scheduledTx) Delete(ctx context.Context, key Key) error {
+	return t.tx.Delete(ctx, key)
+}
+
+func (t *scheduledTx) List(ctx context.Context, prefix Key) ([]Key, error) {
+	return t.tx.List(ctx, prefix)
+}
+
+func (t *scheduledTx) Rollback(ctx context.Context) error { return t.tx.Rollback(ctx) }
+
+func (t *scheduledTx) Commit(ctx context.Context) error {
+	if err := t.s.acquire(ctx, t.priority); err != nil {
+		return err
+	}
+	defer t.s.release()
+	return t.tx.Commit(ctx)
+}
@@ -0,0 +1,117 @@
+package txkv_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+// gatedKV records the order of commits, each held until the gate lets it
+// through.
+type gatedKV struct {
+	TransactionalKV
+	gate chan struct{}
+
+	mu      sync.Mutex
+	commits []string
+}
+
+func (g *gatedKV) Begin(ctx context.Context) (TxKV, error) {
+	tx, err := g.TransactionalKV.Begin(ctx)
+	return &gatedTx{TxKV: tx, g: g}, err
+}
+
+type gatedTx struct {
+	TxKV
+	g    *gatedKV
+	name string
+}
+
+func (t *gatedTx) Put(ctx context.Context, key Key, value Value) error {
+	t.name = string(key)
+	return t.TxKV.Put(ctx, key, value)
+}
+
+func (t *gatedTx) Commit(ctx context.Context) error {
+	t.g.mu.Lock()
+	t.g.commits = append(t.g.commits, t.name)
+	t.g.mu.Unlock()
+	<-t.g.gate
+	return t.TxKV.Commit(ctx)
+}
+
+// commitInBackground commits a transaction named `name`, and waits a bit for
+// it to be queued.
+func commitInBackground(t *testing.T, wg *sync.WaitGroup, kv TransactionalKV, name string, priority TxPriority) {
+	ctx := context.Background()
+	tx, err := BeginTx(ctx, kv, TxOptions{Priority: priority})
+	require.NoError(t, err)
+	require.NoError(t, tx.Put(ctx, Key(name), Value("v")))
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := tx.Commit(ctx); err != nil {
+			t.Error(err)
+		}
+	}()
+	time.Sleep(20 * time.Millisecond)
+}
+
+func TestCommitSchedulerPriority(t *testing.T) {
+	inner := &gatedKV{TransactionalKV: InMem(), gate: make(chan struct{})}
+	kv := WithCommitScheduler(inner, CommitSchedulerOptions{Aging: time.Hour})
+
+	var wg sync.WaitGroup
+	commitInBackground(t, &wg, kv, "first", PriorityLow)
+	commitInBackground(t, &wg, kv, "low", PriorityLow)
+	commitInBackground(t, &wg, kv, "normal", PriorityNormal)
+	commitInBackground(t, &wg, kv, "high", PriorityHigh)
+	for i := 0; i < 4; i++ {
+		inner.gate <- struct{}{}
+	}
+	wg.Wait()
+	require.Equal(t, []string{"first", "high", "normal", "low"}, inner.commits)
+	mustFind(context.Background(), t, kv, Key("low"), Value("v"))
+}
+
+func TestCommitSchedulerAging(t *testing.T) {
+	inner := &gatedKV{TransactionalKV: InMem(), gate: make(chan struct{})}
+	kv := WithCommitScheduler(inner, CommitSchedulerOptions{Aging: time.Millisecond})
+
+	var wg sync.WaitGroup
+	commitInBackground(t, &wg, kv, "first", PriorityNormal)
+	commitInBackground(t, &wg, kv, "old-low", PriorityLow)
+	commitInBackground(t, &wg, kv, "new-high", PriorityHigh)
+	for i := 0; i < 3; i++ {
+		inner.gate <- struct{}{}
+	}
+	wg.Wait()
+	require.Equal(t, []string{"first", "old-low", "new-high"}, inner.commits)
+}
+
+func TestCommitSchedulerCancel(t *testing.T) {
+	inner := &gatedKV{TransactionalKV: InMem(), gate: make(chan struct{})}
+	kv := WithCommitScheduler(inner, CommitSchedulerOptions{})
+
+	var wg sync.WaitGroup
+	commitInBackground(t, &wg, kv, "first", PriorityNormal)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	require.ErrorIs(t, tx.Commit(ctx), context.DeadlineExceeded)
+
+	inner.gate <- struct{}{}
+	wg.Wait()
+	// the slot is free again
+	commitInBackground(t, &wg, kv, "second", PriorityNormal)
+	inner.gate <- struct{}{}
+	wg.Wait()
+	require.Equal(t, []string{"first", "second"}, inner.commits)
+}
@@ -0,0 +1,120 @@
+// Package set implements sets and sorted sets on a txkv store, like those of
+// Redis but durable, on any backend.
+//
+// A set lives under a prefix of the store, its members being keys built
+// with txkv.KeyOf, so that they sort bytewise. Mutations are made within a
+// transaction, so they can be committed along with other writes; reads are
+// made on any store, or transaction. Mutations read members and write them
+// back: for concurrent ones not to overwrite each other, the store must fail
+// transactions that read a key written since, with an error matching
+// txkv.ErrConflict, like InMem, Disk and txkvbadger do, or serialize them,
+// like txkvbolt does. Run them with txkv.RetryTx to retry those conflicts.
+package set
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aybabtme/txkv"
+)
+
+// Set is a set of byte strings.
+type Set struct {
+	prefix txkv.Key
+}
+
+// New returns the set stored under `prefix`, which must hold nothing else.
+func New(prefix txkv.Key) *Set {
+	return &Set{prefix: prefix}
+}
+
+func (s *Set) key(member []byte) txkv.Key {
+	return appendKey(s.prefix, txkv.KeyOf(member))
+}
+
+// Add adds `members` to the set as part of `tx`, and returns how many weren't
+// in it yet.
+func (s *Set) Add(ctx context.Context, tx txkv.TxKV, members ...[]byte) (int, error) {
+	added := 0
+	for _, member := range members {
+		key := s.key(member)
+		_, ok, err := tx.Get(ctx, key)
+		if err != nil {
+			return added, err
+		}
+		if ok {
+			continue
+		}
+		if err := tx.Put(ctx, key, txkv.Value{}); err != nil {
+			return added, err
+		}
+		added++
+	}
+	return added, nil
+}
+
+// Remove removes `members` from the set as part of `tx`, and returns how many
+// were in it.
+func (s *Set) Remove(ctx context.Context, tx txkv.TxKV, members ...[]byte) (int, error) {
+	removed := 0
+	for _, member := range members {
+		key := s.key(member)
+		_, ok, err := tx.Get(ctx, key)
+		if err != nil {
+			return removed, err
+		}
+		if !ok {
+			continue
+		}
+		if err := tx.Delete(ctx, key); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// Contains tells whether `member` is in the set.
+func (s *Set) Contains(ctx context.Context, kv txkv.KV, member []byte) (bool, error) {
+	_, ok, err := kv.Get(ctx, s.key(member))
+	return ok, err
+}
+
+// Members returns the members of the set, in bytewise order.
+func (s *Set) Members(ctx context.Context, kv txkv.KV) ([][]byte, error) {
+	keys, err := kv.List(ctx, s.prefix)
+	if err != nil {
+		return nil, err
+	}
+	members := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		segments, err := parseKey(s.prefix, key, 1)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, segments[0].Value.([]byte))
+	}
+	return members, nil
+}
+
+// Len returns the number of members of the set.
+func (s *Set) Len(ctx context.Context, kv txkv.KV) (int, error) {
+	keys, err := kv.List(ctx, s.prefix)
+	return len(keys), err
+}
+
+func appendKey(prefix, key txkv.Key) txkv.Key {
+	return append(append(txkv.Key(nil), prefix...), key...)
+}
+
+// parseKey parses the `n` segments of `key` after `prefix`.
+func parseKey(prefix, key txkv.Key, n int) ([]txkv.Segment, error) {
+	segments, err := txkv.ParseKey(key[len(prefix):])
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) != n {
+		return nil, fmt.Errorf("%w: %q isn't a key of a set", txkv.ErrMalformedKey, key)
+	}
+	return segments, nil
+}
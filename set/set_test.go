@@ -0,0 +1,74 @@
+package set_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aybabtme/txkv"
+	"github.com/aybabtme/txkv/set"
+)
+
+// inTx runs `fn` in a transaction of `kv`, and commits it.
+func inTx(t *testing.T, kv txkv.TransactionalKV, fn func(tx txkv.TxKV)) {
+	t.Helper()
+	ctx := context.Background()
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	fn(tx)
+	require.NoError(t, tx.Commit(ctx))
+}
+
+func members(ms ...string) [][]byte {
+	out := make([][]byte, 0, len(ms))
+	for _, m := range ms {
+		out = append(out, []byte(m))
+	}
+	return out
+}
+
+func TestSet(t *testing.T) {
+	ctx := context.Background()
+	kv := txkv.InMem()
+	tags := set.New(txkv.Key("tags/"))
+	other := set.New(txkv.Key("labels/"))
+
+	inTx(t, kv, func(tx txkv.TxKV) {
+		n, err := tags.Add(ctx, tx, members("go", "kv", "go", "db")...)
+		require.NoError(t, err)
+		require.Equal(t, 3, n)
+		n, err = other.Add(ctx, tx, members("unrelated")...)
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+	})
+
+	ok, err := tags.Contains(ctx, kv, []byte("kv"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = tags.Contains(ctx, kv, []byte("rust"))
+	require.NoError(t, err)
+	require.False(t, ok)
+	got, err := tags.Members(ctx, kv)
+	require.NoError(t, err)
+	require.Equal(t, members("db", "go", "kv"), got)
+
+	inTx(t, kv, func(tx txkv.TxKV) {
+		n, err := tags.Remove(ctx, tx, members("kv", "rust")...)
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+	})
+	n, err := tags.Len(ctx, kv)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	// mutations are rolled back with their transaction
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	_, err = tags.Add(ctx, tx, []byte("rolled back"))
+	require.NoError(t, err)
+	require.NoError(t, tx.Rollback(ctx))
+	n, err = tags.Len(ctx, kv)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+}
@@ -0,0 +1,170 @@
+package set
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/aybabtme/txkv"
+)
+
+// ErrNaN is returned when scoring a member with NaN, which has no order.
+var ErrNaN = errors.New("set: score is NaN")
+
+// Scored is a member of a sorted set, with its score.
+type Scored struct {
+	Member []byte
+	Score  float64
+}
+
+// SortedSet is a set of byte strings ordered by score, then bytewise.
+//
+// Each member is stored twice: with its score, to look it up, and in an index
+// ordered by score, to range over scores.
+type SortedSet struct {
+	prefix txkv.Key
+}
+
+// NewSorted returns the sorted set stored under `prefix`, which must hold
+// nothing else.
+func NewSorted(prefix txkv.Key) *SortedSet {
+	return &SortedSet{prefix: prefix}
+}
+
+func (s *SortedSet) memberKey(member []byte) txkv.Key {
+	return appendKey(s.prefix, txkv.KeyOf("m", member))
+}
+
+func (s *SortedSet) scoreKey(score float64, member []byte) txkv.Key {
+	return appendKey(s.prefix, txkv.KeyOf("s", sortableScore(score), member))
+}
+
+// sortableScore maps scores to integers in the same order. -0 is mapped
+// like 0, as it's equal to it.
+func sortableScore(score float64) uint64 {
+	if score == 0 {
+		score = 0
+	}
+	bits := math.Float64bits(score)
+	if bits&(1<<63) != 0 {
+		return ^bits
+	}
+	return bits | 1<<63
+}
+
+func unsortableScore(u uint64) float64 {
+	if u&(1<<63) != 0 {
+		return math.Float64frombits(u &^ (1 << 63))
+	}
+	return math.Float64frombits(^u)
+}
+
+// Add sets the score of `member` as part of `tx`, adding it if it wasn't in
+// the set, and tells whether it was added.
+func (s *SortedSet) Add(ctx context.Context, tx txkv.TxKV, member []byte, score float64) (bool, error) {
+	if math.IsNaN(score) {
+		return false, ErrNaN
+	}
+	if score == 0 {
+		score = 0 // not -0
+	}
+	old, ok, err := s.Score(ctx, tx, member)
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		if old == score {
+			return false, nil
+		}
+		if err := tx.Delete(ctx, s.scoreKey(old, member)); err != nil {
+			return false, err
+		}
+	}
+	if err := tx.Put(ctx, s.memberKey(member), binary.BigEndian.AppendUint64(nil, math.Float64bits(score))); err != nil {
+		return false, err
+	}
+	return !ok, tx.Put(ctx, s.scoreKey(score, member), txkv.Value{})
+}
+
+// IncrBy adds `delta` to the score of `member` as part of `tx`, adding it with
+// a score of `delta` if it wasn't in the set, and returns its new score.
+func (s *SortedSet) IncrBy(ctx context.Context, tx txkv.TxKV, member []byte, delta float64) (float64, error) {
+	score, _, err := s.Score(ctx, tx, member)
+	if err != nil {
+		return 0, err
+	}
+	score += delta
+	_, err = s.Add(ctx, tx, member, score)
+	return score, err
+}
+
+// Remove removes `member` from the set as part of `tx`, and tells whether it
+// was in it.
+func (s *SortedSet) Remove(ctx context.Context, tx txkv.TxKV, member []byte) (bool, error) {
+	score, ok, err := s.Score(ctx, tx, member)
+	if err != nil || !ok {
+		return false, err
+	}
+	if err := tx.Delete(ctx, s.memberKey(member)); err != nil {
+		return false, err
+	}
+	return true, tx.Delete(ctx, s.scoreKey(score, member))
+}
+
+// Score returns the score of `member`, if it's in the set.
+func (s *SortedSet) Score(ctx context.Context, kv txkv.KV, member []byte) (float64, bool, error) {
+	v, ok, err := kv.Get(ctx, s.memberKey(member))
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	if len(v) != 8 {
+		return 0, false, fmt.Errorf("set: malformed score of member %q", member)
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(v)), true, nil
+}
+
+// Len returns the number of members of the set.
+func (s *SortedSet) Len(ctx context.Context, kv txkv.KV) (int, error) {
+	keys, err := kv.List(ctx, appendKey(s.prefix, txkv.KeyOf("m")))
+	return len(keys), err
+}
+
+// RangeByScore returns up to `limit` members scored within [min, max], in
+// order, or all of them if `limit` is 0. Stores that are a
+// txkv.LimitedLister only list the members returned.
+func (s *SortedSet) RangeByScore(ctx context.Context, kv txkv.KV, min, max float64, limit int) ([]Scored, error) {
+	if math.IsNaN(min) || math.IsNaN(max) {
+		return nil, ErrNaN
+	}
+	indexPrefix := appendKey(s.prefix, txkv.KeyOf("s"))
+	// the members scored `min` sort after it
+	after := appendKey(s.prefix, txkv.KeyOf("s", sortableScore(min)))
+	var out []Scored
+	for {
+		page := 256
+		if limit > 0 && limit-len(out) < page {
+			page = limit - len(out)
+		}
+		res, err := txkv.ListWithOptions(ctx, kv, indexPrefix, txkv.ListOptions{Limit: page, After: after})
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range res.Keys {
+			segments, err := parseKey(s.prefix, key, 3)
+			if err != nil {
+				return nil, err
+			}
+			score := unsortableScore(segments[1].Value.(uint64))
+			if score > max {
+				return out, nil
+			}
+			out = append(out, Scored{Member: segments[2].Value.([]byte), Score: score})
+		}
+		if !res.Truncated || (limit > 0 && len(out) >= limit) {
+			return out, nil
+		}
+		after = res.Cursor
+	}
+}
@@ -0,0 +1,147 @@
+package set_test
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aybabtme/txkv"
+	"github.com/aybabtme/txkv/set"
+)
+
+func TestSortedSet(t *testing.T) {
+	ctx := context.Background()
+	kv := txkv.InMem()
+	board := set.NewSorted(txkv.Key("board/"))
+
+	inTx(t, kv, func(tx txkv.TxKV) {
+		for member, score := range map[string]float64{
+			"alice": 10, "bob": -2.5, "carol": 10, "dave": 0, "erin": math.Inf(1), "frank": -0.5,
+		} {
+			added, err := board.Add(ctx, tx, []byte(member), score)
+			require.NoError(t, err)
+			require.True(t, added)
+		}
+		// rescoring moves the member
+		added, err := board.Add(ctx, tx, []byte("dave"), 7)
+		require.NoError(t, err)
+		require.False(t, added)
+		_, err = board.Add(ctx, tx, []byte("nan"), math.NaN())
+		require.ErrorIs(t, err, set.ErrNaN)
+	})
+
+	score, ok, err := board.Score(ctx, kv, []byte("dave"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, 7.0, score)
+	n, err := board.Len(ctx, kv)
+	require.NoError(t, err)
+	require.Equal(t, 6, n)
+
+	got, err := board.RangeByScore(ctx, kv, math.Inf(-1), math.Inf(1), 0)
+	require.NoError(t, err)
+	require.Equal(t, []set.Scored{
+		{Member: []byte("bob"), Score: -2.5},
+		{Member: []byte("frank"), Score: -0.5},
+		{Member: []byte("dave"), Score: 7},
+		{Member: []byte("alice"), Score: 10},
+		{Member: []byte("carol"), Score: 10},
+		{Member: []byte("erin"), Score: math.Inf(1)},
+	}, got)
+	got, err = board.RangeByScore(ctx, kv, -0.5, 10, 3)
+	require.NoError(t, err)
+	require.Equal(t, []set.Scored{
+		{Member: []byte("frank"), Score: -0.5},
+		{Member: []byte("dave"), Score: 7},
+		{Member: []byte("alice"), Score: 10},
+	}, got)
+	got, err = board.RangeByScore(ctx, kv, 11, 100, 0)
+	require.NoError(t, err)
+	require.Empty(t, got)
+
+	inTx(t, kv, func(tx txkv.TxKV) {
+		score, err := board.IncrBy(ctx, tx, []byte("bob"), 20)
+		require.NoError(t, err)
+		require.Equal(t, 17.5, score)
+		removed, err := board.Remove(ctx, tx, []byte("erin"))
+		require.NoError(t, err)
+		require.True(t, removed)
+		removed, err = board.Remove(ctx, tx, []byte("nobody"))
+		require.NoError(t, err)
+		require.False(t, removed)
+	})
+	got, err = board.RangeByScore(ctx, kv, 10, math.Inf(1), 0)
+	require.NoError(t, err)
+	require.Equal(t, []set.Scored{
+		{Member: []byte("alice"), Score: 10},
+		{Member: []byte("carol"), Score: 10},
+		{Member: []byte("bob"), Score: 17.5},
+	}, got)
+}
+
+func TestSortedSetPages(t *testing.T) {
+	ctx := context.Background()
+	kv := txkv.InMem()
+	s := set.NewSorted(txkv.Key("z/"))
+	inTx(t, kv, func(tx txkv.TxKV) {
+		for i := 0; i < 1000; i++ {
+			_, err := s.Add(ctx, tx, []byte(fmt.Sprintf("m%04d", i)), float64(i))
+			require.NoError(t, err)
+		}
+	})
+	got, err := s.RangeByScore(ctx, kv, 100, 899, 0)
+	require.NoError(t, err)
+	require.Len(t, got, 800)
+	require.Equal(t, 899.0, got[799].Score)
+	got, err = s.RangeByScore(ctx, kv, 100, 899, 300)
+	require.NoError(t, err)
+	require.Len(t, got, 300)
+}
+
+func TestSortedSetConcurrent(t *testing.T) {
+	ctx := context.Background()
+	kv := txkv.InMem()
+	board := set.NewSorted(txkv.Key("board/"))
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := txkv.RetryTx(ctx, kv, txkv.RetryPolicy{MaxAttempts: 1000}, func(ctx context.Context, tx txkv.TxKV) error {
+				_, err := board.IncrBy(ctx, tx, []byte("alice"), 1)
+				return err
+			})
+			require.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	score, ok, err := board.Score(ctx, kv, []byte("alice"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, float64(20), score)
+	members, err := board.RangeByScore(ctx, kv, math.Inf(-1), math.Inf(1), 0)
+	require.NoError(t, err)
+	require.Equal(t, []set.Scored{{Member: []byte("alice"), Score: 20}}, members)
+}
+
+func TestSortedSetNegativeZero(t *testing.T) {
+	ctx := context.Background()
+	kv := txkv.InMem()
+	board := set.NewSorted(txkv.Key("board/"))
+	inTx(t, kv, func(tx txkv.TxKV) {
+		_, err := board.Add(ctx, tx, []byte("alice"), math.Copysign(0, -1))
+		require.NoError(t, err)
+		_, err = board.Add(ctx, tx, []byte("bob"), 0)
+		require.NoError(t, err)
+	})
+	members, err := board.RangeByScore(ctx, kv, 0, 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, []set.Scored{{Member: []byte("alice")}, {Member: []byte("bob")}}, members)
+	score, _, err := board.Score(ctx, kv, []byte("alice"))
+	require.NoError(t, err)
+	require.False(t, math.Signbit(score))
+}
@@ -0,0 +1,210 @@
+package txkv
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"sync"
+)
+
+// ShadowOptions tune a ShadowKV.
+type ShadowOptions struct {
+	// ReadSampleRate is the fraction of reads also made on the shadow store
+	// and compared. Defaults to none.
+	ReadSampleRate float64
+	// OnDivergence, if set, is called with every divergence found.
+	OnDivergence func(ctx context.Context, d Divergence)
+}
+
+// Divergence is an operation whose outcome differed on the shadow store:
+// it failed there, or read something else.
+type Divergence struct {
+	// Op is the operation, like OpGet.
+	Op string
+	// Key is the key read or written, or the prefix listed.
+	Key Key
+	// ShadowErr is the error the shadow store failed with, if it did.
+	ShadowErr error
+	// The values read, for a Get.
+	Primary, Shadow           Value
+	PrimaryFound, ShadowFound bool
+	// The keys listed, for a List.
+	PrimaryKeys, ShadowKeys []Key
+}
+
+// ShadowStats count what a ShadowKV mirrored.
+type ShadowStats struct {
+	Writes      uint64
+	Reads       uint64
+	Divergences uint64
+}
+
+// WithShadow returns a ShadowKV serving from `primary`, mirroring to
+// `shadow`.
+func WithShadow(primary, shadow TransactionalKV, opts ShadowOptions) *ShadowKV {
+	if opts.ReadSampleRate < 0 {
+		opts.ReadSampleRate = 0
+	}
+	return &ShadowKV{primary: primary, shadow: shadow, opts: opts}
+}
+
+// ShadowKV is a TransactionalKV serving from a primary store, mirroring its
+// writes and a sample of its reads to a shadow store and reporting where they
+// diverge, to try a new backend with production traffic before switching to
+// it. The shadow store should start as a copy of the primary one.
+//
+// Writes are mirrored once they succeed on the primary store, transactions
+// once they commit, as a batch. The shadow store never fails an operation:
+// its errors are reported as divergences. It's called in line, so it adds to
+// the latency of the operations it sees.
+type ShadowKV struct {
+	primary TransactionalKV
+	shadow  TransactionalKV
+	opts    ShadowOptions
+
+	mu    sync.Mutex
+	stats ShadowStats
+}
+
+// Stats returns what was mirrored so far.
+func (s *ShadowKV) Stats() ShadowStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+func (s *ShadowKV) diverged(ctx context.Context, d Divergence) {
+	s.mu.Lock()
+	s.stats.Divergences++
+	s.mu.Unlock()
+	if s.opts.OnDivergence != nil {
+		s.opts.OnDivergence(ctx, d)
+	}
+}
+
+func (s *ShadowKV) count(stat *uint64) {
+	s.mu.Lock()
+	*stat++
+	s.mu.Unlock()
+}
+
+// mirror applies `ops`, which succeeded on the primary store, to the shadow.
+func (s *ShadowKV) mirror(ctx context.Context, op string, key Key, ops []Op) {
+	s.count(&s.stats.Writes)
+	if err := Apply(ctx, s.shadow, ops); err != nil {
+		s.diverged(ctx, Divergence{Op: op, Key: key, ShadowErr: err})
+	}
+}
+
+func (s *ShadowKV) sampled() bool {
+	return s.opts.ReadSampleRate > 0 && rand.Float64() < s.opts.ReadSampleRate
+}
+
+func (s *ShadowKV) Put(ctx context.Context, key Key, value Value) error {
+	if err := s.primary.Put(ctx, key, value); err != nil {
+		return err
+	}
+	s.mirror(ctx, OpPut, key, []Op{PutOp(key, value)})
+	return nil
+}
+
+func (s *ShadowKV) Get(ctx context.Context, key Key) (Value, bool, error) {
+	v, ok, err := s.primary.Get(ctx, key)
+	if err != nil || !s.sampled() {
+		return v, ok, err
+	}
+	s.count(&s.stats.Reads)
+	sv, sok, serr := s.shadow.Get(ctx, key)
+	if serr != nil || ok != sok || !bytes.Equal(v, sv) {
+		s.diverged(ctx, Divergence{
+			Op: OpGet, Key: key, ShadowErr: serr,
+			Primary: v, PrimaryFound: ok, Shadow: sv, ShadowFound: sok,
+		})
+	}
+	return v, ok, nil
+}
+
+func (s *ShadowKV) Delete(ctx context.Context, key Key) error {
+	if err := s.primary.Delete(ctx, key); err != nil {
+		return err
+	}
+	s.mirror(ctx, OpDelete, key, []Op{DeleteOp(key)})
+	return nil
+}
+
+func (s *ShadowKV) List(ctx context.Context, prefix Key) ([]Key, error) {
+	keys, err := s.primary.List(ctx, prefix)
+	if err != nil || !s.sampled() {
+		return keys, err
+	}
+	s.count(&s.stats.Reads)
+	skeys, serr := s.shadow.List(ctx, prefix)
+	if serr != nil || !sameKeys(keys, skeys) {
+		s.diverged(ctx, Divergence{Op: OpList, Key: prefix, ShadowErr: serr, PrimaryKeys: keys, ShadowKeys: skeys})
+	}
+	return keys, nil
+}
+
+func (s *ShadowKV) Begin(ctx context.Context) (TxKV, error) {
+	tx, err := s.primary.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &shadowTx{s: s, tx: tx}, nil
+}
+
+func sameKeys(a, b []Key) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// shadowTx records its writes, to mirror them once committed. Its reads
+// aren't mirrored, as the shadow store doesn't see its writes yet.
+type shadowTx struct {
+	s   *ShadowKV
+	tx  TxKV
+	ops []Op
+}
+
+func (t *shadowTx) Put(ctx context.Context, key Key, value Value) error {
+	if err := t.tx.Put(ctx, key, value); err != nil {
+		return err
+	}
+	t.ops = append(t.ops, PutOp(key, value))
+	return nil
+}
+
+func (t *shadowTx) Get(ctx context.Context, key Key) (Value, bool, error) {
+	return t.tx.Get(ctx, key)
+}
+
+func (t *shadowTx) Delete(ctx context.Context, key Key) error {
+	if err := t.tx.Delete(ctx, key); err != nil {
+		return err
+	}
+	t.ops = append(t.ops, DeleteOp(key))
+	return nil
+}
+
+func (t *shadowTx) List(ctx context.Context, prefix Key) ([]Key, error) {
+	return t.tx.List(ctx, prefix)
+}
+
+func (t *shadowTx) Commit(ctx context.Context) error {
+	if err := t.tx.Commit(ctx); err != nil {
+		return err
+	}
+	if len(t.ops) > 0 {
+		t.s.mirror(ctx, OpCommit, nil, t.ops)
+	}
+	return nil
+}
+
+func (t *shadowTx) Rollback(ctx context.Context) error { return t.tx.Rollback(ctx) }
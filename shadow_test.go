@@ -0,0 +1,79 @@
+package txkv_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestShadow(t *testing.T) {
+	testKV(t, func(t testing.TB) TransactionalKV {
+		return WithShadow(InMem(), InMem(), ShadowOptions{ReadSampleRate: 1})
+	})
+}
+
+func TestShadowDivergences(t *testing.T) {
+	ctx := context.Background()
+	primary, shadow := InMem(), InMem()
+	var divergences []Divergence
+	kv := WithShadow(primary, shadow, ShadowOptions{
+		ReadSampleRate: 1,
+		OnDivergence: func(_ context.Context, d Divergence) {
+			divergences = append(divergences, d)
+		},
+	})
+
+	// writes are mirrored, transactions once committed
+	mustPut(ctx, t, kv, Key("a"), Value("1"))
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Put(ctx, Key("b"), Value("2")))
+	require.NoError(t, tx.Delete(ctx, Key("a")))
+	mustFind(ctx, t, shadow, Key("a"), Value("1"))
+	require.NoError(t, tx.Commit(ctx))
+	mustNotFind(ctx, t, shadow, Key("a"))
+	mustFind(ctx, t, shadow, Key("b"), Value("2"))
+	require.Empty(t, divergences)
+
+	// reads that differ are reported, and served from the primary
+	require.NoError(t, shadow.Put(ctx, Key("b"), Value("stale")))
+	v, ok, err := kv.Get(ctx, Key("b"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, Value("2"), v)
+	require.NoError(t, shadow.Put(ctx, Key("c"), Value("extra")))
+	keys, err := kv.List(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, []Key{Key("b")}, keys)
+	require.Equal(t, []Divergence{
+		{Op: OpGet, Key: Key("b"), Primary: Value("2"), PrimaryFound: true, Shadow: Value("stale"), ShadowFound: true},
+		{Op: OpList, PrimaryKeys: []Key{Key("b")}, ShadowKeys: []Key{Key("b"), Key("c")}},
+	}, divergences)
+	require.Equal(t, ShadowStats{Writes: 2, Reads: 2, Divergences: 2}, kv.Stats())
+}
+
+func TestShadowFailures(t *testing.T) {
+	ctx := context.Background()
+	broken := errors.New("broken")
+	shadow := &flakyKV{TransactionalKV: InMem(), failures: 1, err: broken}
+	var divergences []Divergence
+	kv := WithShadow(InMem(), shadow, ShadowOptions{
+		OnDivergence: func(_ context.Context, d Divergence) {
+			divergences = append(divergences, d)
+		},
+	})
+
+	// the shadow store failing doesn't fail the write
+	mustPut(ctx, t, kv, Key("a"), Value("1"))
+	require.Len(t, divergences, 1)
+	require.Equal(t, OpPut, divergences[0].Op)
+	require.ErrorIs(t, divergences[0].ShadowErr, broken)
+
+	// without sampling, reads aren't mirrored
+	mustFind(ctx, t, kv, Key("a"), Value("1"))
+	require.Equal(t, ShadowStats{Writes: 1, Divergences: 1}, kv.Stats())
+}
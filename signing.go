@@ -0,0 +1,187 @@
+package txkv
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Signer signs messages and verifies their signatures.
+type Signer interface {
+	Sign(msg []byte) ([]byte, error)
+	// Verify returns an error wrapping ErrInvalidSignature if `sig` isn't a
+	// valid signature of `msg`.
+	Verify(msg, sig []byte) error
+}
+
+// ErrInvalidSignature is matched by the errors returned when a signature
+// doesn't verify.
+var ErrInvalidSignature = errors.New("txkv: invalid signature")
+
+// HMACSigner returns a Signer using HMAC-SHA256 with `secret`.
+func HMACSigner(secret []byte) Signer { return hmacSigner(secret) }
+
+type hmacSigner []byte
+
+func (s hmacSigner) Sign(msg []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, s)
+	mac.Write(msg)
+	return mac.Sum(nil), nil
+}
+
+func (s hmacSigner) Verify(msg, sig []byte) error {
+	want, _ := s.Sign(msg)
+	if !hmac.Equal(want, sig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Ed25519Signer returns a Signer using the private key `priv`.
+func Ed25519Signer(priv ed25519.PrivateKey) Signer {
+	return &ed25519Signer{priv: priv, pub: priv.Public().(ed25519.PublicKey)}
+}
+
+// Ed25519Verifier returns a Signer that can only verify signatures made with
+// the private key matching `pub`. Writing through a store using it fails,
+// which suits readers that must not be able to forge values.
+func Ed25519Verifier(pub ed25519.PublicKey) Signer {
+	return &ed25519Signer{pub: pub}
+}
+
+type ed25519Signer struct {
+	priv ed25519.PrivateKey
+	pub  ed25519.PublicKey
+}
+
+func (s *ed25519Signer) Sign(msg []byte) ([]byte, error) {
+	if s.priv == nil {
+		return nil, errors.New("txkv: this signer can only verify signatures")
+	}
+	return ed25519.Sign(s.priv, msg), nil
+}
+
+func (s *ed25519Signer) Verify(msg, sig []byte) error {
+	if !ed25519.Verify(s.pub, msg, sig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// SignatureError is returned when a value read from the store doesn't carry a
+// valid signature, meaning it was tampered with or written by someone else.
+type SignatureError struct {
+	Key Key
+	Err error
+}
+
+func (e *SignatureError) Error() string {
+	return fmt.Sprintf("txkv: can't verify value at key %q: %v", e.Key, e.Err)
+}
+
+func (e *SignatureError) Unwrap() error { return e.Err }
+
+var errMalformedSignedValue = fmt.Errorf("%w: malformed signed value", ErrInvalidSignature)
+
+// WithSigning returns a TransactionalKV that signs values before they reach
+// `kv`, and verifies them when they're read. Signatures cover the key as well
+// as the value, so a value can't be moved to another key undetected. Values
+// stay readable in the underlying store; combine with WithEncryption if they
+// must also be confidential.
+func WithSigning(kv TransactionalKV, signer Signer) TransactionalKV {
+	return &signedKV{kv: kv, signer: signer}
+}
+
+type signedKV struct {
+	kv     TransactionalKV
+	signer Signer
+}
+
+func (s *signedKV) Put(ctx context.Context, key Key, value Value) error {
+	return signedPut(ctx, s.signer, s.kv, key, value)
+}
+
+func (s *signedKV) Get(ctx context.Context, key Key) (Value, bool, error) {
+	return signedGet(ctx, s.signer, s.kv, key)
+}
+
+func (s *signedKV) Delete(ctx context.Context, key Key) error {
+	return s.kv.Delete(ctx, key)
+}
+
+func (s *signedKV) List(ctx context.Context, prefix Key) ([]Key, error) {
+	return s.kv.List(ctx, prefix)
+}
+
+func (s *signedKV) Begin(ctx context.Context) (TxKV, error) {
+	tx, err := s.kv.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &signedTx{tx: tx, signer: s.signer}, nil
+}
+
+type signedTx struct {
+	tx     TxKV
+	signer Signer
+}
+
+func (s *signedTx) Put(ctx context.Context, key Key, value Value) error {
+	return signedPut(ctx, s.signer, s.tx, key, value)
+}
+
+func (s *signedTx) Get(ctx context.Context, key Key) (Value, bool, error) {
+	return signedGet(ctx, s.signer, s.tx, key)
+}
+
+func (s *signedTx) Delete(ctx context.Context, key Key) error {
+	return s.tx.Delete(ctx, key)
+}
+
+func (s *signedTx) List(ctx context.Context, prefix Key) ([]Key, error) {
+	return s.tx.List(ctx, prefix)
+}
+
+func (s *signedTx) Commit(ctx context.Context) error   { return s.tx.Commit(ctx) }
+func (s *signedTx) Rollback(ctx context.Context) error { return s.tx.Rollback(ctx) }
+
+// signedMessage is what's actually signed: the key, length-prefixed, followed
+// by the value.
+func signedMessage(key Key, value Value) []byte {
+	msg := make([]byte, 0, binary.MaxVarintLen64+len(key)+len(value))
+	msg = binary.AppendUvarint(msg, uint64(len(key)))
+	msg = append(msg, key...)
+	return append(msg, value...)
+}
+
+// signed values are stored as `uvarint len | signature | value`
+func signedPut(ctx context.Context, signer Signer, kv KV, key Key, value Value) error {
+	sig, err := signer.Sign(signedMessage(key, value))
+	if err != nil {
+		return err
+	}
+	stored := make(Value, 0, binary.MaxVarintLen64+len(sig)+len(value))
+	stored = binary.AppendUvarint(stored, uint64(len(sig)))
+	stored = append(stored, sig...)
+	stored = append(stored, value...)
+	return kv.Put(ctx, key, stored)
+}
+
+func signedGet(ctx context.Context, signer Signer, kv KV, key Key) (Value, bool, error) {
+	stored, ok, err := kv.Get(ctx, key)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	sig, value, ok := readUvarintBytes(stored)
+	if !ok {
+		return nil, false, &SignatureError{Key: key, Err: errMalformedSignedValue}
+	}
+	if err := signer.Verify(signedMessage(key, value), sig); err != nil {
+		return nil, false, &SignatureError{Key: key, Err: err}
+	}
+	return value, true, nil
+}
@@ -0,0 +1,74 @@
+package txkv_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestSigningHMAC(t *testing.T) {
+	testKV(t, func(t testing.TB) TransactionalKV {
+		return WithSigning(InMem(), HMACSigner([]byte("secret")))
+	})
+}
+
+func TestSigningEd25519(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	testKV(t, func(t testing.TB) TransactionalKV {
+		return WithSigning(InMem(), Ed25519Signer(priv))
+	})
+
+	ctx := context.Background()
+	raw := InMem()
+	writer := WithSigning(raw, Ed25519Signer(priv))
+	reader := WithSigning(raw, Ed25519Verifier(pub))
+
+	mustPut(ctx, t, writer, Key("hello"), Value("world"))
+	mustFind(ctx, t, reader, Key("hello"), Value("world"))
+
+	// readers can't forge values
+	require.Error(t, reader.Put(ctx, Key("hello"), Value("forged")))
+}
+
+func TestSigningTampering(t *testing.T) {
+	ctx := context.Background()
+	raw := InMem()
+	kv := WithSigning(raw, HMACSigner([]byte("secret")))
+
+	mustPut(ctx, t, kv, Key("hello"), Value("world"))
+	stored, _, err := raw.Get(ctx, Key("hello"))
+	require.NoError(t, err)
+
+	var serr *SignatureError
+
+	// changing the value is detected
+	tampered := append(Value(nil), stored...)
+	tampered[len(tampered)-1] = '!'
+	mustPut(ctx, t, raw, Key("hello"), tampered)
+	_, _, err = kv.Get(ctx, Key("hello"))
+	require.True(t, errors.As(err, &serr), "%v", err)
+	require.ErrorIs(t, err, ErrInvalidSignature)
+
+	// moving it elsewhere too
+	mustPut(ctx, t, raw, Key("moved"), stored)
+	_, _, err = kv.Get(ctx, Key("moved"))
+	require.ErrorIs(t, err, ErrInvalidSignature)
+
+	// and so are unsigned values
+	mustPut(ctx, t, raw, Key("unsigned"), Value("raw"))
+	_, _, err = kv.Get(ctx, Key("unsigned"))
+	require.ErrorIs(t, err, ErrInvalidSignature)
+
+	// another secret doesn't verify
+	mustPut(ctx, t, raw, Key("hello"), stored)
+	other := WithSigning(raw, HMACSigner([]byte("other")))
+	_, _, err = other.Get(ctx, Key("hello"))
+	require.ErrorIs(t, err, ErrInvalidSignature)
+}
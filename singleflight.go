@@ -0,0 +1,81 @@
+package txkv
+
+import (
+	"context"
+
+	"github.com/aybabtme/txkv/internal/singleflight"
+)
+
+// SingleflightOptions tune WithSingleflight.
+type SingleflightOptions struct {
+	// Lists also collapses concurrent Lists of the same prefix.
+	Lists bool
+}
+
+// WithSingleflight returns a TransactionalKV collapsing concurrent Gets of the
+// same key into a single Get on `kv`, whose result all the callers get. It
+// spares remote or disk backends from read storms on hot keys.
+//
+// A Get joining one in flight may thus miss a write that completed after the
+// shared Get started. Callers get their own copy of shared results. The
+// context of the first caller is the one used for the actual Get, so its
+// cancellation fails the others too. Reads within transactions aren't
+// collapsed, since they see the transaction's own writes.
+func WithSingleflight(kv TransactionalKV, opts SingleflightOptions) TransactionalKV {
+	return &singleflightKV{kv: kv, opts: opts}
+}
+
+type singleflightKV struct {
+	kv    TransactionalKV
+	opts  SingleflightOptions
+	gets  singleflight.Group
+	lists singleflight.Group
+}
+
+type getResult struct {
+	value Value
+	ok    bool
+}
+
+func (s *singleflightKV) Put(ctx context.Context, key Key, value Value) error {
+	return s.kv.Put(ctx, key, value)
+}
+
+func (s *singleflightKV) Get(ctx context.Context, key Key) (Value, bool, error) {
+	v, err, shared := s.gets.Do(string(key), func() (interface{}, error) {
+		value, ok, err := s.kv.Get(ctx, key)
+		return getResult{value: value, ok: ok}, err
+	})
+	res, _ := v.(getResult)
+	if shared && res.value != nil {
+		res.value = append(Value(nil), res.value...)
+	}
+	return res.value, res.ok, err
+}
+
+func (s *singleflightKV) Delete(ctx context.Context, key Key) error {
+	return s.kv.Delete(ctx, key)
+}
+
+func (s *singleflightKV) List(ctx context.Context, prefix Key) ([]Key, error) {
+	if !s.opts.Lists {
+		return s.kv.List(ctx, prefix)
+	}
+	v, err, shared := s.lists.Do(string(prefix), func() (interface{}, error) {
+		return s.kv.List(ctx, prefix)
+	})
+	keys, _ := v.([]Key)
+	if shared && keys != nil {
+		// the keys themselves may be the store's memory
+		copied := make([]Key, len(keys))
+		for i, key := range keys {
+			copied[i] = append(Key{}, key...)
+		}
+		keys = copied
+	}
+	return keys, err
+}
+
+func (s *singleflightKV) Begin(ctx context.Context) (TxKV, error) {
+	return s.kv.Begin(ctx)
+}
@@ -0,0 +1,73 @@
+package txkv_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+// slowKV counts reads, and makes them take a while.
+type slowKV struct {
+	TransactionalKV
+	gets, lists int32
+}
+
+func (s *slowKV) Get(ctx context.Context, key Key) (Value, bool, error) {
+	atomic.AddInt32(&s.gets, 1)
+	time.Sleep(20 * time.Millisecond)
+	return s.TransactionalKV.Get(ctx, key)
+}
+
+func (s *slowKV) List(ctx context.Context, prefix Key) ([]Key, error) {
+	atomic.AddInt32(&s.lists, 1)
+	time.Sleep(20 * time.Millisecond)
+	return s.TransactionalKV.List(ctx, prefix)
+}
+
+func TestSingleflight(t *testing.T) {
+	testKV(t, func(t testing.TB) TransactionalKV {
+		return WithSingleflight(InMem(), SingleflightOptions{Lists: true})
+	})
+}
+
+func TestSingleflightCollapses(t *testing.T) {
+	ctx := context.Background()
+	slow := &slowKV{TransactionalKV: InMem(WithCopy())}
+	mustPut(ctx, t, slow, Key("hot"), Value("value"))
+	kv := WithSingleflight(slow, SingleflightOptions{Lists: true})
+
+	const readers = 20
+	values := make([]Value, readers)
+	lists := make([][]Key, readers)
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i], _, _ = kv.Get(ctx, Key("hot"))
+			lists[i], _ = kv.List(ctx, Key(""))
+		}(i)
+	}
+	wg.Wait()
+
+	require.Less(t, atomic.LoadInt32(&slow.gets), int32(readers/2))
+	require.Less(t, atomic.LoadInt32(&slow.lists), int32(readers/2))
+	for i := range values {
+		require.Equal(t, Value("value"), values[i])
+		require.Equal(t, []Key{Key("hot")}, lists[i])
+	}
+
+	// shared values are copies
+	values[0][0] = 'X'
+	require.Equal(t, Value("value"), values[1])
+	lists[0][0][0] = 'X'
+	for i := 1; i < readers; i++ {
+		require.Equal(t, []Key{Key("hot")}, lists[i])
+	}
+}
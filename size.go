@@ -0,0 +1,48 @@
+package txkv
+
+import "context"
+
+// SizeStats describes the keys under a prefix.
+type SizeStats struct {
+	Keys       int
+	KeyBytes   int64
+	ValueBytes int64
+	// Approximate is true when the figures are estimates.
+	Approximate bool
+}
+
+// Bytes is the total size of keys and values.
+func (s SizeStats) Bytes() int64 { return s.KeyBytes + s.ValueBytes }
+
+// Sizer is implemented by stores that can report the size of a prefix
+// without reading every value through the KV interface.
+type Sizer interface {
+	SizeOf(ctx context.Context, prefix Key) (SizeStats, error)
+}
+
+// SizeOf reports how many keys `kv` holds under `prefix`, and how many bytes
+// they take, for capacity planning per tenant or feature. Stores that are a
+// Sizer compute it themselves, possibly approximately; others have every key
+// under `prefix` read.
+func SizeOf(ctx context.Context, kv KV, prefix Key) (SizeStats, error) {
+	if s, ok := kv.(Sizer); ok {
+		return s.SizeOf(ctx, prefix)
+	}
+	var stats SizeStats
+	keys, err := kv.List(ctx, prefix)
+	if err != nil {
+		return stats, err
+	}
+	for _, key := range keys {
+		v, ok, err := kv.Get(ctx, key)
+		if err != nil {
+			return stats, err
+		}
+		if ok {
+			stats.Keys++
+			stats.KeyBytes += int64(len(key))
+			stats.ValueBytes += int64(len(v))
+		}
+	}
+	return stats, nil
+}
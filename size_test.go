@@ -0,0 +1,39 @@
+package txkv_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestSizeOf(t *testing.T) {
+	ctx := context.Background()
+	kvs := map[string]TransactionalKV{
+		"inmem":   InMem(),
+		"wrapped": WithMaintenance(InMem()),
+	}
+	for name, kv := range kvs {
+		t.Run(name, func(t *testing.T) {
+			mustPut(ctx, t, kv, Key("tenant-a/1"), Value("12345"))
+			mustPut(ctx, t, kv, Key("tenant-a/2"), Value("123"))
+			mustPut(ctx, t, kv, Key("tenant-b/1"), Value("1"))
+
+			stats, err := SizeOf(ctx, kv, Key("tenant-a/"))
+			require.NoError(t, err)
+			require.Equal(t, SizeStats{Keys: 2, KeyBytes: 20, ValueBytes: 8}, stats)
+			require.Equal(t, int64(28), stats.Bytes())
+
+			stats, err = SizeOf(ctx, kv, Key(""))
+			require.NoError(t, err)
+			require.Equal(t, 3, stats.Keys)
+			require.Equal(t, int64(39), stats.Bytes())
+
+			stats, err = SizeOf(ctx, kv, Key("tenant-c/"))
+			require.NoError(t, err)
+			require.Zero(t, stats)
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package txkv
+
+import (
+	"context"
+	"errors"
+)
+
+// StringKV is a KV of string keys and values, for scripts and tests tired of
+// converting to Key and Value.
+type StringKV struct {
+	kv KV
+}
+
+// Strings returns a StringKV over `kv`.
+func Strings(kv KV) StringKV {
+	return StringKV{kv: kv}
+}
+
+// KV returns the KV underneath.
+func (s StringKV) KV() KV { return s.kv }
+
+func (s StringKV) Put(ctx context.Context, key, value string) error {
+	return s.kv.Put(ctx, Key(key), Value(value))
+}
+
+func (s StringKV) Get(ctx context.Context, key string) (string, bool, error) {
+	v, ok, err := s.kv.Get(ctx, Key(key))
+	return string(v), ok, err
+}
+
+func (s StringKV) Delete(ctx context.Context, key string) error {
+	return s.kv.Delete(ctx, Key(key))
+}
+
+func (s StringKV) List(ctx context.Context, prefix string) ([]string, error) {
+	keys, err := s.kv.List(ctx, Key(prefix))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, 0, len(keys))
+	for _, key := range keys {
+		out = append(out, string(key))
+	}
+	return out, nil
+}
+
+// Begin starts a transaction, if the KV underneath is a TransactionalKV.
+func (s StringKV) Begin(ctx context.Context) (StringTxKV, error) {
+	tkv, ok := s.kv.(TransactionalKV)
+	if !ok {
+		return StringTxKV{}, errors.New("txkv: store isn't transactional")
+	}
+	tx, err := tkv.Begin(ctx)
+	if err != nil {
+		return StringTxKV{}, err
+	}
+	return StringTxKV{StringKV: Strings(tx), tx: tx}, nil
+}
+
+// StringTxKV is a transaction of a StringKV.
+type StringTxKV struct {
+	StringKV
+	tx TxKV
+}
+
+func (s StringTxKV) Commit(ctx context.Context) error   { return s.tx.Commit(ctx) }
+func (s StringTxKV) Rollback(ctx context.Context) error { return s.tx.Rollback(ctx) }
@@ -0,0 +1,46 @@
+package txkv_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestStrings(t *testing.T) {
+	ctx := context.Background()
+	kv := Strings(InMem())
+
+	require.NoError(t, kv.Put(ctx, "a/1", "one"))
+	require.NoError(t, kv.Put(ctx, "a/2", "two"))
+	require.NoError(t, kv.Put(ctx, "b", "three"))
+
+	v, ok, err := kv.Get(ctx, "a/1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "one", v)
+
+	keys, err := kv.List(ctx, "a/")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a/1", "a/2"}, keys)
+
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Delete(ctx, "a/1"))
+	require.NoError(t, tx.Commit(ctx))
+
+	_, ok, err = kv.Get(ctx, "a/1")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// an empty listing is an empty slice
+	keys, err = kv.List(ctx, "nope/")
+	require.NoError(t, err)
+	require.Empty(t, keys)
+
+	// transactions don't nest
+	_, err = Strings(tx.KV()).Begin(ctx)
+	require.Error(t, err)
+}
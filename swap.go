@@ -0,0 +1,265 @@
+package txkv
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrOverlappingSwap is returned when swapping prefixes that overlap each
+// other, or the prefixes of another swap.
+var ErrOverlappingSwap = errors.New("txkv: overlapping swapped prefixes")
+
+// SwapOptions tune a SwapKV.
+type SwapOptions struct {
+	// StateKey is where the swapped prefixes are kept. It's hidden from
+	// List. Defaults to "\x00swaps".
+	StateKey Key
+}
+
+// WithPrefixSwaps returns a SwapKV over `kv`.
+func WithPrefixSwaps(kv TransactionalKV, opts SwapOptions) *SwapKV {
+	if opts.StateKey == nil {
+		opts.StateKey = Key("\x00swaps")
+	}
+	return &SwapKV{kv: kv, opts: opts}
+}
+
+// SwapKV is a TransactionalKV whose prefixes can be exchanged instantly, for
+// blue/green swaps of datasets: a dataset built under a staging prefix is
+// promoted by swapping it with the live one, and rolled back by swapping them
+// again.
+//
+// Entries don't move when prefixes are swapped. Instead, the swapped pairs
+// are kept in the store, and keys under one prefix of a pair are stored
+// under the other. Every operation reads them, in the same transaction when
+// there's one, so all users of the store see a swap at once.
+type SwapKV struct {
+	kv   TransactionalKV
+	opts SwapOptions
+}
+
+// swapPair is a pair of swapped prefixes.
+type swapPair struct{ a, b Key }
+
+// SwapPrefixes exchanges the entries under prefixes `a` and `b`, atomically.
+// Swapping them again undoes it. Neither can be under the other, nor overlap
+// the prefixes of another swap in effect.
+func (s *SwapKV) SwapPrefixes(ctx context.Context, a, b Key) error {
+	if len(a) == 0 || len(b) == 0 || bytes.HasPrefix(a, b) || bytes.HasPrefix(b, a) {
+		return fmt.Errorf("%w: %q and %q", ErrOverlappingSwap, a, b)
+	}
+	tx, err := s.kv.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	if err := s.swap(ctx, tx, a, b); err != nil {
+		_ = tx.Rollback(ctx)
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (s *SwapKV) swap(ctx context.Context, tx TxKV, a, b Key) error {
+	pairs, err := s.pairs(ctx, tx)
+	if err != nil {
+		return err
+	}
+	kept := pairs[:0]
+	undone := false
+	for _, p := range pairs {
+		if (bytes.Equal(p.a, a) && bytes.Equal(p.b, b)) || (bytes.Equal(p.a, b) && bytes.Equal(p.b, a)) {
+			undone = true
+			continue
+		}
+		for _, x := range []Key{p.a, p.b} {
+			for _, y := range []Key{a, b} {
+				if bytes.HasPrefix(x, y) || bytes.HasPrefix(y, x) {
+					return fmt.Errorf("%w: %q and %q", ErrOverlappingSwap, x, y)
+				}
+			}
+		}
+		kept = append(kept, p)
+	}
+	if !undone {
+		kept = append(kept, swapPair{a: a, b: b})
+	}
+	if len(kept) == 0 {
+		return tx.Delete(ctx, s.opts.StateKey)
+	}
+	var state Value
+	for _, p := range kept {
+		state = binary.AppendUvarint(state, uint64(len(p.a)))
+		state = append(state, p.a...)
+		state = binary.AppendUvarint(state, uint64(len(p.b)))
+		state = append(state, p.b...)
+	}
+	return tx.Put(ctx, s.opts.StateKey, state)
+}
+
+// Swapped returns the pairs of prefixes currently swapped.
+func (s *SwapKV) Swapped(ctx context.Context) ([][2]Key, error) {
+	pairs, err := s.pairs(ctx, s.kv)
+	if err != nil {
+		return nil, err
+	}
+	swapped := make([][2]Key, 0, len(pairs))
+	for _, p := range pairs {
+		swapped = append(swapped, [2]Key{p.a, p.b})
+	}
+	return swapped, nil
+}
+
+func (s *SwapKV) pairs(ctx context.Context, kv KV) ([]swapPair, error) {
+	state, ok, err := kv.Get(ctx, s.opts.StateKey)
+	if err != nil || !ok {
+		return nil, err
+	}
+	var pairs []swapPair
+	for len(state) > 0 {
+		a, rest, ok := readUvarintBytes(state)
+		if !ok {
+			return nil, errors.New("txkv: malformed swap state")
+		}
+		b, rest, ok := readUvarintBytes(rest)
+		if !ok {
+			return nil, errors.New("txkv: malformed swap state")
+		}
+		pairs = append(pairs, swapPair{a: a, b: b})
+		state = rest
+	}
+	return pairs, nil
+}
+
+// translate returns where `key` is stored given `pairs`, and the other way
+// around: it's its own inverse.
+func translate(pairs []swapPair, key Key) Key {
+	for _, p := range pairs {
+		from, to := p.a, p.b
+		if !bytes.HasPrefix(key, from) {
+			from, to = p.b, p.a
+		}
+		if bytes.HasPrefix(key, from) {
+			return append(append(Key(nil), to...), key[len(from):]...)
+		}
+	}
+	return key
+}
+
+func (s *SwapKV) Put(ctx context.Context, key Key, value Value) error {
+	tx, err := s.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	if err := tx.Put(ctx, key, value); err != nil {
+		_ = tx.Rollback(ctx)
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (s *SwapKV) Get(ctx context.Context, key Key) (Value, bool, error) {
+	pairs, err := s.pairs(ctx, s.kv)
+	if err != nil {
+		return nil, false, err
+	}
+	return s.kv.Get(ctx, translate(pairs, key))
+}
+
+func (s *SwapKV) Delete(ctx context.Context, key Key) error {
+	tx, err := s.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	if err := tx.Delete(ctx, key); err != nil {
+		_ = tx.Rollback(ctx)
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (s *SwapKV) List(ctx context.Context, prefix Key) ([]Key, error) {
+	pairs, err := s.pairs(ctx, s.kv)
+	if err != nil {
+		return nil, err
+	}
+	return swapList(ctx, s, s.kv, pairs, prefix)
+}
+
+func (s *SwapKV) Begin(ctx context.Context) (TxKV, error) {
+	tx, err := s.kv.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pairs, err := s.pairs(ctx, tx)
+	if err != nil {
+		_ = tx.Rollback(ctx)
+		return nil, err
+	}
+	return &swapTx{s: s, tx: tx, pairs: pairs}, nil
+}
+
+// swapList lists the keys under `prefix` as seen through `pairs`: those
+// stored under it, and those stored under the other prefix of pairs it
+// covers.
+func swapList(ctx context.Context, s *SwapKV, kv KV, pairs []swapPair, prefix Key) ([]Key, error) {
+	stored := []Key{translate(pairs, prefix)}
+	for _, p := range pairs {
+		if bytes.HasPrefix(p.a, prefix) != bytes.HasPrefix(p.b, prefix) {
+			if bytes.HasPrefix(p.a, prefix) {
+				stored = append(stored, p.b)
+			} else {
+				stored = append(stored, p.a)
+			}
+		}
+	}
+	seen := make(map[string]bool)
+	var keys []Key
+	for _, sp := range stored {
+		listed, err := kv.List(ctx, sp)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range listed {
+			if bytes.Equal(k, s.opts.StateKey) {
+				continue
+			}
+			key := translate(pairs, k)
+			if !bytes.HasPrefix(key, prefix) || seen[string(key)] {
+				continue
+			}
+			seen[string(key)] = true
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+	return keys, nil
+}
+
+type swapTx struct {
+	s     *SwapKV
+	tx    TxKV
+	pairs []swapPair
+}
+
+func (s *swapTx) Put(ctx context.Context, key Key, value Value) error {
+	return s.tx.Put(ctx, translate(s.pairs, key), value)
+}
+
+func (s *swapTx) Get(ctx context.Context, key Key) (Value, bool, error) {
+	return s.tx.Get(ctx, translate(s.pairs, key))
+}
+
+func (s *swapTx) Delete(ctx context.Context, key Key) error {
+	return s.tx.Delete(ctx, translate(s.pairs, key))
+}
+
+func (s *swapTx) List(ctx context.Context, prefix Key) ([]Key, error) {
+	return swapList(ctx, s.s, s.tx, s.pairs, prefix)
+}
+
+func (s *swapTx) Commit(ctx context.Context) error   { return s.tx.Commit(ctx) }
+func (s *swapTx) Rollback(ctx context.Context) error { return s.tx.Rollback(ctx) }
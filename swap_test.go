@@ -0,0 +1,69 @@
+package txkv_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestPrefixSwaps(t *testing.T) {
+	testKV(t, func(t testing.TB) TransactionalKV {
+		return WithPrefixSwaps(InMem(), SwapOptions{})
+	})
+}
+
+func TestSwapPrefixes(t *testing.T) {
+	ctx := context.Background()
+	raw := InMem()
+	kv := WithPrefixSwaps(raw, SwapOptions{})
+
+	mustPut(ctx, t, kv, Key("live/a"), Value("old a"))
+	mustPut(ctx, t, kv, Key("live/b"), Value("old b"))
+	mustPut(ctx, t, kv, Key("staging/a"), Value("new a"))
+	mustPut(ctx, t, kv, Key("staging/c"), Value("new c"))
+	mustPut(ctx, t, kv, Key("other"), Value("x"))
+
+	// promote
+	require.NoError(t, kv.SwapPrefixes(ctx, Key("live/"), Key("staging/")))
+	mustFind(ctx, t, kv, Key("live/a"), Value("new a"))
+	mustFind(ctx, t, kv, Key("live/c"), Value("new c"))
+	mustNotFind(ctx, t, kv, Key("live/b"))
+	mustFind(ctx, t, kv, Key("staging/b"), Value("old b"))
+	mustList(ctx, t, kv, Key("live/"), []Key{Key("live/a"), Key("live/c")})
+	mustList(ctx, t, kv, Key("l"), []Key{Key("live/a"), Key("live/c")})
+	mustList(ctx, t, kv, Key(""), []Key{
+		Key("live/a"), Key("live/c"), Key("other"), Key("staging/a"), Key("staging/b"),
+	})
+	swapped, err := kv.Swapped(ctx)
+	require.NoError(t, err)
+	require.Equal(t, [][2]Key{{Key("live/"), Key("staging/")}}, swapped)
+
+	// writes land in the promoted dataset, within transactions too
+	mustPut(ctx, t, kv, Key("live/d"), Value("new d"))
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	mustFind(ctx, t, tx, Key("live/a"), Value("new a"))
+	require.NoError(t, tx.Delete(ctx, Key("live/c")))
+	mustList(ctx, t, tx, Key("live/"), []Key{Key("live/a"), Key("live/d")})
+	require.NoError(t, tx.Commit(ctx))
+	mustFind(ctx, t, raw, Key("staging/d"), Value("new d"))
+
+	// roll back
+	require.NoError(t, kv.SwapPrefixes(ctx, Key("staging/"), Key("live/")))
+	mustFind(ctx, t, kv, Key("live/a"), Value("old a"))
+	mustList(ctx, t, kv, Key("staging/"), []Key{Key("staging/a"), Key("staging/d")})
+	mustNotFind(ctx, t, raw, Key("\x00swaps"))
+
+	for _, pair := range [][2]Key{
+		{Key("live/"), Key("live/x/")},
+		{Key(""), Key("live/")},
+	} {
+		require.ErrorIs(t, kv.SwapPrefixes(ctx, pair[0], pair[1]), ErrOverlappingSwap)
+	}
+	require.NoError(t, kv.SwapPrefixes(ctx, Key("live/"), Key("staging/")))
+	require.ErrorIs(t, kv.SwapPrefixes(ctx, Key("live/x/"), Key("blue/")), ErrOverlappingSwap)
+	require.NoError(t, kv.SwapPrefixes(ctx, Key("blue/"), Key("green/")))
+}
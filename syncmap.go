@@ -0,0 +1,193 @@
+package txkv
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+)
+
+// SyncMap exposes a TransactionalKV with the method set of sync.Map, to ease
+// the migration of in-process caches to a shared store.
+//
+// Keys and values must be a Key, a Value, a []byte, a string or nil, which
+// is stored as an empty value. Loaded values are always of type Value. Since
+// sync.Map methods can't fail, errors from the underlying store cause a
+// panic.
+//
+// Writes go one at a time, so that methods that read then write
+// (LoadOrStore, Swap, CompareAndSwap, etc.) are atomic like those of
+// sync.Map. That only holds within the SyncMap: other users of the store,
+// in this process or others, can still write between the read and the write
+// unless the store's transactions detect it.
+type SyncMap struct {
+	kv TransactionalKV
+	mu sync.Mutex // held while writing
+}
+
+// NewSyncMap returns a SyncMap backed by `kv`.
+func NewSyncMap(kv TransactionalKV) *SyncMap {
+	return &SyncMap{kv: kv}
+}
+
+// Load returns the value stored in the map for a key, or nil if no value is
+// present. The ok result indicates whether value was found in the map.
+func (m *SyncMap) Load(key interface{}) (value interface{}, ok bool) {
+	v, ok, err := m.kv.Get(context.Background(), toBytes(key))
+	must(err)
+	if !ok {
+		return nil, false
+	}
+	return v, true
+}
+
+// Store sets the value for a key.
+func (m *SyncMap) Store(key, value interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	must(m.kv.Put(context.Background(), toBytes(key), toBytes(value)))
+}
+
+// LoadOrStore returns the existing value for the key if present. Otherwise, it
+// stores and returns the given value. The loaded result is true if the value
+// was loaded, false if stored.
+func (m *SyncMap) LoadOrStore(key, value interface{}) (actual interface{}, loaded bool) {
+	k, v := toBytes(key), toBytes(value)
+	m.update(func(ctx context.Context, tx TxKV) {
+		old, ok, err := tx.Get(ctx, k)
+		must(err)
+		if ok {
+			actual, loaded = old, true
+			return
+		}
+		must(tx.Put(ctx, k, v))
+		actual = Value(v)
+	})
+	return actual, loaded
+}
+
+// LoadAndDelete deletes the value for a key, returning the previous value if
+// any. The loaded result reports whether the key was present.
+func (m *SyncMap) LoadAndDelete(key interface{}) (value interface{}, loaded bool) {
+	k := toBytes(key)
+	m.update(func(ctx context.Context, tx TxKV) {
+		old, ok, err := tx.Get(ctx, k)
+		must(err)
+		if !ok {
+			return
+		}
+		must(tx.Delete(ctx, k))
+		value, loaded = old, true
+	})
+	return value, loaded
+}
+
+// Delete deletes the value for a key.
+func (m *SyncMap) Delete(key interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	must(m.kv.Delete(context.Background(), toBytes(key)))
+}
+
+// Swap swaps the value for a key and returns the previous value if any. The
+// loaded result reports whether the key was present.
+func (m *SyncMap) Swap(key, value interface{}) (previous interface{}, loaded bool) {
+	k, v := toBytes(key), toBytes(value)
+	m.update(func(ctx context.Context, tx TxKV) {
+		old, ok, err := tx.Get(ctx, k)
+		must(err)
+		must(tx.Put(ctx, k, v))
+		if ok {
+			previous, loaded = old, true
+		}
+	})
+	return previous, loaded
+}
+
+// CompareAndSwap swaps the old and new values for key if the value stored in
+// the map is equal to old.
+func (m *SyncMap) CompareAndSwap(key, old, new interface{}) (swapped bool) {
+	k, o, n := toBytes(key), toBytes(old), toBytes(new)
+	m.update(func(ctx context.Context, tx TxKV) {
+		cur, ok, err := tx.Get(ctx, k)
+		must(err)
+		if !ok || !bytes.Equal(cur, o) {
+			return
+		}
+		must(tx.Put(ctx, k, n))
+		swapped = true
+	})
+	return swapped
+}
+
+// CompareAndDelete deletes the entry for key if its value is equal to old.
+func (m *SyncMap) CompareAndDelete(key, old interface{}) (deleted bool) {
+	k, o := toBytes(key), toBytes(old)
+	m.update(func(ctx context.Context, tx TxKV) {
+		cur, ok, err := tx.Get(ctx, k)
+		must(err)
+		if !ok || !bytes.Equal(cur, o) {
+			return
+		}
+		must(tx.Delete(ctx, k))
+		deleted = true
+	})
+	return deleted
+}
+
+// Range calls f sequentially for each key and value present in the map, in
+// key order. If f returns false, range stops the iteration. Keys are passed
+// as Key, values as Value.
+func (m *SyncMap) Range(f func(key, value interface{}) bool) {
+	ctx := context.Background()
+	keys, err := m.kv.List(ctx, nil)
+	must(err)
+	for _, key := range keys {
+		v, ok, err := m.kv.Get(ctx, key)
+		must(err)
+		if !ok {
+			continue // deleted since we listed it
+		}
+		if !f(key, v) {
+			return
+		}
+	}
+}
+
+func (m *SyncMap) update(fn func(context.Context, TxKV)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ctx := context.Background()
+	tx, err := m.kv.Begin(ctx)
+	must(err)
+	defer func() {
+		if r := recover(); r != nil {
+			_ = tx.Rollback(ctx)
+			panic(r)
+		}
+	}()
+	fn(ctx, tx)
+	must(tx.Commit(ctx))
+}
+
+func toBytes(v interface{}) []byte {
+	switch v := v.(type) {
+	case Key:
+		return v
+	case Value:
+		return v
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	case nil:
+		return []byte{}
+	}
+	panic(fmt.Sprintf("txkv: SyncMap keys and values must be Key, Value, []byte or string, got %T", v))
+}
+
+func must(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
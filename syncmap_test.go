@@ -0,0 +1,89 @@
+package txkv_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestSyncMap(t *testing.T) {
+	m := NewSyncMap(InMem())
+
+	_, ok := m.Load("hello")
+	require.False(t, ok)
+
+	m.Store("hello", "world")
+	v, ok := m.Load(Key("hello"))
+	require.True(t, ok)
+	require.Equal(t, Value("world"), v)
+
+	actual, loaded := m.LoadOrStore("hello", "ignored")
+	require.True(t, loaded)
+	require.Equal(t, Value("world"), actual)
+
+	actual, loaded = m.LoadOrStore("bonjour", []byte("monde"))
+	require.False(t, loaded)
+	require.Equal(t, Value("monde"), actual)
+
+	require.False(t, m.CompareAndSwap("hello", "wrong", "new"))
+	require.True(t, m.CompareAndSwap("hello", "world", "new"))
+
+	previous, loaded := m.Swap("hello", "newer")
+	require.True(t, loaded)
+	require.Equal(t, Value("new"), previous)
+
+	require.False(t, m.CompareAndDelete("hello", "new"))
+	require.True(t, m.CompareAndDelete("hello", "newer"))
+
+	var keys []Key
+	m.Range(func(key, value interface{}) bool {
+		keys = append(keys, key.(Key))
+		return true
+	})
+	require.Equal(t, []Key{Key("bonjour")}, keys)
+
+	value, loaded := m.LoadAndDelete("bonjour")
+	require.True(t, loaded)
+	require.Equal(t, Value("monde"), value)
+
+	_, loaded = m.LoadAndDelete("bonjour")
+	require.False(t, loaded)
+
+	require.Panics(t, func() { m.Store(42, "nope") })
+}
+
+func TestSyncMapConcurrentCompareAndSwap(t *testing.T) {
+	m := NewSyncMap(InMem())
+	m.Store("k", "0")
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		swapped int
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if m.CompareAndSwap("k", "0", "1") {
+				mu.Lock()
+				swapped++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, 1, swapped)
+}
+
+func TestSyncMapNil(t *testing.T) {
+	m := NewSyncMap(InMem())
+	m.Store("k", nil)
+	v, ok := m.Load("k")
+	require.True(t, ok)
+	require.Empty(t, v)
+	require.True(t, m.CompareAndSwap("k", nil, "v"))
+}
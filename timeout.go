@@ -0,0 +1,120 @@
+package txkv
+
+import (
+	"context"
+	"time"
+)
+
+// Timeouts are default deadlines per kind of operation. A zero timeout leaves
+// the operation unbounded.
+type Timeouts struct {
+	Get time.Duration
+	// Put also applies to Delete.
+	Put  time.Duration
+	List time.Duration
+	// Commit also applies to Begin.
+	Commit time.Duration
+}
+
+// WithTimeouts returns a TransactionalKV that bounds the operations of `kv`
+// with `timeouts` when their context has no deadline, so that a hung backend
+// can't stall the application indefinitely. Contexts that have a deadline
+// are left untouched. It's up to `kv` to honor the deadline.
+func WithTimeouts(kv TransactionalKV, timeouts Timeouts) TransactionalKV {
+	return &timeoutKV{kv: kv, timeouts: timeouts}
+}
+
+type timeoutKV struct {
+	kv       TransactionalKV
+	timeouts Timeouts
+}
+
+// withTimeout returns `ctx` bounded by `d`, unless it already has a deadline.
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return ctx, func() {}
+	}
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+func (t *timeoutKV) Put(ctx context.Context, key Key, value Value) error {
+	return timeoutPut(ctx, t.timeouts, t.kv, key, value)
+}
+
+func (t *timeoutKV) Get(ctx context.Context, key Key) (Value, bool, error) {
+	return timeoutGet(ctx, t.timeouts, t.kv, key)
+}
+
+func (t *timeoutKV) Delete(ctx context.Context, key Key) error {
+	return timeoutDelete(ctx, t.timeouts, t.kv, key)
+}
+
+func (t *timeoutKV) List(ctx context.Context, prefix Key) ([]Key, error) {
+	return timeoutList(ctx, t.timeouts, t.kv, prefix)
+}
+
+func (t *timeoutKV) Begin(ctx context.Context) (TxKV, error) {
+	ctx, cancel := withTimeout(ctx, t.timeouts.Commit)
+	defer cancel()
+	tx, err := t.kv.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &timeoutTx{tx: tx, timeouts: t.timeouts}, nil
+}
+
+type timeoutTx struct {
+	tx       TxKV
+	timeouts Timeouts
+}
+
+func (t *timeoutTx) Put(ctx context.Context, key Key, value Value) error {
+	return timeoutPut(ctx, t.timeouts, t.tx, key, value)
+}
+
+func (t *timeoutTx) Get(ctx context.Context, key Key) (Value, bool, error) {
+	return timeoutGet(ctx, t.timeouts, t.tx, key)
+}
+
+func (t *timeoutTx) Delete(ctx context.Context, key Key) error {
+	return timeoutDelete(ctx, t.timeouts, t.tx, key)
+}
+
+func (t *timeoutTx) List(ctx context.Context, prefix Key) ([]Key, error) {
+	return timeoutList(ctx, t.timeouts, t.tx, prefix)
+}
+
+func (t *timeoutTx) Commit(ctx context.Context) error {
+	ctx, cancel := withTimeout(ctx, t.timeouts.Commit)
+	defer cancel()
+	return t.tx.Commit(ctx)
+}
+
+func (t *timeoutTx) Rollback(ctx context.Context) error { return t.tx.Rollback(ctx) }
+
+func timeoutPut(ctx context.Context, timeouts Timeouts, kv KV, key Key, value Value) error {
+	ctx, cancel := withTimeout(ctx, timeouts.Put)
+	defer cancel()
+	return kv.Put(ctx, key, value)
+}
+
+func timeoutGet(ctx context.Context, timeouts Timeouts, kv KV, key Key) (Value, bool, error) {
+	ctx, cancel := withTimeout(ctx, timeouts.Get)
+	defer cancel()
+	return kv.Get(ctx, key)
+}
+
+func timeoutDelete(ctx context.Context, timeouts Timeouts, kv KV, key Key) error {
+	ctx, cancel := withTimeout(ctx, timeouts.Put)
+	defer cancel()
+	return kv.Delete(ctx, key)
+}
+
+func timeoutList(ctx context.Context, timeouts Timeouts, kv KV, prefix Key) ([]Key, error) {
+	ctx, cancel := withTimeout(ctx, timeouts.List)
+	defer cancel()
+	return kv.List(ctx, prefix)
+}
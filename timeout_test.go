@@ -0,0 +1,70 @@
+package txkv_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+// hungKV blocks Get and Commit until their context is done.
+type hungKV struct {
+	TransactionalKV
+}
+
+func (h *hungKV) Get(ctx context.Context, key Key) (Value, bool, error) {
+	<-ctx.Done()
+	return nil, false, ctx.Err()
+}
+
+func (h *hungKV) Begin(ctx context.Context) (TxKV, error) {
+	tx, err := h.TransactionalKV.Begin(ctx)
+	return &hungTx{TxKV: tx}, err
+}
+
+type hungTx struct {
+	TxKV
+}
+
+func (h *hungTx) Commit(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestTimeouts(t *testing.T) {
+	testKV(t, func(t testing.TB) TransactionalKV {
+		return WithTimeouts(InMem(), Timeouts{Get: time.Second, Put: time.Second, List: time.Second, Commit: time.Second})
+	})
+}
+
+func TestTimeoutsApplied(t *testing.T) {
+	ctx := context.Background()
+	kv := WithTimeouts(&hungKV{TransactionalKV: InMem()}, Timeouts{
+		Get:    10 * time.Millisecond,
+		Commit: 20 * time.Millisecond,
+	})
+
+	start := time.Now()
+	_, _, err := kv.Get(ctx, Key("hello"))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), time.Second)
+
+	// operations without a timeout aren't bounded
+	mustPut(ctx, t, kv, Key("hello"), Value("world"))
+
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	mustPut(ctx, t, tx, Key("hello"), Value("world"))
+	require.ErrorIs(t, tx.Commit(ctx), context.DeadlineExceeded)
+
+	// deadlines set by the caller take precedence
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	_, _, err = kv.Get(ctx, Key("hello"))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+}
@@ -0,0 +1,256 @@
+package txkv
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotInTrash is returned when restoring a key that isn't in the trash.
+var ErrNotInTrash = errors.New("txkv: key not in the trash")
+
+// DefaultTrashGCInterval is how often GC purges the trash when given an
+// interval of 0.
+const DefaultTrashGCInterval = time.Hour
+
+// TrashOptions tune a TrashKV.
+type TrashOptions struct {
+	// Prefix is where deleted entries are kept. Defaults to "\x00trash/".
+	Prefix Key
+	// Retention is how long deleted entries are kept before Purge removes
+	// them. Defaults to 7 days.
+	Retention time.Duration
+	// Now is the clock. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// WithTrash returns a TrashKV over `kv`.
+func WithTrash(kv TransactionalKV, opts TrashOptions) *TrashKV {
+	if opts.Prefix == nil {
+		opts.Prefix = Key("\x00trash/")
+	}
+	if opts.Retention <= 0 {
+		opts.Retention = 7 * 24 * time.Hour
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &TrashKV{kv: kv, opts: opts}
+}
+
+// TrashKV is a TransactionalKV whose deletes move entries to a trash area,
+// from which they can be restored until they're purged, to recover from
+// mistaken deletes in operational stores. The trash is hidden from List.
+type TrashKV struct {
+	kv   TransactionalKV
+	opts TrashOptions
+}
+
+// TrashedKey is an entry in the trash.
+type TrashedKey struct {
+	Key       Key
+	DeletedAt time.Time
+}
+
+func (t *TrashKV) Put(ctx context.Context, key Key, value Value) error {
+	return t.kv.Put(ctx, key, value)
+}
+
+func (t *TrashKV) Get(ctx context.Context, key Key) (Value, bool, error) {
+	return t.kv.Get(ctx, key)
+}
+
+func (t *TrashKV) Delete(ctx context.Context, key Key) error {
+	tx, err := t.kv.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	if err := trashDelete(ctx, t, tx, key); err != nil {
+		_ = tx.Rollback(ctx)
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (t *TrashKV) List(ctx context.Context, prefix Key) ([]Key, error) {
+	return trashList(ctx, t, t.kv, prefix)
+}
+
+func (t *TrashKV) Begin(ctx context.Context) (TxKV, error) {
+	tx, err := t.kv.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &trashTx{t: t, tx: tx}, nil
+}
+
+// Restore puts a deleted key back with the value it had, and removes it from
+// the trash. It fails if the key was written since it was deleted.
+func (t *TrashKV) Restore(ctx context.Context, key Key) error {
+	tx, err := t.kv.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	err = func() error {
+		entry, ok, err := tx.Get(ctx, t.trashKey(key))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%w: %q", ErrNotInTrash, key)
+		}
+		if _, exists, err := tx.Get(ctx, key); err != nil {
+			return err
+		} else if exists {
+			return fmt.Errorf("txkv: %q was written since it was deleted, not restoring over it", key)
+		}
+		_, value := decodeTrashed(entry)
+		if err := tx.Put(ctx, key, value); err != nil {
+			return err
+		}
+		return tx.Delete(ctx, t.trashKey(key))
+	}()
+	if err != nil {
+		_ = tx.Rollback(ctx)
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// Trashed returns the deleted keys with `prefix` in the trash, in key order.
+func (t *TrashKV) Trashed(ctx context.Context, prefix Key) ([]TrashedKey, error) {
+	keys, err := t.kv.List(ctx, t.trashKey(prefix))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]TrashedKey, 0, len(keys))
+	for _, key := range keys {
+		entry, ok, err := t.kv.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		deletedAt, _ := decodeTrashed(entry)
+		out = append(out, TrashedKey{Key: key[len(t.opts.Prefix):], DeletedAt: deletedAt})
+	}
+	return out, nil
+}
+
+// Purge removes the entries deleted longer than the retention ago from the
+// trash, and returns how many it removed.
+func (t *TrashKV) Purge(ctx context.Context) (int, error) {
+	trashed, err := t.Trashed(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	cutoff := t.opts.Now().Add(-t.opts.Retention)
+	var expired []Key
+	for _, tk := range trashed {
+		if tk.DeletedAt.Before(cutoff) {
+			expired = append(expired, t.trashKey(tk.Key))
+		}
+	}
+	if len(expired) == 0 {
+		return 0, nil
+	}
+	if err := DeleteMany(ctx, t.kv, expired); err != nil {
+		return 0, err
+	}
+	return len(expired), nil
+}
+
+// GC purges the trash every `interval` until `ctx` is done. An `interval` of
+// 0 means DefaultTrashGCInterval.
+func (t *TrashKV) GC(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		interval = DefaultTrashGCInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := t.Purge(ctx); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (t *TrashKV) trashKey(key Key) Key {
+	return append(append(Key(nil), t.opts.Prefix...), key...)
+}
+
+type trashTx struct {
+	t  *TrashKV
+	tx TxKV
+}
+
+func (t *trashTx) Put(ctx context.Context, key Key, value Value) error {
+	return t.tx.Put(ctx, key, value)
+}
+
+func (t *trashTx) Get(ctx context.Context, key Key) (Value, bool, error) {
+	return t.tx.Get(ctx, key)
+}
+
+func (t *trashTx) Delete(ctx context.Context, key Key) error {
+	return trashDelete(ctx, t.t, t.tx, key)
+}
+
+func (t *trashTx) List(ctx context.Context, prefix Key) ([]Key, error) {
+	return trashList(ctx, t.t, t.tx, prefix)
+}
+
+func (t *trashTx) Commit(ctx context.Context) error   { return t.tx.Commit(ctx) }
+func (t *trashTx) Rollback(ctx context.Context) error { return t.tx.Rollback(ctx) }
+
+// trashDelete moves `key` to the trash, within the transaction `tx`.
+func trashDelete(ctx context.Context, t *TrashKV, tx TxKV, key Key) error {
+	value, ok, err := tx.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	if err := tx.Put(ctx, t.trashKey(key), encodeTrashed(t.opts.Now(), value)); err != nil {
+		return err
+	}
+	return tx.Delete(ctx, key)
+}
+
+func trashList(ctx context.Context, t *TrashKV, kv KV, prefix Key) ([]Key, error) {
+	keys, err := kv.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	out := keys[:0]
+	for _, key := range keys {
+		if !bytes.HasPrefix(key, t.opts.Prefix) {
+			out = append(out, key)
+		}
+	}
+	return out, nil
+}
+
+// encodeTrashed prefixes `value` with its deletion time, in Unix nanoseconds.
+func encodeTrashed(deletedAt time.Time, value Value) Value {
+	out := make(Value, 8, 8+len(value))
+	binary.BigEndian.PutUint64(out, uint64(deletedAt.UnixNano()))
+	return append(out, value...)
+}
+
+func decodeTrashed(entry Value) (time.Time, Value) {
+	if len(entry) < 8 {
+		return time.Time{}, nil
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(entry))), entry[8:]
+}
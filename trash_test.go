@@ -0,0 +1,79 @@
+package txkv_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestTrash(t *testing.T) {
+	testKV(t, func(t testing.TB) TransactionalKV {
+		return WithTrash(InMem(), TrashOptions{})
+	})
+}
+
+func TestTrashRestore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1000, 0)
+	raw := InMem()
+	kv := WithTrash(raw, TrashOptions{Retention: time.Hour, Now: func() time.Time { return now }})
+
+	mustPut(ctx, t, kv, Key("a"), Value("1"))
+	mustPut(ctx, t, kv, Key("b"), Value("2"))
+	require.NoError(t, kv.Delete(ctx, Key("a")))
+	mustNotFind(ctx, t, kv, Key("a"))
+	mustList(ctx, t, kv, nil, []Key{Key("b")})
+
+	// deletes within transactions are trashed too
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Delete(ctx, Key("b")))
+	require.NoError(t, tx.Commit(ctx))
+	mustNotFind(ctx, t, kv, Key("b"))
+
+	trashed, err := kv.Trashed(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, []TrashedKey{{Key: Key("a"), DeletedAt: now}, {Key: Key("b"), DeletedAt: now}}, trashed)
+
+	require.NoError(t, kv.Restore(ctx, Key("a")))
+	mustFind(ctx, t, kv, Key("a"), Value("1"))
+	require.ErrorIs(t, kv.Restore(ctx, Key("a")), ErrNotInTrash)
+
+	// a key written since it was deleted isn't restored over
+	mustPut(ctx, t, kv, Key("b"), Value("new"))
+	require.Error(t, kv.Restore(ctx, Key("b")))
+	mustFind(ctx, t, kv, Key("b"), Value("new"))
+
+	// entries are purged past the retention
+	require.NoError(t, kv.Delete(ctx, Key("a")))
+	n, err := kv.Purge(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+	now = now.Add(2 * time.Hour)
+	n, err = kv.Purge(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	trashed, err = kv.Trashed(ctx, nil)
+	require.NoError(t, err)
+	require.Empty(t, trashed)
+	mustList(ctx, t, raw, nil, []Key{Key("b")})
+}
+
+func TestTrashGCDefaultInterval(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	now := time.Now()
+	kv := WithTrash(InMem(), TrashOptions{Retention: time.Hour, Now: func() time.Time { return now }})
+	mustPut(ctx, t, kv, Key("a"), Value("1"))
+	require.NoError(t, kv.Delete(ctx, Key("a")))
+	now = now.Add(2 * time.Hour)
+
+	require.ErrorIs(t, kv.GC(ctx, 0), context.DeadlineExceeded)
+	trashed, err := kv.Trashed(context.Background(), nil)
+	require.NoError(t, err)
+	require.Empty(t, trashed)
+}
@@ -0,0 +1,191 @@
+package txkv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ErrMalformedKey is returned when parsing a key that wasn't built by KeyOf.
+var ErrMalformedKey = errors.New("txkv: malformed key")
+
+// Segment is one part of a key built by KeyOf. Its Value is nil, a []byte, a
+// string, an int64 (for all the signed integer types), a uint64 (for all the
+// unsigned ones) or a bool.
+type Segment struct {
+	Value interface{}
+}
+
+func (s Segment) String() string {
+	switch v := s.Value.(type) {
+	case []byte:
+		return fmt.Sprintf("%x", v)
+	case string:
+		return fmt.Sprintf("%q", v)
+	}
+	return fmt.Sprint(s.Value)
+}
+
+// type codes of the segments, in the order segments of different types sort
+const (
+	tupleNil   = 0x00
+	tupleBytes = 0x01
+	tupleStr   = 0x02
+	tupleInt   = 0x15
+	tupleUint  = 0x16
+	tupleFalse = 0x26
+	tupleTrue  = 0x27
+)
+
+// KeyOf builds a key out of typed segments, instead of formatting strings by
+// hand. Keys sort by their segments in order: strings and bytes
+// lexicographically, integers numerically. A key is a prefix of the keys
+// built from more segments, so listing KeyOf("users") finds all the keys
+// built from KeyOf("users", ...).
+//
+// Segments can be nil, []byte, Key, string, bool, and any integer type.
+// KeyOf panics on other types.
+func KeyOf(segments ...interface{}) Key {
+	var key Key
+	for _, seg := range segments {
+		key = appendSegment(key, seg)
+	}
+	return key
+}
+
+// ParseKey splits a key built by KeyOf back into its segments.
+func ParseKey(key Key) ([]Segment, error) {
+	var segments []Segment
+	for i := 0; i < len(key); {
+		code := key[i]
+		i++
+		var v interface{}
+		switch code {
+		case tupleNil:
+		case tupleBytes, tupleStr:
+			b, n, ok := readEscaped(key[i:])
+			if !ok {
+				return nil, fmt.Errorf("%w: unterminated segment at byte %d", ErrMalformedKey, i-1)
+			}
+			i += n
+			if code == tupleStr {
+				v = string(b)
+			} else {
+				v = b
+			}
+		case tupleInt, tupleUint:
+			if len(key)-i < 8 {
+				return nil, fmt.Errorf("%w: short integer at byte %d", ErrMalformedKey, i-1)
+			}
+			u := binary.BigEndian.Uint64(key[i:])
+			i += 8
+			if code == tupleInt {
+				v = int64(u ^ 1<<63)
+			} else {
+				v = u
+			}
+		case tupleFalse, tupleTrue:
+			v = code == tupleTrue
+		default:
+			return nil, fmt.Errorf("%w: unknown segment type %#x at byte %d", ErrMalformedKey, code, i-1)
+		}
+		segments = append(segments, Segment{Value: v})
+	}
+	return segments, nil
+}
+
+// EntityPrefix returns the prefix of the keys of all the entities of a kind
+// in a namespace.
+func EntityPrefix(namespace, entity string) Key {
+	return KeyOf(namespace, entity)
+}
+
+// EntityKey returns the key of the entity `id` of a kind in a namespace.
+func EntityKey(namespace, entity string, id interface{}) Key {
+	return KeyOf(namespace, entity, id)
+}
+
+func appendSegment(key Key, seg interface{}) Key {
+	switch v := seg.(type) {
+	case nil:
+		return append(key, tupleNil)
+	case []byte:
+		return appendEscaped(append(key, tupleBytes), v)
+	case Key:
+		return appendEscaped(append(key, tupleBytes), v)
+	case string:
+		return appendEscaped(append(key, tupleStr), []byte(v))
+	case bool:
+		if v {
+			return append(key, tupleTrue)
+		}
+		return append(key, tupleFalse)
+	case int:
+		return appendInt(key, int64(v))
+	case int8:
+		return appendInt(key, int64(v))
+	case int16:
+		return appendInt(key, int64(v))
+	case int32:
+		return appendInt(key, int64(v))
+	case int64:
+		return appendInt(key, v)
+	case uint:
+		return appendUint(key, uint64(v))
+	case uint8:
+		return appendUint(key, uint64(v))
+	case uint16:
+		return appendUint(key, uint64(v))
+	case uint32:
+		return appendUint(key, uint64(v))
+	case uint64:
+		return appendUint(key, v)
+	}
+	panic(fmt.Sprintf("txkv: unsupported key segment type %T", seg))
+}
+
+// appendEscaped appends `b` with its 0x00 bytes escaped as 0x00 0xff, and a
+// 0x00 terminator, which keeps the lexicographic order of `b`.
+func appendEscaped(key Key, b []byte) Key {
+	for _, c := range b {
+		key = append(key, c)
+		if c == 0x00 {
+			key = append(key, 0xff)
+		}
+	}
+	return append(key, 0x00)
+}
+
+// readEscaped reads what appendEscaped wrote, returning how many bytes of
+// `key` it used.
+func readEscaped(key Key) ([]byte, int, bool) {
+	b := []byte{}
+	for i := 0; i < len(key); i++ {
+		if key[i] != 0x00 {
+			b = append(b, key[i])
+			continue
+		}
+		if i+1 < len(key) && key[i+1] == 0xff {
+			b = append(b, 0x00)
+			i++
+			continue
+		}
+		return b, i + 1, true
+	}
+	return nil, 0, false
+}
+
+// appendInt flips the sign bit so that negative numbers sort first.
+func appendInt(key Key, v int64) Key {
+	return appendUint64(append(key, tupleInt), uint64(v)^1<<63)
+}
+
+func appendUint(key Key, v uint64) Key {
+	return appendUint64(append(key, tupleUint), v)
+}
+
+func appendUint64(key Key, v uint64) Key {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	return append(key, b[:]...)
+}
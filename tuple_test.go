@@ -0,0 +1,71 @@
+package txkv_test
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestKeyOfRoundTrips(t *testing.T) {
+	key := KeyOf("users", int64(-42), uint8(7), []byte{0x00, 0x01, 0x00}, true, nil, "")
+	segments, err := ParseKey(key)
+	require.NoError(t, err)
+	require.Equal(t, []Segment{
+		{Value: "users"},
+		{Value: int64(-42)},
+		{Value: uint64(7)},
+		{Value: []byte{0x00, 0x01, 0x00}},
+		{Value: true},
+		{Value: nil},
+		{Value: ""},
+	}, segments)
+}
+
+func TestKeyOfSorts(t *testing.T) {
+	want := []Key{
+		KeyOf("a"),
+		KeyOf("a", math.MinInt64),
+		KeyOf("a", -1),
+		KeyOf("a", 0),
+		KeyOf("a", 2),
+		KeyOf("a", 10),
+		KeyOf("a\x00"),
+		KeyOf("a\x00b"),
+		KeyOf("ab"),
+	}
+	got := append([]Key(nil), want...)
+	sort.Slice(got, func(i, j int) bool { return bytes.Compare(got[i], got[j]) < 0 })
+	require.Equal(t, want, got)
+}
+
+func TestKeyOfPrefixes(t *testing.T) {
+	ctx := context.Background()
+	kv := InMem()
+	mustPut(ctx, t, kv, EntityKey("app", "user", 2), Value("bob"))
+	mustPut(ctx, t, kv, EntityKey("app", "user", 10), Value("carol"))
+	mustPut(ctx, t, kv, EntityKey("app", "users", 1), Value("not a user"))
+
+	// ids sort numerically, and "users" isn't under "user"
+	mustList(ctx, t, kv, EntityPrefix("app", "user"), []Key{
+		EntityKey("app", "user", 2),
+		EntityKey("app", "user", 10),
+	})
+}
+
+func TestParseKeyMalformed(t *testing.T) {
+	for _, key := range []Key{
+		Key("\x02unterminated"),
+		Key("\x15\x00\x01"),
+		Key("\x7f"),
+	} {
+		_, err := ParseKey(key)
+		require.ErrorIs(t, err, ErrMalformedKey)
+	}
+	require.Panics(t, func() { KeyOf(1.5) })
+}
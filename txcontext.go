@@ -0,0 +1,46 @@
+package txkv
+
+import "context"
+
+type txKey struct{}
+
+// ContextWithTx returns a context carrying `tx` as the ambient transaction,
+// which FromContext hands to the code it's passed to.
+func ContextWithTx(ctx context.Context, tx TxKV) context.Context {
+	return context.WithValue(ctx, txKey{}, tx)
+}
+
+// TxFromContext returns the ambient transaction carried by `ctx`, if any.
+func TxFromContext(ctx context.Context) (TxKV, bool) {
+	tx, ok := ctx.Value(txKey{}).(TxKV)
+	return tx, ok
+}
+
+// FromContext returns the ambient transaction carried by `ctx`, or `kv` if
+// there's none, so that code can take part in the transaction of its caller
+// without being handed it. The transaction is expected to be one of `kv`.
+func FromContext(ctx context.Context, kv KV) KV {
+	if tx, ok := TxFromContext(ctx); ok {
+		return tx
+	}
+	return kv
+}
+
+// InTx runs `fn` within the ambient transaction of `ctx`, or within a new
+// transaction of `kv` if there's none, made ambient to `fn`. A new
+// transaction is committed if `fn` succeeds and rolled back otherwise; an
+// ambient one is left to its owner.
+func InTx(ctx context.Context, kv TransactionalKV, fn func(ctx context.Context) error) error {
+	if _, ok := TxFromContext(ctx); ok {
+		return fn(ctx)
+	}
+	tx, err := kv.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	if err := fn(ContextWithTx(ctx, tx)); err != nil {
+		_ = tx.Rollback(ctx)
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,74 @@
+package txkv_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+// transfer is application code that doesn't know whether it runs in a
+// transaction.
+func transfer(ctx context.Context, kv TransactionalKV, from, to Key) error {
+	return InTx(ctx, kv, func(ctx context.Context) error {
+		store := FromContext(ctx, kv)
+		v, ok, err := store.Get(ctx, from)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("nothing to transfer")
+		}
+		if err := store.Delete(ctx, from); err != nil {
+			return err
+		}
+		return store.Put(ctx, to, v)
+	})
+}
+
+func TestFromContext(t *testing.T) {
+	ctx := context.Background()
+	kv := InMem()
+	require.Equal(t, KV(kv), FromContext(ctx, kv))
+	_, ok := TxFromContext(ctx)
+	require.False(t, ok)
+
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	txCtx := ContextWithTx(ctx, tx)
+	require.Equal(t, KV(tx), FromContext(txCtx, kv))
+	got, ok := TxFromContext(txCtx)
+	require.True(t, ok)
+	require.Equal(t, tx, got)
+	require.NoError(t, tx.Rollback(ctx))
+}
+
+func TestInTx(t *testing.T) {
+	ctx := context.Background()
+	kv := InMem()
+	mustPut(ctx, t, kv, Key("a"), Value("coin"))
+
+	// without an ambient transaction, one is made and committed
+	require.NoError(t, transfer(ctx, kv, Key("a"), Key("b")))
+	mustNotFind(ctx, t, kv, Key("a"))
+	mustFind(ctx, t, kv, Key("b"), Value("coin"))
+
+	// and rolled back on failure
+	require.Error(t, transfer(ctx, kv, Key("a"), Key("c")))
+	mustFind(ctx, t, kv, Key("b"), Value("coin"))
+
+	// an ambient transaction is joined, and left to its owner
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	txCtx := ContextWithTx(ctx, tx)
+	require.NoError(t, transfer(txCtx, kv, Key("b"), Key("c")))
+	require.NoError(t, transfer(txCtx, kv, Key("c"), Key("d")))
+	mustFind(ctx, t, kv, Key("b"), Value("coin"))
+	mustNotFind(ctx, t, kv, Key("d"))
+	require.NoError(t, tx.Commit(ctx))
+	mustNotFind(ctx, t, kv, Key("b"))
+	mustFind(ctx, t, kv, Key("d"), Value("coin"))
+}
@@ -5,6 +5,9 @@ package txkv
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
+	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/aybabtme/txkv/internal/ds"
@@ -27,7 +30,7 @@ type KV interface {
 // - atomicity: as expected
 // - consistency: as expected
 // - isolation: only read-commited
-// - durability: no, only in-memory
+// - durability: depends on the store; InMem has none, Disk syncs every commit
 type TransactionalKV interface {
 	KV
 	Begin(ctx context.Context) (TxKV, error)
@@ -40,34 +43,178 @@ type TxKV interface {
 	Rollback(ctx context.Context) error
 }
 
-// InMem returns an in-memory TransactionalKV.
-func InMem() TransactionalKV {
-	return newMemKV()
+// InMem returns an in-memory TransactionalKV. Its transactions fail to
+// commit with an error matching ErrConflict when a key they read was written
+// since, rather than overwrite that write with values computed from what it
+// replaced. Listings aren't checked.
+func InMem(opts ...InMemOption) TransactionalKV {
+	var cfg inMemConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return newMemKV(cfg)
+}
+
+// InMemOption configures the store returned by InMem.
+type InMemOption func(*inMemConfig)
+
+type inMemConfig struct {
+	cmp           func(a, b []byte) int // nil for bytewise
+	copy          bool
+	compactRatio  float64 // 0 to never compact on its own
+	lockProfiling bool
+	prefixIndexes int
+}
+
+// WithComparator orders keys with `cmp` instead of bytewise, for instance to
+// order them case-insensitively. `cmp` returns a negative number when a < b,
+// a positive one when a > b, and 0 when they're the same key. Lists,
+// iterators and samples follow that order.
+//
+// Prefixes still match bytewise. Since keys sharing a prefix needn't be
+// next to each other in a custom order, operations on a prefix go through
+// the whole store. Helpers that resume from a key, like migrate.ForEachKey,
+// assume bytewise order.
+func WithComparator(cmp func(a, b Key) int) InMemOption {
+	return func(cfg *inMemConfig) {
+		cfg.cmp = func(a, b []byte) int { return cmp(a, b) }
+	}
+}
+
+// WithCopy has the store copy the keys and values it's given and returns,
+// so that callers can reuse their buffers, and modify what they read,
+// without corrupting the store. By default, they're shared and must be left
+// alone.
+func WithCopy() InMemOption {
+	return func(cfg *inMemConfig) {
+		cfg.copy = true
+	}
+}
+
+// WithAutoCompact has the store compact itself once the deleted keys it
+// remembers outnumber `ratio` times its live keys, and are at least
+// MinAutoCompact. See Compacter.
+func WithAutoCompact(ratio float64) InMemOption {
+	return func(cfg *inMemConfig) {
+		cfg.compactRatio = ratio
+	}
 }
 
+// MinAutoCompact is the fewest deleted keys a store compacts on its own.
+const MinAutoCompact = 1024
+
 type memkv struct {
 	mu   sync.Mutex
 	smap *ds.SortedBytesToBytesMap
+	cfg  inMemConfig
+
+	// seq is bumped on every commit. revs holds the seq of the last commit
+	// that modified each key, including deleted ones, as a uvarint. Deleted
+	// keys are kept until the store is compacted, at seq compacted.
+	seq       uint64
+	revs      *ds.SortedBytesToBytesMap
+	compacted uint64
+
+	waits *lockWaits   // nil unless profiling locks
+	index *prefixIndex // nil unless indexing prefixes
+}
+
+func newMemKV(cfg inMemConfig) *memkv {
+	k := &memkv{cfg: cfg}
+	if cfg.lockProfiling {
+		k.waits = newLockWaits()
+	}
+	if cfg.cmp != nil && cfg.prefixIndexes > 0 {
+		k.index = newPrefixIndex(cfg.prefixIndexes)
+	}
+	k.smap, k.revs = k.newMap(), k.newMap()
+	return k
+}
+
+func (k *memkv) newMap() *ds.SortedBytesToBytesMap {
+	if k.cfg.cmp == nil {
+		return ds.NewSortedBytesToBytesMap()
+	}
+	return ds.NewSortedBytesToBytesMapFunc(k.cfg.cmp)
 }
 
-func newMemKV() *memkv {
-	return &memkv{smap: ds.NewSortedBytesToBytesMap()}
+// own returns a copy of `b` if the store is in copy mode, and `b` otherwise.
+func (k *memkv) own(b []byte) []byte {
+	if !k.cfg.copy || b == nil {
+		return b
+	}
+	return append([]byte{}, b...)
+}
+
+func (k *memkv) ownKeys(keys []Key) []Key {
+	if k.cfg.copy {
+		for i, key := range keys {
+			keys[i] = k.own(key)
+		}
+	}
+	return keys
+}
+
+func (k *memkv) compare(a, b []byte) int {
+	if k.cfg.cmp != nil {
+		return k.cfg.cmp(a, b)
+	}
+	return bytes.Compare(a, b)
+}
+
+// rangePrefix visits the keys of `m` with `prefix` in order, until `visit`
+// returns false.
+func (k *memkv) rangePrefix(m *ds.SortedBytesToBytesMap, prefix Key, visit func(k, v []byte) bool) {
+	if k.cfg.cmp != nil {
+		if m == k.smap && k.index != nil {
+			if idx := k.lookupIndex(prefix); idx != nil {
+				idx.Keys(func(key, _ []byte) bool {
+					v, _ := m.Get(key)
+					return visit(key, v)
+				})
+				return
+			}
+		}
+		// in a custom order, keys with the prefix can be anywhere
+		m.Keys(func(key, v []byte) bool {
+			if !bytes.HasPrefix(key, prefix) {
+				return true
+			}
+			return visit(key, v)
+		})
+		return
+	}
+	for it := m.Iter(prefix, PrefixSuccessor(prefix)); it.Next(); {
+		if !visit(it.Key(), it.Val()) {
+			return
+		}
+	}
 }
 
 func (k *memkv) Put(ctx context.Context, key Key, value Value) error {
-	k.mu.Lock()
+	key, value = k.own(key), k.own(value)
+	k.lock(OpPut)
+	k.seq++
 	k.put(key, value)
 	k.mu.Unlock()
 	return nil
 }
 
-func (k *memkv) put(key Key, value Value) { k.smap.Put(key, value) }
+func (k *memkv) put(key Key, value Value) {
+	k.smap.Put(key, value)
+	k.indexWrite(key, false)
+	k.touch(key)
+}
+
+func (k *memkv) touch(key Key) {
+	k.revs.Put(key, binary.AppendUvarint(nil, k.seq))
+}
 
 func (k *memkv) Get(ctx context.Context, key Key) (Value, bool, error) {
-	k.mu.Lock()
+	k.lock(OpGet)
 	v, ok := k.get(key)
 	k.mu.Unlock()
-	return v, ok, nil
+	return k.own(v), ok, nil
 }
 
 func (k *memkv) get(key Key) (Value, bool) {
@@ -75,143 +222,597 @@ func (k *memkv) get(key Key) (Value, bool) {
 }
 
 func (k *memkv) Delete(ctx context.Context, key Key) error {
-	k.mu.Lock()
+	k.lock(OpDelete)
+	k.seq++
 	k.delete(key)
+	k.autoCompact()
 	k.mu.Unlock()
 	return nil
 }
 
-func (k *memkv) delete(key Key) { _, _ = k.smap.Delete(key) }
+func (k *memkv) delete(key Key) {
+	if _, ok := k.smap.Delete(key); ok {
+		k.indexWrite(key, true)
+		k.touch(key)
+	}
+}
+
+func (k *memkv) Seq(ctx context.Context) (uint64, error) {
+	k.lock(opSeq)
+	defer k.mu.Unlock()
+	return k.seq, nil
+}
+
+func (k *memkv) ListModifiedSince(ctx context.Context, prefix Key, since uint64) ([]Key, error) {
+	k.lock(opChanges)
+	defer k.mu.Unlock()
+	if since < k.compacted {
+		return nil, ErrCompacted
+	}
+	var keys []Key
+	k.rangePrefix(k.revs, prefix, func(k, v []byte) bool {
+		if rev, _ := binary.Uvarint(v); rev > since {
+			keys = append(keys, k)
+		}
+		return true
+	})
+	return k.ownKeys(keys), nil
+}
 
 func (k *memkv) List(ctx context.Context, prefix Key) ([]Key, error) {
-	k.mu.Lock()
-	keys := k.list(prefix)
+	k.lock(OpList)
+	keys := k.listFiltered(prefix, nil)
 	k.mu.Unlock()
-	return keys, nil
+	return k.ownKeys(keys), nil
 }
 
-func (k *memkv) list(prefix Key) []Key {
-	firstK, _, ok := k.smap.Ceiling(prefix)
-	if !ok {
-		return nil
-	}
-	lastK, _, _ := k.smap.Max()
+func (k *memkv) ListFiltered(ctx context.Context, prefix Key, keep func(Key) bool) ([]Key, error) {
+	k.lock(OpList)
+	keys := k.listFiltered(prefix, keep)
+	k.mu.Unlock()
+	return k.ownKeys(keys), nil
+}
 
+func (k *memkv) listFiltered(prefix Key, keep func(Key) bool) []Key {
 	var keys []Key
-	k.smap.RangedKeys(firstK, lastK, func(k, v []byte) bool {
-		if !bytes.HasPrefix(k, prefix) {
-			return false
+	k.rangePrefix(k.smap, prefix, func(k, v []byte) bool {
+		if keep == nil || keep(k) {
+			keys = append(keys, k)
 		}
-		keys = append(keys, k)
 		return true
 	})
 	return keys
 }
 
+// ListWithOptions walks the map from After, stopping past the limit. In a
+// custom order, it lists the whole prefix to pick the first keys bytewise.
+func (k *memkv) ListWithOptions(ctx context.Context, prefix Key, opts ListOptions) (ListResult, error) {
+	var keys []Key
+	visit := func(key, _ []byte) bool {
+		if opts.After != nil && bytes.Compare(key, opts.After) <= 0 {
+			return true
+		}
+		keys = append(keys, key)
+		// in a custom order, the keys to keep can be anywhere
+		return k.cfg.cmp != nil || opts.Limit <= 0 || len(keys) <= opts.Limit
+	}
+	k.lock(OpList)
+	if k.cfg.cmp != nil {
+		k.rangePrefix(k.smap, prefix, visit)
+		sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+	} else if opts.After == nil || bytes.Compare(opts.After, prefix) < 0 {
+		k.rangePrefix(k.smap, prefix, visit)
+	} else {
+		for it := k.smap.Iter(keySuccessor(opts.After), PrefixSuccessor(prefix)); it.Next(); {
+			if !visit(it.Key(), it.Val()) {
+				break
+			}
+		}
+	}
+	k.mu.Unlock()
+	return truncateList(k.ownKeys(keys), opts.Limit), nil
+}
+
+func (k *memkv) ScanPartitions(ctx context.Context, prefix Key, n int) ([]Iterator, error) {
+	k.lock(opScan)
+	defer k.mu.Unlock()
+
+	if k.cfg.cmp != nil {
+		return splitKeys(k, k.listFiltered(prefix, nil), n), nil
+	}
+	// split on ranks, so partitions are balanced without visiting the keys
+	end := PrefixSuccessor(prefix)
+	lo, hi := k.prefixRanks(prefix)
+	total := hi - lo
+	if n > total {
+		n = total
+	}
+	if n <= 0 {
+		return []Iterator{&keysIterator{}}, nil
+	}
+	its := make([]Iterator, 0, n)
+	for i := 0; i < n; i++ {
+		start, _, _ := k.smap.Select(lo + i*total/n)
+		stop := end
+		if i < n-1 {
+			stop, _, _ = k.smap.Select(lo + (i+1)*total/n)
+		}
+		its = append(its, &memIterator{kv: k, next: start, end: stop})
+	}
+	return its, nil
+}
+
+func (k *memkv) Sample(ctx context.Context, prefix Key, n int) ([]Key, error) {
+	if n < 0 {
+		return nil, errNegativeSample
+	}
+	k.lock(opSample)
+	defer k.mu.Unlock()
+	if k.cfg.cmp != nil {
+		keys := k.listFiltered(prefix, nil)
+		if n >= len(keys) {
+			return k.ownKeys(keys), nil
+		}
+		sample := make([]Key, 0, n)
+		for _, i := range sampleIndexes(len(keys), n) {
+			sample = append(sample, keys[i])
+		}
+		return k.ownKeys(sample), nil
+	}
+	lo, hi := k.prefixRanks(prefix)
+	if n > hi-lo {
+		n = hi - lo
+	}
+	keys := make([]Key, 0, n)
+	for _, i := range sampleIndexes(hi-lo, n) {
+		key, _, _ := k.smap.Select(lo + i)
+		keys = append(keys, key)
+	}
+	return k.ownKeys(keys), nil
+}
+
+func (k *memkv) SizeOf(ctx context.Context, prefix Key) (SizeStats, error) {
+	k.lock(opSize)
+	defer k.mu.Unlock()
+	var stats SizeStats
+	k.rangePrefix(k.smap, prefix, func(k, v []byte) bool {
+		stats.Keys++
+		stats.KeyBytes += int64(len(k))
+		stats.ValueBytes += int64(len(v))
+		return true
+	})
+	return stats, nil
+}
+
+// DeletePrefix cuts the keys with `prefix` out of the map at once.
+func (k *memkv) DeletePrefix(ctx context.Context, prefix Key) (int, error) {
+	k.lock(opDeletePrefix)
+	defer k.mu.Unlock()
+	var keys []Key
+	k.rangePrefix(k.smap, prefix, func(key, _ []byte) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	k.seq++
+	if k.cfg.cmp != nil {
+		// the keys aren't a range in a custom order
+		batch := make([]txWrite, 0, len(keys))
+		for _, key := range keys {
+			batch = append(batch, txWrite{key: key, deleted: true})
+		}
+		k.apply(batch)
+		return len(keys), nil
+	}
+	n := k.smap.DeleteRange(prefix, PrefixSuccessor(prefix))
+	rev := binary.AppendUvarint(nil, k.seq)
+	touched := make([]ds.Entry, 0, len(keys))
+	for _, key := range keys {
+		touched = append(touched, ds.Entry{Key: key, Val: rev})
+	}
+	k.revs.PutAll(touched)
+	k.autoCompact()
+	return n, nil
+}
+
+// Patch patches the value under the lock. Values can be shared with callers,
+// so the patched value is a copy.
+func (k *memkv) Patch(ctx context.Context, key Key, ops ...PatchOp) (int, error) {
+	key = k.own(key)
+	k.lock(opPatch)
+	defer k.mu.Unlock()
+	v, _ := k.get(key)
+	patched, err := patchValue(v, ops)
+	if err != nil {
+		return 0, err
+	}
+	k.seq++
+	k.put(key, patched)
+	return len(patched), nil
+}
+
+// Apply writes all the ops under the lock, as a single commit.
+func (k *memkv) Apply(ctx context.Context, ops []Op) error {
+	writes := make([]txWrite, 0, len(ops))
+	for _, op := range ops {
+		writes = append(writes, txWrite{key: k.own(op.Key), value: k.own(op.Value), deleted: op.Delete})
+	}
+	sorted := k.sortWrites(writes)
+
+	k.lock(opApply)
+	k.seq++
+	k.apply(sorted)
+	k.mu.Unlock()
+	return nil
+}
+
+// sortWrites sorts `writes` in key order, in place, keeping only the last
+// write of each key.
+func (k *memkv) sortWrites(writes []txWrite) []txWrite {
+	sort.SliceStable(writes, func(i, j int) bool {
+		return k.compare(writes[i].key, writes[j].key) < 0
+	})
+	sorted := writes[:0]
+	for i, w := range writes {
+		if i+1 < len(writes) && k.compare(w.key, writes[i+1].key) == 0 {
+			continue
+		}
+		sorted = append(sorted, w)
+	}
+	return sorted
+}
+
+// BulkLoad puts entries straight into the map, a batch at a time, without
+// the bookkeeping of transactions.
+func (k *memkv) BulkLoad(ctx context.Context, iter EntryIterator, opts BulkLoadOptions) (int, error) {
+	return bulkLoad(ctx, iter, opts, func(batch []entry) error {
+		writes := make([]txWrite, 0, len(batch))
+		for _, e := range batch {
+			writes = append(writes, txWrite{key: k.own(e.key), value: k.own(e.value)})
+		}
+		sorted := k.sortWrites(writes)
+
+		k.lock(opBulkLoad)
+		k.seq++
+		k.apply(sorted)
+		k.mu.Unlock()
+		return nil
+	})
+}
+
+// prefixRanks returns the ranks of the first key with `prefix`, and of the
+// first key after them.
+func (k *memkv) prefixRanks(prefix Key) (lo, hi int) {
+	lo, hi = k.smap.Rank(prefix), k.smap.Size()
+	if end := PrefixSuccessor(prefix); end != nil {
+		hi = k.smap.Rank(end)
+	}
+	return lo, hi
+}
+
+// memIterator walks the keys in [next, end) without holding the lock
+// between calls, so it sees the writes committed meanwhile.
+type memIterator struct {
+	kv    *memkv
+	next  Key
+	end   Key
+	done  bool
+	key   Key
+	value Value
+}
+
+func (it *memIterator) Next(ctx context.Context) bool {
+	if it.done {
+		return false
+	}
+	it.kv.lock(opIterate)
+	k, v, ok := it.kv.smap.Ceiling(it.next)
+	it.kv.mu.Unlock()
+	if !ok || !inRange(k, it.end) {
+		it.done, it.key, it.value = true, nil, nil
+		return false
+	}
+	it.next, it.key, it.value = keySuccessor(k), it.kv.own(k), it.kv.own(v)
+	return true
+}
+
+func (it *memIterator) Key() Key     { return it.key }
+func (it *memIterator) Value() Value { return it.value }
+func (it *memIterator) Err() error   { return nil }
+func (it *memIterator) Close() error { it.done = true; return nil }
+
 func (k *memkv) Begin(ctx context.Context) (TxKV, error) {
-	return &txmemkv{
-		root:       k,
-		tx:         newMemKV(),
-		updated:    make(map[string]struct{}),
-		tombstones: make(map[string]struct{}),
-	}, nil
+	return &txmemkv{root: k}, nil
 }
 
 type txmemkv struct {
 	root *memkv
-	tx   *memkv
 
-	mu         sync.Mutex
-	updated    map[string]struct{}
-	tombstones map[string]struct{}
+	mu sync.Mutex
+	// writes holds what the transaction wrote to each key, in key order: its
+	// value, or tombstone if it was deleted. It's only made on the first
+	// write: until then, reads go straight to the root.
+	writes *ds.SortedBytesToBytesMap
+	// reads holds the revision of each key the transaction read from the
+	// root, as it was when first read, to tell at commit if it was written
+	// since. A key that was never written has no revision.
+	reads map[string][]byte
 }
 
-func (k *txmemkv) Put(ctx context.Context, key Key, value Value) error {
-	k.mu.Lock()
-	delete(k.tombstones, string(key)) // if it was delete, it's not anymore
-	k.updated[string(key)] = struct{}{}
-	err := k.tx.Put(ctx, key, value)
+// tombstone marks deleted keys in the writes of a transaction. It's told
+// apart from empty values by its address, which callers can't share.
+var tombstone = tombstoneMark[:0]
+
+var tombstoneMark [1]byte
+
+func isTombstone(v []byte) bool {
+	return cap(v) == 1 && &v[:1][0] == &tombstoneMark[0]
+}
+
+func (k *txmemkv) write(op string, key Key, value Value) {
+	k.lock(op)
+	if k.writes == nil {
+		k.writes = k.root.newMap()
+	}
+	k.writes.Put(key, value)
 	k.mu.Unlock()
-	return err
 }
 
+func (k *txmemkv) Put(ctx context.Context, key Key, value Value) error {
+	k.write(OpPut, k.root.own(key), k.root.own(value))
+	return nil
+}
+
+// Get looks the key up in the transaction's writes, and only then in the
+// root, after letting go of the transaction's lock: reads never hold both.
 func (k *txmemkv) Get(ctx context.Context, key Key) (Value, bool, error) {
-	k.mu.Lock()
-	if _, ok := k.tombstones[string(key)]; ok {
-		k.mu.Unlock()
-		return nil, false, nil
-	}
-	if _, ok := k.updated[string(key)]; ok {
-		k.mu.Unlock()
-		return k.tx.Get(ctx, key)
+	k.lock(OpGet)
+	if k.writes != nil {
+		if v, ok := k.writes.Get(key); ok {
+			k.mu.Unlock()
+			if isTombstone(v) {
+				return nil, false, nil
+			}
+			return k.root.own(v), true, nil
+		}
 	}
+	k.mu.Unlock()
 	// we offer read-commited, we don't offer repeatable-reads: we'll see
 	// concurrently commited changes to the underlying KV
-	v, ok, err := k.root.Get(ctx, key)
+	k.root.lock(OpGet)
+	v, ok := k.root.get(key)
+	rev, _ := k.root.revs.Get(key)
+	k.root.mu.Unlock()
+
+	k.lock(OpGet)
+	if k.reads == nil {
+		k.reads = make(map[string][]byte)
+	}
+	if _, seen := k.reads[string(key)]; !seen {
+		k.reads[string(key)] = rev
+	}
 	k.mu.Unlock()
-	return v, ok, err
+	return k.root.own(v), ok, nil
 }
 
 func (k *txmemkv) Delete(ctx context.Context, key Key) error {
-	k.mu.Lock()
-	k.tombstones[string(key)] = struct{}{}
-	delete(k.updated, string(key)) // remove from updated set, if it was there
-	err := k.tx.Delete(ctx, key)
-	k.mu.Unlock()
-	return err
+	k.write(OpDelete, k.root.own(key), tombstone)
+	return nil
 }
 
 func (k *txmemkv) List(ctx context.Context, prefix Key) ([]Key, error) {
-	k.mu.Lock()
-	k.root.mu.Lock()
-	k.tx.mu.Lock()
+	return k.ListFiltered(ctx, prefix, nil)
+}
+
+// ListFiltered merges the transaction's writes with a single snapshot of the
+// root, listed in one go under its lock, so the keys it returns are always
+// those of a state that existed: a commit lands either before or after the
+// listing, never halfway through.
+func (k *txmemkv) ListFiltered(ctx context.Context, prefix Key, keep func(Key) bool) ([]Key, error) {
+	k.lock(OpList)
+	if k.writes == nil {
+		k.mu.Unlock()
+		return k.root.ListFiltered(ctx, prefix, keep)
+	}
+	defer k.mu.Unlock()
 
-	keys := k.root.list(prefix)
+	k.root.lock(OpList)
+	rootKeys := k.root.listFiltered(prefix, keep)
 	k.root.mu.Unlock()
 
-	txkeys := k.tx.list(prefix)
-	k.tx.mu.Unlock()
+	var writes []txWrite
+	k.root.rangePrefix(k.writes, prefix, func(key, v []byte) bool {
+		if deleted := isTombstone(v); deleted || keep == nil || keep(key) {
+			writes = append(writes, txWrite{key: key, deleted: deleted})
+		}
+		return true
+	})
+
+	return k.root.ownKeys(mergeKeys(k.root.compare, rootKeys, writes)), nil
+}
 
-	merged := ds.NewSortedBytesSet()
-	for _, key := range keys {
-		if _, ok := k.tombstones[string(key)]; !ok {
-			merged.Put(key)
+// ListWithOptions resumes from After in the root when the transaction
+// hasn't written yet, and lists the whole prefix otherwise.
+func (k *txmemkv) ListWithOptions(ctx context.Context, prefix Key, opts ListOptions) (ListResult, error) {
+	k.lock(OpList)
+	written := k.writes != nil
+	k.mu.Unlock()
+	if !written {
+		return k.root.ListWithOptions(ctx, prefix, opts)
+	}
+	return ListWithOptions(ctx, struct{ KV }{k}, prefix, opts)
+}
+
+// mergeKeys merges the sorted keys of the root with the sorted writes of a
+// transaction, leaving out the keys it deleted, in a single pass.
+func mergeKeys(compare func(a, b []byte) int, rootKeys []Key, writes []txWrite) []Key {
+	var out []Key
+	i, j := 0, 0
+	for i < len(rootKeys) || j < len(writes) {
+		var cmp int
+		switch {
+		case i == len(rootKeys):
+			cmp = 1
+		case j == len(writes):
+			cmp = -1
+		default:
+			cmp = compare(rootKeys[i], writes[j].key)
+		}
+		switch {
+		case cmp < 0:
+			out = append(out, rootKeys[i])
+			i++
+		case cmp > 0:
+			if !writes[j].deleted {
+				out = append(out, writes[j].key)
+			}
+			j++
+		default:
+			if !writes[j].deleted {
+				out = append(out, writes[j].key)
+			}
+			i++
+			j++
 		}
 	}
-	k.mu.Unlock()
+	return out
+}
 
-	for _, key := range txkeys {
-		merged.Put(key)
+func (k *txmemkv) Pending(ctx context.Context) ([]Op, error) {
+	k.lock(opPending)
+	defer k.mu.Unlock()
+	if k.writes == nil {
+		return nil, nil
 	}
-	var out []Key
-	merged.Keys(func(k []byte) bool {
-		out = append(out, Key(k))
+	ops := make([]Op, 0, k.writes.Size())
+	k.writes.Keys(func(key, v []byte) bool {
+		if isTombstone(v) {
+			ops = append(ops, DeleteOp(k.root.own(key)))
+		} else {
+			ops = append(ops, PutOp(k.root.own(key), k.root.own(v)))
+		}
 		return true
 	})
-	return out, nil
+	return ops, nil
 }
 
 func (k *txmemkv) Commit(ctx context.Context) error {
-	k.mu.Lock()
-	k.root.mu.Lock()
-	k.tx.mu.Lock()
-
-	for deleted := range k.tombstones {
-		k.root.delete(Key(deleted))
+	k.lock(OpCommit)
+	defer k.mu.Unlock()
+
+	// build the batch without holding the root lock, so that readers of the
+	// root only wait for it to be applied
+	var batch []txWrite
+	if k.writes != nil {
+		batch = make([]txWrite, 0, k.writes.Size())
+		k.writes.Keys(func(key, v []byte) bool {
+			if isTombstone(v) {
+				batch = append(batch, txWrite{key: key, deleted: true})
+			} else {
+				batch = append(batch, txWrite{key: key, value: v})
+			}
+			return true
+		})
 	}
-	for updated := range k.updated {
-		key := Key(updated)
-		if v, ok := k.tx.get(key); ok {
-			k.root.put(key, v)
+
+	k.root.lock(OpCommit)
+	defer k.root.mu.Unlock()
+	if len(batch) > 0 {
+		if err := k.root.checkReads(k.reads); err != nil {
+			return err
 		}
 	}
+	k.root.seq++
+	k.root.apply(batch)
+	return nil
+}
 
-	k.root.mu.Unlock()
-	k.tx.mu.Unlock()
-	k.mu.Unlock()
+// readRevs returns the revisions of the keys the transaction read.
+func (k *txmemkv) readRevs() map[string][]byte {
+	k.lock(OpCommit)
+	defer k.mu.Unlock()
+	return k.reads
+}
+
+// checkReads fails with ErrConflict if any key of `reads` was written since
+// its revision was read, so that a transaction doesn't overwrite a write it
+// didn't see with values computed from what it replaced. It must be called
+// with the lock held.
+func (k *memkv) checkReads(reads map[string][]byte) error {
+	for key, read := range reads {
+		if rev, _ := k.revs.Get([]byte(key)); !bytes.Equal(rev, read) {
+			return fmt.Errorf("%w: %q was written since it was read", ErrConflict, key)
+		}
+	}
 	return nil
 }
 
+// txWrite is a key written by a transaction.
+type txWrite struct {
+	key     Key
+	value   Value
+	deleted bool
+}
+
+// apply writes a batch sorted in key order.
+func (k *memkv) apply(batch []txWrite) {
+	puts := make([]ds.Entry, 0, len(batch))
+	touched := make([]ds.Entry, 0, len(batch))
+	rev := binary.AppendUvarint(nil, k.seq)
+	for _, w := range batch {
+		if !w.deleted {
+			puts = append(puts, ds.Entry{Key: w.key, Val: w.value})
+		} else if _, ok := k.smap.Delete(w.key); !ok {
+			continue
+		}
+		k.indexWrite(w.key, w.deleted)
+		touched = append(touched, ds.Entry{Key: w.key, Val: rev})
+	}
+	k.smap.PutAll(puts)
+	k.revs.PutAll(touched)
+	k.autoCompact()
+}
+
+// Compact forgets the deleted keys, rebuilding the map of revisions with
+// only the live ones.
+func (k *memkv) Compact(ctx context.Context) (int, error) {
+	k.lock(opCompact)
+	defer k.mu.Unlock()
+	return k.compact(), nil
+}
+
+func (k *memkv) compact() int {
+	live := make([]ds.Entry, 0, k.smap.Size())
+	k.revs.Keys(func(key, rev []byte) bool {
+		if k.smap.Has(key) {
+			live = append(live, ds.Entry{Key: key, Val: rev})
+		}
+		return true
+	})
+	dead := k.revs.Size() - len(live)
+	if dead == 0 {
+		return 0
+	}
+	revs := k.newMap()
+	revs.PutAll(live)
+	k.revs, k.compacted = revs, k.seq
+	return dead
+}
+
+// autoCompact compacts the store if it's set to, and enough keys are dead.
+// It must be called with the lock held.
+func (k *memkv) autoCompact() {
+	if k.cfg.compactRatio <= 0 {
+		return
+	}
+	dead := k.revs.Size() - k.smap.Size()
+	if dead >= MinAutoCompact && float64(dead) > k.cfg.compactRatio*float64(k.smap.Size()) {
+		k.compact()
+	}
+}
+
 func (k *txmemkv) Rollback(ctx context.Context) error {
 	// do nothing
 	return nil
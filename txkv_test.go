@@ -1,7 +1,10 @@
 package txkv_test
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -13,6 +16,210 @@ func TestInMem(t *testing.T) {
 	testKV(t, func(t testing.TB) TransactionalKV { return InMem() })
 }
 
+func TestInMemCopy(t *testing.T) {
+	testKV(t, func(t testing.TB) TransactionalKV { return InMem(WithCopy()) })
+
+	ctx := context.Background()
+	kv := InMem(WithCopy())
+	key, value := Key("key"), Value("value")
+	mustPut(ctx, t, kv, key, value)
+	key[0], value[0] = 'X', 'X'
+	mustFind(ctx, t, kv, Key("key"), Value("value"))
+
+	got, _, err := kv.Get(ctx, Key("key"))
+	require.NoError(t, err)
+	got[0] = 'X'
+	keys, err := kv.List(ctx, Key(""))
+	require.NoError(t, err)
+	keys[0][0] = 'X'
+	mustFind(ctx, t, kv, Key("key"), Value("value"))
+}
+
+func TestInMemTxListConsistent(t *testing.T) {
+	ctx := context.Background()
+	kv := InMem()
+	mustPut(ctx, t, kv, Key("k/0"), Value("token"))
+	mustPut(ctx, t, kv, Key("other"), Value("x"))
+
+	// a writer moves the token from key to key, one commit at a time; every
+	// state has it exactly once
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			tx, err := kv.Begin(ctx)
+			if err == nil {
+				_ = tx.Delete(ctx, Key(fmt.Sprintf("k/%d", i%10)))
+				_ = tx.Put(ctx, Key(fmt.Sprintf("k/%d", (i+1)%10)), Value("token"))
+				err = tx.Commit(ctx)
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Put(ctx, Key("k/mine"), Value("x")))
+	for i := 0; i < 2000; i++ {
+		keys, err := tx.List(ctx, Key("k/"))
+		require.NoError(t, err)
+		require.Len(t, keys, 2, "%q", keys)
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestInMemTxWrites(t *testing.T) {
+	ctx := context.Background()
+	kv := InMem()
+	mustPut(ctx, t, kv, Key("a"), Value("root"))
+	mustPut(ctx, t, kv, Key("b"), Value("root"))
+
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	// empty values aren't deletions
+	mustPut(ctx, t, tx, Key("empty"), Value{})
+	mustFind(ctx, t, tx, Key("empty"), Value{})
+	mustDelete(ctx, t, tx, Key("a"))
+	mustNotFind(ctx, t, tx, Key("a"))
+	mustPut(ctx, t, tx, Key("a"), Value("again"))
+	mustFind(ctx, t, tx, Key("a"), Value("again"))
+	mustPut(ctx, t, tx, Key("c"), Value("tx"))
+	mustDelete(ctx, t, tx, Key("c"))
+	mustDelete(ctx, t, tx, Key("b"))
+	mustList(ctx, t, tx, Key(""), []Key{Key("a"), Key("empty")})
+	mustList(ctx, t, kv, Key(""), []Key{Key("a"), Key("b")})
+	require.NoError(t, tx.Commit(ctx))
+
+	mustList(ctx, t, kv, Key(""), []Key{Key("a"), Key("empty")})
+	mustFind(ctx, t, kv, Key("a"), Value("again"))
+	mustFind(ctx, t, kv, Key("empty"), Value{})
+}
+
+func TestInMemConflict(t *testing.T) {
+	testConflict(t, InMem())
+}
+
+func testConflict(t *testing.T, kv TransactionalKV) {
+	ctx := context.Background()
+	mustPut(ctx, t, kv, Key("n"), Value("0"))
+
+	// a write landing after a read fails the commit
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	mustFind(ctx, t, tx, Key("n"), Value("0"))
+	mustPut(ctx, t, kv, Key("n"), Value("1"))
+	require.NoError(t, tx.Put(ctx, Key("n"), Value("tx")))
+	require.ErrorIs(t, tx.Commit(ctx), ErrConflict)
+	mustFind(ctx, t, kv, Key("n"), Value("1"))
+
+	// so do the creation and deletion of keys read
+	tx, err = kv.Begin(ctx)
+	require.NoError(t, err)
+	mustNotFind(ctx, t, tx, Key("new"))
+	mustPut(ctx, t, kv, Key("new"), Value("x"))
+	require.NoError(t, tx.Put(ctx, Key("other"), Value("tx")))
+	require.ErrorIs(t, tx.Commit(ctx), ErrConflict)
+	mustNotFind(ctx, t, kv, Key("other"))
+
+	tx, err = kv.Begin(ctx)
+	require.NoError(t, err)
+	mustFind(ctx, t, tx, Key("new"), Value("x"))
+	mustDelete(ctx, t, kv, Key("new"))
+	require.NoError(t, tx.Put(ctx, Key("other"), Value("tx")))
+	require.ErrorIs(t, tx.Commit(ctx), ErrConflict)
+
+	// keys written without being read, and reads without writes, don't
+	tx, err = kv.Begin(ctx)
+	require.NoError(t, err)
+	mustFind(ctx, t, tx, Key("n"), Value("1"))
+	mustPut(ctx, t, kv, Key("n"), Value("2"))
+	require.NoError(t, tx.Commit(ctx))
+	tx, err = kv.Begin(ctx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Put(ctx, Key("n"), Value("tx")))
+	mustPut(ctx, t, kv, Key("n"), Value("3"))
+	require.NoError(t, tx.Commit(ctx))
+	mustFind(ctx, t, kv, Key("n"), Value("tx"))
+
+	// increments retried on conflicts all land
+	mustPut(ctx, t, kv, Key("n"), Value(""))
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := RetryTx(ctx, kv, RetryPolicy{MaxAttempts: 1000}, func(ctx context.Context, tx TxKV) error {
+				v, _, err := tx.Get(ctx, Key("n"))
+				if err != nil {
+					return err
+				}
+				return tx.Put(ctx, Key("n"), append(v[:len(v):len(v)], '+'))
+			})
+			require.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	mustFind(ctx, t, kv, Key("n"), Value("++++++++++"))
+}
+
+// caseInsensitive orders keys ignoring case, breaking ties bytewise.
+func caseInsensitive(a, b Key) int {
+	if c := bytes.Compare(bytes.ToLower(a), bytes.ToLower(b)); c != 0 {
+		return c
+	}
+	return bytes.Compare(a, b)
+}
+
+func TestInMemComparator(t *testing.T) {
+	testKV(t, func(t testing.TB) TransactionalKV { return InMem(WithComparator(caseInsensitive)) })
+
+	ctx := context.Background()
+	kv := InMem(WithComparator(caseInsensitive))
+	for _, key := range []string{"a/B", "a/c", "A/d", "a/a", "b"} {
+		mustPut(ctx, t, kv, Key(key), Value(key))
+	}
+	mustList(ctx, t, kv, Key(""), []Key{Key("a/a"), Key("a/B"), Key("a/c"), Key("A/d"), Key("b")})
+	// prefixes still match bytewise, though their keys aren't together
+	mustList(ctx, t, kv, Key("a/"), []Key{Key("a/a"), Key("a/B"), Key("a/c")})
+
+	// transactions merge their writes in the same order
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	mustPut(ctx, t, tx, Key("a/b"), Value("a/b"))
+	mustDelete(ctx, t, tx, Key("a/c"))
+	mustList(ctx, t, tx, Key("a/"), []Key{Key("a/a"), Key("a/B"), Key("a/b")})
+	require.NoError(t, tx.Commit(ctx))
+	mustList(ctx, t, kv, Key(""), []Key{Key("a/a"), Key("a/B"), Key("a/b"), Key("A/d"), Key("b")})
+
+	// and so do iterators
+	its, err := ScanPartitions(ctx, kv, Key("a/"), 2)
+	require.NoError(t, err)
+	var scanned []Key
+	for _, it := range its {
+		for it.Next(ctx) {
+			scanned = append(scanned, it.Key())
+		}
+		require.NoError(t, it.Err())
+		require.NoError(t, it.Close())
+	}
+	require.Equal(t, []Key{Key("a/a"), Key("a/B"), Key("a/b")}, scanned)
+
+	stats, err := SizeOf(ctx, kv, Key("a/"))
+	require.NoError(t, err)
+	require.Equal(t, 3, stats.Keys)
+}
+
 func testKV(t *testing.T, mkKV func(t testing.TB) TransactionalKV) {
 	t.Helper()
 	tests := []struct {
@@ -236,6 +443,31 @@ func testKV(t *testing.T, mkKV func(t testing.TB) TransactionalKV) {
 				mustList(ctx, t, kv, Key(prefix), wantAfterTx)
 			},
 		},
+		{
+			name: "list in tx merges its writes",
+			op: func(ctx context.Context, t *testing.T, kv TransactionalKV) {
+				dummy := Value("world")
+				for _, k := range []string{"a", "c", "e", "g"} {
+					mustPut(ctx, t, kv, Key(k), dummy)
+				}
+
+				tx, err := kv.Begin(ctx)
+				require.NoError(t, err)
+				mustPut(ctx, t, tx, Key("b"), dummy) // before a root key
+				mustPut(ctx, t, tx, Key("c"), dummy) // over a root key
+				mustDelete(ctx, t, tx, Key("e"))     // hiding a root key
+				mustPut(ctx, t, tx, Key("h"), dummy) // after all root keys
+				mustList(ctx, t, tx, Key(""), []Key{Key("a"), Key("b"), Key("c"), Key("g"), Key("h")})
+
+				// committed changes show through, unless the tx wrote over them
+				mustPut(ctx, t, kv, Key("d"), dummy)
+				mustDelete(ctx, t, kv, Key("c"))
+				mustDelete(ctx, t, kv, Key("g"))
+				mustList(ctx, t, tx, Key(""), []Key{Key("a"), Key("b"), Key("c"), Key("d"), Key("h")})
+
+				require.NoError(t, tx.Rollback(ctx))
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -284,3 +516,135 @@ func mustList(ctx context.Context, t *testing.T, kv KV, prefix Key, want []Key)
 	require.NoError(t, err)
 	require.Equal(t, want, got)
 }
+
+func BenchmarkCommit(b *testing.B) {
+	for _, keys := range []int{1, 100} {
+		for _, readers := range []int{0, 4} {
+			b.Run(fmt.Sprintf("keys=%d/readers=%d", keys, readers), func(b *testing.B) {
+				benchmarkCommit(b, keys, readers)
+			})
+		}
+	}
+}
+
+// benchmarkCommit commits transactions of `keys` writes, while `readers`
+// list and get from the store.
+func benchmarkCommit(b *testing.B, keys, readers int) {
+	ctx := context.Background()
+	kv := InMem()
+	for i := 0; i < 10000; i++ {
+		if err := kv.Put(ctx, Key(fmt.Sprintf("base/%05d", i)), Value("value")); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				_, _ = kv.List(ctx, Key("base/0"))
+				_, _, _ = kv.Get(ctx, Key("base/00042"))
+			}
+		}()
+	}
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tx, err := kv.Begin(ctx)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for j := 0; j < keys; j++ {
+			_ = tx.Put(ctx, Key(fmt.Sprintf("tx/%05d", (i*keys+j)%10000)), Value("value"))
+		}
+		if err := tx.Commit(ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkTxGet reads from a transaction shared by concurrent readers, keys
+// it wrote and keys it didn't.
+func BenchmarkTxGet(b *testing.B) {
+	ctx := context.Background()
+	kv := InMem()
+	for i := 0; i < 1000; i++ {
+		if err := kv.Put(ctx, Key(fmt.Sprintf("root/%04d", i)), Value("value")); err != nil {
+			b.Fatal(err)
+		}
+	}
+	tx, err := kv.Begin(ctx)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		if err := tx.Put(ctx, Key(fmt.Sprintf("tx/%04d", i)), Value("value")); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	for _, prefix := range []string{"tx", "root"} {
+		b.Run(prefix, func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					if _, _, err := tx.Get(ctx, Key(fmt.Sprintf("%s/%04d", prefix, i%1000))); err != nil {
+						b.Fatal(err)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkReadOnlyTx runs transactions that only read, and for comparison
+// ones that also write a key, which takes them off the read-only path.
+func BenchmarkReadOnlyTx(b *testing.B) {
+	ctx := context.Background()
+	kv := InMem()
+	for i := 0; i < 1000; i++ {
+		if err := kv.Put(ctx, Key(fmt.Sprintf("root/%04d", i)), Value("value")); err != nil {
+			b.Fatal(err)
+		}
+	}
+	for _, write := range []bool{false, true} {
+		b.Run(fmt.Sprintf("write=%v", write), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				tx, err := kv.Begin(ctx)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if write {
+					if err := tx.Put(ctx, Key("other"), Value("value")); err != nil {
+						b.Fatal(err)
+					}
+				}
+				for j := 0; j < 10; j++ {
+					if _, _, err := tx.Get(ctx, Key(fmt.Sprintf("root/%04d", (i+j)%1000))); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if _, err := tx.List(ctx, Key("root/00")); err != nil {
+					b.Fatal(err)
+				}
+				if err := tx.Rollback(ctx); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
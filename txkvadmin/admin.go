@@ -0,0 +1,285 @@
+// Package txkvadmin serves operational actions on a txkv store over HTTP, so
+// operators don't need a shell next to the store.
+//
+// Every request is authorized by a txkv.Policy, for txkv.ActionAdmin on the
+// key naming the action: "backup", "restore", "compact", "read-only",
+// "stats" or "quotas". Actions the store can't perform answer 501.
+//
+//	GET  /backup?prefix=&format=jsonl|csv  exports the keys, as txkv.Export
+//	POST /restore?format=jsonl|csv         imports the body, as txkv.Import
+//	POST /compact                          compacts, if a txkv.Compacter
+//	GET  /read-only                        tells if writes are frozen
+//	PUT  /read-only                        freezes or unfreezes writes, with
+//	                                       a body of {"read_only": bool}
+//	GET  /stats?prefix=                    reports txkv.SizeOf, the seq of
+//	                                       txkv.ChangeTrackers, and the lock
+//	                                       waits of txkv.LockProfilers
+//	GET  /quotas                           reports the usage of Options.Quotas
+package txkvadmin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/aybabtme/txkv"
+)
+
+// Options tune the handler.
+type Options struct {
+	// Policy authorizes the actions. Required.
+	Policy txkv.Policy
+	// Principal tells on behalf of whom a request is made, for instance from
+	// its client certificate. Requests have no principal by default.
+	Principal func(r *http.Request) (txkv.Principal, error)
+	// Compacter compacts the store, when it's wrapped. Defaults to the store,
+	// if it's a txkv.Compacter.
+	Compacter txkv.Compacter
+	// Quotas, if set, are reported by /quotas.
+	Quotas *txkv.QuotaKV
+	// LockProfiler, if set, has /stats report lock waits. Defaults to the
+	// store, if it's a txkv.LockProfiler.
+	LockProfiler txkv.LockProfiler
+}
+
+// NewHandler returns the admin handler of `kv`.
+func NewHandler(kv txkv.TransactionalKV, opts Options) http.Handler {
+	if opts.Compacter == nil {
+		opts.Compacter, _ = kv.(txkv.Compacter)
+	}
+	if opts.LockProfiler == nil {
+		opts.LockProfiler, _ = kv.(txkv.LockProfiler)
+	}
+	h := &handler{kv: kv, opts: opts}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/backup", h.action("backup", http.MethodGet, h.backup))
+	mux.HandleFunc("/restore", h.action("restore", http.MethodPost, h.restore))
+	mux.HandleFunc("/compact", h.action("compact", http.MethodPost, h.compact))
+	mux.HandleFunc("/read-only", h.action("read-only", "", h.readOnly))
+	mux.HandleFunc("/stats", h.action("stats", http.MethodGet, h.stats))
+	mux.HandleFunc("/quotas", h.action("quotas", http.MethodGet, h.quotas))
+	return mux
+}
+
+type handler struct {
+	kv   txkv.TransactionalKV
+	opts Options
+}
+
+// statusError is an error answered with a given status.
+type statusError struct {
+	status int
+	err    error
+}
+
+func (e *statusError) Error() string { return e.err.Error() }
+
+func errorf(status int, format string, args ...interface{}) error {
+	return &statusError{status: status, err: fmt.Errorf(format, args...)}
+}
+
+// action authorizes the requests of action `name`, made with `method` unless
+// empty, before serving them with `serve`.
+func (h *handler) action(name, method string, serve func(w http.ResponseWriter, r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := h.authorize(r, name)
+		if err == nil && method != "" && r.Method != method {
+			err = errorf(http.StatusMethodNotAllowed, "txkvadmin: %s wants %s", name, method)
+		}
+		if err == nil {
+			err = serve(w, r)
+		}
+		if err != nil {
+			writeError(w, err)
+		}
+	}
+}
+
+func (h *handler) authorize(r *http.Request, name string) error {
+	if h.opts.Policy == nil {
+		return errorf(http.StatusForbidden, "txkvadmin: no policy")
+	}
+	var p txkv.Principal
+	if h.opts.Principal != nil {
+		var err error
+		if p, err = h.opts.Principal(r); err != nil {
+			return &statusError{status: http.StatusUnauthorized, err: err}
+		}
+	}
+	ok, err := h.opts.Policy(r.Context(), p, txkv.ActionAdmin, txkv.Key(name))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return &txkv.PermissionDeniedError{Principal: p, Action: txkv.ActionAdmin, Key: txkv.Key(name)}
+	}
+	return nil
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var se *statusError
+	switch {
+	case errors.As(err, &se):
+		status = se.status
+	case errors.Is(err, txkv.ErrPermissionDenied):
+		status = http.StatusForbidden
+	case errors.Is(err, txkv.ErrReadOnly), errors.Is(err, txkv.ErrQuotaExceeded):
+		status = http.StatusConflict
+	}
+	http.Error(w, err.Error(), status)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(v)
+}
+
+func format(r *http.Request) (txkv.Format, error) {
+	switch f := r.URL.Query().Get("format"); f {
+	case "", "jsonl":
+		return txkv.FormatJSONL, nil
+	case "csv":
+		return txkv.FormatCSV, nil
+	default:
+		return 0, errorf(http.StatusBadRequest, "txkvadmin: unknown format %q", f)
+	}
+}
+
+func (h *handler) backup(w http.ResponseWriter, r *http.Request) error {
+	f, err := format(r)
+	if err != nil {
+		return err
+	}
+	// once the body started, errors can only cut it short
+	_, err = txkv.Export(r.Context(), h.kv, txkv.Key(r.URL.Query().Get("prefix")), w, f)
+	return err
+}
+
+func (h *handler) restore(w http.ResponseWriter, r *http.Request) error {
+	f, err := format(r)
+	if err != nil {
+		return err
+	}
+	n, err := txkv.Import(r.Context(), h.kv, r.Body, f, txkv.BulkLoadOptions{})
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, map[string]int{"keys": n})
+}
+
+func (h *handler) compact(w http.ResponseWriter, r *http.Request) error {
+	if h.opts.Compacter == nil {
+		return errorf(http.StatusNotImplemented, "txkvadmin: the store can't be compacted")
+	}
+	n, err := h.opts.Compacter.Compact(r.Context())
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, map[string]int{"forgotten": n})
+}
+
+type readOnlyState struct {
+	ReadOnly bool `json:"read_only"`
+}
+
+func (h *handler) readOnly(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case http.MethodGet:
+		ro, ok := h.kv.(interface{ ReadOnly() bool })
+		if !ok {
+			return errorf(http.StatusNotImplemented, "txkvadmin: the store has no read-only mode")
+		}
+		return writeJSON(w, readOnlyState{ReadOnly: ro.ReadOnly()})
+	case http.MethodPut:
+		admin, ok := h.kv.(txkv.Admin)
+		if !ok {
+			return errorf(http.StatusNotImplemented, "txkvadmin: the store has no read-only mode")
+		}
+		var state readOnlyState
+		if err := json.NewDecoder(r.Body).Decode(&state); err != nil {
+			return errorf(http.StatusBadRequest, "txkvadmin: %v", err)
+		}
+		if err := admin.SetReadOnly(r.Context(), state.ReadOnly); err != nil {
+			return err
+		}
+		return writeJSON(w, state)
+	default:
+		return errorf(http.StatusMethodNotAllowed, "txkvadmin: read-only wants GET or PUT")
+	}
+}
+
+type stats struct {
+	Keys        int     `json:"keys"`
+	KeyBytes    int64   `json:"key_bytes"`
+	ValueBytes  int64   `json:"value_bytes"`
+	Approximate bool    `json:"approximate"`
+	Seq         *uint64 `json:"seq,omitempty"`
+	LockWaits   *locks  `json:"lock_waits,omitempty"`
+}
+
+type locks struct {
+	Store map[string]lockWait `json:"store"`
+	Tx    map[string]lockWait `json:"tx"`
+}
+
+type lockWait struct {
+	Count   int64 `json:"count"`
+	TotalNs int64 `json:"total_ns"`
+	MaxNs   int64 `json:"max_ns"`
+}
+
+func lockWaits(waits map[string]txkv.LockWait) map[string]lockWait {
+	out := make(map[string]lockWait, len(waits))
+	for op, w := range waits {
+		out[op] = lockWait{Count: w.Count, TotalNs: int64(w.Total), MaxNs: int64(w.Max)}
+	}
+	return out
+}
+
+func (h *handler) stats(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	size, err := txkv.SizeOf(ctx, h.kv, txkv.Key(r.URL.Query().Get("prefix")))
+	if err != nil {
+		return err
+	}
+	s := stats{Keys: size.Keys, KeyBytes: size.KeyBytes, ValueBytes: size.ValueBytes, Approximate: size.Approximate}
+	if ct, ok := h.kv.(txkv.ChangeTracker); ok {
+		seq, err := ct.Seq(ctx)
+		if err != nil {
+			return err
+		}
+		s.Seq = &seq
+	}
+	if h.opts.LockProfiler != nil {
+		waits := h.opts.LockProfiler.LockWaits()
+		s.LockWaits = &locks{Store: lockWaits(waits.Store), Tx: lockWaits(waits.Tx)}
+	}
+	return writeJSON(w, s)
+}
+
+type quotaUsage struct {
+	Prefix   string `json:"prefix"`
+	MaxKeys  int    `json:"max_keys"`
+	MaxBytes int64  `json:"max_bytes"`
+	Keys     int    `json:"keys"`
+	Bytes    int64  `json:"bytes"`
+}
+
+func (h *handler) quotas(w http.ResponseWriter, r *http.Request) error {
+	if h.opts.Quotas == nil {
+		return errorf(http.StatusNotImplemented, "txkvadmin: no quotas")
+	}
+	usage := []quotaUsage{}
+	for _, u := range h.opts.Quotas.Usage() {
+		usage = append(usage, quotaUsage{
+			Prefix:   string(u.Prefix),
+			MaxKeys:  u.MaxKeys,
+			MaxBytes: u.MaxBytes,
+			Keys:     u.Keys,
+			Bytes:    u.Bytes,
+		})
+	}
+	return writeJSON(w, usage)
+}
@@ -0,0 +1,108 @@
+package txkvadmin_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aybabtme/txkv"
+	"github.com/aybabtme/txkv/txkvadmin"
+)
+
+func newServer(t *testing.T) (*httptest.Server, txkv.TransactionalKV) {
+	ctx := context.Background()
+	inner := txkv.InMem(txkv.WithLockProfiling())
+	kv := txkv.WithMaintenance(inner)
+	require.NoError(t, kv.Put(ctx, txkv.Key("user/ann"), txkv.Value("paris")))
+	require.NoError(t, kv.Put(ctx, txkv.Key("user/bob"), txkv.Value("rome")))
+
+	acl := txkv.NewPrefixACL(txkv.InMem(), txkv.Key("acl/"))
+	require.NoError(t, acl.Grant(ctx, "ops", nil, txkv.ActionAdmin))
+	require.NoError(t, acl.Grant(ctx, "viewer", txkv.Key("stats"), txkv.ActionAdmin))
+
+	srv := httptest.NewServer(txkvadmin.NewHandler(kv, txkvadmin.Options{
+		Policy: acl.Policy(),
+		Principal: func(r *http.Request) (txkv.Principal, error) {
+			return txkv.Principal(r.Header.Get("X-Principal")), nil
+		},
+		Compacter:    inner.(txkv.Compacter),
+		LockProfiler: inner.(txkv.LockProfiler),
+	}))
+	t.Cleanup(srv.Close)
+	return srv, kv
+}
+
+func do(t *testing.T, srv *httptest.Server, principal, method, path, body string) (int, string) {
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("X-Principal", principal)
+	res, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return res.StatusCode, string(b)
+}
+
+func TestAdmin(t *testing.T) {
+	ctx := context.Background()
+	srv, kv := newServer(t)
+
+	status, body := do(t, srv, "ops", http.MethodGet, "/backup?prefix=user/", "")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, `{"key":"user/ann","value":"paris"}
+{"key":"user/bob","value":"rome"}
+`, body)
+
+	status, body = do(t, srv, "ops", http.MethodPost, "/restore", `{"key":"user/cid","value":"oslo"}`+"\n")
+	require.Equal(t, http.StatusOK, status, body)
+	v, ok, err := kv.Get(ctx, txkv.Key("user/cid"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, txkv.Value("oslo"), v)
+
+	status, body = do(t, srv, "viewer", http.MethodGet, "/stats?prefix=user/", "")
+	require.Equal(t, http.StatusOK, status)
+	var stats map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(body), &stats))
+	require.EqualValues(t, 3, stats["keys"])
+	puts := stats["lock_waits"].(map[string]interface{})["store"].(map[string]interface{})["put"]
+	require.EqualValues(t, 2, puts.(map[string]interface{})["count"])
+
+	status, body = do(t, srv, "ops", http.MethodPut, "/read-only", `{"read_only":true}`)
+	require.Equal(t, http.StatusOK, status, body)
+	require.ErrorIs(t, kv.Put(ctx, txkv.Key("x"), txkv.Value("v")), txkv.ErrReadOnly)
+	status, body = do(t, srv, "ops", http.MethodGet, "/read-only", "")
+	require.Equal(t, http.StatusOK, status)
+	require.JSONEq(t, `{"read_only":true}`, body)
+	status, _ = do(t, srv, "ops", http.MethodPost, "/restore", `{"key":"user/dan","value":"lima"}`+"\n")
+	require.Equal(t, http.StatusConflict, status)
+	status, _ = do(t, srv, "ops", http.MethodPut, "/read-only", `{"read_only":false}`)
+	require.Equal(t, http.StatusOK, status)
+
+	require.NoError(t, kv.Delete(ctx, txkv.Key("user/cid")))
+	status, body = do(t, srv, "ops", http.MethodPost, "/compact", "")
+	require.Equal(t, http.StatusOK, status)
+	require.JSONEq(t, `{"forgotten":1}`, body)
+
+	status, _ = do(t, srv, "ops", http.MethodGet, "/quotas", "")
+	require.Equal(t, http.StatusNotImplemented, status)
+	status, _ = do(t, srv, "ops", http.MethodGet, "/compact", "")
+	require.Equal(t, http.StatusMethodNotAllowed, status)
+}
+
+func TestAdminAuthorization(t *testing.T) {
+	srv, _ := newServer(t)
+	for _, principal := range []string{"", "viewer", "stranger"} {
+		status, _ := do(t, srv, principal, http.MethodGet, "/backup", "")
+		require.Equal(t, http.StatusForbidden, status, principal)
+	}
+	status, _ := do(t, srv, "stranger", http.MethodGet, "/stats", "")
+	require.Equal(t, http.StatusForbidden, status)
+}
@@ -0,0 +1,218 @@
+// Package txkvbadger is a txkv store backed by a Badger database, so that the
+// code tested against txkv.InMem runs unchanged against durable storage.
+//
+// Transactions are Badger transactions: they read a consistent snapshot,
+// and fail to commit with an error matching txkv.ErrConflict when a key they
+// read was written concurrently, in which case they can be run again, as by
+// txkv.RetryTx. Badger bounds their size: a transaction writing too much
+// fails with badger.ErrTxnTooBig.
+package txkvbadger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	badger "github.com/dgraph-io/badger/v4"
+
+	"github.com/aybabtme/txkv"
+)
+
+var (
+	_ txkv.TransactionalKV = (*KV)(nil)
+	_ txkv.Batcher         = (*KV)(nil)
+)
+
+// KV is a TransactionalKV stored in a Badger database.
+type KV struct {
+	db *badger.DB
+}
+
+// Wrap returns the store of `db`. Closing `db` is left to the caller.
+func Wrap(db *badger.DB) *KV {
+	return &KV{db: db}
+}
+
+// Open opens the Badger database at `path`, or an in-memory one if `path` is
+// empty, and returns its store. Close closes it.
+func Open(path string) (*KV, error) {
+	opts := badger.DefaultOptions(path).WithLogger(nil)
+	if path == "" {
+		opts = opts.WithInMemory(true)
+	}
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+	return Wrap(db), nil
+}
+
+// Close closes the database.
+func (kv *KV) Close() error {
+	return kv.db.Close()
+}
+
+func (kv *KV) Put(ctx context.Context, key txkv.Key, value txkv.Value) error {
+	return kv.Apply(ctx, []txkv.Op{txkv.PutOp(key, value)})
+}
+
+func (kv *KV) Get(ctx context.Context, key txkv.Key) (value txkv.Value, ok bool, err error) {
+	err = kv.db.View(func(txn *badger.Txn) error {
+		value, ok, err = get(txn, key)
+		return err
+	})
+	return value, ok, err
+}
+
+func (kv *KV) Delete(ctx context.Context, key txkv.Key) error {
+	return kv.Apply(ctx, []txkv.Op{txkv.DeleteOp(key)})
+}
+
+func (kv *KV) List(ctx context.Context, prefix txkv.Key) (keys []txkv.Key, err error) {
+	err = kv.db.View(func(txn *badger.Txn) error {
+		keys = list(txn, prefix)
+		return nil
+	})
+	return keys, err
+}
+
+// Apply applies `ops` in a single Badger transaction.
+func (kv *KV) Apply(ctx context.Context, ops []txkv.Op) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return conflict(kv.db.Update(func(txn *badger.Txn) error {
+		for _, op := range ops {
+			if err := write(txn, op); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+
+func (kv *KV) Begin(ctx context.Context) (txkv.TxKV, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &tx{txn: kv.db.NewTransaction(true)}, nil
+}
+
+// tx is a Badger transaction, which can't be used concurrently.
+type tx struct {
+	mu   sync.Mutex
+	txn  *badger.Txn
+	done bool
+}
+
+var errDone = errors.New("txkvbadger: transaction already committed or rolled back")
+
+func (t *tx) Put(ctx context.Context, key txkv.Key, value txkv.Value) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return errDone
+	}
+	// Badger holds on to them until the commit, while callers may reuse them
+	return write(t.txn, txkv.PutOp(clone(key), clone(value)))
+}
+
+func (t *tx) Get(ctx context.Context, key txkv.Key) (txkv.Value, bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return nil, false, errDone
+	}
+	return get(t.txn, key)
+}
+
+func (t *tx) Delete(ctx context.Context, key txkv.Key) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return errDone
+	}
+	return write(t.txn, txkv.DeleteOp(clone(key)))
+}
+
+func clone(b []byte) []byte {
+	return append(make([]byte, 0, len(b)), b...)
+}
+
+func (t *tx) List(ctx context.Context, prefix txkv.Key) ([]txkv.Key, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return nil, errDone
+	}
+	return list(t.txn, prefix), nil
+}
+
+func (t *tx) Commit(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return errDone
+	}
+	t.done = true
+	return conflict(t.txn.Commit())
+}
+
+func (t *tx) Rollback(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return errDone
+	}
+	t.done = true
+	t.txn.Discard()
+	return nil
+}
+
+// conflict has Badger's conflicts match txkv.ErrConflict.
+func conflict(err error) error {
+	if errors.Is(err, badger.ErrConflict) {
+		return fmt.Errorf("%w: %w", txkv.ErrConflict, err)
+	}
+	return err
+}
+
+func get(txn *badger.Txn, key txkv.Key) (txkv.Value, bool, error) {
+	item, err := txn.Get(key)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	v, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, false, err
+	}
+	if v == nil {
+		v = txkv.Value{}
+	}
+	return v, true, nil
+}
+
+// list iterates the keys only, without fetching their values.
+func list(txn *badger.Txn, prefix txkv.Key) []txkv.Key {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = prefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	var keys []txkv.Key
+	for it.Rewind(); it.Valid(); it.Next() {
+		keys = append(keys, it.Item().KeyCopy(nil))
+	}
+	return keys
+}
+
+func write(txn *badger.Txn, op txkv.Op) error {
+	if op.Delete {
+		return txn.Delete(op.Key)
+	}
+	return txn.Set(op.Key, op.Value)
+}
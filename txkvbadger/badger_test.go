@@ -0,0 +1,131 @@
+package txkvbadger_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aybabtme/txkv"
+	"github.com/aybabtme/txkv/txkvbadger"
+)
+
+func open(t *testing.T, path string) *txkvbadger.KV {
+	t.Helper()
+	kv, err := txkvbadger.Open(path)
+	require.NoError(t, err)
+	return kv
+}
+
+func mustFind(t *testing.T, kv txkv.KV, key, want string) {
+	t.Helper()
+	v, ok, err := kv.Get(context.Background(), txkv.Key(key))
+	require.NoError(t, err)
+	require.True(t, ok, "%q not found", key)
+	require.Equal(t, want, string(v))
+}
+
+func mustNotFind(t *testing.T, kv txkv.KV, key string) {
+	t.Helper()
+	_, ok, err := kv.Get(context.Background(), txkv.Key(key))
+	require.NoError(t, err)
+	require.False(t, ok, "%q found", key)
+}
+
+func TestKV(t *testing.T) {
+	ctx := context.Background()
+	path := t.TempDir()
+	kv := open(t, path)
+
+	require.NoError(t, kv.Put(ctx, txkv.Key("a/1"), txkv.Value("one")))
+	require.NoError(t, kv.Put(ctx, txkv.Key("a/2"), txkv.Value{}))
+	require.NoError(t, kv.Put(ctx, txkv.Key("b"), txkv.Value("bee")))
+	mustFind(t, kv, "a/1", "one")
+	mustFind(t, kv, "a/2", "")
+	mustNotFind(t, kv, "a")
+	keys, err := kv.List(ctx, txkv.Key("a/"))
+	require.NoError(t, err)
+	require.Equal(t, []txkv.Key{txkv.Key("a/1"), txkv.Key("a/2")}, keys)
+	require.NoError(t, kv.Delete(ctx, txkv.Key("a/1")))
+	mustNotFind(t, kv, "a/1")
+
+	// transactions see their writes, and commit them at once
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Put(ctx, txkv.Key("c"), txkv.Value("sea")))
+	require.NoError(t, tx.Delete(ctx, txkv.Key("b")))
+	mustFind(t, tx, "c", "sea")
+	mustNotFind(t, tx, "b")
+	mustFind(t, kv, "b", "bee")
+	keys, err = tx.List(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, []txkv.Key{txkv.Key("a/2"), txkv.Key("c")}, keys)
+	require.NoError(t, tx.Commit(ctx))
+	mustFind(t, kv, "c", "sea")
+	mustNotFind(t, kv, "b")
+
+	tx, err = kv.Begin(ctx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Put(ctx, txkv.Key("d"), txkv.Value("dee")))
+	require.NoError(t, tx.Rollback(ctx))
+	mustNotFind(t, kv, "d")
+	require.Error(t, tx.Commit(ctx))
+
+	// the data is durable
+	require.NoError(t, kv.Close())
+	kv = open(t, path)
+	defer kv.Close()
+	keys, err = kv.List(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, []txkv.Key{txkv.Key("a/2"), txkv.Key("c")}, keys)
+}
+
+func TestConflict(t *testing.T) {
+	ctx := context.Background()
+	kv := open(t, "")
+	defer kv.Close()
+	require.NoError(t, kv.Put(ctx, txkv.Key("n"), txkv.Value("0")))
+
+	a, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	mustFind(t, a, "n", "0")
+	require.NoError(t, kv.Put(ctx, txkv.Key("n"), txkv.Value("1")))
+	mustFind(t, a, "n", "0") // a snapshot
+	require.NoError(t, a.Put(ctx, txkv.Key("n"), txkv.Value("2")))
+	require.ErrorIs(t, a.Commit(ctx), txkv.ErrConflict)
+
+	// increments retried on conflicts all land
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := txkv.RetryTx(ctx, kv, txkv.RetryPolicy{MaxAttempts: 100}, func(ctx context.Context, tx txkv.TxKV) error {
+				v, _, err := tx.Get(ctx, txkv.Key("n"))
+				if err != nil {
+					return err
+				}
+				return tx.Put(ctx, txkv.Key("n"), append(v, '+'))
+			})
+			require.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	mustFind(t, kv, "n", "1++++++++++")
+}
+
+func TestReusedBuffers(t *testing.T) {
+	ctx := context.Background()
+	kv := open(t, "")
+	defer kv.Close()
+
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	key, value := []byte("k1"), []byte("v1")
+	require.NoError(t, tx.Put(ctx, key, value))
+	key[1], value[1] = '2', '2'
+	require.NoError(t, tx.Commit(ctx))
+	mustFind(t, kv, "k1", "v1")
+	mustNotFind(t, kv, "k2")
+}
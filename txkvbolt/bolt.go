@@ -0,0 +1,186 @@
+// Package txkvbolt is a txkv store backed by a bucket of a bbolt database, so
+// that programs already using bbolt can use txkv without changing storage
+// engines, and get durable storage.
+//
+// Transactions are bbolt read-write transactions: they see a consistent
+// snapshot, and bbolt only allows one at a time, so a transaction blocks
+// other writes, including single Puts and Deletes, until it ends. Code
+// holding a transaction must not write outside of it.
+package txkvbolt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/aybabtme/txkv"
+)
+
+var (
+	_ txkv.TransactionalKV = (*KV)(nil)
+	_ txkv.Batcher         = (*KV)(nil)
+)
+
+// ErrEmptyKey is returned when writing an empty key, which bbolt doesn't
+// allow.
+var ErrEmptyKey = errors.New("txkvbolt: keys can't be empty")
+
+// KV is a TransactionalKV stored in a bbolt bucket.
+type KV struct {
+	db     *bolt.DB
+	bucket []byte
+}
+
+// Wrap returns the store of bucket `bucket` of `db`, creating the bucket if
+// needed. Closing `db` is left to the caller.
+func Wrap(db *bolt.DB, bucket []byte) (*KV, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &KV{db: db, bucket: append([]byte(nil), bucket...)}, nil
+}
+
+func (kv *KV) Put(ctx context.Context, key txkv.Key, value txkv.Value) error {
+	return kv.Apply(ctx, []txkv.Op{txkv.PutOp(key, value)})
+}
+
+func (kv *KV) Get(ctx context.Context, key txkv.Key) (value txkv.Value, ok bool, err error) {
+	err = kv.db.View(func(tx *bolt.Tx) error {
+		value, ok = get(tx.Bucket(kv.bucket), key)
+		return nil
+	})
+	return value, ok, err
+}
+
+func (kv *KV) Delete(ctx context.Context, key txkv.Key) error {
+	return kv.Apply(ctx, []txkv.Op{txkv.DeleteOp(key)})
+}
+
+func (kv *KV) List(ctx context.Context, prefix txkv.Key) (keys []txkv.Key, err error) {
+	err = kv.db.View(func(tx *bolt.Tx) error {
+		keys = list(tx.Bucket(kv.bucket), prefix)
+		return nil
+	})
+	return keys, err
+}
+
+// Apply applies `ops` in a single bbolt transaction.
+func (kv *KV) Apply(ctx context.Context, ops []txkv.Op) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return kv.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(kv.bucket)
+		for _, op := range ops {
+			if err := write(b, op); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (kv *KV) Begin(ctx context.Context) (txkv.TxKV, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	btx, err := kv.db.Begin(true)
+	if err != nil {
+		return nil, err
+	}
+	return &tx{tx: btx, b: btx.Bucket(kv.bucket)}, nil
+}
+
+// tx is a bbolt read-write transaction, which can't be used concurrently.
+type tx struct {
+	mu sync.Mutex
+	tx *bolt.Tx
+	b  *bolt.Bucket
+}
+
+func (t *tx) Put(ctx context.Context, key txkv.Key, value txkv.Value) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// bbolt holds on to them until the commit, while callers may reuse them
+	return write(t.b, txkv.PutOp(clone(key), clone(value)))
+}
+
+func (t *tx) Get(ctx context.Context, key txkv.Key) (txkv.Value, bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tx.DB() == nil {
+		return nil, false, bolt.ErrTxClosed
+	}
+	v, ok := get(t.b, key)
+	return v, ok, nil
+}
+
+func (t *tx) Delete(ctx context.Context, key txkv.Key) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return write(t.b, txkv.DeleteOp(key))
+}
+
+func (t *tx) List(ctx context.Context, prefix txkv.Key) ([]txkv.Key, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tx.DB() == nil {
+		return nil, bolt.ErrTxClosed
+	}
+	return list(t.b, prefix), nil
+}
+
+func (t *tx) Commit(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.tx.Commit()
+}
+
+func (t *tx) Rollback(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.tx.Rollback()
+}
+
+// get copies the value out, as bbolt's are only valid within its
+// transaction.
+func get(b *bolt.Bucket, key txkv.Key) (txkv.Value, bool) {
+	if len(key) == 0 {
+		return nil, false
+	}
+	v := b.Get(key)
+	if v == nil {
+		return nil, false
+	}
+	return append(txkv.Value{}, v...), true
+}
+
+func list(b *bolt.Bucket, prefix txkv.Key) []txkv.Key {
+	var keys []txkv.Key
+	c := b.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		keys = append(keys, append(txkv.Key(nil), k...))
+	}
+	return keys
+}
+
+func clone(b []byte) []byte {
+	return append(make([]byte, 0, len(b)), b...)
+}
+
+func write(b *bolt.Bucket, op txkv.Op) error {
+	if len(op.Key) == 0 {
+		return ErrEmptyKey
+	}
+	if op.Delete {
+		return b.Delete(op.Key)
+	}
+	return b.Put(op.Key, op.Value)
+}
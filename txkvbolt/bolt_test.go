@@ -0,0 +1,103 @@
+package txkvbolt_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/aybabtme/txkv"
+	"github.com/aybabtme/txkv/txkvbolt"
+)
+
+func open(t *testing.T, path string) (*bolt.DB, *txkvbolt.KV) {
+	t.Helper()
+	db, err := bolt.Open(path, 0o600, nil)
+	require.NoError(t, err)
+	kv, err := txkvbolt.Wrap(db, []byte("kv"))
+	require.NoError(t, err)
+	return db, kv
+}
+
+func mustFind(t *testing.T, kv txkv.KV, key, want string) {
+	t.Helper()
+	v, ok, err := kv.Get(context.Background(), txkv.Key(key))
+	require.NoError(t, err)
+	require.True(t, ok, "%q not found", key)
+	require.Equal(t, want, string(v))
+}
+
+func mustNotFind(t *testing.T, kv txkv.KV, key string) {
+	t.Helper()
+	_, ok, err := kv.Get(context.Background(), txkv.Key(key))
+	require.NoError(t, err)
+	require.False(t, ok, "%q found", key)
+}
+
+func TestKV(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "bolt.db")
+	db, kv := open(t, path)
+
+	require.NoError(t, kv.Put(ctx, txkv.Key("a/1"), txkv.Value("one")))
+	require.NoError(t, kv.Put(ctx, txkv.Key("a/2"), txkv.Value{}))
+	require.NoError(t, kv.Put(ctx, txkv.Key("b"), txkv.Value("bee")))
+	mustFind(t, kv, "a/1", "one")
+	mustFind(t, kv, "a/2", "")
+	mustNotFind(t, kv, "a")
+	keys, err := kv.List(ctx, txkv.Key("a/"))
+	require.NoError(t, err)
+	require.Equal(t, []txkv.Key{txkv.Key("a/1"), txkv.Key("a/2")}, keys)
+	require.NoError(t, kv.Delete(ctx, txkv.Key("a/1")))
+	mustNotFind(t, kv, "a/1")
+	require.ErrorIs(t, kv.Put(ctx, nil, txkv.Value("x")), txkvbolt.ErrEmptyKey)
+
+	// transactions see their writes, and commit them at once
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Put(ctx, txkv.Key("c"), txkv.Value("sea")))
+	require.NoError(t, tx.Delete(ctx, txkv.Key("b")))
+	mustFind(t, tx, "c", "sea")
+	mustNotFind(t, tx, "b")
+	keys, err = tx.List(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, []txkv.Key{txkv.Key("a/2"), txkv.Key("c")}, keys)
+	require.NoError(t, tx.Commit(ctx))
+	mustFind(t, kv, "c", "sea")
+	mustNotFind(t, kv, "b")
+
+	tx, err = kv.Begin(ctx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Put(ctx, txkv.Key("d"), txkv.Value("dee")))
+	require.NoError(t, tx.Rollback(ctx))
+	mustNotFind(t, kv, "d")
+	require.Error(t, tx.Commit(ctx))
+
+	require.NoError(t, txkv.Apply(ctx, kv, []txkv.Op{txkv.PutOp(txkv.Key("e"), txkv.Value("ee")), txkv.DeleteOp(txkv.Key("c"))}))
+
+	// the data is durable
+	require.NoError(t, db.Close())
+	db, kv = open(t, path)
+	defer db.Close()
+	keys, err = kv.List(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, []txkv.Key{txkv.Key("a/2"), txkv.Key("e")}, keys)
+	mustFind(t, kv, "e", "ee")
+}
+
+func TestReusedBuffers(t *testing.T) {
+	ctx := context.Background()
+	db, kv := open(t, filepath.Join(t.TempDir(), "bolt.db"))
+	defer db.Close()
+
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	key, value := []byte("k1"), []byte("v1")
+	require.NoError(t, tx.Put(ctx, key, value))
+	key[1], value[1] = '2', '2'
+	require.NoError(t, tx.Commit(ctx))
+	mustFind(t, kv, "k1", "v1")
+	mustNotFind(t, kv, "k2")
+}
@@ -0,0 +1,242 @@
+package txkvetcd
+
+import (
+	"context"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+
+	"github.com/aybabtme/txkv"
+)
+
+// leases are the leases granted by a server, and the keys attached to them.
+// Its lock is held by writes to the store, so that the keys of a lease are
+// deleted in between.
+type leases struct {
+	kv txkv.TransactionalKV
+
+	mu     sync.Mutex
+	nextID int64
+	byID   map[int64]*lease
+	byKey  map[string]int64
+}
+
+type lease struct {
+	id      int64
+	ttl     int64 // granted, in seconds
+	expires time.Time
+	timer   *time.Timer
+	keys    map[string]struct{}
+}
+
+func newLeases(kv txkv.TransactionalKV) *leases {
+	return &leases{kv: kv, byID: make(map[int64]*lease), byKey: make(map[string]int64)}
+}
+
+func (ls *leases) existsLocked(id int64) bool {
+	_, ok := ls.byID[id]
+	return ok
+}
+
+// attachLocked attaches keys to leases, or detaches them for 0. Keys
+// attached to a lease that expired meanwhile are left detached.
+func (ls *leases) attachLocked(attach map[string]int64) {
+	for key, id := range attach {
+		if prev, ok := ls.byKey[key]; ok {
+			if l, ok := ls.byID[prev]; ok {
+				delete(l.keys, key)
+			}
+			delete(ls.byKey, key)
+		}
+		if l, ok := ls.byID[id]; ok {
+			l.keys[key] = struct{}{}
+			ls.byKey[key] = id
+		}
+	}
+}
+
+// grant grants a lease of `ttl` seconds, with ID `id` or the next free one
+// if 0.
+func (ls *leases) grant(id, ttl int64) (*lease, error) {
+	if ttl < 1 {
+		ttl = 1
+	}
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if id == 0 {
+		for ls.nextID++; ls.existsLocked(ls.nextID); ls.nextID++ {
+		}
+		id = ls.nextID
+	} else if ls.existsLocked(id) {
+		return nil, rpctypes.ErrGRPCLeaseExist
+	}
+	l := &lease{id: id, ttl: ttl, keys: make(map[string]struct{})}
+	l.expires = time.Now().Add(time.Duration(ttl) * time.Second)
+	l.timer = time.AfterFunc(time.Duration(ttl)*time.Second, func() { ls.expire(id) })
+	ls.byID[id] = l
+	return l, nil
+}
+
+// keepAlive renews lease `id` for its TTL, and returns it.
+func (ls *leases) keepAlive(id int64) (int64, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	l, ok := ls.byID[id]
+	if !ok {
+		return 0, rpctypes.ErrGRPCLeaseNotFound
+	}
+	l.expires = time.Now().Add(time.Duration(l.ttl) * time.Second)
+	l.timer.Reset(time.Duration(l.ttl) * time.Second)
+	return l.ttl, nil
+}
+
+// expire revokes lease `id` if it wasn't renewed since its timer fired.
+func (ls *leases) expire(id int64) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if l, ok := ls.byID[id]; ok && !time.Now().Before(l.expires) {
+		if err := ls.revokeLocked(context.Background(), l); err != nil {
+			l.timer.Reset(time.Second) // try again
+		}
+	}
+}
+
+// revoke revokes lease `id`, deleting its keys.
+func (ls *leases) revoke(ctx context.Context, id int64) error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	l, ok := ls.byID[id]
+	if !ok {
+		return rpctypes.ErrGRPCLeaseNotFound
+	}
+	return ls.revokeLocked(ctx, l)
+}
+
+// revokeLocked deletes the keys of `l` in a transaction, then forgets it.
+// The lease is kept if that fails, so that revoking it can be retried.
+func (ls *leases) revokeLocked(ctx context.Context, l *lease) error {
+	tx, err := ls.kv.Begin(ctx)
+	if err != nil {
+		return toStatus(err)
+	}
+	for key := range l.keys {
+		if err := tx.Delete(ctx, txkv.Key(key)); err != nil {
+			_ = tx.Rollback(ctx)
+			return toStatus(err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return toStatus(err)
+	}
+	l.timer.Stop()
+	for key := range l.keys {
+		delete(ls.byKey, key)
+	}
+	delete(ls.byID, l.id)
+	return nil
+}
+
+// status returns the TTL left to lease `id`, in seconds, and its keys.
+func (ls *leases) status(id int64) (ttl, granted int64, keys [][]byte, ok bool) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	l, ok := ls.byID[id]
+	if !ok {
+		return 0, 0, nil, false
+	}
+	left := time.Until(l.expires)
+	ttl = int64((left + time.Second - 1) / time.Second)
+	for key := range l.keys {
+		keys = append(keys, []byte(key))
+	}
+	sort.Slice(keys, func(i, j int) bool { return string(keys[i]) < string(keys[j]) })
+	return ttl, l.ttl, keys, true
+}
+
+func (ls *leases) ids() []int64 {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ids := make([]int64, 0, len(ls.byID))
+	for id := range ls.byID {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func (s *Server) LeaseGrant(ctx context.Context, r *pb.LeaseGrantRequest) (*pb.LeaseGrantResponse, error) {
+	l, err := s.leases.grant(r.ID, r.TTL)
+	if err != nil {
+		return nil, err
+	}
+	h, err := s.header(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.LeaseGrantResponse{Header: h, ID: l.id, TTL: l.ttl}, nil
+}
+
+func (s *Server) LeaseRevoke(ctx context.Context, r *pb.LeaseRevokeRequest) (*pb.LeaseRevokeResponse, error) {
+	if err := s.leases.revoke(ctx, r.ID); err != nil {
+		return nil, err
+	}
+	h, err := s.header(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.LeaseRevokeResponse{Header: h}, nil
+}
+
+// LeaseKeepAlive answers unknown leases with a TTL of 0, like etcd.
+func (s *Server) LeaseKeepAlive(stream pb.Lease_LeaseKeepAliveServer) error {
+	for {
+		r, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		ttl, _ := s.leases.keepAlive(r.ID)
+		h, err := s.header(stream.Context())
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(&pb.LeaseKeepAliveResponse{Header: h, ID: r.ID, TTL: ttl}); err != nil {
+			return err
+		}
+	}
+}
+
+// LeaseTimeToLive answers unknown leases with a TTL of -1, like etcd.
+func (s *Server) LeaseTimeToLive(ctx context.Context, r *pb.LeaseTimeToLiveRequest) (*pb.LeaseTimeToLiveResponse, error) {
+	h, err := s.header(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ttl, granted, keys, ok := s.leases.status(r.ID)
+	if !ok {
+		return &pb.LeaseTimeToLiveResponse{Header: h, ID: r.ID, TTL: -1}, nil
+	}
+	res := &pb.LeaseTimeToLiveResponse{Header: h, ID: r.ID, TTL: ttl, GrantedTTL: granted}
+	if r.Keys {
+		res.Keys = keys
+	}
+	return res, nil
+}
+
+func (s *Server) LeaseLeases(ctx context.Context, r *pb.LeaseLeasesRequest) (*pb.LeaseLeasesResponse, error) {
+	h, err := s.header(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := &pb.LeaseLeasesResponse{Header: h}
+	for _, id := range s.leases.ids() {
+		res.Leases = append(res.Leases, &pb.LeaseStatus{ID: id})
+	}
+	return res, nil
+}
@@ -0,0 +1,89 @@
+package txkvetcd_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/aybabtme/txkv"
+)
+
+func TestLease(t *testing.T) {
+	ctx := context.Background()
+	conn := newConn(t, txkv.InMem())
+	kv, leases := pb.NewKVClient(conn), pb.NewLeaseClient(conn)
+
+	grant, err := leases.LeaseGrant(ctx, &pb.LeaseGrantRequest{TTL: 60})
+	require.NoError(t, err)
+	_, err = leases.LeaseGrant(ctx, &pb.LeaseGrantRequest{ID: grant.ID, TTL: 60})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	for _, k := range []string{"a", "b", "c"} {
+		_, err := kv.Put(ctx, &pb.PutRequest{Key: []byte(k), Value: []byte("v"), Lease: grant.ID})
+		require.NoError(t, err)
+	}
+	// put again without a lease, "c" is detached from it
+	_, err = kv.Put(ctx, &pb.PutRequest{Key: []byte("c"), Value: []byte("v")})
+	require.NoError(t, err)
+
+	ttl, err := leases.LeaseTimeToLive(ctx, &pb.LeaseTimeToLiveRequest{ID: grant.ID, Keys: true})
+	require.NoError(t, err)
+	require.EqualValues(t, 60, ttl.GrantedTTL)
+	require.Equal(t, [][]byte{[]byte("a"), []byte("b")}, ttl.Keys)
+
+	list, err := leases.LeaseLeases(ctx, &pb.LeaseLeasesRequest{})
+	require.NoError(t, err)
+	require.Len(t, list.Leases, 1)
+
+	_, err = leases.LeaseRevoke(ctx, &pb.LeaseRevokeRequest{ID: grant.ID})
+	require.NoError(t, err)
+	res, err := kv.Range(ctx, &pb.RangeRequest{Key: []byte{0}, RangeEnd: []byte{0}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"c"}, keysOf(res))
+
+	_, err = leases.LeaseRevoke(ctx, &pb.LeaseRevokeRequest{ID: grant.ID})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	ttl, err = leases.LeaseTimeToLive(ctx, &pb.LeaseTimeToLiveRequest{ID: grant.ID})
+	require.NoError(t, err)
+	require.EqualValues(t, -1, ttl.TTL)
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	ctx := context.Background()
+	conn := newConn(t, txkv.InMem())
+	kv, leases := pb.NewKVClient(conn), pb.NewLeaseClient(conn)
+
+	grant, err := leases.LeaseGrant(ctx, &pb.LeaseGrantRequest{TTL: 1})
+	require.NoError(t, err)
+	_, err = kv.Put(ctx, &pb.PutRequest{Key: []byte("a"), Value: []byte("v"), Lease: grant.ID})
+	require.NoError(t, err)
+
+	// kept alive past its TTL
+	stream, err := leases.LeaseKeepAlive(ctx)
+	require.NoError(t, err)
+	time.Sleep(600 * time.Millisecond)
+	require.NoError(t, stream.Send(&pb.LeaseKeepAliveRequest{ID: grant.ID}))
+	alive, err := stream.Recv()
+	require.NoError(t, err)
+	require.EqualValues(t, 1, alive.TTL)
+	time.Sleep(600 * time.Millisecond)
+	res, err := kv.Range(ctx, &pb.RangeRequest{Key: []byte("a")})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a"}, keysOf(res))
+
+	// then left to expire
+	require.Eventually(t, func() bool {
+		res, err := kv.Range(ctx, &pb.RangeRequest{Key: []byte("a")})
+		return err == nil && len(res.Kvs) == 0
+	}, 3*time.Second, 50*time.Millisecond)
+	require.NoError(t, stream.Send(&pb.LeaseKeepAliveRequest{ID: grant.ID}))
+	alive, err = stream.Recv()
+	require.NoError(t, err)
+	require.EqualValues(t, 0, alive.TTL)
+	require.NoError(t, stream.CloseSend())
+}
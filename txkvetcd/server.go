@@ -0,0 +1,426 @@
+// Package txkvetcd serves a txkv store over the KV, Watch and Lease services
+// of the etcd v3 gRPC API, so that tools written against etcd can use any
+// txkv backend.
+//
+// Range, Put, DeleteRange and Txn are supported. Stores don't keep
+// revisions, so requests for past revisions fail, and the revisions of keys
+// are left to 0. The revision in response headers is the seq of stores that
+// are a txkv.ChangeTracker. In Txn comparisons, keys have a value, and their
+// version and revisions can be compared with 0 to tell if they exist; other
+// comparisons fail. Compact isn't supported.
+//
+// Watch needs a store that's a txkv.ChangeTracker, which it polls: see
+// Options.WatchInterval. Leases are kept by the server, in memory: they're
+// forgotten, and their keys kept, when it stops.
+package txkvetcd
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"time"
+
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/aybabtme/txkv"
+)
+
+// DefaultWatchInterval is how often watched stores are polled for changes
+// when Options.WatchInterval is 0.
+const DefaultWatchInterval = 100 * time.Millisecond
+
+// Options tune a Server.
+type Options struct {
+	// WatchInterval is how often watches poll the store for changes. The
+	// changes made to a key between two polls are coalesced into one event,
+	// and the events of a poll are sent in one response, so it's also the
+	// longest a change waits to be sent. Defaults to DefaultWatchInterval.
+	WatchInterval time.Duration
+}
+
+// Register serves `kv` on `s` as the etcd KV, Watch and Lease services.
+func Register(s *grpc.Server, kv txkv.TransactionalKV, opts Options) {
+	srv := NewServer(kv, opts)
+	pb.RegisterKVServer(s, srv)
+	pb.RegisterWatchServer(s, srv)
+	pb.RegisterLeaseServer(s, srv)
+}
+
+// Server implements the etcd KV, Watch and Lease services over a store.
+//
+// Its writes go one at a time, so that they land either before or after
+// the expiry of a lease, never halfway through.
+type Server struct {
+	pb.UnimplementedKVServer
+	pb.UnimplementedWatchServer
+	pb.UnimplementedLeaseServer
+	kv     txkv.TransactionalKV
+	opts   Options
+	leases *leases
+}
+
+// NewServer returns a Server over `kv`.
+func NewServer(kv txkv.TransactionalKV, opts Options) *Server {
+	if opts.WatchInterval <= 0 {
+		opts.WatchInterval = DefaultWatchInterval
+	}
+	return &Server{kv: kv, opts: opts, leases: newLeases(kv)}
+}
+
+var (
+	_ pb.KVServer    = (*Server)(nil)
+	_ pb.WatchServer = (*Server)(nil)
+	_ pb.LeaseServer = (*Server)(nil)
+)
+
+// Range reads in a single transaction, so that the keys it counts are those
+// it returns.
+func (s *Server) Range(ctx context.Context, r *pb.RangeRequest) (*pb.RangeResponse, error) {
+	var res *pb.RangeResponse
+	err := s.inTx(ctx, func(tx txkv.TxKV) (err error) {
+		res, err = doRange(ctx, tx, r)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	res.Header, err = s.header(ctx)
+	return res, err
+}
+
+func (s *Server) Put(ctx context.Context, r *pb.PutRequest) (*pb.PutResponse, error) {
+	var res *pb.PutResponse
+	err := s.write(ctx, func(tx txkv.TxKV, w *writes) (err error) {
+		res, err = doPut(ctx, tx, w, r)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	res.Header, err = s.header(ctx)
+	return res, err
+}
+
+func (s *Server) DeleteRange(ctx context.Context, r *pb.DeleteRangeRequest) (*pb.DeleteRangeResponse, error) {
+	var res *pb.DeleteRangeResponse
+	err := s.write(ctx, func(tx txkv.TxKV, w *writes) (err error) {
+		res, err = doDeleteRange(ctx, tx, w, r)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	res.Header, err = s.header(ctx)
+	return res, err
+}
+
+func (s *Server) Txn(ctx context.Context, r *pb.TxnRequest) (*pb.TxnResponse, error) {
+	var res *pb.TxnResponse
+	err := s.write(ctx, func(tx txkv.TxKV, w *writes) (err error) {
+		res, err = doTxn(ctx, tx, w, r)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	res.Header, err = s.header(ctx)
+	return res, err
+}
+
+// write runs `fn` in a transaction, then attaches the keys it wrote to their
+// leases, holding the lock of the leases throughout.
+func (s *Server) write(ctx context.Context, fn func(tx txkv.TxKV, w *writes) error) error {
+	s.leases.mu.Lock()
+	defer s.leases.mu.Unlock()
+	w := &writes{leases: s.leases, attach: make(map[string]int64)}
+	if err := s.inTx(ctx, func(tx txkv.TxKV) error { return fn(tx, w) }); err != nil {
+		return err
+	}
+	s.leases.attachLocked(w.attach)
+	return nil
+}
+
+// writes collects the leases the keys written are attached to, 0 for none.
+type writes struct {
+	leases *leases
+	attach map[string]int64
+}
+
+func (s *Server) inTx(ctx context.Context, fn func(tx txkv.TxKV) error) error {
+	tx, err := s.kv.Begin(ctx)
+	if err != nil {
+		return toStatus(err)
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback(ctx)
+		return err
+	}
+	return toStatus(tx.Commit(ctx))
+}
+
+func (s *Server) header(ctx context.Context) (*pb.ResponseHeader, error) {
+	h := &pb.ResponseHeader{}
+	if ct, ok := s.kv.(txkv.ChangeTracker); ok {
+		seq, err := ct.Seq(ctx)
+		if err != nil {
+			return nil, toStatus(err)
+		}
+		h.Revision = int64(seq)
+	}
+	return h, nil
+}
+
+func doRange(ctx context.Context, kv txkv.KV, r *pb.RangeRequest) (*pb.RangeResponse, error) {
+	if r.Revision != 0 {
+		return nil, status.Error(codes.Unimplemented, "txkvetcd: past revisions aren't kept")
+	}
+	if r.SortTarget != pb.RangeRequest_KEY {
+		return nil, status.Error(codes.Unimplemented, "txkvetcd: can only sort by key")
+	}
+	keys, err := rangeKeys(ctx, kv, r.Key, r.RangeEnd)
+	if err != nil {
+		return nil, err
+	}
+	if r.SortOrder == pb.RangeRequest_DESCEND {
+		for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+	}
+	res := &pb.RangeResponse{Count: int64(len(keys))}
+	if r.CountOnly {
+		return res, nil
+	}
+	if r.Limit > 0 && int64(len(keys)) > r.Limit {
+		keys, res.More = keys[:r.Limit], true
+	}
+	for _, key := range keys {
+		kvpb := &mvccpb.KeyValue{Key: key}
+		if !r.KeysOnly {
+			v, ok, err := kv.Get(ctx, key)
+			if err != nil {
+				return nil, toStatus(err)
+			}
+			if !ok {
+				res.Count-- // deleted by a commit racing ours
+				continue
+			}
+			kvpb.Value = v
+		}
+		res.Kvs = append(res.Kvs, kvpb)
+	}
+	return res, nil
+}
+
+func doPut(ctx context.Context, kv txkv.KV, w *writes, r *pb.PutRequest) (*pb.PutResponse, error) {
+	if r.Lease != 0 && !w.leases.existsLocked(r.Lease) {
+		return nil, rpctypes.ErrGRPCLeaseNotFound
+	}
+	res := &pb.PutResponse{}
+	value := r.Value
+	if r.PrevKv || r.IgnoreValue {
+		prev, ok, err := kv.Get(ctx, r.Key)
+		if err != nil {
+			return nil, toStatus(err)
+		}
+		if r.IgnoreValue {
+			if !ok {
+				return nil, status.Error(codes.InvalidArgument, "etcdserver: key not found")
+			}
+			value = prev
+		}
+		if r.PrevKv && ok {
+			res.PrevKv = &mvccpb.KeyValue{Key: r.Key, Value: prev}
+		}
+	}
+	if err := kv.Put(ctx, r.Key, value); err != nil {
+		return nil, toStatus(err)
+	}
+	if !r.IgnoreLease {
+		w.attach[string(r.Key)] = r.Lease
+	}
+	return res, nil
+}
+
+func doDeleteRange(ctx context.Context, kv txkv.KV, w *writes, r *pb.DeleteRangeRequest) (*pb.DeleteRangeResponse, error) {
+	keys, err := rangeKeys(ctx, kv, r.Key, r.RangeEnd)
+	if err != nil {
+		return nil, err
+	}
+	res := &pb.DeleteRangeResponse{}
+	for _, key := range keys {
+		if r.PrevKv {
+			v, ok, err := kv.Get(ctx, key)
+			if err != nil {
+				return nil, toStatus(err)
+			}
+			if !ok {
+				continue
+			}
+			res.PrevKvs = append(res.PrevKvs, &mvccpb.KeyValue{Key: key, Value: v})
+		}
+		if err := kv.Delete(ctx, key); err != nil {
+			return nil, toStatus(err)
+		}
+		w.attach[string(key)] = 0
+		res.Deleted++
+	}
+	return res, nil
+}
+
+func doTxn(ctx context.Context, tx txkv.TxKV, w *writes, r *pb.TxnRequest) (*pb.TxnResponse, error) {
+	succeeded := true
+	for _, c := range r.Compare {
+		ok, err := compare(ctx, tx, c)
+		if err != nil {
+			return nil, err
+		}
+		succeeded = succeeded && ok
+	}
+	ops := r.Failure
+	if succeeded {
+		ops = r.Success
+	}
+	res := &pb.TxnResponse{Succeeded: succeeded}
+	for _, op := range ops {
+		var resOp *pb.ResponseOp
+		switch req := op.Request.(type) {
+		case *pb.RequestOp_RequestRange:
+			out, err := doRange(ctx, tx, req.RequestRange)
+			if err != nil {
+				return nil, err
+			}
+			resOp = &pb.ResponseOp{Response: &pb.ResponseOp_ResponseRange{ResponseRange: out}}
+		case *pb.RequestOp_RequestPut:
+			out, err := doPut(ctx, tx, w, req.RequestPut)
+			if err != nil {
+				return nil, err
+			}
+			resOp = &pb.ResponseOp{Response: &pb.ResponseOp_ResponsePut{ResponsePut: out}}
+		case *pb.RequestOp_RequestDeleteRange:
+			out, err := doDeleteRange(ctx, tx, w, req.RequestDeleteRange)
+			if err != nil {
+				return nil, err
+			}
+			resOp = &pb.ResponseOp{Response: &pb.ResponseOp_ResponseDeleteRange{ResponseDeleteRange: out}}
+		case *pb.RequestOp_RequestTxn:
+			out, err := doTxn(ctx, tx, w, req.RequestTxn)
+			if err != nil {
+				return nil, err
+			}
+			resOp = &pb.ResponseOp{Response: &pb.ResponseOp_ResponseTxn{ResponseTxn: out}}
+		default:
+			return nil, status.Error(codes.InvalidArgument, "txkvetcd: unknown request op")
+		}
+		res.Responses = append(res.Responses, resOp)
+	}
+	return res, nil
+}
+
+// compare evaluates a Txn comparison on a single key.
+func compare(ctx context.Context, kv txkv.KV, c *pb.Compare) (bool, error) {
+	if len(c.RangeEnd) > 0 {
+		return false, status.Error(codes.Unimplemented, "txkvetcd: can only compare single keys")
+	}
+	v, ok, err := kv.Get(ctx, c.Key)
+	if err != nil {
+		return false, toStatus(err)
+	}
+	var cmp int
+	switch target := c.TargetUnion.(type) {
+	case *pb.Compare_Value:
+		if !ok {
+			return false, nil // like etcd, missing keys have no value to compare
+		}
+		cmp = bytes.Compare(v, target.Value)
+	case *pb.Compare_Version:
+		cmp, err = existence(ok, target.Version)
+	case *pb.Compare_CreateRevision:
+		cmp, err = existence(ok, target.CreateRevision)
+	case *pb.Compare_ModRevision:
+		cmp, err = existence(ok, target.ModRevision)
+	default:
+		return false, status.Errorf(codes.Unimplemented, "txkvetcd: can't compare %v", c.Target)
+	}
+	if err != nil {
+		return false, err
+	}
+	switch c.Result {
+	case pb.Compare_EQUAL:
+		return cmp == 0, nil
+	case pb.Compare_NOT_EQUAL:
+		return cmp != 0, nil
+	case pb.Compare_GREATER:
+		return cmp > 0, nil
+	case pb.Compare_LESS:
+		return cmp < 0, nil
+	}
+	return false, status.Errorf(codes.InvalidArgument, "txkvetcd: unknown comparison %v", c.Result)
+}
+
+// existence compares the version or a revision of a key with `n`, which must
+// be 0: existing keys are greater, missing ones equal.
+func existence(exists bool, n int64) (int, error) {
+	if n != 0 {
+		return 0, status.Error(codes.Unimplemented, "txkvetcd: versions and revisions can only be compared with 0")
+	}
+	if exists {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// rangeKeys lists the keys in the etcd range [key, end): just `key` if `end`
+// is empty, and all the keys from `key` on if `end` is "\x00".
+func rangeKeys(ctx context.Context, kv txkv.KV, key, end []byte) ([]txkv.Key, error) {
+	if len(end) == 0 {
+		_, ok, err := kv.Get(ctx, key)
+		if err != nil || !ok {
+			return nil, toStatus(err)
+		}
+		return []txkv.Key{key}, nil
+	}
+	prefix, in := keyRange(key, end)
+	keys, err := txkv.ListFiltered(ctx, kv, prefix, in)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if !sort.SliceIsSorted(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 }) {
+		sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+	}
+	return keys, nil
+}
+
+// keyRange returns the longest prefix common to the etcd range [key, end),
+// to list it, and whether a key is within it.
+func keyRange(key, end []byte) (txkv.Key, func(txkv.Key) bool) {
+	if len(end) == 0 {
+		return key, func(k txkv.Key) bool { return bytes.Equal(k, key) }
+	}
+	all := len(end) == 1 && end[0] == 0
+	var prefix txkv.Key
+	if !all {
+		n := 0
+		for n < len(key) && n < len(end) && key[n] == end[n] {
+			n++
+		}
+		prefix = key[:n]
+	}
+	return prefix, func(k txkv.Key) bool {
+		return bytes.Compare(k, key) >= 0 && (all || bytes.Compare(k, end) < 0)
+	}
+}
+
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return status.FromContextError(err).Err()
+}
@@ -0,0 +1,147 @@
+package txkvetcd_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/aybabtme/txkv"
+	"github.com/aybabtme/txkv/txkvetcd"
+)
+
+func newClient(t *testing.T, kv txkv.TransactionalKV) pb.KVClient {
+	return pb.NewKVClient(newConn(t, kv))
+}
+
+func newConn(t *testing.T, kv txkv.TransactionalKV) *grpc.ClientConn {
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	txkvetcd.Register(s, kv, txkvetcd.Options{WatchInterval: 10 * time.Millisecond})
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func keysOf(res *pb.RangeResponse) []string {
+	var keys []string
+	for _, kv := range res.Kvs {
+		keys = append(keys, string(kv.Key))
+	}
+	return keys
+}
+
+func TestRangePutDelete(t *testing.T) {
+	ctx := context.Background()
+	c := newClient(t, txkv.InMem())
+
+	for _, k := range []string{"a", "b/1", "b/2", "b/3", "c"} {
+		_, err := c.Put(ctx, &pb.PutRequest{Key: []byte(k), Value: []byte("v" + k)})
+		require.NoError(t, err)
+	}
+
+	res, err := c.Range(ctx, &pb.RangeRequest{Key: []byte("b/2")})
+	require.NoError(t, err)
+	require.Len(t, res.Kvs, 1)
+	require.Equal(t, "vb/2", string(res.Kvs[0].Value))
+
+	res, err = c.Range(ctx, &pb.RangeRequest{Key: []byte("b/"), RangeEnd: []byte("b0")})
+	require.NoError(t, err)
+	require.Equal(t, []string{"b/1", "b/2", "b/3"}, keysOf(res))
+
+	res, err = c.Range(ctx, &pb.RangeRequest{Key: []byte("b/2"), RangeEnd: []byte{0}, Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, []string{"b/2", "b/3"}, keysOf(res))
+	require.True(t, res.More)
+	require.EqualValues(t, 3, res.Count)
+
+	res, err = c.Range(ctx, &pb.RangeRequest{Key: []byte("a"), RangeEnd: []byte("c"), SortOrder: pb.RangeRequest_DESCEND, KeysOnly: true})
+	require.NoError(t, err)
+	require.Equal(t, []string{"b/3", "b/2", "b/1", "a"}, keysOf(res))
+	require.Empty(t, res.Kvs[0].Value)
+
+	put, err := c.Put(ctx, &pb.PutRequest{Key: []byte("a"), Value: []byte("new"), PrevKv: true})
+	require.NoError(t, err)
+	require.Equal(t, "va", string(put.PrevKv.Value))
+
+	del, err := c.DeleteRange(ctx, &pb.DeleteRangeRequest{Key: []byte("b/"), RangeEnd: []byte("b0"), PrevKv: true})
+	require.NoError(t, err)
+	require.EqualValues(t, 3, del.Deleted)
+	require.Len(t, del.PrevKvs, 3)
+
+	res, err = c.Range(ctx, &pb.RangeRequest{Key: []byte{0}, RangeEnd: []byte{0}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "c"}, keysOf(res))
+
+	_, err = c.Put(ctx, &pb.PutRequest{Key: []byte("a"), Lease: 1})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestTxn(t *testing.T) {
+	ctx := context.Background()
+	c := newClient(t, txkv.InMem())
+
+	// create "lock" only if it doesn't exist
+	create := &pb.TxnRequest{
+		Compare: []*pb.Compare{{
+			Key:         []byte("lock"),
+			Target:      pb.Compare_CREATE,
+			Result:      pb.Compare_EQUAL,
+			TargetUnion: &pb.Compare_CreateRevision{CreateRevision: 0},
+		}},
+		Success: []*pb.RequestOp{{Request: &pb.RequestOp_RequestPut{RequestPut: &pb.PutRequest{Key: []byte("lock"), Value: []byte("me")}}}},
+		Failure: []*pb.RequestOp{{Request: &pb.RequestOp_RequestRange{RequestRange: &pb.RangeRequest{Key: []byte("lock")}}}},
+	}
+	res, err := c.Txn(ctx, create)
+	require.NoError(t, err)
+	require.True(t, res.Succeeded)
+
+	res, err = c.Txn(ctx, create)
+	require.NoError(t, err)
+	require.False(t, res.Succeeded)
+	require.Equal(t, "me", string(res.Responses[0].GetResponseRange().Kvs[0].Value))
+
+	// compare-and-swap on the value
+	cas := &pb.TxnRequest{
+		Compare: []*pb.Compare{{
+			Key:         []byte("lock"),
+			Target:      pb.Compare_VALUE,
+			Result:      pb.Compare_EQUAL,
+			TargetUnion: &pb.Compare_Value{Value: []byte("me")},
+		}},
+		Success: []*pb.RequestOp{{Request: &pb.RequestOp_RequestDeleteRange{RequestDeleteRange: &pb.DeleteRangeRequest{Key: []byte("lock")}}}},
+	}
+	res, err = c.Txn(ctx, cas)
+	require.NoError(t, err)
+	require.True(t, res.Succeeded)
+	require.EqualValues(t, 1, res.Responses[0].GetResponseDeleteRange().Deleted)
+
+	res, err = c.Txn(ctx, cas)
+	require.NoError(t, err)
+	require.False(t, res.Succeeded)
+
+	_, err = c.Txn(ctx, &pb.TxnRequest{Compare: []*pb.Compare{{
+		Key:         []byte("lock"),
+		Target:      pb.Compare_MOD,
+		Result:      pb.Compare_EQUAL,
+		TargetUnion: &pb.Compare_ModRevision{ModRevision: 3},
+	}}})
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+}
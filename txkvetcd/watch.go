@@ -0,0 +1,214 @@
+package txkvetcd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/aybabtme/txkv"
+)
+
+// Watch polls the store for the keys modified in the watched ranges, with
+// ListModifiedSince. Changes are coalesced: a watcher gets the latest value
+// of each key modified since the last poll, as a PUT, or a DELETE if it's
+// gone, and a key can be reported twice. A start revision replays the keys
+// modified since it the same way; if the store forgot the deletions since
+// then, the watch is canceled with the compact revision set. Previous values
+// aren't kept, so watches asking for them are refused.
+func (s *Server) Watch(stream pb.Watch_WatchServer) error {
+	ct, ok := s.kv.(txkv.ChangeTracker)
+	if !ok {
+		return status.Error(codes.Unimplemented, "txkvetcd: watching needs a store that's a txkv.ChangeTracker")
+	}
+	ctx, cancel := context.WithCancel(stream.Context())
+	w := &watchStream{s: s, ct: ct, stream: stream, watchers: make(map[int64]context.CancelFunc)}
+	defer w.wg.Wait()
+	defer cancel()
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch r := req.RequestUnion.(type) {
+		case *pb.WatchRequest_CreateRequest:
+			err = w.create(ctx, r.CreateRequest)
+		case *pb.WatchRequest_CancelRequest:
+			err = w.cancel(ctx, r.CancelRequest.WatchId)
+		case *pb.WatchRequest_ProgressRequest:
+			err = w.progress(ctx)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// watchStream holds the watchers of a Watch stream.
+type watchStream struct {
+	s      *Server
+	ct     txkv.ChangeTracker
+	stream pb.Watch_WatchServer
+	wg     sync.WaitGroup
+
+	mu       sync.Mutex // held while sending, as streams aren't safe for concurrent sends
+	nextID   int64
+	watchers map[int64]context.CancelFunc
+}
+
+func (w *watchStream) send(res *pb.WatchResponse) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.stream.Send(res)
+}
+
+func (w *watchStream) create(ctx context.Context, r *pb.WatchCreateRequest) error {
+	h, err := w.s.header(ctx)
+	if err != nil {
+		return err
+	}
+	refuse := func(reason string) error {
+		return w.send(&pb.WatchResponse{Header: h, WatchId: -1, Created: true, Canceled: true, CancelReason: reason})
+	}
+	if r.PrevKv {
+		return refuse("txkvetcd: previous values aren't kept")
+	}
+	since := uint64(h.Revision)
+	if r.StartRevision > 0 {
+		since = uint64(r.StartRevision - 1)
+	}
+
+	w.mu.Lock()
+	id := r.WatchId
+	if id == 0 {
+		for ; w.watchers[w.nextID] != nil; w.nextID++ {
+		}
+		id = w.nextID
+	} else if w.watchers[id] != nil {
+		w.mu.Unlock()
+		return refuse("etcdserver: duplicate watch ID provided on the WatchStream")
+	}
+	wctx, cancel := context.WithCancel(ctx)
+	w.watchers[id] = cancel
+	err = w.stream.Send(&pb.WatchResponse{Header: h, WatchId: id, Created: true})
+	w.mu.Unlock()
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.watch(wctx, id, r, since)
+	}()
+	return nil
+}
+
+func (w *watchStream) cancel(ctx context.Context, id int64) error {
+	w.mu.Lock()
+	if cancel := w.watchers[id]; cancel != nil {
+		cancel()
+		delete(w.watchers, id)
+	}
+	w.mu.Unlock()
+	h, err := w.s.header(ctx)
+	if err != nil {
+		return err
+	}
+	return w.send(&pb.WatchResponse{Header: h, WatchId: id, Canceled: true})
+}
+
+func (w *watchStream) progress(ctx context.Context) error {
+	h, err := w.s.header(ctx)
+	if err != nil {
+		return err
+	}
+	return w.send(&pb.WatchResponse{Header: h, WatchId: -1})
+}
+
+// watch polls the changes made to the range of `r` after `since`, until
+// `ctx` is done.
+func (w *watchStream) watch(ctx context.Context, id int64, r *pb.WatchCreateRequest, since uint64) {
+	var noPut, noDelete bool
+	for _, f := range r.Filters {
+		noPut = noPut || f == pb.WatchCreateRequest_NOPUT
+		noDelete = noDelete || f == pb.WatchCreateRequest_NODELETE
+	}
+	prefix, in := keyRange(r.Key, r.RangeEnd)
+
+	t := time.NewTicker(w.s.opts.WatchInterval)
+	defer t.Stop()
+	for {
+		seq, events, err := w.changes(ctx, prefix, in, since, noPut, noDelete)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			res := &pb.WatchResponse{Header: &pb.ResponseHeader{Revision: int64(seq)}, WatchId: id, Canceled: true, CancelReason: err.Error()}
+			if errors.Is(err, txkv.ErrCompacted) {
+				res.CompactRevision = int64(seq)
+			}
+			w.mu.Lock()
+			delete(w.watchers, id)
+			w.mu.Unlock()
+			_ = w.send(res)
+			return
+		}
+		if len(events) > 0 {
+			res := &pb.WatchResponse{Header: &pb.ResponseHeader{Revision: int64(seq)}, WatchId: id, Events: events}
+			if w.send(res) != nil {
+				return
+			}
+		}
+		since = seq
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// changes returns the seq of the store, and the events of the keys in range
+// modified after `since`.
+func (w *watchStream) changes(ctx context.Context, prefix txkv.Key, in func(txkv.Key) bool, since uint64, noPut, noDelete bool) (uint64, []*mvccpb.Event, error) {
+	seq, err := w.ct.Seq(ctx)
+	if err != nil || seq == since {
+		return seq, nil, err
+	}
+	keys, err := w.ct.ListModifiedSince(ctx, prefix, since)
+	if err != nil {
+		return seq, nil, err
+	}
+	var events []*mvccpb.Event
+	for _, key := range keys {
+		if !in(key) {
+			continue
+		}
+		v, ok, err := w.s.kv.Get(ctx, key)
+		if err != nil {
+			return seq, nil, err
+		}
+		ev := &mvccpb.Event{Kv: &mvccpb.KeyValue{Key: key, ModRevision: int64(seq)}}
+		if ok {
+			ev.Type, ev.Kv.Value = mvccpb.PUT, v
+		} else {
+			ev.Type = mvccpb.DELETE
+		}
+		if (ok && noPut) || (!ok && noDelete) {
+			continue
+		}
+		events = append(events, ev)
+	}
+	return seq, events, nil
+}
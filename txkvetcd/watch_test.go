@@ -0,0 +1,76 @@
+package txkvetcd_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+
+	"github.com/aybabtme/txkv"
+)
+
+func TestWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := txkv.InMem()
+	conn := newConn(t, store)
+	kv := pb.NewKVClient(conn)
+
+	stream, err := pb.NewWatchClient(conn).Watch(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pb.WatchRequest{RequestUnion: &pb.WatchRequest_CreateRequest{
+		CreateRequest: &pb.WatchCreateRequest{Key: []byte("a/"), RangeEnd: []byte("a0")},
+	}}))
+	created, err := stream.Recv()
+	require.NoError(t, err)
+	require.True(t, created.Created)
+
+	_, err = kv.Put(ctx, &pb.PutRequest{Key: []byte("a/1"), Value: []byte("v")})
+	require.NoError(t, err)
+	_, err = kv.Put(ctx, &pb.PutRequest{Key: []byte("b"), Value: []byte("out of range")})
+	require.NoError(t, err)
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, created.WatchId, res.WatchId)
+	require.Len(t, res.Events, 1)
+	require.Equal(t, mvccpb.PUT, res.Events[0].Type)
+	require.Equal(t, "a/1", string(res.Events[0].Kv.Key))
+	require.Equal(t, "v", string(res.Events[0].Kv.Value))
+
+	_, err = kv.DeleteRange(ctx, &pb.DeleteRangeRequest{Key: []byte("a/1")})
+	require.NoError(t, err)
+	res, err = stream.Recv()
+	require.NoError(t, err)
+	require.Len(t, res.Events, 1)
+	require.Equal(t, mvccpb.DELETE, res.Events[0].Type)
+
+	// a watch from a past revision replays what changed since
+	require.NoError(t, stream.Send(&pb.WatchRequest{RequestUnion: &pb.WatchRequest_CreateRequest{
+		CreateRequest: &pb.WatchCreateRequest{Key: []byte("b"), StartRevision: 1},
+	}}))
+	created, err = stream.Recv()
+	require.NoError(t, err)
+	require.True(t, created.Created)
+	res, err = stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, created.WatchId, res.WatchId)
+	require.Equal(t, "b", string(res.Events[0].Kv.Key))
+
+	require.NoError(t, stream.Send(&pb.WatchRequest{RequestUnion: &pb.WatchRequest_CancelRequest{
+		CancelRequest: &pb.WatchCancelRequest{WatchId: created.WatchId},
+	}}))
+	res, err = stream.Recv()
+	require.NoError(t, err)
+	require.True(t, res.Canceled)
+
+	// previous values aren't kept
+	require.NoError(t, stream.Send(&pb.WatchRequest{RequestUnion: &pb.WatchRequest_CreateRequest{
+		CreateRequest: &pb.WatchCreateRequest{Key: []byte("a"), PrevKv: true},
+	}}))
+	res, err = stream.Recv()
+	require.NoError(t, err)
+	require.True(t, res.Canceled)
+	require.NotEmpty(t, res.CancelReason)
+}
@@ -0,0 +1,39 @@
+// Package txkvhttp has HTTP middleware for the handlers of applications
+// serving a txkv store.
+package txkvhttp
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/aybabtme/txkv"
+)
+
+// ReadSnapshot returns a handler pinning every request to a read snapshot of
+// `kv` before handing it to `next`, so that all the reads of a request are
+// consistent with each other. Handlers read through the snapshot with
+// txkv.SnapshotFromContext(r.Context(), kv), and write to `kv` as usual.
+//
+// When the snapshot is stale, the request should be retried: handlers can
+// answer with Stale.
+func ReadSnapshot(kv txkv.KV, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, err := txkv.ContextWithReadSnapshot(r.Context(), kv)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Stale answers with 503 and tells the client to retry if `err` is due to a
+// stale snapshot, and tells whether it did.
+func Stale(w http.ResponseWriter, err error) bool {
+	if !errors.Is(err, txkv.ErrSnapshotStale) {
+		return false
+	}
+	w.Header().Set("Retry-After", "0")
+	http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	return true
+}
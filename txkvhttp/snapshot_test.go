@@ -0,0 +1,55 @@
+package txkvhttp_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aybabtme/txkv"
+	"github.com/aybabtme/txkv/txkvhttp"
+)
+
+func TestReadSnapshot(t *testing.T) {
+	ctx := context.Background()
+	kv := txkv.InMem()
+	require.NoError(t, kv.Put(ctx, txkv.Key("a"), txkv.Value("1")))
+	require.NoError(t, kv.Put(ctx, txkv.Key("b"), txkv.Value("1")))
+
+	// midway, a writer changes both values
+	h := txkvhttp.ReadSnapshot(kv, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		store := txkv.SnapshotFromContext(r.Context(), kv)
+		a, _, err := store.Get(r.Context(), txkv.Key("a"))
+		require.NoError(t, err)
+		if r.URL.Query().Get("write") != "" {
+			tx, err := kv.Begin(r.Context())
+			require.NoError(t, err)
+			require.NoError(t, tx.Put(r.Context(), txkv.Key("a"), txkv.Value("2")))
+			require.NoError(t, tx.Put(r.Context(), txkv.Key("b"), txkv.Value("2")))
+			require.NoError(t, tx.Commit(r.Context()))
+		}
+		b, _, err := store.Get(r.Context(), txkv.Key("b"))
+		if txkvhttp.Stale(w, err) {
+			return
+		}
+		require.NoError(t, err)
+		fmt.Fprintf(w, "%s %s", a, b)
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "1 1", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?write=1", nil))
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	require.Equal(t, "0", rec.Header().Get("Retry-After"))
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, "2 2", rec.Body.String())
+}
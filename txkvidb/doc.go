@@ -0,0 +1,10 @@
+// Package txkvidb is a txkv store backed by the IndexedDB database of a
+// browser, for running txkv-based logic client-side, in programs built with
+// GOOS=js GOARCH=wasm. It's empty on other platforms.
+//
+// Keys and values are stored as binary in a single object store, which
+// IndexedDB orders bytewise like the other stores. IndexedDB transactions
+// can't stay open across calls from Go, so transactions buffer their writes
+// and apply them in a single IndexedDB transaction on Commit. Like InMem,
+// they read committed data, not a snapshot.
+package txkvidb
@@ -0,0 +1,284 @@
+//go:build js && wasm
+
+package txkvidb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"syscall/js"
+
+	"github.com/aybabtme/txkv"
+)
+
+// storeName is the object store holding the keys.
+const storeName = "kv"
+
+var (
+	_ txkv.TransactionalKV = (*KV)(nil)
+	_ txkv.Batcher         = (*KV)(nil)
+	_ txkv.PendingLister   = (*tx)(nil)
+)
+
+// KV is a TransactionalKV stored in an IndexedDB database.
+type KV struct {
+	db js.Value
+}
+
+// Open opens the IndexedDB database `name`, creating it if needed.
+func Open(ctx context.Context, name string) (*KV, error) {
+	idb := js.Global().Get("indexedDB")
+	if idb.IsUndefined() {
+		return nil, errors.New("txkvidb: IndexedDB isn't available")
+	}
+	req := idb.Call("open", name, 1)
+	upgrade := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		req.Get("result").Call("createObjectStore", storeName)
+		return nil
+	})
+	defer upgrade.Release()
+	req.Set("onupgradeneeded", upgrade)
+
+	db, err := await(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("txkvidb: can't open database %q: %w", name, err)
+	}
+	return &KV{db: db}, nil
+}
+
+// Close closes the database.
+func (kv *KV) Close() error {
+	kv.db.Call("close")
+	return nil
+}
+
+func (kv *KV) Put(ctx context.Context, key txkv.Key, value txkv.Value) error {
+	return kv.write(ctx, map[string]write{string(key): {value: value}})
+}
+
+func (kv *KV) Get(ctx context.Context, key txkv.Key) (txkv.Value, bool, error) {
+	store := kv.db.Call("transaction", storeName, "readonly").Call("objectStore", storeName)
+	v, err := await(ctx, store.Call("get", toJS(key)))
+	if err != nil {
+		return nil, false, err
+	}
+	if v.IsUndefined() {
+		return nil, false, nil
+	}
+	return fromJS(v), true, nil
+}
+
+func (kv *KV) Delete(ctx context.Context, key txkv.Key) error {
+	return kv.write(ctx, map[string]write{string(key): {deleted: true}})
+}
+
+func (kv *KV) List(ctx context.Context, prefix txkv.Key) ([]txkv.Key, error) {
+	store := kv.db.Call("transaction", storeName, "readonly").Call("objectStore", storeName)
+	var req js.Value
+	if len(prefix) == 0 {
+		req = store.Call("getAllKeys")
+	} else {
+		keyRange := js.Global().Get("IDBKeyRange")
+		var r js.Value
+		if end := txkv.PrefixSuccessor(prefix); end != nil {
+			r = keyRange.Call("bound", toJS(prefix), toJS(end), false, true)
+		} else {
+			r = keyRange.Call("lowerBound", toJS(prefix))
+		}
+		req = store.Call("getAllKeys", r)
+	}
+	res, err := await(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	var keys []txkv.Key
+	for i := 0; i < res.Length(); i++ {
+		keys = append(keys, fromJS(res.Index(i)))
+	}
+	return keys, nil
+}
+
+// Apply applies `ops` in a single IndexedDB transaction.
+func (kv *KV) Apply(ctx context.Context, ops []txkv.Op) error {
+	writes := make(map[string]write, len(ops))
+	for _, op := range ops {
+		writes[string(op.Key)] = write{value: op.Value, deleted: op.Delete}
+	}
+	return kv.write(ctx, writes)
+}
+
+func (kv *KV) Begin(ctx context.Context) (txkv.TxKV, error) {
+	return &tx{kv: kv, writes: make(map[string]write)}, nil
+}
+
+type write struct {
+	value   txkv.Value
+	deleted bool
+}
+
+// write applies `writes` in a single IndexedDB transaction, and waits for it
+// to be durable.
+func (kv *KV) write(ctx context.Context, writes map[string]write) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	t := kv.db.Call("transaction", storeName, "readwrite")
+	store := t.Call("objectStore", storeName)
+	for key, w := range writes {
+		if w.deleted {
+			store.Call("delete", toJS(txkv.Key(key)))
+		} else {
+			store.Call("put", toJS(w.value), toJS(txkv.Key(key)))
+		}
+	}
+	return awaitTx(t)
+}
+
+type tx struct {
+	kv *KV
+
+	mu     sync.Mutex
+	writes map[string]write
+}
+
+func (t *tx) Put(ctx context.Context, key txkv.Key, value txkv.Value) error {
+	t.mu.Lock()
+	t.writes[string(key)] = write{value: append(txkv.Value(nil), value...)}
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *tx) Get(ctx context.Context, key txkv.Key) (txkv.Value, bool, error) {
+	t.mu.Lock()
+	w, ok := t.writes[string(key)]
+	t.mu.Unlock()
+	if ok {
+		return w.value, !w.deleted, nil
+	}
+	return t.kv.Get(ctx, key)
+}
+
+func (t *tx) Delete(ctx context.Context, key txkv.Key) error {
+	t.mu.Lock()
+	t.writes[string(key)] = write{deleted: true}
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *tx) List(ctx context.Context, prefix txkv.Key) ([]txkv.Key, error) {
+	keys, err := t.kv.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var out []txkv.Key
+	for _, key := range keys {
+		if _, ok := t.writes[string(key)]; !ok {
+			out = append(out, key)
+		}
+	}
+	for key, w := range t.writes {
+		if !w.deleted && len(key) >= len(prefix) && key[:len(prefix)] == string(prefix) {
+			out = append(out, txkv.Key(key))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return string(out[i]) < string(out[j]) })
+	return out, nil
+}
+
+func (t *tx) Pending(ctx context.Context) ([]txkv.Op, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ops := make([]txkv.Op, 0, len(t.writes))
+	for key, w := range t.writes {
+		ops = append(ops, txkv.Op{Key: txkv.Key(key), Value: w.value, Delete: w.deleted})
+	}
+	sort.Slice(ops, func(i, j int) bool { return string(ops[i].Key) < string(ops[j].Key) })
+	return ops, nil
+}
+
+func (t *tx) Commit(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.writes) == 0 {
+		return nil
+	}
+	return t.kv.write(ctx, t.writes)
+}
+
+func (t *tx) Rollback(ctx context.Context) error {
+	t.mu.Lock()
+	t.writes = make(map[string]write)
+	t.mu.Unlock()
+	return nil
+}
+
+// await waits for an IDBRequest to complete, returning its result. Requests
+// can't be cancelled, so `ctx` is only checked before waiting.
+func await(ctx context.Context, req js.Value) (js.Value, error) {
+	if err := ctx.Err(); err != nil {
+		return js.Undefined(), err
+	}
+	done := make(chan error, 1)
+	onSuccess := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		done <- nil
+		return nil
+	})
+	onError := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		done <- domError(req.Get("error"))
+		return nil
+	})
+	defer onSuccess.Release()
+	defer onError.Release()
+	req.Set("onsuccess", onSuccess)
+	req.Set("onerror", onError)
+	if err := <-done; err != nil {
+		return js.Undefined(), err
+	}
+	return req.Get("result"), nil
+}
+
+// awaitTx waits for an IDBTransaction to complete.
+func awaitTx(t js.Value) error {
+	done := make(chan error, 1)
+	onComplete := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		done <- nil
+		return nil
+	})
+	onAbort := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		done <- domError(t.Get("error"))
+		return nil
+	})
+	defer onComplete.Release()
+	defer onAbort.Release()
+	t.Set("oncomplete", onComplete)
+	t.Set("onabort", onAbort)
+	return <-done
+}
+
+func domError(v js.Value) error {
+	if v.IsNull() || v.IsUndefined() {
+		return errors.New("txkvidb: request failed")
+	}
+	return fmt.Errorf("txkvidb: %s: %s", v.Get("name").String(), v.Get("message").String())
+}
+
+func toJS(b []byte) js.Value {
+	u := js.Global().Get("Uint8Array").New(len(b))
+	js.CopyBytesToJS(u, b)
+	return u
+}
+
+// fromJS copies binary keys, which IndexedDB returns as ArrayBuffers, and
+// values, stored as Uint8Arrays.
+func fromJS(v js.Value) []byte {
+	if v.InstanceOf(js.Global().Get("ArrayBuffer")) {
+		v = js.Global().Get("Uint8Array").New(v)
+	}
+	b := make([]byte, v.Length())
+	js.CopyBytesToGo(b, v)
+	return b
+}
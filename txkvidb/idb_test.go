@@ -0,0 +1,49 @@
+//go:build js && wasm
+
+package txkvidb
+
+import (
+	"context"
+	"syscall/js"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aybabtme/txkv"
+)
+
+func TestKV(t *testing.T) {
+	if js.Global().Get("indexedDB").IsUndefined() {
+		t.Skip("no IndexedDB in this runtime")
+	}
+	ctx := context.Background()
+	kv, err := Open(ctx, t.Name())
+	require.NoError(t, err)
+	defer kv.Close()
+
+	require.NoError(t, kv.Put(ctx, txkv.Key("a/1"), txkv.Value("one")))
+	require.NoError(t, kv.Put(ctx, txkv.Key("a/2"), txkv.Value("two")))
+	require.NoError(t, kv.Put(ctx, txkv.Key("b"), txkv.Value("three")))
+
+	v, ok, err := kv.Get(ctx, txkv.Key("a/1"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, txkv.Value("one"), v)
+
+	keys, err := kv.List(ctx, txkv.Key("a/"))
+	require.NoError(t, err)
+	require.Equal(t, []txkv.Key{txkv.Key("a/1"), txkv.Key("a/2")}, keys)
+
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Delete(ctx, txkv.Key("a/1")))
+	require.NoError(t, tx.Put(ctx, txkv.Key("a/3"), txkv.Value("four")))
+	keys, err = tx.List(ctx, txkv.Key("a/"))
+	require.NoError(t, err)
+	require.Equal(t, []txkv.Key{txkv.Key("a/2"), txkv.Key("a/3")}, keys)
+	require.NoError(t, tx.Commit(ctx))
+
+	_, ok, err = kv.Get(ctx, txkv.Key("a/1"))
+	require.NoError(t, err)
+	require.False(t, ok)
+}
@@ -0,0 +1,281 @@
+// Package txkvmock provides a scriptable fake txkv store, for unit tests of
+// application code that need to control what the store answers, or make it
+// fail, and verify how it was called.
+//
+//	kv := txkvmock.New(t)
+//	kv.ExpectGet(txkv.Key("user/1")).Returns(txkv.Value("alice"))
+//	kv.ExpectPut(txkv.Key("user/1"), nil).Fails(txkv.ErrTransient)
+//	... exercise code using kv ...
+//
+// Calls are matched against the expectations in the order they were set,
+// each expectation being met a number of times, once by default. Unexpected
+// calls fail the test. Expectations not met by the end of the test fail it
+// too.
+package txkvmock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/aybabtme/txkv"
+)
+
+// ErrUnexpectedCall is returned by the calls no expectation matches.
+var ErrUnexpectedCall = errors.New("txkvmock: unexpected call")
+
+// The methods of a store.
+const (
+	Get      = "Get"
+	Put      = "Put"
+	Delete   = "Delete"
+	List     = "List"
+	Begin    = "Begin"
+	Commit   = "Commit"
+	Rollback = "Rollback"
+)
+
+// Call is a call made to a Mock.
+type Call struct {
+	Method string
+	// Key is the key read or written, or the prefix listed.
+	Key   txkv.Key
+	Value txkv.Value
+	// InTx tells whether the call was made within a transaction.
+	InTx bool
+}
+
+func (c Call) String() string {
+	s := c.Method
+	if c.InTx {
+		s = "tx." + s
+	}
+	switch c.Method {
+	case Get, Delete, List:
+		return fmt.Sprintf("%s(%q)", s, c.Key)
+	case Put:
+		return fmt.Sprintf("%s(%q, %q)", s, c.Key, c.Value)
+	}
+	return s + "()"
+}
+
+// Expectation is a call a Mock expects, and how it answers it.
+type Expectation struct {
+	method string
+	key    txkv.Key
+	value  txkv.Value
+
+	result txkv.Value
+	found  bool
+	keys   []txkv.Key
+	err    error
+	times  int
+	calls  int
+}
+
+// Returns has a Get find `value`.
+func (e *Expectation) Returns(value txkv.Value) *Expectation {
+	e.result, e.found = value, true
+	return e
+}
+
+// Lists has a List return `keys`.
+func (e *Expectation) Lists(keys ...txkv.Key) *Expectation {
+	e.keys = keys
+	return e
+}
+
+// Fails has the call fail with `err`.
+func (e *Expectation) Fails(err error) *Expectation {
+	e.err = err
+	return e
+}
+
+// Times has the expectation met `n` times.
+func (e *Expectation) Times(n int) *Expectation {
+	e.times = n
+	return e
+}
+
+func (e *Expectation) matches(c Call) bool {
+	if e.method != c.Method || e.calls >= e.times {
+		return false
+	}
+	switch c.Method {
+	case Get, Delete, List:
+		return bytes.Equal(e.key, c.Key)
+	case Put:
+		return bytes.Equal(e.key, c.Key) && (e.value == nil || bytes.Equal(e.value, c.Value))
+	}
+	return true
+}
+
+func (e *Expectation) String() string {
+	return Call{Method: e.method, Key: e.key, Value: e.value}.String()
+}
+
+// Mock is a txkv.TransactionalKV answering as it's told to. It's safe for
+// concurrent use.
+type Mock struct {
+	t testing.TB
+
+	mu           sync.Mutex
+	expectations []*Expectation
+	calls        []Call
+}
+
+var _ txkv.TransactionalKV = (*Mock)(nil)
+
+// New returns a Mock failing `t` on unexpected calls, and on expectations
+// not met when `t` ends.
+func New(t testing.TB) *Mock {
+	m := &Mock{t: t}
+	t.Cleanup(func() { m.AssertExpectations(t) })
+	return m
+}
+
+func (m *Mock) expect(method string, key txkv.Key, value txkv.Value) *Expectation {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := &Expectation{method: method, key: key, value: value, times: 1}
+	m.expectations = append(m.expectations, e)
+	return e
+}
+
+// ExpectGet expects a Get of `key`, which finds nothing unless told to.
+func (m *Mock) ExpectGet(key txkv.Key) *Expectation { return m.expect(Get, key, nil) }
+
+// ExpectPut expects a Put of `value` at `key`, or of any value if `value` is
+// nil.
+func (m *Mock) ExpectPut(key txkv.Key, value txkv.Value) *Expectation {
+	return m.expect(Put, key, value)
+}
+
+// ExpectDelete expects a Delete of `key`.
+func (m *Mock) ExpectDelete(key txkv.Key) *Expectation { return m.expect(Delete, key, nil) }
+
+// ExpectList expects a List of `prefix`, which lists nothing unless told to.
+func (m *Mock) ExpectList(prefix txkv.Key) *Expectation { return m.expect(List, prefix, nil) }
+
+// ExpectBegin expects a transaction to begin. Calls within transactions are
+// matched against the same expectations as others.
+func (m *Mock) ExpectBegin() *Expectation { return m.expect(Begin, nil, nil) }
+
+// ExpectCommit expects a transaction to commit.
+func (m *Mock) ExpectCommit() *Expectation { return m.expect(Commit, nil, nil) }
+
+// ExpectRollback expects a transaction to roll back.
+func (m *Mock) ExpectRollback() *Expectation { return m.expect(Rollback, nil, nil) }
+
+// Calls returns the calls made so far, in order.
+func (m *Mock) Calls() []Call {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Call(nil), m.calls...)
+}
+
+// AssertExpectations fails `t` if some expectations weren't met. It's done
+// when the test given to New ends.
+func (m *Mock) AssertExpectations(t testing.TB) {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.expectations {
+		if e.calls < e.times {
+			t.Errorf("txkvmock: expected %s %d times, called %d times", e, e.times, e.calls)
+		}
+	}
+}
+
+// call records `c` and returns the expectation it meets.
+func (m *Mock) call(c Call) (*Expectation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, c)
+	for _, e := range m.expectations {
+		if e.matches(c) {
+			e.calls++
+			return e, nil
+		}
+	}
+	m.t.Errorf("txkvmock: unexpected call %s", c)
+	return nil, fmt.Errorf("%w: %s", ErrUnexpectedCall, c)
+}
+
+func (m *Mock) get(c Call) (txkv.Value, bool, error) {
+	e, err := m.call(c)
+	if err != nil {
+		return nil, false, err
+	}
+	return e.result, e.found, e.err
+}
+
+func (m *Mock) list(c Call) ([]txkv.Key, error) {
+	e, err := m.call(c)
+	if err != nil {
+		return nil, err
+	}
+	return e.keys, e.err
+}
+
+func (m *Mock) do(c Call) error {
+	e, err := m.call(c)
+	if err != nil {
+		return err
+	}
+	return e.err
+}
+
+func (m *Mock) Put(ctx context.Context, key txkv.Key, value txkv.Value) error {
+	return m.do(Call{Method: Put, Key: key, Value: value})
+}
+
+func (m *Mock) Get(ctx context.Context, key txkv.Key) (txkv.Value, bool, error) {
+	return m.get(Call{Method: Get, Key: key})
+}
+
+func (m *Mock) Delete(ctx context.Context, key txkv.Key) error {
+	return m.do(Call{Method: Delete, Key: key})
+}
+
+func (m *Mock) List(ctx context.Context, prefix txkv.Key) ([]txkv.Key, error) {
+	return m.list(Call{Method: List, Key: prefix})
+}
+
+func (m *Mock) Begin(ctx context.Context) (txkv.TxKV, error) {
+	if err := m.do(Call{Method: Begin}); err != nil {
+		return nil, err
+	}
+	return &tx{m: m}, nil
+}
+
+type tx struct {
+	m *Mock
+}
+
+func (t *tx) Put(ctx context.Context, key txkv.Key, value txkv.Value) error {
+	return t.m.do(Call{Method: Put, Key: key, Value: value, InTx: true})
+}
+
+func (t *tx) Get(ctx context.Context, key txkv.Key) (txkv.Value, bool, error) {
+	return t.m.get(Call{Method: Get, Key: key, InTx: true})
+}
+
+func (t *tx) Delete(ctx context.Context, key txkv.Key) error {
+	return t.m.do(Call{Method: Delete, Key: key, InTx: true})
+}
+
+func (t *tx) List(ctx context.Context, prefix txkv.Key) ([]txkv.Key, error) {
+	return t.m.list(Call{Method: List, Key: prefix, InTx: true})
+}
+
+func (t *tx) Commit(ctx context.Context) error {
+	return t.m.do(Call{Method: Commit, InTx: true})
+}
+
+func (t *tx) Rollback(ctx context.Context) error {
+	return t.m.do(Call{Method: Rollback, InTx: true})
+}
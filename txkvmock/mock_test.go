@@ -0,0 +1,115 @@
+package txkvmock_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aybabtme/txkv"
+	"github.com/aybabtme/txkv/txkvmock"
+)
+
+// rename is application code under test.
+func rename(ctx context.Context, kv txkv.TransactionalKV, from, to txkv.Key) error {
+	tx, err := kv.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	v, ok, err := tx.Get(ctx, from)
+	if err == nil && !ok {
+		err = fmt.Errorf("%q not found", from)
+	}
+	if err == nil {
+		err = tx.Put(ctx, to, v)
+	}
+	if err == nil {
+		err = tx.Delete(ctx, from)
+	}
+	if err != nil {
+		_ = tx.Rollback(ctx)
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func TestMock(t *testing.T) {
+	ctx := context.Background()
+	kv := txkvmock.New(t)
+	kv.ExpectBegin()
+	kv.ExpectGet(txkv.Key("a")).Returns(txkv.Value("1"))
+	kv.ExpectPut(txkv.Key("b"), txkv.Value("1"))
+	kv.ExpectDelete(txkv.Key("a"))
+	kv.ExpectCommit()
+	require.NoError(t, rename(ctx, kv, txkv.Key("a"), txkv.Key("b")))
+
+	require.Equal(t, []txkvmock.Call{
+		{Method: txkvmock.Begin},
+		{Method: txkvmock.Get, Key: txkv.Key("a"), InTx: true},
+		{Method: txkvmock.Put, Key: txkv.Key("b"), Value: txkv.Value("1"), InTx: true},
+		{Method: txkvmock.Delete, Key: txkv.Key("a"), InTx: true},
+		{Method: txkvmock.Commit, InTx: true},
+	}, kv.Calls())
+}
+
+func TestMockFailures(t *testing.T) {
+	ctx := context.Background()
+	kv := txkvmock.New(t)
+	kv.ExpectBegin().Times(2)
+	kv.ExpectGet(txkv.Key("a")).Returns(txkv.Value("1")).Times(2)
+	kv.ExpectPut(txkv.Key("b"), nil).Fails(txkv.ErrTransient)
+	kv.ExpectRollback()
+	require.ErrorIs(t, rename(ctx, kv, txkv.Key("a"), txkv.Key("b")), txkv.ErrTransient)
+
+	// expectations are met in order
+	kv.ExpectPut(txkv.Key("b"), nil)
+	kv.ExpectDelete(txkv.Key("a")).Fails(txkv.ErrConflict)
+	kv.ExpectRollback()
+	require.ErrorIs(t, rename(ctx, kv, txkv.Key("a"), txkv.Key("b")), txkv.ErrConflict)
+
+	kv.ExpectList(txkv.Key("user/")).Lists(txkv.Key("user/1"), txkv.Key("user/2"))
+	keys, err := kv.List(ctx, txkv.Key("user/"))
+	require.NoError(t, err)
+	require.Equal(t, []txkv.Key{txkv.Key("user/1"), txkv.Key("user/2")}, keys)
+}
+
+// recordingT records the failures of a test, without failing it.
+type recordingT struct {
+	testing.TB
+	errors  []string
+	cleanup []func()
+}
+
+func (r *recordingT) Helper() {}
+
+func (r *recordingT) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recordingT) Cleanup(fn func()) { r.cleanup = append(r.cleanup, fn) }
+
+func TestMockVerification(t *testing.T) {
+	ctx := context.Background()
+	rt := &recordingT{TB: t}
+	kv := txkvmock.New(rt)
+	kv.ExpectGet(txkv.Key("a"))
+	kv.ExpectPut(txkv.Key("a"), txkv.Value("1"))
+
+	_, ok, err := kv.Get(ctx, txkv.Key("a"))
+	require.NoError(t, err)
+	require.False(t, ok)
+	err = kv.Put(ctx, txkv.Key("a"), txkv.Value("2"))
+	require.ErrorIs(t, err, txkvmock.ErrUnexpectedCall)
+	_, _, err = kv.Get(ctx, txkv.Key("a"))
+	require.ErrorIs(t, err, txkvmock.ErrUnexpectedCall)
+
+	for _, fn := range rt.cleanup {
+		fn()
+	}
+	require.Equal(t, []string{
+		`txkvmock: unexpected call Put("a", "2")`,
+		`txkvmock: unexpected call Get("a")`,
+		`txkvmock: expected Put("a", "1") 1 times, called 0 times`,
+	}, rt.errors)
+}
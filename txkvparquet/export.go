@@ -0,0 +1,172 @@
+// Package txkvparquet exports the contents of a txkv store to Parquet files,
+// so they can be analyzed with DuckDB, Spark and the like without custom
+// tooling.
+//
+// Rows have columns key and value, both binary, plus the optional metadata
+// columns version and updated_at. Files can be partitioned by key prefix, in
+// the Hive layout understood by most query engines.
+package txkvparquet
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/aybabtme/txkv"
+)
+
+// Row is the schema of exported rows.
+type Row struct {
+	Key       []byte     `parquet:"key"`
+	Value     []byte     `parquet:"value"`
+	Version   *int64     `parquet:"version,optional"`
+	UpdatedAt *time.Time `parquet:"updated_at,optional"`
+}
+
+// Metadata describes a key, for the metadata columns.
+type Metadata struct {
+	Version   int64
+	UpdatedAt time.Time
+}
+
+// Options tune an export.
+type Options struct {
+	// Depth is the number of key segments after the exported prefix that
+	// make up a partition. With Depth 1 and Sep '/', keys "users/1" and
+	// "users/2" go to partition "users/". Keys with fewer segments get a
+	// partition of their own. With Depth 0, everything goes in a single
+	// partition named after the exported prefix.
+	Depth int
+	// Sep separates key segments. Defaults to '/'.
+	Sep byte
+	// Metadata, if set, fills the metadata columns of each key. The columns
+	// are left null for keys it returns false for, or if it's not set.
+	Metadata func(ctx context.Context, key txkv.Key) (Metadata, bool, error)
+}
+
+// Export writes the keys of `kv` under `prefix` and their values as Parquet
+// files, one per partition, each written to what `create` returns for it. It
+// returns how many keys it exported.
+func Export(ctx context.Context, kv txkv.KV, prefix txkv.Key, create func(partition txkv.Key) (io.WriteCloser, error), opts Options) (int, error) {
+	if opts.Sep == 0 {
+		opts.Sep = '/'
+	}
+	its, err := txkv.ScanPartitions(ctx, kv, prefix, 1)
+	if err != nil {
+		return 0, err
+	}
+	it := its[0]
+	defer it.Close()
+
+	var p *partitionWriter
+	done := make(map[string]bool)
+	n := 0
+	for it.Next(ctx) {
+		key := it.Key()
+		partition := partitionOf(key, len(prefix), opts.Sep, opts.Depth)
+		// keys are in order, so a partition's keys are all together
+		if p == nil || !bytes.Equal(p.name, partition) {
+			if err := p.close(); err != nil {
+				return n, err
+			}
+			if done[string(partition)] {
+				// a custom key order can split a partition, whose file was
+				// already written
+				return n, fmt.Errorf("txkvparquet: keys of partition %q aren't together, is the store ordered bytewise?", partition)
+			}
+			done[string(partition)] = true
+			if p, err = newPartitionWriter(partition, create); err != nil {
+				return n, err
+			}
+		}
+		row := Row{Key: key, Value: it.Value()}
+		if opts.Metadata != nil {
+			md, ok, err := opts.Metadata(ctx, key)
+			if err != nil {
+				_ = p.close()
+				return n, err
+			}
+			if ok {
+				row.Version, row.UpdatedAt = &md.Version, &md.UpdatedAt
+			}
+		}
+		if _, err := p.pw.Write([]Row{row}); err != nil {
+			_ = p.close()
+			return n, err
+		}
+		n++
+	}
+	if err := it.Err(); err != nil {
+		_ = p.close()
+		return n, err
+	}
+	return n, p.close()
+}
+
+// ExportDir is Export writing files under `dir`. With a Depth of 0, the
+// single file is `dir/data.parquet`. Otherwise, each partition is in
+// `dir/partition=<escaped partition>/data.parquet`, so that query engines
+// expose the partition as a column.
+func ExportDir(ctx context.Context, kv txkv.KV, prefix txkv.Key, dir string, opts Options) (int, error) {
+	return Export(ctx, kv, prefix, func(partition txkv.Key) (io.WriteCloser, error) {
+		path := dir
+		if opts.Depth > 0 {
+			path = filepath.Join(dir, "partition="+url.PathEscape(string(partition)))
+		}
+		if err := os.MkdirAll(path, 0o755); err != nil {
+			return nil, err
+		}
+		return os.Create(filepath.Join(path, "data.parquet"))
+	}, opts)
+}
+
+type partitionWriter struct {
+	name txkv.Key
+	w    io.WriteCloser
+	pw   *parquet.GenericWriter[Row]
+}
+
+func newPartitionWriter(name txkv.Key, create func(txkv.Key) (io.WriteCloser, error)) (*partitionWriter, error) {
+	w, err := create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &partitionWriter{
+		name: append(txkv.Key(nil), name...),
+		w:    w,
+		pw:   parquet.NewGenericWriter[Row](w),
+	}, nil
+}
+
+// close flushes the partition. It's a no-op on a nil writer.
+func (p *partitionWriter) close() error {
+	if p == nil {
+		return nil
+	}
+	err := p.pw.Close()
+	if cerr := p.w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// partitionOf returns the prefix of `key` ending with the `depth`-th `sep`
+// found after `from`, or `key` if there are not that many.
+func partitionOf(key txkv.Key, from int, sep byte, depth int) txkv.Key {
+	end := from
+	for i := 0; i < depth; i++ {
+		j := bytes.IndexByte(key[end:], sep)
+		if j < 0 {
+			return key
+		}
+		end += j + 1
+	}
+	return key[:end]
+}
@@ -0,0 +1,79 @@
+package txkvparquet_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aybabtme/txkv"
+	"github.com/aybabtme/txkv/txkvparquet"
+)
+
+func TestExportDir(t *testing.T) {
+	ctx := context.Background()
+	kv := txkv.InMem()
+	for _, key := range []string{"app/users/1", "app/users/2", "app/sessions/a", "app/config", "other"} {
+		require.NoError(t, kv.Put(ctx, txkv.Key(key), txkv.Value("value of "+key)))
+	}
+	updatedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	dir := t.TempDir()
+	n, err := txkvparquet.ExportDir(ctx, kv, txkv.Key("app/"), dir, txkvparquet.Options{
+		Depth: 1,
+		Metadata: func(ctx context.Context, key txkv.Key) (txkvparquet.Metadata, bool, error) {
+			if string(key) == "app/config" {
+				return txkvparquet.Metadata{}, false, nil
+			}
+			return txkvparquet.Metadata{Version: int64(len(key)), UpdatedAt: updatedAt}, true, nil
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 4, n)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var partitions []string
+	for _, e := range entries {
+		partitions = append(partitions, e.Name())
+	}
+	require.Equal(t, []string{"partition=app%2Fconfig", "partition=app%2Fsessions%2F", "partition=app%2Fusers%2F"}, partitions)
+
+	rows, err := parquet.ReadFile[txkvparquet.Row](filepath.Join(dir, "partition=app%2Fusers%2F", "data.parquet"))
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	require.Equal(t, []byte("app/users/1"), rows[0].Key)
+	require.Equal(t, []byte("value of app/users/1"), rows[0].Value)
+	require.Equal(t, int64(11), *rows[0].Version)
+	require.True(t, updatedAt.Equal(*rows[0].UpdatedAt))
+	require.Equal(t, []byte("app/users/2"), rows[1].Key)
+
+	rows, err = parquet.ReadFile[txkvparquet.Row](filepath.Join(dir, "partition=app%2Fconfig", "data.parquet"))
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	require.Nil(t, rows[0].Version)
+	require.Nil(t, rows[0].UpdatedAt)
+}
+
+func TestExportSingleFile(t *testing.T) {
+	ctx := context.Background()
+	kv := txkv.InMem()
+	for _, key := range []string{"a", "b/c", "d"} {
+		require.NoError(t, kv.Put(ctx, txkv.Key(key), txkv.Value{0xff, 0x00}))
+	}
+
+	dir := t.TempDir()
+	n, err := txkvparquet.ExportDir(ctx, kv, nil, dir, txkvparquet.Options{})
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+
+	rows, err := parquet.ReadFile[txkvparquet.Row](filepath.Join(dir, "data.parquet"))
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	require.Equal(t, []byte{0xff, 0x00}, rows[2].Value)
+	require.Nil(t, rows[2].Version)
+}
@@ -0,0 +1,252 @@
+// Package txkvsnap publishes immutable snapshots of a txkv store as single
+// files, which any number of processes can open read-only. Files are mapped
+// in memory where the platform allows it, so that values are read without
+// copies and processes share the same pages: it suits mostly-static datasets
+// read by many, like feature stores.
+//
+// A snapshot file holds the entries in key order, followed by an index of
+// their offsets and a footer:
+//
+//	entry:  key length (u32) | value length (u32) | key | value
+//	index:  offset of each entry (u64)
+//	footer: index offset (u64) | number of entries (u64) | magic
+//
+// Integers are big-endian.
+package txkvsnap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/aybabtme/txkv"
+)
+
+const (
+	magic      = "TXKVSNP1"
+	footerSize = 8 + 8 + len(magic)
+)
+
+// ErrClosed is returned when using a closed snapshot.
+var ErrClosed = errors.New("txkvsnap: snapshot is closed")
+
+// Write writes a snapshot of the keys of `kv` with `prefix` to `w`, and
+// returns how many it wrote. Keys and values can't be over 4GiB. The keys
+// are read one by one: `kv` must not be written to meanwhile for the snapshot
+// to be consistent.
+func Write(ctx context.Context, w io.Writer, kv txkv.KV, prefix txkv.Key) (int, error) {
+	keys, err := kv.List(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+
+	bw := bufio.NewWriter(w)
+	var (
+		offset  uint64
+		offsets []uint64
+		head    [8]byte
+	)
+	for _, key := range keys {
+		v, ok, err := kv.Get(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			continue
+		}
+		// lengths are stored on 32 bits
+		if uint64(len(key)) > math.MaxUint32 {
+			return 0, errors.New("txkvsnap: key over 4GiB")
+		}
+		if uint64(len(v)) > math.MaxUint32 {
+			return 0, fmt.Errorf("txkvsnap: value of %q over 4GiB", key)
+		}
+		offsets = append(offsets, offset)
+		binary.BigEndian.PutUint32(head[:4], uint32(len(key)))
+		binary.BigEndian.PutUint32(head[4:], uint32(len(v)))
+		bw.Write(head[:])
+		bw.Write(key)
+		bw.Write(v)
+		offset += uint64(len(head) + len(key) + len(v))
+	}
+	for _, o := range offsets {
+		bw.Write(binary.BigEndian.AppendUint64(nil, o))
+	}
+	bw.Write(binary.BigEndian.AppendUint64(nil, offset))
+	bw.Write(binary.BigEndian.AppendUint64(nil, uint64(len(offsets))))
+	bw.WriteString(magic)
+	return len(offsets), bw.Flush()
+}
+
+// Publish writes a snapshot of the keys of `kv` with `prefix` to the file at
+// `path`, atomically replacing it: processes that have the previous snapshot
+// open keep reading it, those opening `path` get the new one.
+func Publish(ctx context.Context, path string, kv txkv.KV, prefix txkv.Key) (int, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	n, err := Write(ctx, f, kv, prefix)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, err
+	}
+	return n, os.Rename(f.Name(), path)
+}
+
+// Snapshot is a read-only txkv.KV over a snapshot file. It's safe for
+// concurrent use.
+//
+// The keys and values it returns point into the file's mapping: they must not
+// be modified, and must not be used once it's closed.
+type Snapshot struct {
+	mu     sync.RWMutex
+	data   []byte
+	unmap  func() error
+	index  []byte // the offsets of the entries
+	closed bool
+}
+
+var _ txkv.KV = (*Snapshot)(nil)
+
+// Open opens the snapshot file at `path`.
+func Open(path string) (*Snapshot, error) {
+	data, unmap, err := mapFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := parse(data)
+	if err != nil {
+		_ = unmap()
+		return nil, fmt.Errorf("txkvsnap: %s: %w", path, err)
+	}
+	s.unmap = unmap
+	return s, nil
+}
+
+// parse checks that the entries of `data` follow each other up to its index,
+// so that reading them can't go out of bounds.
+func parse(data []byte) (*Snapshot, error) {
+	if len(data) < footerSize || string(data[len(data)-len(magic):]) != magic {
+		return nil, errors.New("not a snapshot file")
+	}
+	size := uint64(len(data) - footerSize)
+	footer := data[size:]
+	indexOffset := binary.BigEndian.Uint64(footer)
+	n := binary.BigEndian.Uint64(footer[8:])
+	if indexOffset > size || n != (size-indexOffset)/8 || (size-indexOffset)%8 != 0 {
+		return nil, errors.New("corrupted snapshot file: bad footer")
+	}
+	s := &Snapshot{data: data, index: data[indexOffset:size]}
+	var next uint64
+	for i := 0; i < s.Len(); i++ {
+		off := binary.BigEndian.Uint64(s.index[i*8:])
+		if off != next || indexOffset-off < 8 {
+			return nil, fmt.Errorf("corrupted snapshot file: bad offset of entry %d", i)
+		}
+		keyLen := uint64(binary.BigEndian.Uint32(data[off:]))
+		valueLen := uint64(binary.BigEndian.Uint32(data[off+4:]))
+		if indexOffset-off-8 < keyLen+valueLen {
+			return nil, fmt.Errorf("corrupted snapshot file: entry %d overruns the data", i)
+		}
+		next = off + 8 + keyLen + valueLen
+	}
+	if next != indexOffset {
+		return nil, errors.New("corrupted snapshot file: data past the last entry")
+	}
+	return s, nil
+}
+
+// Close releases the snapshot.
+func (s *Snapshot) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.unmap()
+}
+
+// Len returns the number of entries of the snapshot.
+func (s *Snapshot) Len() int { return len(s.index) / 8 }
+
+// entry returns the i-th entry.
+func (s *Snapshot) entry(i int) (txkv.Key, txkv.Value) {
+	off := binary.BigEndian.Uint64(s.index[i*8:])
+	head := s.data[off : off+8]
+	keyLen := uint64(binary.BigEndian.Uint32(head))
+	valueLen := uint64(binary.BigEndian.Uint32(head[4:]))
+	keyStart := off + 8
+	valueStart := keyStart + keyLen
+	valueEnd := valueStart + valueLen
+	return s.data[keyStart:valueStart:valueStart], s.data[valueStart:valueEnd:valueEnd]
+}
+
+// search returns the index of the first entry whose key is at least `key`.
+func (s *Snapshot) search(key txkv.Key) int {
+	return sort.Search(s.Len(), func(i int) bool {
+		k, _ := s.entry(i)
+		return bytes.Compare(k, key) >= 0
+	})
+}
+
+func (s *Snapshot) Get(ctx context.Context, key txkv.Key) (txkv.Value, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return nil, false, ErrClosed
+	}
+	i := s.search(key)
+	if i == s.Len() {
+		return nil, false, nil
+	}
+	k, v := s.entry(i)
+	if !bytes.Equal(k, key) {
+		return nil, false, nil
+	}
+	return v, true, nil
+}
+
+func (s *Snapshot) List(ctx context.Context, prefix txkv.Key) ([]txkv.Key, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	var keys []txkv.Key
+	for i := s.search(prefix); i < s.Len(); i++ {
+		k, _ := s.entry(i)
+		if !bytes.HasPrefix(k, prefix) {
+			break
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// Put fails with txkv.ErrReadOnly: snapshots are immutable.
+func (s *Snapshot) Put(ctx context.Context, key txkv.Key, value txkv.Value) error {
+	return txkv.ErrReadOnly
+}
+
+// Delete fails with txkv.ErrReadOnly: snapshots are immutable.
+func (s *Snapshot) Delete(ctx context.Context, key txkv.Key) error {
+	return txkv.ErrReadOnly
+}
@@ -0,0 +1,123 @@
+package txkvsnap_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aybabtme/txkv"
+	"github.com/aybabtme/txkv/txkvsnap"
+)
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	kv := txkv.InMem()
+	for i := 0; i < 100; i++ {
+		require.NoError(t, kv.Put(ctx, txkv.Key(fmt.Sprintf("feat/%03d", i)), txkv.Value(fmt.Sprint(i*i))))
+	}
+	require.NoError(t, kv.Put(ctx, txkv.Key("feat/empty"), txkv.Value{}))
+	require.NoError(t, kv.Put(ctx, txkv.Key("other"), txkv.Value("skipped")))
+
+	path := filepath.Join(t.TempDir(), "features.snap")
+	n, err := txkvsnap.Publish(ctx, path, kv, txkv.Key("feat/"))
+	require.NoError(t, err)
+	require.Equal(t, 101, n)
+
+	snap, err := txkvsnap.Open(path)
+	require.NoError(t, err)
+	require.Equal(t, 101, snap.Len())
+
+	v, ok, err := snap.Get(ctx, txkv.Key("feat/042"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, txkv.Value("1764"), v)
+	v, ok, err = snap.Get(ctx, txkv.Key("feat/empty"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Empty(t, v)
+	for _, missing := range []string{"feat/04", "feat/0420", "other", "zzz", ""} {
+		_, ok, err = snap.Get(ctx, txkv.Key(missing))
+		require.NoError(t, err)
+		require.False(t, ok, missing)
+	}
+
+	keys, err := snap.List(ctx, txkv.Key("feat/09"))
+	require.NoError(t, err)
+	require.Len(t, keys, 10)
+	require.Equal(t, txkv.Key("feat/090"), keys[0])
+	keys, err = snap.List(ctx, nil)
+	require.NoError(t, err)
+	require.Len(t, keys, 101)
+
+	require.ErrorIs(t, snap.Put(ctx, txkv.Key("feat/x"), txkv.Value("x")), txkv.ErrReadOnly)
+	require.ErrorIs(t, snap.Delete(ctx, txkv.Key("feat/000")), txkv.ErrReadOnly)
+
+	// republishing doesn't disturb the readers of the previous snapshot
+	require.NoError(t, kv.Put(ctx, txkv.Key("feat/042"), txkv.Value("new")))
+	_, err = txkvsnap.Publish(ctx, path, kv, txkv.Key("feat/"))
+	require.NoError(t, err)
+	v, _, err = snap.Get(ctx, txkv.Key("feat/042"))
+	require.NoError(t, err)
+	require.Equal(t, txkv.Value("1764"), v)
+	require.NoError(t, snap.Close())
+	_, _, err = snap.Get(ctx, txkv.Key("feat/042"))
+	require.ErrorIs(t, err, txkvsnap.ErrClosed)
+
+	snap, err = txkvsnap.Open(path)
+	require.NoError(t, err)
+	defer snap.Close()
+	v, _, err = snap.Get(ctx, txkv.Key("feat/042"))
+	require.NoError(t, err)
+	require.Equal(t, txkv.Value("new"), v)
+}
+
+func TestOpenInvalid(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"empty":   "",
+		"garbage": "this is not a snapshot file at all",
+	} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+		_, err := txkvsnap.Open(path)
+		require.Error(t, err, name)
+	}
+}
+
+func TestOpenCorrupted(t *testing.T) {
+	ctx := context.Background()
+	kv := txkv.InMem()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, kv.Put(ctx, txkv.Key(fmt.Sprintf("k%d", i)), txkv.Value("value")))
+	}
+	var buf bytes.Buffer
+	_, err := txkvsnap.Write(ctx, &buf, kv, nil)
+	require.NoError(t, err)
+	good := buf.Bytes()
+	footer := len(good) - 8 - 8 - len("TXKVSNP1")
+	index := footer - 3*8
+
+	dir := t.TempDir()
+	for name, corrupt := range map[string]func(b []byte){
+		// index offset + count*8 wraps around to the right size
+		"overflowing count":   func(b []byte) { binary.BigEndian.PutUint64(b[footer+8:], 3+1<<61) },
+		"index past the end":  func(b []byte) { binary.BigEndian.PutUint64(b[footer:], uint64(len(b))) },
+		"offset past the end": func(b []byte) { binary.BigEndian.PutUint64(b[index+8:], 1<<40) },
+		"offset in an entry":  func(b []byte) { binary.BigEndian.PutUint64(b[index+8:], 3) },
+		"key length":          func(b []byte) { binary.BigEndian.PutUint32(b[0:], 1<<31) },
+		"value length":        func(b []byte) { binary.BigEndian.PutUint32(b[4:], 6) },
+	} {
+		b := append([]byte(nil), good...)
+		corrupt(b)
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, b, 0o644))
+		_, err := txkvsnap.Open(path)
+		require.Error(t, err, name)
+	}
+}
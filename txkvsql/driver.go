@@ -0,0 +1,378 @@
+// Package txkvsql is a minimal database/sql driver that exposes a txkv store as
+// a single two-column table, kv(key, value). It's meant for inspecting stores
+// with SQL-shaped tooling, not as a general purpose SQL engine.
+//
+// Stores are made available under a name with Register, then opened with
+// sql.Open("txkv", name). Alternatively, sql.OpenDB(NewConnector(kv)) skips
+// the registry entirely.
+package txkvsql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/aybabtme/txkv"
+)
+
+// DriverName is the name under which the driver is registered with
+// database/sql.
+const DriverName = "txkv"
+
+func init() {
+	sql.Register(DriverName, &Driver{})
+}
+
+var (
+	storesMu sync.Mutex
+	stores   = make(map[string]txkv.TransactionalKV)
+)
+
+// Register makes a store available to sql.Open(DriverName, name). Registering
+// the same name twice replaces the previous store.
+func Register(name string, kv txkv.TransactionalKV) {
+	storesMu.Lock()
+	stores[name] = kv
+	storesMu.Unlock()
+}
+
+// Unregister removes a store from the registry. Connections that are already
+// opened are unaffected.
+func Unregister(name string) {
+	storesMu.Lock()
+	delete(stores, name)
+	storesMu.Unlock()
+}
+
+// EscapeLike escapes `\`, `%` and `_` in `s` with `\`, so that it's matched
+// literally by a LIKE pattern with ESCAPE '\'. Keys with a prefix are
+// selected with:
+//
+//	SELECT key FROM kv WHERE key LIKE ? ESCAPE '\'
+//
+// with EscapeLike(prefix)+"%" as the argument.
+func EscapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Driver implements driver.Driver, looking up stores by name in the registry.
+type Driver struct{}
+
+var (
+	_ driver.Driver        = (*Driver)(nil)
+	_ driver.DriverContext = (*Driver)(nil)
+)
+
+// Open returns a connection to the store registered as `name`.
+func (d *Driver) Open(name string) (driver.Conn, error) {
+	c, err := d.OpenConnector(name)
+	if err != nil {
+		return nil, err
+	}
+	return c.Connect(context.Background())
+}
+
+// OpenConnector returns a connector to the store registered as `name`.
+func (d *Driver) OpenConnector(name string) (driver.Connector, error) {
+	storesMu.Lock()
+	kv, ok := stores[name]
+	storesMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("txkvsql: no store registered as %q", name)
+	}
+	return &connector{drv: d, kv: kv}, nil
+}
+
+// NewConnector returns a connector for `kv`, to be used with sql.OpenDB.
+func NewConnector(kv txkv.TransactionalKV) driver.Connector {
+	return &connector{drv: &Driver{}, kv: kv}
+}
+
+type connector struct {
+	drv *Driver
+	kv  txkv.TransactionalKV
+}
+
+func (c *connector) Connect(context.Context) (driver.Conn, error) {
+	return &conn{kv: c.kv}, nil
+}
+
+func (c *connector) Driver() driver.Driver { return c.drv }
+
+type conn struct {
+	kv txkv.TransactionalKV
+	tx txkv.TxKV // set while a transaction is in progress
+}
+
+var (
+	_ driver.Conn               = (*conn)(nil)
+	_ driver.ConnBeginTx        = (*conn)(nil)
+	_ driver.ConnPrepareContext = (*conn)(nil)
+	_ driver.ExecerContext      = (*conn)(nil)
+	_ driver.QueryerContext     = (*conn)(nil)
+)
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	st, err := parse(query)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{conn: c, st: st}, nil
+}
+
+func (c *conn) Close() error {
+	if c.tx != nil {
+		err := c.tx.Rollback(context.Background())
+		c.tx = nil
+		return err
+	}
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if c.tx != nil {
+		return nil, errors.New("txkvsql: a transaction is already in progress")
+	}
+	// read-only txs are simply regular txs
+	switch sql.IsolationLevel(opts.Isolation) {
+	case sql.LevelDefault, sql.LevelReadUncommitted, sql.LevelReadCommitted:
+	default:
+		return nil, fmt.Errorf("txkvsql: isolation level %v is not supported, only read-committed", sql.IsolationLevel(opts.Isolation))
+	}
+	tx, err := c.kv.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.tx = tx
+	return &sqltx{conn: c}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	st, err := parse(query)
+	if err != nil {
+		return nil, err
+	}
+	return (&stmt{conn: c, st: st}).ExecContext(ctx, args)
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	st, err := parse(query)
+	if err != nil {
+		return nil, err
+	}
+	return (&stmt{conn: c, st: st}).QueryContext(ctx, args)
+}
+
+// target is the KV statements apply to: the tx if there's one, otherwise the
+// store itself.
+func (c *conn) target() txkv.KV {
+	if c.tx != nil {
+		return c.tx
+	}
+	return c.kv
+}
+
+type sqltx struct {
+	conn *conn
+}
+
+func (t *sqltx) Commit() error {
+	tx := t.conn.tx
+	if tx == nil {
+		return errors.New("txkvsql: transaction is already done")
+	}
+	t.conn.tx = nil
+	return tx.Commit(context.Background())
+}
+
+func (t *sqltx) Rollback() error {
+	tx := t.conn.tx
+	if tx == nil {
+		return errors.New("txkvsql: transaction is already done")
+	}
+	t.conn.tx = nil
+	return tx.Rollback(context.Background())
+}
+
+type stmt struct {
+	conn *conn
+	st   *statement
+}
+
+var (
+	_ driver.Stmt             = (*stmt)(nil)
+	_ driver.StmtExecContext  = (*stmt)(nil)
+	_ driver.StmtQueryContext = (*stmt)(nil)
+)
+
+func (s *stmt) Close() error  { return nil }
+func (s *stmt) NumInput() int { return s.st.numInput }
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	kv := s.conn.target()
+	switch s.st.kind {
+	case stmtInsert:
+		key, err := resolve(s.st.key, args)
+		if err != nil {
+			return nil, err
+		}
+		value, err := resolve(s.st.value, args)
+		if err != nil {
+			return nil, err
+		}
+		if err := kv.Put(ctx, txkv.Key(key), txkv.Value(value)); err != nil {
+			return nil, err
+		}
+		return driver.RowsAffected(1), nil
+
+	case stmtDelete:
+		keys, err := s.matchingKeys(ctx, kv, args)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if err := kv.Delete(ctx, key); err != nil {
+				return nil, err
+			}
+		}
+		return driver.RowsAffected(len(keys)), nil
+	}
+	return nil, errors.New("txkvsql: statement doesn't modify anything, use Query")
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if s.st.kind != stmtSelect {
+		return nil, errors.New("txkvsql: statement returns no rows, use Exec")
+	}
+	kv := s.conn.target()
+	keys, err := s.matchingKeys(ctx, kv, args)
+	if err != nil {
+		return nil, err
+	}
+	out := &rows{columns: s.st.columns}
+	for _, key := range keys {
+		value, ok, err := kv.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			// deleted since we listed it
+			continue
+		}
+		row := make([]driver.Value, 0, len(s.st.columns))
+		for _, col := range s.st.columns {
+			switch col {
+			case colKey:
+				row = append(row, []byte(key))
+			case colValue:
+				row = append(row, []byte(value))
+			}
+		}
+		out.rows = append(out.rows, row)
+	}
+	return out, nil
+}
+
+// matchingKeys returns the keys selected by the statement's WHERE clause.
+func (s *stmt) matchingKeys(ctx context.Context, kv txkv.KV, args []driver.NamedValue) ([]txkv.Key, error) {
+	switch s.st.where {
+	case whereEq:
+		key, err := resolve(s.st.whereValue, args)
+		if err != nil {
+			return nil, err
+		}
+		return existingKey(ctx, kv, txkv.Key(key))
+
+	case whereLike:
+		pattern, err := resolve(s.st.whereValue, args)
+		if err != nil {
+			return nil, err
+		}
+		prefix, exact, err := likePrefix(string(pattern), s.st.likeEscape)
+		if err != nil {
+			return nil, err
+		}
+		if exact {
+			return existingKey(ctx, kv, txkv.Key(prefix))
+		}
+		return kv.List(ctx, txkv.Key(prefix))
+	}
+	return kv.List(ctx, txkv.Key(""))
+}
+
+// existingKey returns `key` if it's in `kv`.
+func existingKey(ctx context.Context, kv txkv.KV, key txkv.Key) ([]txkv.Key, error) {
+	_, ok, err := kv.Get(ctx, key)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return []txkv.Key{key}, nil
+}
+
+// resolve returns the bytes an operand stands for.
+func resolve(op operand, args []driver.NamedValue) ([]byte, error) {
+	if op.placeholder == 0 {
+		return []byte(op.lit), nil
+	}
+	for _, arg := range args {
+		if arg.Ordinal != op.placeholder {
+			continue
+		}
+		switch v := arg.Value.(type) {
+		case []byte:
+			return v, nil
+		case string:
+			return []byte(v), nil
+		}
+		return nil, fmt.Errorf("txkvsql: argument %d must be a string or []byte, got %T", op.placeholder, arg.Value)
+	}
+	return nil, fmt.Errorf("txkvsql: missing argument %d", op.placeholder)
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return named
+}
+
+type rows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *rows) Columns() []string { return r.columns }
+func (r *rows) Close() error      { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
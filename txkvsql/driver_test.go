@@ -0,0 +1,156 @@
+package txkvsql_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aybabtme/txkv"
+	"github.com/aybabtme/txkv/txkvsql"
+)
+
+func TestDriver(t *testing.T) {
+	ctx := context.Background()
+	kv := txkv.InMem()
+	txkvsql.Register(t.Name(), kv)
+	defer txkvsql.Unregister(t.Name())
+
+	db, err := sql.Open(txkvsql.DriverName, t.Name())
+	require.NoError(t, err)
+	defer db.Close()
+
+	for _, k := range []string{"a/1", "a/2", "b/1"} {
+		res, err := db.ExecContext(ctx, "INSERT INTO kv (key, value) VALUES (?, ?)", k, "v-"+k)
+		require.NoError(t, err)
+		n, err := res.RowsAffected()
+		require.NoError(t, err)
+		require.EqualValues(t, 1, n)
+	}
+
+	// it went in the store
+	got, ok, err := kv.Get(ctx, txkv.Key("a/2"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, txkv.Value("v-a/2"), got)
+
+	require.Equal(t, [][2]string{
+		{"a/1", "v-a/1"},
+		{"a/2", "v-a/2"},
+	}, query(t, db, "SELECT key, value FROM kv WHERE key LIKE 'a/%'"))
+
+	require.Equal(t, [][2]string{
+		{"v-b/1", "b/1"},
+	}, query(t, db, "select value, key from kv where key = ?", "b/1"))
+
+	require.Len(t, query(t, db, "SELECT * FROM kv"), 3)
+	require.Empty(t, query(t, db, "SELECT * FROM kv WHERE key = 'nope'"))
+	// without a trailing %, LIKE matches a single key
+	require.Equal(t, [][2]string{{"b/1", "v-b/1"}}, query(t, db, "SELECT key, value FROM kv WHERE key LIKE 'b/1'"))
+	require.Empty(t, query(t, db, "SELECT * FROM kv WHERE key LIKE 'b/'"))
+
+	res, err := db.ExecContext(ctx, "DELETE FROM kv WHERE key LIKE ?", "a/%")
+	require.NoError(t, err)
+	n, err := res.RowsAffected()
+	require.NoError(t, err)
+	require.EqualValues(t, 2, n)
+
+	keys, err := kv.List(ctx, txkv.Key(""))
+	require.NoError(t, err)
+	require.Equal(t, []txkv.Key{txkv.Key("b/1")}, keys)
+}
+
+func TestDriverTx(t *testing.T) {
+	ctx := context.Background()
+	kv := txkv.InMem()
+	db := sql.OpenDB(txkvsql.NewConnector(kv))
+	defer db.Close()
+
+	tx, err := db.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	_, err = tx.ExecContext(ctx, "INSERT INTO kv VALUES ('hello', 'world')")
+	require.NoError(t, err)
+
+	// visible in the tx, but not outside of it
+	var value string
+	err = tx.QueryRowContext(ctx, "SELECT value FROM kv WHERE key = 'hello'").Scan(&value)
+	require.NoError(t, err)
+	require.Equal(t, "world", value)
+	_, ok, err := kv.Get(ctx, txkv.Key("hello"))
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.NoError(t, tx.Commit())
+
+	_, ok, err = kv.Get(ctx, txkv.Key("hello"))
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+func TestDriverLikeEscape(t *testing.T) {
+	ctx := context.Background()
+	kv := txkv.InMem()
+	for _, k := range []string{"user_1/a", "userX1/b", "100%/c", `a\b`} {
+		require.NoError(t, kv.Put(ctx, txkv.Key(k), txkv.Value("v")))
+	}
+	db := sql.OpenDB(txkvsql.NewConnector(kv))
+	defer db.Close()
+
+	keys := func(q string, args ...interface{}) []string {
+		var out []string
+		for _, row := range query(t, db, q, args...) {
+			out = append(out, row[0])
+		}
+		return out
+	}
+	require.Equal(t, []string{"user_1/a"}, keys(`SELECT key, value FROM kv WHERE key LIKE ? ESCAPE '\'`, txkvsql.EscapeLike("user_1/")+"%"))
+	require.Equal(t, []string{"100%/c"}, keys(`SELECT key, value FROM kv WHERE key LIKE '100\%/%' ESCAPE '\'`))
+	require.Equal(t, []string{`a\b`}, keys(`SELECT key, value FROM kv WHERE key LIKE ? ESCAPE '\'`, txkvsql.EscapeLike(`a\b`)))
+	require.Equal(t, []string{"user_1/a"}, keys(`SELECT key, value FROM kv WHERE key LIKE 'user!_1/%' ESCAPE '!'`))
+
+	for _, q := range []string{
+		`SELECT key FROM kv WHERE key LIKE 'user_1/%' ESCAPE '\'`,
+		`SELECT key FROM kv WHERE key LIKE 'a\b' ESCAPE '\'`,
+		`SELECT key FROM kv WHERE key LIKE 'a\' ESCAPE '\'`,
+		`SELECT key FROM kv WHERE key LIKE 'a%' ESCAPE 'ab'`,
+	} {
+		_, err := db.Query(q)
+		require.Error(t, err, q)
+	}
+	_, err := db.Query(`SELECT key FROM kv WHERE key LIKE 'a%' ESCAPE ?`, `\`)
+	require.Error(t, err)
+}
+
+func TestDriverUnsupported(t *testing.T) {
+	db := sql.OpenDB(txkvsql.NewConnector(txkv.InMem()))
+	defer db.Close()
+
+	for _, q := range []string{
+		"UPDATE kv SET value = 'a'",
+		"SELECT key FROM other",
+		"SELECT key FROM kv WHERE value = 'a'",
+		"SELECT key FROM kv WHERE key LIKE '%a'",
+		"SELECT key FROM kv WHERE key LIKE 'a_b%'",
+		"SELECT key FROM kv WHERE key LIKE 'a%b%'",
+		"INSERT INTO kv (key) VALUES ('a')",
+	} {
+		_, err := db.Query(q)
+		require.Error(t, err, q)
+	}
+}
+
+func query(t *testing.T, db *sql.DB, q string, args ...interface{}) [][2]string {
+	t.Helper()
+	rows, err := db.Query(q, args...)
+	require.NoError(t, err)
+	defer rows.Close()
+	var out [][2]string
+	for rows.Next() {
+		var row [2]string
+		require.NoError(t, rows.Scan(&row[0], &row[1]))
+		out = append(out, row)
+	}
+	require.NoError(t, rows.Err())
+	return out
+}
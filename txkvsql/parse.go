@@ -0,0 +1,402 @@
+package txkvsql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// the only table there is
+const tableName = "kv"
+
+const (
+	colKey   = "key"
+	colValue = "value"
+)
+
+type stmtKind int
+
+const (
+	stmtSelect stmtKind = iota
+	stmtInsert
+	stmtDelete
+)
+
+// operand is either a literal or a positional placeholder.
+type operand struct {
+	lit         string
+	placeholder int // 0 when it's a literal, otherwise the 1-based ordinal
+}
+
+type whereOp int
+
+const (
+	whereNone whereOp = iota
+	whereEq
+	whereLike
+)
+
+type statement struct {
+	kind stmtKind
+
+	// select
+	columns []string
+
+	// insert
+	key, value operand
+
+	// select, delete
+	where      whereOp
+	whereValue operand
+	// likeEscape is the escape character of the LIKE pattern, if any
+	likeEscape string
+
+	numInput int
+}
+
+// parse understands a very small subset of SQL:
+//
+//	SELECT <cols> FROM kv [WHERE key = <op> | WHERE key LIKE <op> [ESCAPE '<c>']]
+//	INSERT INTO kv [(key, value)] VALUES (<op>, <op>)
+//	DELETE FROM kv [WHERE key = <op> | WHERE key LIKE <op> [ESCAPE '<c>']]
+//
+// where <op> is a '-quoted string or a `?` placeholder. LIKE patterns only
+// support a trailing `%`, which maps to a prefix listing; without it, they
+// match a single key. With an ESCAPE character, `%`, `_` and itself are
+// matched literally when they follow it: see EscapeLike.
+func parse(query string) (*statement, error) {
+	toks, err := tokenize(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	var st *statement
+	switch {
+	case p.keyword("SELECT"):
+		st, err = p.parseSelect()
+	case p.keyword("INSERT"):
+		st, err = p.parseInsert()
+	case p.keyword("DELETE"):
+		st, err = p.parseDelete()
+	default:
+		return nil, p.errorf("expected SELECT, INSERT or DELETE")
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.punct(";")
+	if !p.done() {
+		return nil, p.errorf("unexpected trailing input")
+	}
+	st.numInput = p.placeholders
+	return st, nil
+}
+
+type tokKind int
+
+const (
+	tokIdent tokKind = iota
+	tokString
+	tokPlaceholder
+	tokPunct
+)
+
+type token struct {
+	kind tokKind
+	text string
+	pos  int
+}
+
+func tokenize(query string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '?':
+			toks = append(toks, token{kind: tokPlaceholder, text: "?", pos: i})
+			i++
+		case strings.IndexByte("(),*=;", c) >= 0:
+			toks = append(toks, token{kind: tokPunct, text: string(c), pos: i})
+			i++
+		case c == '\'':
+			start := i
+			var sb strings.Builder
+			i++
+			for {
+				if i >= len(query) {
+					return nil, fmt.Errorf("txkvsql: unterminated string at offset %d", start)
+				}
+				if query[i] == '\'' {
+					// '' is an escaped quote
+					if i+1 < len(query) && query[i+1] == '\'' {
+						sb.WriteByte('\'')
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				sb.WriteByte(query[i])
+				i++
+			}
+			toks = append(toks, token{kind: tokString, text: sb.String(), pos: start})
+		case isIdentByte(c):
+			start := i
+			for i < len(query) && isIdentByte(query[i]) {
+				i++
+			}
+			toks = append(toks, token{kind: tokIdent, text: query[start:i], pos: start})
+		default:
+			return nil, fmt.Errorf("txkvsql: unexpected character %q at offset %d", c, i)
+		}
+	}
+	return toks, nil
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' ||
+		('a' <= c && c <= 'z') ||
+		('A' <= c && c <= 'Z') ||
+		('0' <= c && c <= '9')
+}
+
+type parser struct {
+	toks         []token
+	i            int
+	placeholders int
+}
+
+func (p *parser) done() bool { return p.i >= len(p.toks) }
+
+func (p *parser) peek() (token, bool) {
+	if p.done() {
+		return token{}, false
+	}
+	return p.toks[p.i], true
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	if tok, ok := p.peek(); ok {
+		return fmt.Errorf("txkvsql: at offset %d: %s", tok.pos, fmt.Sprintf(format, args...))
+	}
+	return fmt.Errorf("txkvsql: at end of input: %s", fmt.Sprintf(format, args...))
+}
+
+// keyword consumes the next token if it's the identifier `kw`, ignoring case.
+func (p *parser) keyword(kw string) bool {
+	tok, ok := p.peek()
+	if !ok || tok.kind != tokIdent || !strings.EqualFold(tok.text, kw) {
+		return false
+	}
+	p.i++
+	return true
+}
+
+// punct consumes the next token if it's the punctuation `s`.
+func (p *parser) punct(s string) bool {
+	tok, ok := p.peek()
+	if !ok || tok.kind != tokPunct || tok.text != s {
+		return false
+	}
+	p.i++
+	return true
+}
+
+func (p *parser) expectKeyword(kw string) error {
+	if !p.keyword(kw) {
+		return p.errorf("expected %s", kw)
+	}
+	return nil
+}
+
+func (p *parser) expectPunct(s string) error {
+	if !p.punct(s) {
+		return p.errorf("expected %q", s)
+	}
+	return nil
+}
+
+func (p *parser) column() (string, error) {
+	for _, col := range []string{colKey, colValue} {
+		if p.keyword(col) {
+			return col, nil
+		}
+	}
+	return "", p.errorf("expected column %q or %q", colKey, colValue)
+}
+
+func (p *parser) table() error {
+	if !p.keyword(tableName) {
+		return p.errorf("expected table %q", tableName)
+	}
+	return nil
+}
+
+func (p *parser) operand() (operand, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return operand{}, p.errorf("expected a string or a placeholder")
+	}
+	switch tok.kind {
+	case tokString:
+		p.i++
+		return operand{lit: tok.text}, nil
+	case tokPlaceholder:
+		p.i++
+		p.placeholders++
+		return operand{placeholder: p.placeholders}, nil
+	}
+	return operand{}, p.errorf("expected a string or a placeholder")
+}
+
+func (p *parser) parseSelect() (*statement, error) {
+	st := &statement{kind: stmtSelect}
+	if p.punct("*") {
+		st.columns = []string{colKey, colValue}
+	} else {
+		for {
+			col, err := p.column()
+			if err != nil {
+				return nil, err
+			}
+			st.columns = append(st.columns, col)
+			if !p.punct(",") {
+				break
+			}
+		}
+	}
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	if err := p.table(); err != nil {
+		return nil, err
+	}
+	return st, p.parseWhere(st)
+}
+
+func (p *parser) parseInsert() (*statement, error) {
+	st := &statement{kind: stmtInsert}
+	if err := p.expectKeyword("INTO"); err != nil {
+		return nil, err
+	}
+	if err := p.table(); err != nil {
+		return nil, err
+	}
+	cols := []string{colKey, colValue}
+	if p.punct("(") {
+		cols = cols[:0]
+		for {
+			col, err := p.column()
+			if err != nil {
+				return nil, err
+			}
+			cols = append(cols, col)
+			if !p.punct(",") {
+				break
+			}
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		if len(cols) != 2 || cols[0] == cols[1] {
+			return nil, p.errorf("INSERT must name both %q and %q", colKey, colValue)
+		}
+	}
+	if err := p.expectKeyword("VALUES"); err != nil {
+		return nil, err
+	}
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+	first, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectPunct(","); err != nil {
+		return nil, err
+	}
+	second, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectPunct(")"); err != nil {
+		return nil, err
+	}
+	if cols[0] == colKey {
+		st.key, st.value = first, second
+	} else {
+		st.key, st.value = second, first
+	}
+	return st, nil
+}
+
+func (p *parser) parseDelete() (*statement, error) {
+	st := &statement{kind: stmtDelete}
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	if err := p.table(); err != nil {
+		return nil, err
+	}
+	return st, p.parseWhere(st)
+}
+
+func (p *parser) parseWhere(st *statement) error {
+	if !p.keyword("WHERE") {
+		return nil
+	}
+	if !p.keyword(colKey) {
+		return p.errorf("only %q can be used in a WHERE clause", colKey)
+	}
+	switch {
+	case p.punct("="):
+		st.where = whereEq
+	case p.keyword("LIKE"):
+		st.where = whereLike
+	default:
+		return p.errorf("expected = or LIKE")
+	}
+	op, err := p.operand()
+	if err != nil {
+		return err
+	}
+	st.whereValue = op
+	if st.where == whereLike && p.keyword("ESCAPE") {
+		esc, err := p.operand()
+		if err != nil {
+			return err
+		}
+		if esc.placeholder != 0 || len(esc.lit) != 1 {
+			return p.errorf("ESCAPE takes a single character string")
+		}
+		st.likeEscape = esc.lit
+	}
+	return nil
+}
+
+// likePrefix turns a LIKE pattern into the prefix it stands for, or into the
+// key it matches exactly when it doesn't end with `%`. Other wildcards, `_`
+// and `%` anywhere else, are refused rather than matched literally, unless
+// they follow `escape`.
+func likePrefix(pattern, escape string) (prefix string, exact bool, err error) {
+	var sb strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case escape != "" && c == escape[0]:
+			if i+1 == len(pattern) || strings.IndexByte("%_"+escape, pattern[i+1]) < 0 {
+				return "", false, fmt.Errorf("txkvsql: invalid escape in LIKE pattern %q", pattern)
+			}
+			i++
+			sb.WriteByte(pattern[i])
+		case c == '%' && i == len(pattern)-1:
+			return sb.String(), false, nil
+		case c == '%' || c == '_':
+			return "", false, fmt.Errorf("txkvsql: unsupported LIKE pattern %q, only a trailing %% is supported", pattern)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String(), true, nil
+}
@@ -0,0 +1,45 @@
+// Package txkvtest helps testing code that uses txkv stores.
+package txkvtest
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aybabtme/txkv"
+)
+
+// update is namespaced so that it doesn't collide with the -update flag many
+// test packages define themselves.
+var update = flag.Bool("txkvtest.update", false, "rewrite the golden files of txkvtest.Golden")
+
+// Golden compares the keys of `kv` under `prefix` and their values with the
+// golden file at `path`, failing `t` if they differ. They're written as JSON
+// lines, in key order, as by txkv.Export. Running the tests with
+// -txkvtest.update writes the golden files instead.
+func Golden(t testing.TB, kv txkv.KV, prefix txkv.Key, path string) {
+	t.Helper()
+	var got bytes.Buffer
+	if _, err := txkv.Export(context.Background(), kv, prefix, &got, txkv.FormatJSONL); err != nil {
+		t.Fatalf("txkvtest: can't export %q: %v", prefix, err)
+	}
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("txkvtest: %v", err)
+		}
+		if err := os.WriteFile(path, got.Bytes(), 0o644); err != nil {
+			t.Fatalf("txkvtest: %v", err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("txkvtest: %v (run with -txkvtest.update to create it)", err)
+	}
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Errorf("txkvtest: keys under %q don't match %s (run with -txkvtest.update to update it)\n got:\n%s\nwant:\n%s", prefix, path, got.Bytes(), want)
+	}
+}
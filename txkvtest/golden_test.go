@@ -0,0 +1,37 @@
+package txkvtest_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aybabtme/txkv"
+	"github.com/aybabtme/txkv/txkvtest"
+)
+
+func TestGolden(t *testing.T) {
+	ctx := context.Background()
+	kv := txkv.InMem()
+	require.NoError(t, kv.Put(ctx, txkv.Key("user/bob"), txkv.Value("rome")))
+	require.NoError(t, kv.Put(ctx, txkv.Key("user/ann"), txkv.Value("paris")))
+	require.NoError(t, kv.Put(ctx, txkv.Key("user/raw"), txkv.Value{0xff}))
+	require.NoError(t, kv.Put(ctx, txkv.Key("other"), txkv.Value("ignored")))
+
+	txkvtest.Golden(t, kv, txkv.Key("user/"), filepath.Join("testdata", "users.golden"))
+
+	require.NoError(t, kv.Put(ctx, txkv.Key("user/ann"), txkv.Value("oslo")))
+	ft := &fakeT{TB: t}
+	txkvtest.Golden(ft, kv, txkv.Key("user/"), filepath.Join("testdata", "users.golden"))
+	require.True(t, ft.failed)
+}
+
+// fakeT records failures instead of failing the test.
+type fakeT struct {
+	testing.TB
+	failed bool
+}
+
+func (t *fakeT) Errorf(format string, args ...interface{}) { t.failed = true }
+func (t *fakeT) Fatalf(format string, args ...interface{}) { t.failed = true }
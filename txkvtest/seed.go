@@ -0,0 +1,89 @@
+package txkvtest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/aybabtme/txkv"
+)
+
+// Fixture is data to seed a store with, as the writes to make.
+type Fixture func(t testing.TB) []txkv.Op
+
+// Seed writes `fixtures` to `kv`, in order and atomically, failing `t` if it
+// can't.
+func Seed(t testing.TB, kv txkv.TransactionalKV, fixtures ...Fixture) {
+	t.Helper()
+	var ops []txkv.Op
+	for _, f := range fixtures {
+		ops = append(ops, f(t)...)
+	}
+	if err := txkv.Apply(context.Background(), kv, ops); err != nil {
+		t.Fatalf("txkvtest: can't seed the store: %v", err)
+	}
+}
+
+// Entries is a fixture of the keys of `entries`, with their values.
+func Entries(entries map[string]string) Fixture {
+	return func(t testing.TB) []txkv.Op {
+		keys := make([]string, 0, len(entries))
+		for key := range entries {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		ops := make([]txkv.Op, 0, len(keys))
+		for _, key := range keys {
+			ops = append(ops, txkv.PutOp(txkv.Key(key), txkv.Value(entries[key])))
+		}
+		return ops
+	}
+}
+
+// File is a fixture of the entries of the file at `path`, as written by
+// txkv.Export, or by Golden: golden files of a test can seed another.
+func File(path string, format txkv.Format) Fixture {
+	return func(t testing.TB) []txkv.Op {
+		t.Helper()
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("txkvtest: %v", err)
+		}
+		defer f.Close()
+		ctx := context.Background()
+		scratch := txkv.InMem()
+		if _, err := txkv.Import(ctx, scratch, f, format, txkv.BulkLoadOptions{}); err != nil {
+			t.Fatalf("txkvtest: can't read %s: %v", path, err)
+		}
+		keys, err := scratch.List(ctx, nil)
+		if err != nil {
+			t.Fatalf("txkvtest: %v", err)
+		}
+		ops := make([]txkv.Op, 0, len(keys))
+		for _, key := range keys {
+			v, _, err := scratch.Get(ctx, key)
+			if err != nil {
+				t.Fatalf("txkvtest: %v", err)
+			}
+			ops = append(ops, txkv.PutOp(key, v))
+		}
+		return ops
+	}
+}
+
+// Generate is a fixture of `n` keys under `prefix`, numbered from 0 and
+// padded with zeros so that they sort in order, with the values `value`
+// returns for each number.
+func Generate(prefix txkv.Key, n int, value func(i int) txkv.Value) Fixture {
+	return func(t testing.TB) []txkv.Op {
+		width := len(fmt.Sprint(n - 1))
+		ops := make([]txkv.Op, 0, n)
+		for i := 0; i < n; i++ {
+			key := append(append(txkv.Key(nil), prefix...), fmt.Sprintf("%0*d", width, i)...)
+			ops = append(ops, txkv.PutOp(key, value(i)))
+		}
+		return ops
+	}
+}
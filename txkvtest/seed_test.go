@@ -0,0 +1,47 @@
+package txkvtest_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aybabtme/txkv"
+	"github.com/aybabtme/txkv/txkvtest"
+)
+
+func TestSeed(t *testing.T) {
+	ctx := context.Background()
+	kv := txkv.InMem()
+	txkvtest.Seed(t, kv,
+		txkvtest.File(filepath.Join("testdata", "users.golden"), txkv.FormatJSONL),
+		txkvtest.Entries(map[string]string{"user/bob": "oslo", "config": "on"}),
+		txkvtest.Generate(txkv.Key("item/"), 12, func(i int) txkv.Value {
+			return txkv.Value(fmt.Sprint(i * i))
+		}),
+	)
+
+	v, ok, err := kv.Get(ctx, txkv.Key("user/ann"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, txkv.Value("paris"), v)
+	// later fixtures win
+	v, _, err = kv.Get(ctx, txkv.Key("user/bob"))
+	require.NoError(t, err)
+	require.Equal(t, txkv.Value("oslo"), v)
+
+	keys, err := kv.List(ctx, txkv.Key("item/"))
+	require.NoError(t, err)
+	require.Len(t, keys, 12)
+	require.Equal(t, txkv.Key("item/00"), keys[0])
+	require.Equal(t, txkv.Key("item/11"), keys[11])
+	v, _, err = kv.Get(ctx, txkv.Key("item/11"))
+	require.NoError(t, err)
+	require.Equal(t, txkv.Value("121"), v)
+
+	ft := &fakeT{TB: t}
+	txkvtest.Seed(ft, kv, txkvtest.File(filepath.Join("testdata", "missing"), txkv.FormatJSONL))
+	require.True(t, ft.failed)
+}
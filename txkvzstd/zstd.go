@@ -0,0 +1,584 @@
+// Package txkvzstd compresses the values of a txkv store with zstd, using
+// dictionaries trained per prefix: values under the same prefix tend to look
+// alike, so a dictionary of what they share compresses even small values.
+//
+// Dictionaries are stored in the store itself, and are never changed once
+// installed. Installing a newer dictionary for a prefix, by hand or by
+// training one, rolls it: values written from then on use it, and values
+// written before keep being read with the one they were written with, until
+// they're recompressed.
+//
+// Stored values start with their encoding:
+//
+//	0                   the value, uncompressed
+//	1 | frame           a zstd frame, without dictionary
+//	2 | uvarint id | frame  a zstd frame, with dictionary `id`
+package txkvzstd
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/aybabtme/txkv"
+)
+
+const (
+	encRaw  = 0
+	encZstd = 1
+	encDict = 2
+)
+
+// Options tune a KV.
+type Options struct {
+	// DictPrefix is where dictionaries are stored. It's hidden from List.
+	// Defaults to "\x00zstd/dicts/".
+	DictPrefix txkv.Key
+	// MinSize is the size under which values aren't compressed. Defaults to
+	// 32 bytes.
+	MinSize int
+	// Level is the compression level. Defaults to zstd.SpeedDefault.
+	Level zstd.EncoderLevel
+}
+
+// ErrUnknownDict is matched by the errors returned when reading a value
+// compressed with a dictionary that isn't in the store.
+var ErrUnknownDict = errors.New("txkvzstd: unknown dictionary")
+
+// KV is a txkv.TransactionalKV compressing the values of another.
+type KV struct {
+	kv   txkv.TransactionalKV
+	opts Options
+
+	plain *codec // without dictionary
+
+	mu    sync.RWMutex
+	dicts map[uint64]*dict
+}
+
+// Dict describes a dictionary.
+type Dict struct {
+	ID     uint64
+	Prefix txkv.Key
+	Size   int
+	// Active tells whether values under Prefix are written with it.
+	Active bool
+}
+
+type dict struct {
+	id uint64
+	// gen orders dictionaries by installation: ids are random, so that
+	// concurrent installations can't pick the same
+	gen     uint64
+	prefix  txkv.Key
+	content []byte
+	codec   *codec
+}
+
+type codec struct {
+	enc *zstd.Encoder
+	dec *zstd.Decoder
+}
+
+func newCodec(level zstd.EncoderLevel, id uint64, content []byte) (*codec, error) {
+	eopts := []zstd.EOption{zstd.WithEncoderLevel(level), zstd.WithEncoderConcurrency(1)}
+	dopts := []zstd.DOption{zstd.WithDecoderConcurrency(1)}
+	if content != nil {
+		eopts = append(eopts, zstd.WithEncoderDictRaw(uint32(id), content))
+		dopts = append(dopts, zstd.WithDecoderDictRaw(uint32(id), content))
+	}
+	enc, err := zstd.NewWriter(nil, eopts...)
+	if err != nil {
+		return nil, err
+	}
+	dec, err := zstd.NewReader(nil, dopts...)
+	if err != nil {
+		return nil, err
+	}
+	return &codec{enc: enc, dec: dec}, nil
+}
+
+// New returns a KV compressing the values of `kv`, with the dictionaries
+// stored in it.
+func New(ctx context.Context, kv txkv.TransactionalKV, opts Options) (*KV, error) {
+	if opts.DictPrefix == nil {
+		opts.DictPrefix = txkv.Key("\x00zstd/dicts/")
+	}
+	if opts.MinSize <= 0 {
+		opts.MinSize = 32
+	}
+	if opts.Level == 0 {
+		opts.Level = zstd.SpeedDefault
+	}
+	plain, err := newCodec(opts.Level, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	z := &KV{kv: kv, opts: opts, plain: plain, dicts: make(map[uint64]*dict)}
+	if err := z.Reload(ctx); err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+// Reload loads the dictionaries installed by other users of the store since
+// the KV was made, so that it writes with them too. Values written with
+// dictionaries it doesn't have are read regardless.
+func (z *KV) Reload(ctx context.Context) error {
+	keys, err := z.kv.List(ctx, z.opts.DictPrefix)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		id, ok := z.dictID(key)
+		if !ok {
+			continue
+		}
+		if _, err := z.dict(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (z *KV) dictKey(id uint64) txkv.Key {
+	return binary.BigEndian.AppendUint64(append(txkv.Key(nil), z.opts.DictPrefix...), id)
+}
+
+func (z *KV) dictID(key txkv.Key) (uint64, bool) {
+	rest := key[len(z.opts.DictPrefix):]
+	if len(rest) != 8 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(rest), true
+}
+
+// dict returns dictionary `id`, loading it from the store if needed.
+func (z *KV) dict(ctx context.Context, id uint64) (*dict, error) {
+	z.mu.RLock()
+	d, ok := z.dicts[id]
+	z.mu.RUnlock()
+	if ok {
+		return d, nil
+	}
+	v, ok, err := z.kv.Get(ctx, z.dictKey(id))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownDict, id)
+	}
+	d, err = parseDict(id, v)
+	if err != nil {
+		return nil, err
+	}
+	if d.codec, err = newCodec(z.opts.Level, id, d.content); err != nil {
+		return nil, err
+	}
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if loaded, ok := z.dicts[id]; ok {
+		return loaded, nil
+	}
+	z.dicts[id] = d
+	return d, nil
+}
+
+// dictionaries are stored as `uvarint gen | uvarint prefix length | prefix |
+// content`.
+func encodeDict(gen uint64, prefix txkv.Key, content []byte) txkv.Value {
+	v := binary.AppendUvarint(nil, gen)
+	v = binary.AppendUvarint(v, uint64(len(prefix)))
+	v = append(v, prefix...)
+	return append(v, content...)
+}
+
+func parseDict(id uint64, v txkv.Value) (*dict, error) {
+	gen, n := binary.Uvarint(v)
+	if n <= 0 {
+		return nil, fmt.Errorf("txkvzstd: malformed dictionary %d", id)
+	}
+	v = v[n:]
+	plen, n := binary.Uvarint(v)
+	if n <= 0 || uint64(len(v)-n) < plen {
+		return nil, fmt.Errorf("txkvzstd: malformed dictionary %d", id)
+	}
+	return &dict{id: id, gen: gen, prefix: txkv.Key(v[n : n+int(plen)]), content: v[n+int(plen):]}, nil
+}
+
+// newer tells whether `d` was installed after `other`. Dictionaries
+// installed concurrently can have the same generation: their ids break the
+// tie, the same way for all users of the store.
+func (d *dict) newer(other *dict) bool {
+	if d.gen != other.gen {
+		return d.gen > other.gen
+	}
+	return d.id > other.id
+}
+
+// active returns the dictionary to write `key` with: the newest of those
+// with the longest prefix of `key`, if any.
+func (z *KV) active(key txkv.Key) *dict {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	var best *dict
+	for _, d := range z.dicts {
+		if !bytes.HasPrefix(key, d.prefix) {
+			continue
+		}
+		if best == nil || len(d.prefix) > len(best.prefix) || (len(d.prefix) == len(best.prefix) && d.newer(best)) {
+			best = d
+		}
+	}
+	return best
+}
+
+// Install installs `content` as the dictionary of the values under
+// `prefix`, in place of the current one if any, and returns its ID. Raw
+// dictionaries are made of content typical of the values: Train picks it.
+//
+// IDs are random, and installing never overwrites a dictionary, so that
+// concurrent installations can't replace each other's: values compressed
+// with either stay readable.
+func (z *KV) Install(ctx context.Context, prefix txkv.Key, content []byte) (uint64, error) {
+	if len(content) < 8 {
+		return 0, errors.New("txkvzstd: dictionary too small")
+	}
+	var id uint64
+	err := txkv.RetryTx(ctx, z.kv, txkv.RetryPolicy{}, func(ctx context.Context, tx txkv.TxKV) error {
+		keys, err := tx.List(ctx, z.opts.DictPrefix)
+		if err != nil {
+			return err
+		}
+		var gen uint64
+		for _, key := range keys {
+			if _, ok := z.dictID(key); !ok {
+				continue
+			}
+			v, ok, err := tx.Get(ctx, key)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			if d, err := parseDict(0, v); err == nil && d.gen > gen {
+				gen = d.gen
+			}
+		}
+		for {
+			if id, err = newDictID(); err != nil {
+				return err
+			}
+			_, taken, err := tx.Get(ctx, z.dictKey(id))
+			if err != nil {
+				return err
+			}
+			if !taken {
+				break
+			}
+		}
+		return tx.Put(ctx, z.dictKey(id), encodeDict(gen+1, prefix, content))
+	})
+	if err != nil {
+		return 0, err
+	}
+	if _, err := z.dict(ctx, id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// newDictID returns a random dictionary ID. zstd frames only keep the lower
+// 32 bits of the ID of their dictionary, where 0 means none: they're never
+// 0.
+func newDictID() (uint64, error) {
+	var b [8]byte
+	for {
+		if _, err := rand.Read(b[:]); err != nil {
+			return 0, err
+		}
+		if id := binary.BigEndian.Uint64(b[:]); uint32(id) != 0 {
+			return id, nil
+		}
+	}
+}
+
+// TrainOptions tune Train.
+type TrainOptions struct {
+	// Samples is the number of values sampled. Defaults to 200.
+	Samples int
+	// MaxSize bounds the size of the dictionary. Defaults to 64KiB.
+	MaxSize int
+}
+
+// Train builds a dictionary out of a sample of the values under `prefix`,
+// installs it, and returns its ID. It fails if there are no values to learn
+// from.
+func (z *KV) Train(ctx context.Context, prefix txkv.Key, opts TrainOptions) (uint64, error) {
+	if opts.Samples <= 0 {
+		opts.Samples = 200
+	}
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = 64 << 10
+	}
+	keys, err := txkv.Sample(ctx, z, prefix, opts.Samples)
+	if err != nil {
+		return 0, err
+	}
+	// a raw dictionary is content to match values against: the sampled
+	// values themselves, up to the size limit
+	var content []byte
+	for _, key := range keys {
+		v, ok, err := z.Get(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			continue
+		}
+		if len(content)+len(v) > opts.MaxSize {
+			v = v[:opts.MaxSize-len(content)]
+		}
+		content = append(content, v...)
+		if len(content) == opts.MaxSize {
+			break
+		}
+	}
+	if len(content) < 8 {
+		return 0, fmt.Errorf("txkvzstd: not enough values under %q to train a dictionary", prefix)
+	}
+	return z.Install(ctx, prefix, content)
+}
+
+// Dictionaries returns the dictionaries loaded, in the order they were
+// installed.
+func (z *KV) Dictionaries() []Dict {
+	z.mu.RLock()
+	dicts := make([]*dict, 0, len(z.dicts))
+	for _, d := range z.dicts {
+		dicts = append(dicts, d)
+	}
+	z.mu.RUnlock()
+	sort.Slice(dicts, func(i, j int) bool { return dicts[j].newer(dicts[i]) })
+	out := make([]Dict, 0, len(dicts))
+	for _, d := range dicts {
+		active := z.active(d.prefix)
+		out = append(out, Dict{ID: d.id, Prefix: d.prefix, Size: len(d.content), Active: active == d})
+	}
+	return out
+}
+
+// Recompress rewrites the values under `prefix` that weren't written with
+// the dictionary now active for them, in transactions of `batchSize` keys,
+// and returns how many it rewrote. It can run at leisure after rolling a
+// dictionary.
+func (z *KV) Recompress(ctx context.Context, prefix txkv.Key, batchSize int) (int, error) {
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	keys, err := z.List(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+	rewritten := 0
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > batchSize {
+			batch = batch[:batchSize]
+		}
+		keys = keys[len(batch):]
+		n := 0
+		err := txkv.RetryTx(ctx, z.kv, txkv.RetryPolicy{}, func(ctx context.Context, tx txkv.TxKV) error {
+			n = 0
+			for _, key := range batch {
+				stored, ok, err := tx.Get(ctx, key)
+				if err != nil {
+					return err
+				}
+				if !ok || z.current(key, stored) {
+					continue
+				}
+				v, err := z.decode(ctx, key, stored)
+				if err != nil {
+					return err
+				}
+				if err := tx.Put(ctx, key, z.encode(key, v)); err != nil {
+					return err
+				}
+				n++
+			}
+			return nil
+		})
+		if err != nil {
+			return rewritten, err
+		}
+		rewritten += n
+	}
+	return rewritten, nil
+}
+
+// current tells whether `stored` is encoded as it would be now: with the
+// dictionary active for `key`, or raw when it's too small to be compressed,
+// or compressing it doesn't pay.
+func (z *KV) current(key txkv.Key, stored txkv.Value) bool {
+	if len(stored) == 0 {
+		return false
+	}
+	d := z.active(key)
+	switch stored[0] {
+	case encRaw:
+		return z.encode(key, stored[1:])[0] == encRaw
+	case encZstd:
+		return d == nil
+	case encDict:
+		id, n := binary.Uvarint(stored[1:])
+		return n > 0 && d != nil && d.id == id
+	}
+	return false
+}
+
+// Close releases the resources of the codecs.
+func (z *KV) Close() error {
+	z.plain.close()
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	for _, d := range z.dicts {
+		d.codec.close()
+	}
+	return nil
+}
+
+func (c *codec) close() {
+	_ = c.enc.Close()
+	c.dec.Close()
+}
+
+func (z *KV) encode(key txkv.Key, value txkv.Value) txkv.Value {
+	if len(value) < z.opts.MinSize {
+		return append([]byte{encRaw}, value...)
+	}
+	var out []byte
+	if d := z.active(key); d != nil {
+		out = binary.AppendUvarint([]byte{encDict}, d.id)
+		out = d.codec.enc.EncodeAll(value, out)
+	} else {
+		out = z.plain.enc.EncodeAll(value, []byte{encZstd})
+	}
+	if len(out) > 1+len(value) {
+		return append([]byte{encRaw}, value...)
+	}
+	return out
+}
+
+func (z *KV) decode(ctx context.Context, key txkv.Key, stored txkv.Value) (txkv.Value, error) {
+	if len(stored) == 0 {
+		return nil, fmt.Errorf("txkvzstd: malformed value at %q", key)
+	}
+	switch stored[0] {
+	case encRaw:
+		return stored[1:], nil
+	case encZstd:
+		v, err := z.plain.dec.DecodeAll(stored[1:], nil)
+		if err != nil {
+			return nil, fmt.Errorf("txkvzstd: decompressing %q: %w", key, err)
+		}
+		return v, nil
+	case encDict:
+		id, n := binary.Uvarint(stored[1:])
+		if n <= 0 {
+			break
+		}
+		d, err := z.dict(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.codec.dec.DecodeAll(stored[1+n:], nil)
+		if err != nil {
+			return nil, fmt.Errorf("txkvzstd: decompressing %q: %w", key, err)
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("txkvzstd: malformed value at %q", key)
+}
+
+func (z *KV) Put(ctx context.Context, key txkv.Key, value txkv.Value) error {
+	return z.kv.Put(ctx, key, z.encode(key, value))
+}
+
+func (z *KV) Get(ctx context.Context, key txkv.Key) (txkv.Value, bool, error) {
+	return get(ctx, z, z.kv, key)
+}
+
+func (z *KV) Delete(ctx context.Context, key txkv.Key) error {
+	return z.kv.Delete(ctx, key)
+}
+
+func (z *KV) List(ctx context.Context, prefix txkv.Key) ([]txkv.Key, error) {
+	return list(ctx, z, z.kv, prefix)
+}
+
+func (z *KV) Begin(ctx context.Context) (txkv.TxKV, error) {
+	tx, err := z.kv.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &zstdTx{z: z, tx: tx}, nil
+}
+
+type zstdTx struct {
+	z  *KV
+	tx txkv.TxKV
+}
+
+func (t *zstdTx) Put(ctx context.Context, key txkv.Key, value txkv.Value) error {
+	return t.tx.Put(ctx, key, t.z.encode(key, value))
+}
+
+func (t *zstdTx) Get(ctx context.Context, key txkv.Key) (txkv.Value, bool, error) {
+	return get(ctx, t.z, t.tx, key)
+}
+
+func (t *zstdTx) Delete(ctx context.Context, key txkv.Key) error {
+	return t.tx.Delete(ctx, key)
+}
+
+func (t *zstdTx) List(ctx context.Context, prefix txkv.Key) ([]txkv.Key, error) {
+	return list(ctx, t.z, t.tx, prefix)
+}
+
+func (t *zstdTx) Commit(ctx context.Context) error   { return t.tx.Commit(ctx) }
+func (t *zstdTx) Rollback(ctx context.Context) error { return t.tx.Rollback(ctx) }
+
+func get(ctx context.Context, z *KV, kv txkv.KV, key txkv.Key) (txkv.Value, bool, error) {
+	stored, ok, err := kv.Get(ctx, key)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	v, err := z.decode(ctx, key, stored)
+	if err != nil {
+		return nil, false, err
+	}
+	return v, true, nil
+}
+
+func list(ctx context.Context, z *KV, kv txkv.KV, prefix txkv.Key) ([]txkv.Key, error) {
+	keys, err := kv.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	out := keys[:0]
+	for _, key := range keys {
+		if !bytes.HasPrefix(key, z.opts.DictPrefix) {
+			out = append(out, key)
+		}
+	}
+	return out, nil
+}
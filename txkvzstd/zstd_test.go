@@ -0,0 +1,174 @@
+package txkvzstd_test
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aybabtme/txkv"
+	"github.com/aybabtme/txkv/txkvzstd"
+)
+
+func newKV(t testing.TB, kv txkv.TransactionalKV) *txkvzstd.KV {
+	z, err := txkvzstd.New(context.Background(), kv, txkvzstd.Options{})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = z.Close() })
+	return z
+}
+
+func user(i int) txkv.Value {
+	return txkv.Value(fmt.Sprintf(`{"id":%d,"name":"user number %d","email":"user%d@example.com","roles":["reader"],"active":true}`, i, i, i))
+}
+
+// noise returns `n` bytes that don't compress.
+func noise(n int) txkv.Value {
+	v := make(txkv.Value, n)
+	_, _ = rand.New(rand.NewSource(1)).Read(v)
+	return v
+}
+
+func storedSize(t *testing.T, kv txkv.KV, prefix txkv.Key) int {
+	ctx := context.Background()
+	keys, err := kv.List(ctx, prefix)
+	require.NoError(t, err)
+	size := 0
+	for _, key := range keys {
+		v, _, err := kv.Get(ctx, key)
+		require.NoError(t, err)
+		size += len(v)
+	}
+	return size
+}
+
+func TestZstd(t *testing.T) {
+	ctx := context.Background()
+	raw := txkv.InMem()
+	z := newKV(t, raw)
+	big := txkv.Value(strings.Repeat("compressible ", 100))
+	require.NoError(t, z.Put(ctx, txkv.Key("big"), big))
+	require.NoError(t, z.Put(ctx, txkv.Key("small"), txkv.Value("tiny")))
+	require.NoError(t, z.Put(ctx, txkv.Key("empty"), txkv.Value{}))
+
+	tx, err := z.Begin(ctx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Put(ctx, txkv.Key("tx"), big))
+	v, ok, err := tx.Get(ctx, txkv.Key("tx"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, big, v)
+	require.NoError(t, tx.Commit(ctx))
+
+	for key, want := range map[string]txkv.Value{"big": big, "small": txkv.Value("tiny"), "empty": {}, "tx": big} {
+		v, ok, err := z.Get(ctx, txkv.Key(key))
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, string(want), string(v))
+	}
+	require.Less(t, storedSize(t, raw, txkv.Key("big")), len(big)/10)
+	require.NoError(t, z.Delete(ctx, txkv.Key("big")))
+	_, ok, err = z.Get(ctx, txkv.Key("big"))
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestDictionaries(t *testing.T) {
+	ctx := context.Background()
+	raw := txkv.InMem()
+	z := newKV(t, raw)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, z.Put(ctx, txkv.Key(fmt.Sprintf("users/%03d", i)), user(i)))
+	}
+	require.NoError(t, z.Put(ctx, txkv.Key("tiny"), txkv.Value("x")))
+	require.NoError(t, z.Put(ctx, txkv.Key("users/tiny"), txkv.Value("x")))
+	without := storedSize(t, raw, txkv.Key("users/"))
+
+	id, err := z.Train(ctx, txkv.Key("users/"), txkvzstd.TrainOptions{Samples: 20})
+	require.NoError(t, err)
+	// written after training, so that the dictionary can't have learnt it
+	require.NoError(t, z.Put(ctx, txkv.Key("users/noise"), noise(200)))
+	require.Equal(t, []txkvzstd.Dict{{ID: id, Prefix: txkv.Key("users/"), Size: z.Dictionaries()[0].Size, Active: true}}, z.Dictionaries())
+
+	// values are rewritten at leisure, and read meanwhile
+	v, ok, err := z.Get(ctx, txkv.Key("users/042"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, user(42), v)
+	n, err := z.Recompress(ctx, txkv.Key("users/"), 30)
+	require.NoError(t, err)
+	require.Equal(t, 100, n)
+	// values left raw, as they're too small or don't compress, are current
+	n, err = z.Recompress(ctx, txkv.Key("users/"), 30)
+	require.NoError(t, err)
+	require.Zero(t, n)
+	with := storedSize(t, raw, txkv.Key("users/")) - storedSize(t, raw, txkv.Key("users/noise"))
+	require.Less(t, with, without/2, "with dictionary: %d, without: %d", with, without)
+
+	// dictionaries are hidden, and found by other users of the store
+	keys, err := z.List(ctx, nil)
+	require.NoError(t, err)
+	require.Len(t, keys, 103)
+	other := newKV(t, raw)
+	v, ok, err = other.Get(ctx, txkv.Key("users/007"))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, user(7), v)
+
+	// rolling to a newer dictionary
+	next, err := other.Install(ctx, txkv.Key("users/"), []byte(`{"id":,"name":"user number ","email":"@example.com","roles":["reader"],"active":true}`))
+	require.NoError(t, err)
+	require.NoError(t, z.Reload(ctx))
+	dicts := z.Dictionaries()
+	require.Len(t, dicts, 2)
+	require.Equal(t, id, dicts[0].ID)
+	require.False(t, dicts[0].Active)
+	require.Equal(t, next, dicts[1].ID)
+	require.True(t, dicts[1].Active)
+	require.NoError(t, z.Put(ctx, txkv.Key("users/100"), user(100)))
+	n, err = z.Recompress(ctx, txkv.Key("users/"), 0)
+	require.NoError(t, err)
+	require.Equal(t, 100, n)
+	for _, i := range []int{0, 100} {
+		v, _, err := other.Get(ctx, txkv.Key(fmt.Sprintf("users/%03d", i)))
+		require.NoError(t, err)
+		require.Equal(t, user(i), v)
+	}
+
+	_, err = z.Train(ctx, txkv.Key("nothing/"), txkvzstd.TrainOptions{})
+	require.Error(t, err)
+}
+
+func TestInstallConcurrent(t *testing.T) {
+	ctx := context.Background()
+	raw := txkv.InMem()
+	a, b := newKV(t, raw), newKV(t, raw)
+	content := []byte(`{"id":,"name":"user number ","email":"@example.com","roles":["reader"],"active":true}`)
+
+	// concurrent installations each get a dictionary of their own
+	var wg sync.WaitGroup
+	ids := make([]uint64, 2)
+	for i, z := range []*txkvzstd.KV{a, b} {
+		wg.Add(1)
+		go func(i int, z *txkvzstd.KV) {
+			defer wg.Done()
+			id, err := z.Install(ctx, txkv.Key("users/"), content)
+			require.NoError(t, err)
+			require.NoError(t, z.Put(ctx, txkv.Key(fmt.Sprintf("users/%03d", i)), user(i)))
+			ids[i] = id
+		}(i, z)
+	}
+	wg.Wait()
+	require.NotEqual(t, ids[0], ids[1])
+
+	other := newKV(t, raw)
+	require.Len(t, other.Dictionaries(), 2)
+	for i := range ids {
+		v, _, err := other.Get(ctx, txkv.Key(fmt.Sprintf("users/%03d", i)))
+		require.NoError(t, err)
+		require.Equal(t, user(i), v)
+	}
+}
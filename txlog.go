@@ -0,0 +1,201 @@
+package txkv
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// TxLogOptions tune WithTxLog.
+type TxLogOptions struct {
+	// SlowCommit, if set, has the logs of transactions taking longer than it,
+	// from Begin to Commit, dumped even when they commit.
+	SlowCommit time.Duration
+	// OnDump is called with the log of every transaction that fails to
+	// commit, or is slow.
+	OnDump func(ctx context.Context, log *TxLog)
+}
+
+// TxLog is the sequence of operations of a transaction.
+type TxLog struct {
+	Began time.Time
+	Ops   []TxLogOp
+	// Duration is the time from Begin to the end of Commit.
+	Duration time.Duration
+	// Err is the error the transaction failed to commit with, if it did.
+	Err error
+}
+
+// TxLogOp is an operation of a transaction.
+type TxLogOp struct {
+	// Op is the operation, like OpGet.
+	Op string
+	// Key is the key read or written, or the prefix listed.
+	Key Key
+	// Value is the value written, or read if found.
+	Value Value
+	Found bool
+	// Keys is the number of keys listed.
+	Keys int
+	// At is when the operation started, since the transaction began.
+	At       time.Duration
+	Duration time.Duration
+	Err      error
+}
+
+// WriteTo writes the log in a readable form, one operation per line.
+func (l *TxLog) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	status := "committed"
+	if l.Err != nil {
+		status = fmt.Sprintf("failed: %v", l.Err)
+	}
+	fmt.Fprintf(cw, "tx began %s, %s after %s\n", l.Began.Format(time.RFC3339Nano), status, l.Duration)
+	for _, op := range l.Ops {
+		fmt.Fprintf(cw, "  +%-12s %-8s", op.At, op.Op)
+		switch op.Op {
+		case OpGet:
+			if op.Found {
+				fmt.Fprintf(cw, " %q = %q", op.Key, op.Value)
+			} else {
+				fmt.Fprintf(cw, " %q not found", op.Key)
+			}
+		case OpPut:
+			fmt.Fprintf(cw, " %q = %q", op.Key, op.Value)
+		case OpDelete:
+			fmt.Fprintf(cw, " %q", op.Key)
+		case OpList:
+			fmt.Fprintf(cw, " %q: %d keys", op.Key, op.Keys)
+		}
+		fmt.Fprintf(cw, " (%s)", op.Duration)
+		if op.Err != nil {
+			fmt.Fprintf(cw, " error: %v", op.Err)
+		}
+		fmt.Fprintln(cw)
+	}
+	return cw.n, cw.err
+}
+
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
+
+// WithTxLog returns a TransactionalKV over `kv` whose transactions log every
+// operation they make, with its timing, to hand the log of those that fail
+// to commit, or are slow, to OnDump: it shows what a misbehaving transaction
+// did, not only what it wrote. Values are logged as they are, so the logs
+// are as sensitive as the data.
+func WithTxLog(kv TransactionalKV, opts TxLogOptions) TransactionalKV {
+	return &txLogKV{kv: kv, opts: opts}
+}
+
+type txLogKV struct {
+	kv   TransactionalKV
+	opts TxLogOptions
+}
+
+func (l *txLogKV) Put(ctx context.Context, key Key, value Value) error {
+	return l.kv.Put(ctx, key, value)
+}
+
+func (l *txLogKV) Get(ctx context.Context, key Key) (Value, bool, error) {
+	return l.kv.Get(ctx, key)
+}
+
+func (l *txLogKV) Delete(ctx context.Context, key Key) error {
+	return l.kv.Delete(ctx, key)
+}
+
+func (l *txLogKV) List(ctx context.Context, prefix Key) ([]Key, error) {
+	return l.kv.List(ctx, prefix)
+}
+
+func (l *txLogKV) Begin(ctx context.Context) (TxKV, error) {
+	began := time.Now()
+	tx, err := l.kv.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &txLogTx{l: l, tx: tx, log: &TxLog{Began: began}}, nil
+}
+
+type txLogTx struct {
+	l  *txLogKV
+	tx TxKV
+
+	mu  sync.Mutex
+	log *TxLog
+}
+
+// record logs `op`, which started at `start`. Its key and value are copied,
+// as callers may reuse their buffers.
+func (t *txLogTx) record(op TxLogOp, start time.Time) {
+	op.Key = append(Key(nil), op.Key...)
+	if op.Value != nil {
+		op.Value = append(Value{}, op.Value...)
+	}
+	op.At = start.Sub(t.log.Began)
+	op.Duration = time.Since(start)
+	t.mu.Lock()
+	t.log.Ops = append(t.log.Ops, op)
+	t.mu.Unlock()
+}
+
+func (t *txLogTx) Put(ctx context.Context, key Key, value Value) error {
+	start := time.Now()
+	err := t.tx.Put(ctx, key, value)
+	t.record(TxLogOp{Op: OpPut, Key: key, Value: value, Err: err}, start)
+	return err
+}
+
+func (t *txLogTx) Get(ctx context.Context, key Key) (Value, bool, error) {
+	start := time.Now()
+	v, ok, err := t.tx.Get(ctx, key)
+	t.record(TxLogOp{Op: OpGet, Key: key, Value: v, Found: ok, Err: err}, start)
+	return v, ok, err
+}
+
+func (t *txLogTx) Delete(ctx context.Context, key Key) error {
+	start := time.Now()
+	err := t.tx.Delete(ctx, key)
+	t.record(TxLogOp{Op: OpDelete, Key: key, Err: err}, start)
+	return err
+}
+
+func (t *txLogTx) List(ctx context.Context, prefix Key) ([]Key, error) {
+	start := time.Now()
+	keys, err := t.tx.List(ctx, prefix)
+	t.record(TxLogOp{Op: OpList, Key: prefix, Keys: len(keys), Err: err}, start)
+	return keys, err
+}
+
+func (t *txLogTx) Commit(ctx context.Context) error {
+	start := time.Now()
+	err := t.tx.Commit(ctx)
+	t.record(TxLogOp{Op: OpCommit, Err: err}, start)
+	t.mu.Lock()
+	t.log.Duration = time.Since(t.log.Began)
+	t.log.Err = err
+	t.mu.Unlock()
+	slow := t.l.opts.SlowCommit > 0 && t.log.Duration > t.l.opts.SlowCommit
+	if (err != nil || slow) && t.l.opts.OnDump != nil {
+		t.l.opts.OnDump(ctx, t.log)
+	}
+	return err
+}
+
+func (t *txLogTx) Rollback(ctx context.Context) error { return t.tx.Rollback(ctx) }
@@ -0,0 +1,114 @@
+package txkv_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestTxLog(t *testing.T) {
+	testKV(t, func(t testing.TB) TransactionalKV {
+		return WithTxLog(InMem(), TxLogOptions{})
+	})
+}
+
+func TestTxLogDump(t *testing.T) {
+	ctx := context.Background()
+	broken := errors.New("disk on fire")
+	var dumped []*TxLog
+	onDump := func(_ context.Context, log *TxLog) { dumped = append(dumped, log) }
+
+	// committed transactions aren't dumped
+	kv := WithTxLog(InMem(), TxLogOptions{OnDump: onDump})
+	mustPut(ctx, t, kv, Key("a"), Value("1"))
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+	require.NoError(t, tx.Put(ctx, Key("b"), Value("2")))
+	require.NoError(t, tx.Commit(ctx))
+	require.Empty(t, dumped)
+
+	// failed ones are, with every operation
+	flaky := &flakyKV{TransactionalKV: InMem(), err: broken}
+	kv = WithTxLog(flaky, TxLogOptions{OnDump: onDump})
+	tx, err = kv.Begin(ctx)
+	require.NoError(t, err)
+	_, _, err = tx.Get(ctx, Key("a"))
+	require.NoError(t, err)
+	require.NoError(t, tx.Put(ctx, Key("a"), Value("1")))
+	require.NoError(t, tx.Put(ctx, Key("a"), Value("2")))
+	_, err = tx.List(ctx, nil)
+	require.NoError(t, err)
+	require.NoError(t, tx.Delete(ctx, Key("b")))
+	flaky.failures = 1
+	require.ErrorIs(t, tx.Commit(ctx), broken)
+
+	require.Len(t, dumped, 1)
+	log := dumped[0]
+	require.ErrorIs(t, log.Err, broken)
+	var ops []string
+	for _, op := range log.Ops {
+		ops = append(ops, op.Op)
+	}
+	require.Equal(t, []string{OpGet, OpPut, OpPut, OpList, OpDelete, OpCommit}, ops)
+	require.Equal(t, Value("2"), log.Ops[2].Value)
+	require.Equal(t, 1, log.Ops[3].Keys)
+	require.ErrorIs(t, log.Ops[5].Err, broken)
+
+	var b strings.Builder
+	_, err = log.WriteTo(&b)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	require.Len(t, lines, 7)
+	require.Contains(t, lines[0], "failed: disk on fire")
+	require.Contains(t, lines[1], `"a" not found`)
+	require.Contains(t, lines[3], `"a" = "2"`)
+	require.Contains(t, lines[4], `"": 1 keys`)
+
+	// slow ones too
+	dumped = nil
+	kv = WithTxLog(InMem(), TxLogOptions{SlowCommit: time.Millisecond, OnDump: onDump})
+	tx, err = kv.Begin(ctx)
+	require.NoError(t, err)
+	time.Sleep(2 * time.Millisecond)
+	require.NoError(t, tx.Commit(ctx))
+	require.Len(t, dumped, 1)
+	require.NoError(t, dumped[0].Err)
+}
+
+func TestTxLogCopies(t *testing.T) {
+	ctx := context.Background()
+	var dumped *TxLog
+	kv := WithTxLog(InMem(), TxLogOptions{SlowCommit: time.Nanosecond, OnDump: func(_ context.Context, log *TxLog) { dumped = log }})
+	tx, err := kv.Begin(ctx)
+	require.NoError(t, err)
+
+	// buffers reused by the caller don't change the log
+	key, value := Key("a"), Value("1")
+	require.NoError(t, tx.Put(ctx, key, value))
+	copy(key, "b")
+	copy(value, "2")
+
+	// operations may be made concurrently
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := tx.Get(ctx, Key("a"))
+			require.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, tx.Commit(ctx))
+	require.NotNil(t, dumped)
+	require.Len(t, dumped.Ops, 6)
+	require.Equal(t, Key("a"), dumped.Ops[0].Key)
+	require.Equal(t, Value("1"), dumped.Ops[0].Value)
+}
@@ -0,0 +1,182 @@
+package txkv
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// UpgradeFunc upgrades a value at `key` from the format of one version to the
+// format of the next.
+type UpgradeFunc func(key Key, value Value) (Value, error)
+
+// UpgradeOptions tune WithUpgrades.
+type UpgradeOptions struct {
+	// Upgrades[i] upgrades values from version i to version i+1. The
+	// current version, that values are written with, is len(Upgrades).
+	Upgrades []UpgradeFunc
+	// WriteBack has values upgraded when read written back, so they're only
+	// upgraded once. It's done in its own transaction, only if the value
+	// didn't change since it was read, and on a best effort basis. Reads
+	// within transactions aren't written back.
+	//
+	// The store's transactions must fail to commit when a key they read was
+	// written since, like those of InMem and Disk, or be serialized: on
+	// other stores, a write landing during the write-back could be replaced
+	// with the older upgraded value. Leave it off for them.
+	WriteBack bool
+}
+
+// ErrUnknownValueVersion is matched by the errors returned when reading a
+// value of a version newer than the current one, meaning it was written by a
+// newer program.
+var ErrUnknownValueVersion = errors.New("txkv: unknown value version")
+
+// ValueVersionError is returned when a value can't be brought to the current
+// version.
+type ValueVersionError struct {
+	Key     Key
+	Version int
+	Err     error
+}
+
+func (e *ValueVersionError) Error() string {
+	return fmt.Sprintf("txkv: upgrading value at %q from version %d: %v", e.Key, e.Version, e.Err)
+}
+
+func (e *ValueVersionError) Unwrap() error { return e.Err }
+
+var errMalformedVersionedValue = errors.New("malformed versioned value")
+
+// WithUpgrades returns a TransactionalKV that tags values with the version of
+// their format, and upgrades older values to the current version when
+// they're read. Value formats can then evolve without migrating the whole
+// store first: callers only ever see the current format.
+//
+// All values must be written through the returned store, which stores them
+// as `uvarint version | value`. A value of a newer version than the current
+// one can't be read.
+func WithUpgrades(kv TransactionalKV, opts UpgradeOptions) TransactionalKV {
+	return &upgradingKV{kv: kv, opts: opts}
+}
+
+type upgradingKV struct {
+	kv   TransactionalKV
+	opts UpgradeOptions
+}
+
+func (u *upgradingKV) Put(ctx context.Context, key Key, value Value) error {
+	return u.kv.Put(ctx, key, u.versioned(value))
+}
+
+func (u *upgradingKV) Get(ctx context.Context, key Key) (Value, bool, error) {
+	stored, ok, err := u.kv.Get(ctx, key)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	value, upgraded, err := u.upgrade(key, stored)
+	if err != nil {
+		return nil, false, err
+	}
+	if upgraded && u.opts.WriteBack {
+		u.writeBack(ctx, key, stored, value)
+	}
+	return value, true, nil
+}
+
+func (u *upgradingKV) Delete(ctx context.Context, key Key) error {
+	return u.kv.Delete(ctx, key)
+}
+
+func (u *upgradingKV) List(ctx context.Context, prefix Key) ([]Key, error) {
+	return u.kv.List(ctx, prefix)
+}
+
+func (u *upgradingKV) Begin(ctx context.Context) (TxKV, error) {
+	tx, err := u.kv.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &upgradingTx{u: u, tx: tx}, nil
+}
+
+type upgradingTx struct {
+	u  *upgradingKV
+	tx TxKV
+}
+
+func (u *upgradingTx) Put(ctx context.Context, key Key, value Value) error {
+	return u.tx.Put(ctx, key, u.u.versioned(value))
+}
+
+func (u *upgradingTx) Get(ctx context.Context, key Key) (Value, bool, error) {
+	stored, ok, err := u.tx.Get(ctx, key)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	value, _, err := u.u.upgrade(key, stored)
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (u *upgradingTx) Delete(ctx context.Context, key Key) error {
+	return u.tx.Delete(ctx, key)
+}
+
+func (u *upgradingTx) List(ctx context.Context, prefix Key) ([]Key, error) {
+	return u.tx.List(ctx, prefix)
+}
+
+func (u *upgradingTx) Commit(ctx context.Context) error   { return u.tx.Commit(ctx) }
+func (u *upgradingTx) Rollback(ctx context.Context) error { return u.tx.Rollback(ctx) }
+
+func (u *upgradingKV) versioned(value Value) Value {
+	stored := make(Value, 0, binary.MaxVarintLen64+len(value))
+	stored = binary.AppendUvarint(stored, uint64(len(u.opts.Upgrades)))
+	return append(stored, value...)
+}
+
+// upgrade brings `stored` to the current version, and tells whether it had
+// to be upgraded.
+func (u *upgradingKV) upgrade(key Key, stored Value) (Value, bool, error) {
+	version, n := binary.Uvarint(stored)
+	if n <= 0 {
+		return nil, false, &ValueVersionError{Key: key, Err: errMalformedVersionedValue}
+	}
+	current := uint64(len(u.opts.Upgrades))
+	if version > current {
+		return nil, false, &ValueVersionError{Key: key, Version: int(version), Err: ErrUnknownValueVersion}
+	}
+	value := stored[n:]
+	for v := version; v < current; v++ {
+		var err error
+		value, err = u.opts.Upgrades[v](key, value)
+		if err != nil {
+			return nil, false, &ValueVersionError{Key: key, Version: int(v), Err: err}
+		}
+	}
+	return value, version < current, nil
+}
+
+// writeBack stores the upgraded `value` at `key`, unless it's no longer
+// `stored`. A write landing after the check fails the commit with a conflict.
+func (u *upgradingKV) writeBack(ctx context.Context, key Key, stored, value Value) {
+	tx, err := u.kv.Begin(ctx)
+	if err != nil {
+		return
+	}
+	current, ok, err := tx.Get(ctx, key)
+	if err != nil || !ok || !bytes.Equal(current, stored) {
+		_ = tx.Rollback(ctx)
+		return
+	}
+	if err := tx.Put(ctx, key, u.versioned(value)); err != nil {
+		_ = tx.Rollback(ctx)
+		return
+	}
+	_ = tx.Commit(ctx)
+}
@@ -0,0 +1,135 @@
+package txkv_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+func TestUpgrades(t *testing.T) {
+	testKV(t, func(t testing.TB) TransactionalKV {
+		return WithUpgrades(InMem(), UpgradeOptions{})
+	})
+
+	ctx := context.Background()
+	raw := InMem()
+	v0 := WithUpgrades(raw, UpgradeOptions{})
+	mustPut(ctx, t, v0, Key("user/1"), Value("alice"))
+
+	// v1 wraps names in a document, v2 adds a field
+	upgrades := []UpgradeFunc{
+		func(key Key, value Value) (Value, error) {
+			return Value(`{"name":"` + string(value) + `"}`), nil
+		},
+		func(key Key, value Value) (Value, error) {
+			return append(bytes.TrimSuffix(value, []byte("}")), `,"admin":false}`...), nil
+		},
+	}
+	v2 := WithUpgrades(raw, UpgradeOptions{Upgrades: upgrades})
+	mustPut(ctx, t, v2, Key("user/2"), Value(`{"name":"bob","admin":true}`))
+
+	mustFind(ctx, t, v2, Key("user/1"), Value(`{"name":"alice","admin":false}`))
+	mustFind(ctx, t, v2, Key("user/2"), Value(`{"name":"bob","admin":true}`))
+	tx, err := v2.Begin(ctx)
+	require.NoError(t, err)
+	mustFind(ctx, t, tx, Key("user/1"), Value(`{"name":"alice","admin":false}`))
+	require.NoError(t, tx.Rollback(ctx))
+
+	// without write back, the old value stays
+	stored, _, err := raw.Get(ctx, Key("user/1"))
+	require.NoError(t, err)
+	require.Equal(t, Value("\x00alice"), stored)
+
+	// older programs can't read newer values
+	_, _, err = v0.Get(ctx, Key("user/2"))
+	var verr *ValueVersionError
+	require.True(t, errors.As(err, &verr), "%v", err)
+	require.ErrorIs(t, err, ErrUnknownValueVersion)
+	require.Equal(t, 2, verr.Version)
+}
+
+func TestUpgradesWriteBack(t *testing.T) {
+	ctx := context.Background()
+	raw := InMem()
+	mustPut(ctx, t, WithUpgrades(raw, UpgradeOptions{}), Key("n"), Value("1"))
+
+	calls := 0
+	kv := WithUpgrades(raw, UpgradeOptions{
+		Upgrades: []UpgradeFunc{func(key Key, value Value) (Value, error) {
+			calls++
+			return append(value, '0'), nil
+		}},
+		WriteBack: true,
+	})
+	mustFind(ctx, t, kv, Key("n"), Value("10"))
+	mustFind(ctx, t, kv, Key("n"), Value("10"))
+	require.Equal(t, 1, calls)
+	mustFind(ctx, t, raw, Key("n"), Value("\x0110"))
+}
+
+func TestUpgradesWriteBackRace(t *testing.T) {
+	ctx := context.Background()
+	raw := InMem()
+	v0 := WithUpgrades(raw, UpgradeOptions{})
+	mustPut(ctx, t, v0, Key("n"), Value("1"))
+
+	// a writer lands between the read and the commit of the write-back
+	racing := &hookedKV{TransactionalKV: raw, afterTxGet: func() {
+		mustPut(ctx, t, v0, Key("n"), Value("2"))
+	}}
+	kv := WithUpgrades(racing, UpgradeOptions{
+		Upgrades: []UpgradeFunc{func(key Key, value Value) (Value, error) {
+			return append(value, '0'), nil
+		}},
+		WriteBack: true,
+	})
+	mustFind(ctx, t, kv, Key("n"), Value("10"))
+	mustFind(ctx, t, raw, Key("n"), Value("\x002"))
+}
+
+// hookedKV calls afterTxGet after every read made within its transactions.
+type hookedKV struct {
+	TransactionalKV
+	afterTxGet func()
+}
+
+func (k *hookedKV) Begin(ctx context.Context) (TxKV, error) {
+	tx, err := k.TransactionalKV.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &hookedTx{TxKV: tx, afterGet: k.afterTxGet}, nil
+}
+
+type hookedTx struct {
+	TxKV
+	afterGet func()
+}
+
+func (t *hookedTx) Get(ctx context.Context, key Key) (Value, bool, error) {
+	v, ok, err := t.TxKV.Get(ctx, key)
+	t.afterGet()
+	return v, ok, err
+}
+
+func TestUpgradesFailure(t *testing.T) {
+	ctx := context.Background()
+	raw := InMem()
+	mustPut(ctx, t, WithUpgrades(raw, UpgradeOptions{}), Key("n"), Value("1"))
+	mustPut(ctx, t, raw, Key("empty"), Value(""))
+
+	boom := errors.New("boom")
+	kv := WithUpgrades(raw, UpgradeOptions{
+		Upgrades: []UpgradeFunc{func(key Key, value Value) (Value, error) { return nil, boom }},
+	})
+	_, _, err := kv.Get(ctx, Key("n"))
+	require.ErrorIs(t, err, boom)
+	_, _, err = kv.Get(ctx, Key("empty"))
+	var verr *ValueVersionError
+	require.True(t, errors.As(err, &verr), "%v", err)
+}
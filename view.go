@@ -0,0 +1,241 @@
+package txkv
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ViewFunc derives the view entries of a source key, calling `emit` for each
+// of them. Entries must belong to a single source key: a view key emitted by
+// two source keys is removed when either of them changes.
+type ViewFunc func(key Key, value Value, emit func(Key, Value)) error
+
+// ViewOptions tune NewView.
+type ViewOptions struct {
+	// StatePrefix is where the view keeps its state in the target: the seq
+	// it synced up to, and which view keys each source key emitted. Defaults
+	// to "\x00view/". It mustn't overlap the view keys.
+	StatePrefix Key
+	// BatchSize is the number of source keys applied per transaction on the
+	// target. Defaults to 100.
+	BatchSize int
+	// Interval is how often Run looks for changes. Defaults to a second.
+	Interval time.Duration
+}
+
+// View maintains a materialized view in a target store, derived from the
+// keys of a source store under a prefix. The source must be a ChangeTracker.
+//
+// The first sync hydrates the view from the whole prefix, later ones only
+// apply the keys modified since. The seq a view synced up to is committed
+// along with the last batch of changes, so a view that stopped midway, even
+// crashing, resumes where it was when synced again: changes are applied once
+// per commit of the source, or again when a sync was interrupted, which
+// rewrites the same entries.
+type View struct {
+	source  KV
+	tracker ChangeTracker
+	prefix  Key
+	target  TransactionalKV
+	fn      ViewFunc
+	opts    ViewOptions
+
+	mu sync.Mutex // a single sync at a time
+}
+
+// NewView returns a view of the keys of `source` under `prefix`, derived with
+// `fn` into `target`. Nothing happens until it's synced.
+func NewView(source KV, prefix Key, target TransactionalKV, fn ViewFunc, opts ViewOptions) (*View, error) {
+	tracker, ok := source.(ChangeTracker)
+	if !ok {
+		return nil, errors.New("txkv: the source of a view must be a ChangeTracker")
+	}
+	if opts.StatePrefix == nil {
+		opts.StatePrefix = Key("\x00view/")
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	return &View{
+		source:  source,
+		tracker: tracker,
+		prefix:  prefix,
+		target:  target,
+		fn:      fn,
+		opts:    opts,
+	}, nil
+}
+
+// Sync applies the changes made to the source since the last sync, and
+// returns how many source keys it applied.
+func (v *View) Sync(ctx context.Context) (int, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	since, err := v.synced(ctx)
+	if err != nil {
+		return 0, err
+	}
+	// note the seq first: keys modified meanwhile are listed now, and again
+	// next time
+	seq, err := v.tracker.Seq(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if seq == since {
+		return 0, nil
+	}
+	keys, err := v.tracker.ListModifiedSince(ctx, v.prefix, since)
+	if errors.Is(err, ErrCompacted) {
+		keys, err = v.allKeys(ctx)
+	}
+	if err != nil {
+		return 0, err
+	}
+	applied := 0
+	for {
+		n := len(keys)
+		if n > v.opts.BatchSize {
+			n = v.opts.BatchSize
+		}
+		last := n == len(keys)
+		if err := v.applyBatch(ctx, keys[:n], seq, last); err != nil {
+			return applied, err
+		}
+		applied += n
+		keys = keys[n:]
+		if last {
+			return applied, nil
+		}
+	}
+}
+
+// Run syncs the view every Interval, until `ctx` is done.
+func (v *View) Run(ctx context.Context) error {
+	for {
+		if _, err := v.Sync(ctx); err != nil {
+			return err
+		}
+		if err := sleep(ctx, v.opts.Interval); err != nil {
+			return err
+		}
+	}
+}
+
+// allKeys lists the source keys whose view entries may have to change when
+// the source forgot what it deleted: those it holds, and those the view
+// holds entries of.
+func (v *View) allKeys(ctx context.Context) ([]Key, error) {
+	keys, err := v.source.List(ctx, v.prefix)
+	if err != nil {
+		return nil, err
+	}
+	srcPrefix := v.stateKey("src/")
+	stateKeys, err := v.target.List(ctx, srcPrefix)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		seen[string(key)] = true
+	}
+	for _, stateKey := range stateKeys {
+		if key := stateKey[len(srcPrefix):]; !seen[string(key)] {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// synced returns the seq the view synced up to.
+func (v *View) synced(ctx context.Context) (uint64, error) {
+	b, ok, err := v.target.Get(ctx, v.stateKey("seq"))
+	if err != nil || !ok {
+		return 0, err
+	}
+	seq, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, fmt.Errorf("txkv: corrupted view state at %q", v.stateKey("seq"))
+	}
+	return seq, nil
+}
+
+// applyBatch applies the changes of `keys` in a transaction, which also
+// records `seq` when it's the last one of a sync.
+func (v *View) applyBatch(ctx context.Context, keys []Key, seq uint64, last bool) error {
+	tx, err := v.target.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := v.apply(ctx, tx, key); err != nil {
+			_ = tx.Rollback(ctx)
+			return err
+		}
+	}
+	if last {
+		if err := tx.Put(ctx, v.stateKey("seq"), binary.AppendUvarint(nil, seq)); err != nil {
+			_ = tx.Rollback(ctx)
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// apply replaces the view entries emitted by `key` with those of its current
+// value.
+func (v *View) apply(ctx context.Context, tx TxKV, key Key) error {
+	emittedKey := v.stateKey("src/" + string(key))
+	emitted, _, err := tx.Get(ctx, emittedKey)
+	if err != nil {
+		return err
+	}
+	for len(emitted) > 0 {
+		viewKey, rest, ok := readUvarintBytes(emitted)
+		if !ok {
+			return fmt.Errorf("txkv: corrupted view state at %q", emittedKey)
+		}
+		if err := tx.Delete(ctx, viewKey); err != nil {
+			return err
+		}
+		emitted = rest
+	}
+
+	value, ok, err := v.source.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return tx.Delete(ctx, emittedKey)
+	}
+	var emitErr error
+	emitted = nil
+	err = v.fn(key, value, func(viewKey Key, viewValue Value) {
+		if emitErr == nil {
+			emitErr = tx.Put(ctx, viewKey, viewValue)
+		}
+		emitted = binary.AppendUvarint(emitted, uint64(len(viewKey)))
+		emitted = append(emitted, viewKey...)
+	})
+	if err != nil {
+		return err
+	}
+	if emitErr != nil {
+		return emitErr
+	}
+	if len(emitted) == 0 {
+		return tx.Delete(ctx, emittedKey)
+	}
+	return tx.Put(ctx, emittedKey, emitted)
+}
+
+func (v *View) stateKey(name string) Key {
+	return append(append(Key(nil), v.opts.StatePrefix...), name...)
+}
@@ -0,0 +1,100 @@
+package txkv_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/aybabtme/txkv"
+)
+
+// byCity indexes "user/<name>" = "<city>" as "city/<city>/<name>".
+func byCity(key Key, value Value, emit func(Key, Value)) error {
+	if bytes.Equal(value, Value("bad")) {
+		return errors.New("bad value")
+	}
+	name := bytes.TrimPrefix(key, Key("user/"))
+	emit(Key("city/"+string(value)+"/"+string(name)), Value{})
+	return nil
+}
+
+func TestView(t *testing.T) {
+	ctx := context.Background()
+	source, target := InMem(), InMem()
+	mustPut(ctx, t, source, Key("user/ann"), Value("paris"))
+	mustPut(ctx, t, source, Key("user/bob"), Value("rome"))
+	mustPut(ctx, t, source, Key("other"), Value("ignored"))
+
+	view, err := NewView(source, Key("user/"), target, byCity, ViewOptions{BatchSize: 1})
+	require.NoError(t, err)
+
+	// hydrates
+	n, err := view.Sync(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	mustList(ctx, t, target, Key("city/"), []Key{Key("city/paris/ann"), Key("city/rome/bob")})
+
+	// then follows changes
+	mustPut(ctx, t, source, Key("user/ann"), Value("rome"))
+	mustDelete(ctx, t, source, Key("user/bob"))
+	mustPut(ctx, t, source, Key("user/cid"), Value("oslo"))
+	n, err = view.Sync(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+	mustList(ctx, t, target, Key("city/"), []Key{Key("city/oslo/cid"), Key("city/rome/ann")})
+
+	n, err = view.Sync(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+}
+
+func TestViewResumes(t *testing.T) {
+	ctx := context.Background()
+	source, target := InMem(), InMem()
+	mustPut(ctx, t, source, Key("user/ann"), Value("paris"))
+	mustPut(ctx, t, source, Key("user/bob"), Value("bad"))
+
+	view, err := NewView(source, Key("user/"), target, byCity, ViewOptions{BatchSize: 1})
+	require.NoError(t, err)
+	_, err = view.Sync(ctx)
+	require.Error(t, err)
+	// the first batch went through, but not the checkpoint
+	mustList(ctx, t, target, Key("city/"), []Key{Key("city/paris/ann")})
+
+	// a new view over the same target picks up from its state
+	mustPut(ctx, t, source, Key("user/bob"), Value("rome"))
+	view, err = NewView(source, Key("user/"), target, byCity, ViewOptions{BatchSize: 1})
+	require.NoError(t, err)
+	_, err = view.Sync(ctx)
+	require.NoError(t, err)
+	mustList(ctx, t, target, Key("city/"), []Key{Key("city/paris/ann"), Key("city/rome/bob")})
+}
+
+func TestViewAfterCompaction(t *testing.T) {
+	ctx := context.Background()
+	source, target := InMem(), InMem()
+	mustPut(ctx, t, source, Key("user/ann"), Value("paris"))
+	mustPut(ctx, t, source, Key("user/bob"), Value("rome"))
+	view, err := NewView(source, Key("user/"), target, byCity, ViewOptions{})
+	require.NoError(t, err)
+	_, err = view.Sync(ctx)
+	require.NoError(t, err)
+
+	// the source forgets bob was deleted, the view still finds out
+	mustDelete(ctx, t, source, Key("user/bob"))
+	mustPut(ctx, t, source, Key("user/cid"), Value("oslo"))
+	_, err = source.(Compacter).Compact(ctx)
+	require.NoError(t, err)
+	n, err := view.Sync(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+	mustList(ctx, t, target, Key("city/"), []Key{Key("city/oslo/cid"), Key("city/paris/ann")})
+}
+
+func TestViewNeedsChangeTracker(t *testing.T) {
+	_, err := NewView(WithMaintenance(InMem()), Key(""), InMem(), byCity, ViewOptions{})
+	require.Error(t, err)
+}